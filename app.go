@@ -1,14 +1,15 @@
+//go:build windows
+
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
-	"unsafe"
 
 	"github.com/tailscale/walk"
 
@@ -16,108 +17,28 @@ import (
 	. "github.com/tailscale/walk/declarative"
 )
 
-var cs CpuSets
-
 func main() {
+	parseFlags()
 	cs.Init()
 
-	var devices []Device
-	devices, handle = FindAllDevices()
-
-	if CLIMode {
-		var newItem *Device
-		for i := 0; i < len(devices); i++ {
-			if devices[i].DevObjName == flagDevObjName {
-				newItem = &devices[i]
-				break
-			}
-		}
-		if newItem == nil {
-			SetupDiDestroyDeviceInfoList(handle)
-			os.Exit(1)
-		}
-		orgItem := *newItem
-
-		var assignmentSetOverride Bits
-		if flagCPU != "" {
-			cpuarray := strings.Split(flagCPU, ",")
-			for _, val := range cpuarray {
-				i, err := strconv.Atoi(val)
-				if err != nil {
-					log.Println(err)
-					continue
-				}
-				assignmentSetOverride = Set(assignmentSetOverride, CPUBits[i])
-			}
-		}
-
-		if flagMsiSupported != -1 || flagMessageNumberLimit != -1 {
-			if flagMsiSupported != -1 {
-				newItem.MsiSupported = uint32(flagMsiSupported)
-			}
-			if flagMessageNumberLimit != -1 {
-				newItem.MessageNumberLimit = uint32(flagMessageNumberLimit)
-			}
-			setMSIMode(newItem)
-		}
-
-		if flagDevicePolicy != -1 || flagDevicePriority != -1 || assignmentSetOverride != ZeroBit {
-			if flagDevicePolicy != -1 {
-				newItem.DevicePolicy = uint32(flagDevicePolicy)
-			}
-			if flagDevicePriority != -1 {
-				newItem.DevicePriority = uint32(flagDevicePriority)
-			}
-			if assignmentSetOverride != ZeroBit {
-				newItem.AssignmentSetOverride = assignmentSetOverride
-			}
-			setAffinityPolicy(newItem)
-		}
-
-		changed := orgItem.MsiSupported != newItem.MsiSupported || orgItem.MessageNumberLimit != newItem.MessageNumberLimit || orgItem.DevicePolicy != newItem.DevicePolicy || orgItem.DevicePriority != newItem.DevicePriority || orgItem.AssignmentSetOverride != newItem.AssignmentSetOverride
-		if flagRestart || (flagRestartOnChange && changed) {
-			propChangeParams := PropChangeParams{
-				ClassInstallHeader: *MakeClassInstallHeader(DIF_PROPERTYCHANGE),
-				StateChange:        DICS_PROPCHANGE,
-				Scope:              DICS_FLAG_GLOBAL,
-			}
-
-			if err := SetupDiSetClassInstallParams(handle, &newItem.Idata, &propChangeParams.ClassInstallHeader, uint32(unsafe.Sizeof(propChangeParams))); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := SetupDiCallClassInstaller(DIF_PROPERTYCHANGE, handle, &newItem.Idata); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := SetupDiSetClassInstallParams(handle, &newItem.Idata, &propChangeParams.ClassInstallHeader, uint32(unsafe.Sizeof(propChangeParams))); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := SetupDiCallClassInstaller(DIF_PROPERTYCHANGE, handle, &newItem.Idata); err != nil {
-				log.Println(err)
-				return
-			}
+	if flag.NArg() != 0 {
+		exitCLI(runCommand(flag.Args()))
+	}
 
-			DeviceInstallParams, err := SetupDiGetDeviceInstallParams(handle, &newItem.Idata)
-			if err != nil {
-				log.Println(err)
-				return
-			}
+	devices, err := loadDevices()
+	if err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
 
-			if DeviceInstallParams.Flags&DI_NEEDREBOOT != 0 { // DI_NEEDREBOOT
-				fmt.Println("Device could not be restarted. Changes will take effect the next time you reboot.")
-			} else {
-				fmt.Println("Device successfully restarted.")
-			}
-		}
-		SetupDiDestroyDeviceInfoList(handle)
-		os.Exit(0)
+	if CLIMode {
+		exitCLI(legacyCLI(devices))
 	}
-	defer SetupDiDestroyDeviceInfoList(handle)
+	defer func() {
+		if err := source.Close(); err != nil {
+			log.Println(err)
+		}
+	}()
 
 	// Sortiert das Array nach Namen
 	sort.Slice(devices, func(i, j int) bool {
@@ -126,6 +47,11 @@ func main() {
 
 	AllDevices := devices
 
+	title := "GoInterruptPolicy"
+	if flagDryRun {
+		title += " (dry run)"
+	}
+
 	var LineEditSearch *walk.LineEdit
 	mw := &MyMainWindow{
 		model: &Model{items: devices},
@@ -133,7 +59,7 @@ func main() {
 	}
 	if err := (MainWindow{
 		AssignTo: &mw.MainWindow,
-		Title:    "GoInterruptPolicy",
+		Title:    title,
 		MinSize: Size{
 			Width:  240,
 			Height: 320,
@@ -148,7 +74,7 @@ func main() {
 		},
 		Children: []Widget{
 			Composite{
-				Layout: VBox{},
+				Layout: HBox{},
 				Children: []Widget{
 					LineEdit{
 						AssignTo:  &LineEditSearch,
@@ -161,10 +87,7 @@ func main() {
 							} else {
 								newDevices := []Device{}
 								for i := 0; i < len(AllDevices); i++ {
-									if strings.Contains(strings.ToLower(AllDevices[i].DeviceDesc), text) ||
-										strings.Contains(strings.ToLower(AllDevices[i].DevObjName), text) ||
-										strings.Contains(strings.ToLower(AllDevices[i].LocationInformation), text) ||
-										strings.Contains(strings.ToLower(AllDevices[i].FriendlyName), text) {
+									if matchesSearch(&AllDevices[i], text) {
 										newDevices = append(newDevices, AllDevices[i])
 									}
 								}
@@ -173,6 +96,22 @@ func main() {
 							}
 						},
 					},
+					PushButton{
+						Text:      "Import .reg",
+						OnClicked: mw.importRegFile,
+					},
+					PushButton{
+						Text:      "Backup all",
+						OnClicked: mw.backupAll,
+					},
+					PushButton{
+						Text: "Devices per CPU",
+						OnClicked: func() {
+							if _, err := RunCPUMapDialog(mw, AllDevices); err != nil {
+								log.Println(err)
+							}
+						},
+					},
 				},
 			},
 			TableView{
@@ -185,6 +124,23 @@ func main() {
 
 				Model:    mw.model,
 				AssignTo: &mw.tv,
+				StyleCell: func(style *walk.CellStyle) {
+					items := mw.tv.Model().(*Model).items
+					if style.Row() >= len(items) {
+						return
+					}
+					if items[style.Row()].State != 0 {
+						style.TextColor = walk.RGB(128, 128, 128)
+					}
+					severity, found := maxSeverity(lintDevice(&items[style.Row()], &cs))
+					switch {
+					case !found:
+					case severity == SeverityError:
+						style.BackgroundColor = walk.RGB(255, 200, 200)
+					case severity == SeverityWarning:
+						style.BackgroundColor = walk.RGB(255, 235, 180)
+					}
+				},
 				OnKeyUp: func(key walk.Key) {
 					i := mw.tv.CurrentIndex()
 					if i == -1 {
@@ -216,6 +172,37 @@ func main() {
 						Title: "Location Info",
 						Width: 150,
 					},
+					{
+						Name:  "PCI",
+						Title: "PCI",
+						Width: 60,
+						FormatFunc: func(value interface{}) string {
+							return value.(PCILocation).String()
+						},
+						LessFunc: func(i, j int) bool {
+							a, b := mw.model.items[i].PCI, mw.model.items[j].PCI
+							if a.Bus != b.Bus {
+								return a.Bus < b.Bus
+							}
+							if a.Device != b.Device {
+								return a.Device < b.Device
+							}
+							return a.Function < b.Function
+						},
+					},
+					{
+						Name:  "Class",
+						Title: "Class",
+						Width: 80,
+					},
+					{
+						Name:  "State",
+						Title: "State",
+						Width: 80,
+						FormatFunc: func(value interface{}) string {
+							return value.(DeviceState).String()
+						},
+					},
 					{
 						Name:      "MsiSupported",
 						Title:     "MSI Mode",
@@ -234,66 +221,24 @@ func main() {
 						Name:  "DevicePolicy",
 						Title: "Device Policy",
 						FormatFunc: func(value interface{}) string {
-							// https://docs.microsoft.com/en-us/windows-hardware/drivers/kernel/interrupt-affinity-and-priority
-							switch value.(uint32) {
-							case IrqPolicyMachineDefault: // 0x00
-								return "Default"
-							case IrqPolicyAllCloseProcessors: // 0x01
-								return "All Close Proc"
-							case IrqPolicyOneCloseProcessor: // 0x02
-								return "One Close Proc"
-							case IrqPolicyAllProcessorsInMachine: // 0x03
-								return "All Proc in Machine"
-							case IrqPolicySpecifiedProcessors: // 0x04
-								return "Specified Proc"
-							case IrqPolicySpreadMessagesAcrossAllProcessors: // 0x05
-								return "Spread Messages Across All Proc"
-							default:
-								return fmt.Sprintf("%d", value.(uint32))
-							}
+							return policyName(value.(uint32))
 						},
 					},
 					{
 						Name:  "AssignmentSetOverride",
 						Title: "Specified Processor",
 						FormatFunc: func(value interface{}) string {
-							if value == ZeroBit {
-								return ""
-							}
-							bits := value.(Bits)
-							var result []string
-							for bit, cpu := range CPUMap {
-								if Has(bit, bits) {
-									result = append(result, cpu)
-								}
-							}
-
-							result, err := sortNumbers(result)
-							if err != nil {
-								log.Println(err)
-							}
-							return strings.Join(result, ",")
+							return value.(CPUMask).String()
 						},
 						LessFunc: func(i, j int) bool {
-							return mw.model.items[i].AssignmentSetOverride < mw.model.items[j].AssignmentSetOverride
+							return mw.model.items[i].AssignmentSetOverride.Less(mw.model.items[j].AssignmentSetOverride)
 						},
 					},
 					{
 						Name:  "DevicePriority",
 						Title: "Device Priority",
 						FormatFunc: func(value interface{}) string {
-							switch value.(uint32) {
-							case 0:
-								return "Undefined"
-							case 1:
-								return "Low"
-							case 2:
-								return "Normal"
-							case 3:
-								return "High"
-							default:
-								return fmt.Sprintf("%d", value.(uint32))
-							}
+							return priorityName(value.(uint32))
 						},
 					},
 					{
@@ -335,6 +280,41 @@ func main() {
 						Name:  "DevObjName",
 						Title: "DevObj Name",
 					},
+					{
+						Name:  "Driver",
+						Title: "Service",
+					},
+					{
+						Name:      "NumaNode",
+						Title:     "NUMA Node",
+						Alignment: AlignCenter,
+						FormatFunc: func(value interface{}) string {
+							return numaNodeName(value.(int))
+						},
+					},
+					{
+						Name:  "InstanceID",
+						Title: "Instance ID",
+						Width: 200,
+					},
+					{
+						Name:  "DeviceIDs",
+						Title: "Hardware ID",
+						Width: 200,
+						FormatFunc: func(value interface{}) string {
+							return firstString(value.([]string))
+						},
+					},
+					{
+						Name:  "Parent",
+						Title: "Parent",
+						Width: 200,
+					},
+					{
+						Name:  "ContainerID",
+						Title: "Container ID",
+						Width: 200,
+					},
 					{
 						Name:  "LastChange",
 						Title: "Last Change",
@@ -396,51 +376,39 @@ func (mw *MyMainWindow) lb_ItemActivated() {
 		return
 	}
 
-	if orgItem.MsiSupported != newItem.MsiSupported || orgItem.MessageNumberLimit != newItem.MessageNumberLimit {
-		setMSIMode(newItem)
+	var plan Plan
+	if err := plan.Add(&orgItem, newItem); err != nil {
+		mw.tv.Model().(*Model).items[mw.tv.CurrentIndex()] = orgItem
+		walk.MsgBox(mw, "Apply Error", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	if flagDryRun {
+		mw.tv.Model().(*Model).items[mw.tv.CurrentIndex()] = orgItem
+		showDryRun(mw, &plan)
+		return
 	}
 
-	if orgItem.DevicePolicy != newItem.DevicePolicy || orgItem.DevicePriority != newItem.DevicePriority || orgItem.AssignmentSetOverride != newItem.AssignmentSetOverride {
-		setAffinityPolicy(newItem)
+	changed := msiChanged(&orgItem, newItem) || affinityChanged(&orgItem, newItem)
+	if err := plan.Apply(); err != nil {
+		readSettings(&orgItem) // a part of the plan may have been written
+		mw.tv.Model().(*Model).items[mw.tv.CurrentIndex()] = orgItem
+		walk.MsgBox(mw, "Apply Error", err.Error(), walk.MsgBoxIconError)
+		return
 	}
 
-	if orgItem.MsiSupported != newItem.MsiSupported || orgItem.MessageNumberLimit != newItem.MessageNumberLimit || orgItem.DevicePolicy != newItem.DevicePolicy || orgItem.DevicePriority != newItem.DevicePriority || orgItem.AssignmentSetOverride != newItem.AssignmentSetOverride {
+	if reason := restartSkipped(newItem); changed && reason != "" {
+		mw.sbi.SetText("Not restarted, " + reason)
+	} else if changed {
 		if walk.MsgBox(mw.WindowBase.Form(), "Restart Device?", `Your changes will not take effect until the device is restarted.
 
 Would you like to attempt to restart the device now?`, walk.MsgBoxYesNo) == 6 {
-			propChangeParams := PropChangeParams{
-				ClassInstallHeader: *MakeClassInstallHeader(DIF_PROPERTYCHANGE),
-				StateChange:        DICS_PROPCHANGE,
-				Scope:              DICS_FLAG_GLOBAL,
-			}
-
-			if err := SetupDiSetClassInstallParams(handle, &newItem.Idata, &propChangeParams.ClassInstallHeader, uint32(unsafe.Sizeof(propChangeParams))); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := SetupDiCallClassInstaller(DIF_PROPERTYCHANGE, handle, &newItem.Idata); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := SetupDiSetClassInstallParams(handle, &newItem.Idata, &propChangeParams.ClassInstallHeader, uint32(unsafe.Sizeof(propChangeParams))); err != nil {
-				log.Println(err)
-				return
-			}
-
-			if err := SetupDiCallClassInstaller(DIF_PROPERTYCHANGE, handle, &newItem.Idata); err != nil {
-				log.Println(err)
-				return
-			}
-
-			DeviceInstallParams, err := SetupDiGetDeviceInstallParams(handle, &newItem.Idata)
+			needReboot, err := source.Restart(newItem)
 			if err != nil {
 				log.Println(err)
 				return
 			}
 
-			if DeviceInstallParams.Flags&DI_NEEDREBOOT != 0 {
+			if needReboot {
 				walk.MsgBox(mw.WindowBase.Form(), "Notice", "Device could not be restarted. Changes will take effect the next time you reboot.", walk.MsgBoxOK)
 			} else {
 				walk.MsgBox(mw.WindowBase.Form(), "Notice", "Device successfully restarted.", walk.MsgBoxOK)
@@ -452,6 +420,133 @@ Would you like to attempt to restart the device now?`, walk.MsgBoxYesNo) == 6 {
 	}
 }
 
+func (mw *MyMainWindow) importRegFile() {
+	path, err := os.Getwd()
+	if err != nil {
+		log.Println(err)
+	}
+
+	filePath, cancel, err := openFileExplorer(mw, path, "Import settings", "Registry File (*.reg)|*.reg")
+	if cancel || err != nil {
+		return
+	}
+
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		walk.MsgBox(mw, "Import Error", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+
+	rf, err := ParseRegFile(data)
+	if err != nil {
+		walk.MsgBox(mw, "Import Error", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+
+	changes, warnings := planImport(rf, mw.model.items)
+	var preview []string
+	for _, change := range changes {
+		preview = append(preview, change.String())
+	}
+	for _, warning := range warnings {
+		preview = append(preview, "Warning: "+warning)
+	}
+	if len(changes) == 0 {
+		preview = append(preview, "Nothing to change.")
+		walk.MsgBox(mw, "Import", strings.Join(preview, "\n\n"), walk.MsgBoxOK)
+		return
+	}
+
+	plan, err := importPlan(changes)
+	if err != nil {
+		walk.MsgBox(mw, "Import Error", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	if flagDryRun {
+		showDryRun(mw, plan)
+		return
+	}
+
+	if walk.MsgBox(mw, "Apply Settings?", strings.Join(preview, "\n\n"), walk.MsgBoxYesNo) != walk.DlgCmdYes {
+		return
+	}
+
+	if err := applyImport(changes, plan); err != nil {
+		// show what was written before the error, not what was planned
+		for i := range mw.model.items {
+			readSettings(&mw.model.items[i])
+		}
+		mw.tv.SetModel(mw.model)
+		walk.MsgBox(mw, "Import Error", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	mw.tv.SetModel(mw.model)
+
+	if walk.MsgBox(mw, "Restart Devices?", `Your changes will not take effect until the devices are restarted.
+
+Would you like to attempt to restart the devices now?`, walk.MsgBoxYesNo) != walk.DlgCmdYes {
+		mw.sbi.SetText("Restart required")
+		return
+	}
+
+	var failed, skipped []string
+	for _, change := range changes {
+		if reason := restartSkipped(change.Device); reason != "" {
+			skipped = append(skipped, deviceTitle(change.Device)+": "+reason)
+			continue
+		}
+		needReboot, err := source.Restart(change.Device)
+		if err != nil {
+			log.Println(err)
+		}
+		if err != nil || needReboot {
+			failed = append(failed, deviceTitle(change.Device))
+		}
+	}
+	var notice string
+	if len(failed) != 0 {
+		notice = "Devices could not be restarted. Changes will take effect the next time you reboot.\n\n" + strings.Join(failed, "\n")
+	} else {
+		notice = "Devices successfully restarted."
+	}
+	if len(skipped) != 0 {
+		notice += "\n\nInactive devices were not restarted:\n\n" + strings.Join(skipped, "\n")
+	}
+	walk.MsgBox(mw, "Notice", notice, walk.MsgBoxOK)
+}
+
+// showDryRun shows the registry operations of plan instead of performing them.
+func showDryRun(owner walk.Form, plan *Plan) {
+	if plan.Empty() {
+		walk.MsgBox(owner, "Dry Run", "Nothing to change.", walk.MsgBoxOK)
+		return
+	}
+	walk.MsgBox(owner, "Dry Run", "Started with -dry-run, nothing was changed. These registry operations would be performed:\n\n"+strings.Join(plan.Lines(), "\n"), walk.MsgBoxOK)
+}
+
+func (mw *MyMainWindow) backupAll() {
+	path, err := os.Getwd()
+	if err != nil {
+		log.Println(err)
+	}
+
+	computerName, err := os.Hostname()
+	if err != nil {
+		computerName = "unknown"
+	}
+
+	filePath, cancel, err := saveFileExplorer(mw, path, backupFileName(time.Now(), computerName), "Backup all devices", "Registry File (*.reg)|*.reg")
+	if cancel || err != nil {
+		return
+	}
+
+	if _, err := writeMachineBackup(filePath, mw.model.items); err != nil {
+		walk.MsgBox(mw, "Backup Error", err.Error(), walk.MsgBoxIconError)
+		return
+	}
+	mw.sbi.SetText("Backup written to " + filePath)
+}
+
 func (mw *MyMainWindow) TextWidthSize(text string) int {
 	canvas, err := (*mw.tv).CreateCanvas()
 	if err != nil {
@@ -475,21 +570,3 @@ type Model struct {
 func (m *Model) Items() interface{} {
 	return m.items
 }
-
-func sortNumbers(data []string) ([]string, error) {
-	var lastErr error
-	sort.Slice(data, func(i, j int) bool {
-		a, err := strconv.ParseInt(data[i], 10, 64)
-		if err != nil {
-			lastErr = err
-			return false
-		}
-		b, err := strconv.ParseInt(data[j], 10, 64)
-		if err != nil {
-			lastErr = err
-			return false
-		}
-		return a < b
-	})
-	return data, lastErr
-}
@@ -0,0 +1,48 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+)
+
+// On other systems the commands work with the devices of -offline or
+// -machine-fixture and the processors of -machine-fixture, which is what
+// the tests and CI use. The main window and the running system need Windows.
+
+var errNotWindows = errors.New("the running system can only be read on Windows, use -offline or -machine-fixture")
+
+// deviceHandle holds the SetupAPI handles on Windows.
+type deviceHandle struct{}
+
+func main() {
+	parseFlags()
+	cs.Init()
+
+	if flag.NArg() == 0 {
+		log.Println("the main window needs Windows, run a command instead, e.g. list")
+		os.Exit(1)
+	}
+	exitCLI(runCommand(flag.Args()))
+}
+
+func liveDeviceSource() (DeviceSource, error) {
+	return nil, errNotWindows
+}
+
+func cpuSetInformation() ([]byte, error) {
+	return nil, errNotWindows
+}
+
+func logicalProcessorInformation() ([]byte, error) {
+	return nil, errNotWindows
+}
+
+// GetSystemInfo returns no information, a capture replayed with
+// -topology-file sets sysInfo.
+func GetSystemInfo() SystemInfo {
+	return SystemInfo{}
+}
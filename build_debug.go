@@ -0,0 +1,6 @@
+//go:build debug
+
+package main
+
+// debugBuild is set by "go build -tags debug", see build.bat.
+const debugBuild = true
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+// loadDevices enumerates the devices of the source chosen by the global
+// options, see openDeviceSource.
+func loadDevices() ([]Device, error) {
+	if source == nil {
+		s, err := openDeviceSource()
+		if err != nil {
+			return nil, err
+		}
+		source = s
+	}
+	return source.Devices()
+}
+
+// exitCLI closes the device source, which saves an offline hive, and exits.
+func exitCLI(code int) {
+	if source != nil {
+		if err := source.Close(); err != nil {
+			log.Println(err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
+
+// importCLI previews and applies a .reg file. The return value is the exit code.
+func importCLI(path string, devices []Device, restart bool) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	rf, err := ParseRegFile(data)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	changes, warnings := planImport(rf, devices)
+	for _, warning := range warnings {
+		fmt.Fprintln(out, "Warning:", warning)
+	}
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "Nothing to change.")
+		return 0
+	}
+
+	for _, change := range changes {
+		fmt.Fprintln(out, change)
+	}
+
+	plan, err := importPlan(changes)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if applied, err := runPlan(plan); err != nil || !applied {
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		fmt.Fprintln(out, "Dry run, nothing was changed.")
+		return 0
+	}
+
+	changed := make([]*Device, len(changes))
+	for i := range changes {
+		*changes[i].Device = changes[i].After
+		changed[i] = changes[i].Device
+		recordChange(&changes[i].Before, changes[i].Device)
+	}
+	return restartChanged(changed, restart)
+}
+
+// runPlan lists the registry operations of plan with -dry-run and otherwise
+// performs them. applied is false for a dry run. The plan is applied only
+// here, afterwards the callers just update their devices.
+func runPlan(plan *Plan) (applied bool, err error) {
+	recordPlan(plan)
+	if !flagDryRun {
+		return true, plan.Apply()
+	}
+	for _, line := range plan.Lines() {
+		fmt.Fprintln(out, "  "+line)
+	}
+	return false, nil
+}
+
+// backupCLI writes the settings of all devices into one .reg file.
+func backupCLI(path string, devices []Device) int {
+	fileName, err := writeMachineBackup(path, devices)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	fmt.Fprintln(out, "Backup written to", fileName)
+	return 0
+}
+
+// restartChanged restarts the changed devices if restart is set, otherwise it
+// only reports that a restart is required.
+func restartChanged(changed []*Device, restart bool) int {
+	if !canRestart() {
+		if len(changed) != 0 {
+			fmt.Fprintln(out, "Offline hive, changes will take effect the next time Windows boots.")
+		}
+		for _, dev := range changed {
+			dev.RebootRequired = true
+			recordRestart(dev, false)
+		}
+		return 0
+	}
+	for _, dev := range changed {
+		if reason := restartSkipped(dev); reason != "" {
+			fmt.Fprintf(out, "%s: Not restarted, %s.\n", deviceTitle(dev), reason)
+			continue
+		}
+		if !restart {
+			fmt.Fprintf(out, "%s: Restart required\n", deviceTitle(dev))
+			continue
+		}
+
+		needReboot, err := source.Restart(dev)
+		if err == nil {
+			dev.RebootRequired = needReboot
+			recordRestart(dev, !needReboot)
+		}
+		switch {
+		case err != nil:
+			log.Println(err)
+			return 1
+		case needReboot:
+			fmt.Fprintf(out, "%s: Device could not be restarted. Changes will take effect the next time you reboot.\n", deviceTitle(dev))
+		default:
+			fmt.Fprintf(out, "%s: Device successfully restarted.\n", deviceTitle(dev))
+		}
+	}
+	return 0
+}
+
+// recommendCLI shows the recommended settings and why, and writes them unless it is a dry run.
+func recommendCLI(devices []Device, restart bool) int {
+	var changed []*Device
+	for _, r := range Recommend(devices, &cs) {
+		fmt.Fprintf(out, "%s [%s]\n", deviceTitle(r.Device), r.Kind)
+		for _, reason := range r.Reasons {
+			fmt.Fprintln(out, "  - "+reason)
+		}
+		if !r.Changed() {
+			continue
+		}
+		for _, line := range describeChanges(r.Device, &r.After) {
+			fmt.Fprintln(out, "  "+line)
+		}
+
+		var plan Plan
+		if err := plan.Add(r.Device, &r.After); err != nil {
+			log.Println(err)
+			return 1
+		}
+		applied, err := runPlan(&plan)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if !applied {
+			continue
+		}
+		before := *r.Device
+		*r.Device = r.After
+		recordChange(&before, r.Device)
+		changed = append(changed, r.Device)
+	}
+	if flagDryRun {
+		fmt.Fprintln(out, "Dry run, nothing was changed. Run with -apply to write the recommended settings.")
+		return 0
+	}
+	if len(changed) == 0 {
+		fmt.Fprintln(out, "Nothing to change.")
+		return 0
+	}
+	return restartChanged(changed, restart)
+}
+
+// profileApplyCLI writes the settings of a profile to the matching devices.
+func profileApplyCLI(path string, devices []Device, restart bool) int {
+	profile, err := LoadProfile(path)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	results, unmatched := profile.Resolve(devices)
+	for _, entry := range unmatched {
+		fmt.Fprintf(out, "Warning: no device matches %q\n", entry.title())
+	}
+
+	var changed []*Device
+	for i := range results {
+		result := &results[i]
+		if overlap := result.overlap(); overlap != "" {
+			fmt.Fprintln(out, "Warning: "+overlap)
+		}
+		if !result.Changed() {
+			continue
+		}
+		fmt.Fprintln(out, deviceTitle(result.Device))
+		for _, line := range describeChanges(&result.Before, &result.After) {
+			fmt.Fprintln(out, "  "+line)
+		}
+		plan, err := result.Plan()
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		applied, err := runPlan(plan)
+		if err != nil {
+			log.Println(err)
+			return 1
+		}
+		if !applied {
+			continue
+		}
+		*result.Device = result.After
+		recordChange(&result.Before, result.Device)
+		changed = append(changed, result.Device)
+	}
+	if flagDryRun {
+		fmt.Fprintln(out, "Dry run, nothing was changed.")
+		return 0
+	}
+	if len(changed) == 0 {
+		fmt.Fprintln(out, "Nothing to change.")
+		return 0
+	}
+	return restartChanged(changed, restart)
+}
+
+// profileVerifyCLI compares the devices with a profile. It returns 2 if they differ.
+func profileVerifyCLI(path string, devices []Device) int {
+	profile, err := LoadProfile(path)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	code := 0
+	results, unmatched := profile.Resolve(devices)
+	for _, entry := range unmatched {
+		fmt.Fprintf(out, "No device matches %q\n", entry.title())
+		code = 2
+	}
+	for i := range results {
+		result := &results[i]
+		if !result.Changed() {
+			fmt.Fprintf(out, "%s: OK\n", deviceTitle(result.Device))
+			continue
+		}
+		fmt.Fprintf(out, "%s: differs\n", deviceTitle(result.Device))
+		for _, line := range describeChanges(&result.Before, &result.After) {
+			fmt.Fprintln(out, "  "+line)
+		}
+		code = 2
+	}
+	return code
+}
+
+// profileGenerateCLI writes a profile of the current settings.
+func profileGenerateCLI(path string, devices []Device) int {
+	profile := GenerateProfile(devices)
+	if err := profile.Save(path); err != nil {
+		log.Println(err)
+		return 1
+	}
+	fmt.Fprintf(out, "Profile with %d devices written to %s\n", len(profile.Devices), path)
+	return 0
+}
+
+// reconcileCLI reports drift against a profile as JSON and optionally fixes
+// it. The report goes to reportPath or to stdout if it is empty.
+func reconcileCLI(path string, devices []Device, fix bool, reportPath string) int {
+	profile, err := LoadProfile(path)
+	if err != nil {
+		log.Println(err)
+		return ReconcileError
+	}
+
+	restart := source.Restart
+	if !canRestart() {
+		restart = nil
+	}
+	report := reconcile(profile, path, devices, fix, restart)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Println(err)
+		return ReconcileError
+	}
+
+	if reportPath == "" {
+		fmt.Println(string(data))
+	} else if err := os.WriteFile(reportPath, data, 0o644); err != nil {
+		log.Println(err)
+		return ReconcileError
+	}
+	return report.ExitCode
+}
+
+// lintCLI prints the findings of every device. Exit code 2 if there are errors.
+func lintCLI(devices []Device) int {
+	findings := Lint(devices, &cs)
+	for _, finding := range findings {
+		fmt.Fprintf(out, "%s: %s: %s [%s]\n", finding.Severity, deviceTitle(finding.Device), finding.Message, finding.Rule)
+	}
+	if len(findings) == 0 {
+		fmt.Fprintln(out, "No problems found.")
+	}
+
+	if severity, found := maxSeverity(findings); found && severity == SeverityError {
+		return 2
+	}
+	return 0
+}
+
+// legacyCLI runs the flat options used before the commands existed.
+func legacyCLI(devices []Device) int {
+	restart := flagRestart || flagRestartOnChange
+	switch {
+	case flagImport != "":
+		return importCLI(flagImport, devices, restart)
+	case flagBackup != "":
+		return backupCLI(flagBackup, devices)
+	case flagLint:
+		return lintCLI(devices)
+	case flagReconcile != "":
+		return reconcileCLI(flagReconcile, devices, flagFix, flagReport)
+	case flagProfileApply != "":
+		return profileApplyCLI(flagProfileApply, devices, restart)
+	case flagProfileVerify != "":
+		return profileVerifyCLI(flagProfileVerify, devices)
+	case flagProfileGenerate != "":
+		return profileGenerateCLI(flagProfileGenerate, devices)
+	}
+
+	selector := flagDevObjName
+	if flagInstance != "" {
+		selector = flagInstance
+	}
+	if selector == "" {
+		fmt.Fprintln(os.Stderr, "no device selected, use -devobj or -instance")
+		return exitUsage
+	}
+	dev, code, ok := selectDeviceCLI(devices, selector)
+	if !ok {
+		return code
+	}
+
+	settings := deviceSettings{
+		MsiSupported:       flagMsiSupported,
+		MessageNumberLimit: flagMessageNumberLimit,
+		DevicePolicy:       flagDevicePolicy,
+		DevicePriority:     flagDevicePriority,
+		CPUs:               flagCPU,
+	}
+	changed := false
+	if !settings.empty() {
+		if changed, code = applySettings(dev, &settings); code != exitOK {
+			return code
+		}
+	}
+	if changed || flagRestart {
+		return restartChanged([]*Device{dev}, restart)
+	}
+	return exitOK
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// countingStore counts the writes to a memoryStore.
+type countingStore struct {
+	*memoryStore
+	writes int
+}
+
+func (s *countingStore) CreateKey(path string) error {
+	s.writes++
+	return s.memoryStore.CreateKey(path)
+}
+
+func (s *countingStore) DeleteKey(path string) error {
+	s.writes++
+	return s.memoryStore.DeleteKey(path)
+}
+
+func (s *countingStore) SetDWordValue(path, name string, value uint32) error {
+	s.writes++
+	return s.memoryStore.SetDWordValue(path, name, value)
+}
+
+func (s *countingStore) SetBinaryValue(path, name string, value []byte) error {
+	s.writes++
+	return s.memoryStore.SetBinaryValue(path, name, value)
+}
+
+func (s *countingStore) DeleteValue(path, name string) error {
+	s.writes++
+	return s.memoryStore.DeleteValue(path, name)
+}
+
+func discardOutput(t *testing.T) {
+	saved := out
+	t.Cleanup(func() { out = saved })
+	out = io.Discard
+}
+
+// TestCLIAppliesOnce expects -import and -profile-apply to perform every
+// registry operation of their plan once.
+func TestCLIAppliesOnce(t *testing.T) {
+	discardOutput(t)
+	dir := t.TempDir()
+	regFile := filepath.Join(dir, "settings.reg")
+	profileFile := filepath.Join(dir, "profile.yaml")
+	if err := os.WriteFile(regFile, createRegFile(testRegPath, &Device{DevicePolicy: IrqPolicySpecifiedProcessors, AssignmentSetOverride: NewCPUMask(2, 3), MsiSupported: 1}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(profileFile, []byte(overlappingProfile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(devices []Device) int{
+		"import":        func(devices []Device) int { return importCLI(regFile, devices, false) },
+		"profile-apply": func(devices []Device) int { return profileApplyCLI(profileFile, devices, false) },
+	}
+	for name, run := range tests {
+		t.Run(name, func(t *testing.T) {
+			devices := reconcileTestDevices(t)
+			devices[0].RegPath = testRegPath
+			stores := make([]*countingStore, len(devices))
+			for i := range devices {
+				stores[i] = &countingStore{memoryStore: devices[i].store.(*memoryStore)}
+				devices[i].store = stores[i]
+			}
+			before := make([]Device, len(devices))
+			copy(before, devices)
+
+			saved := cliResult
+			t.Cleanup(func() { cliResult = saved })
+			cliResult = &Result{index: map[*Device]int{}}
+			if code := run(devices); code != 0 {
+				t.Fatalf("exit code %d", code)
+			}
+
+			var writes int
+			for _, store := range stores {
+				writes += store.writes
+			}
+			if writes == 0 || writes != len(cliResult.Operations) {
+				t.Errorf("%d writes for %d planned operations", writes, len(cliResult.Operations))
+			}
+			if !affinityChanged(&before[0], &devices[0]) {
+				t.Error("the device was not updated")
+			}
+		})
+	}
+}
@@ -0,0 +1,1022 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// Exit codes of the commands. reconcile uses its own codes, see ReconcileInSync.
+const (
+	exitOK       = 0
+	exitError    = 1  // the command failed
+	exitProblems = 2  // lint found errors or the devices differ from a profile
+	exitNotFound = 4  // no device matches the argument
+	exitUsage    = 64 // invalid options or arguments
+)
+
+var errNoDevice = errors.New("no device matches")
+
+type command struct {
+	Name    string
+	Args    string // shown in the usage, e.g. "<device>"
+	Summary string
+	Run     func(name string, args []string) int
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"list", "", "List devices and their interrupt settings.", listCommand},
+		{"get", "<device>", "Show all settings of a device.", getCommand},
+		{"set", "<device>", "Change the interrupt settings of a device.", setCommand},
+		{"export", "[<device>]", "Write the settings of a device, or of all devices, into a .reg file.", exportCommand},
+		{"import", "<file.reg>", "Apply the settings of a .reg file.", importCommand},
+		{"simulate", "<device>", "Predict the processors that receive the interrupts of a device, with its settings or the ones given as options.", simulateCommand},
+		{"restart", "<device>...", "Restart devices so they pick up their new settings.", restartCommand},
+		{"topology", "", "Show the processor topology: groups, cores, caches, NUMA nodes.", topologyCommand},
+		{"capture", "<file>", "Write the raw processor information (CPU sets, system info, CPUID) to a file for bug reports.", captureCommand},
+		{"record", "<file>", "Write the devices, their interrupt settings and the processor topology to a machine fixture (.json), replay it with -machine-fixture.", recordCommand},
+		{"cpumap", "", "List the devices targeting every processor, pinned or by their policy, and flag processors shared by pinned devices.", cpumapCommand},
+		{"recommend", "", "Propose affinity, MSI and message limit settings for GPUs, network and USB controllers from the processor topology.", recommendCommand},
+		{"lint", "", "Check all devices for invalid or risky settings. Exit code 2 on errors.", lintCommand},
+		{"profile", "apply|verify|generate <file>", "Apply, verify (exit code 2 if different) or generate a device profile (.json, .yaml).", profileCommand},
+		{"reconcile", "<profile>", "Report drift against a profile as JSON. Exit code 0=in sync, 1=error, 2=drift or a missing device, 3=drift fixed.", reconcileCommand},
+		{"tui", "", "Full screen terminal interface for SSH and remote shell sessions.", tuiCommand},
+		{"help", "[<command>]", "Show the help of a command.", helpCommand},
+	}
+	if debugBuild {
+		commands = append(commands, command{"fixtures", "[<file|dir>...]", "Check topology fixtures and captures against their expected values (default fixtures/topology). Exit code 2 on differences.", fixturesCommand})
+	}
+}
+
+func programName() string {
+	return filepath.Base(os.Args[0])
+}
+
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].Name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// printUsage prints the commands and the global options.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [global options] <command> [options] [arguments]\n\n", programName())
+	fmt.Fprintln(w, "Without a command the main window is opened.")
+	fmt.Fprintln(w, "\nCommands:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.Name, cmd.Summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\nA <device> is the device object name (\\Device\\00000123), the instance ID,")
+	fmt.Fprintln(w, "the PCI location (bus:device.function) or a unique part of the name.")
+	fmt.Fprintln(w, "\nExit codes: 0=ok, 1=error, 2=problems found, 4=device not found, 64=invalid arguments")
+	fmt.Fprintln(w, "\nGlobal options:")
+	fmt.Fprintln(w, "  -offline string\n    \tWork on an offline SYSTEM hive (Windows\\System32\\config\\SYSTEM) instead of the running system")
+	fmt.Fprintln(w, "  -include-inactive\n    \tAlso list disabled and not present (phantom) devices, e.g. to set the MSI mode of an unplugged device in advance")
+	fmt.Fprintln(w, "  -machine-fixture string\n    \tReplay the devices and processors of a file written by the record command, changes are not saved")
+	fmt.Fprintf(w, "\nRun \"%s help <command>\" for the options of a command.\n", programName())
+}
+
+// runCommand runs the command in args[0] and returns the exit code.
+func runCommand(args []string) int {
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	return cmd.Run(cmd.Name, args[1:])
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	cmd := findCommand(name)
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintf(out, "Usage: %s %s [options] %s\n\n%s\n", programName(), cmd.Name, cmd.Args, cmd.Summary)
+		hasOptions := false
+		fs.VisitAll(func(*flag.Flag) { hasOptions = true })
+		if hasOptions {
+			fmt.Fprintln(out, "\nOptions:")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseArgs parses the options of a command, which may come before or after
+// the arguments, and checks the number of arguments (max -1 is unlimited).
+func parseArgs(fs *flag.FlagSet, args []string, min, max int) ([]string, int, bool) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, exitOK, false
+			}
+			return nil, exitUsage, false
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) < min || (max != -1 && len(positional) > max) {
+		fmt.Fprintf(fs.Output(), "%s: wrong number of arguments\n\n", fs.Name())
+		fs.Usage()
+		return nil, exitUsage, false
+	}
+	return positional, exitOK, true
+}
+
+// addDryRunFlag adds -dry-run to a command that writes settings. It sets the
+// same variable as the global option, so it may come before or after the command.
+func addDryRunFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&flagDryRun, "dry-run", flagDryRun, "Print the registry operations without changing anything")
+}
+
+// loadDevicesCLI loads the devices and logs the error.
+func loadDevicesCLI() ([]Device, int, bool) {
+	devices, err := loadDevices()
+	if err != nil {
+		log.Println(err)
+		return nil, exitError, false
+	}
+	return devices, exitOK, true
+}
+
+// matchesSearch reports whether the name, friendly name, location, device
+// object name, PCI address, class, service, instance, hardware, parent or
+// container ID contains text, which has to be lower case.
+func matchesSearch(dev *Device, text string) bool {
+	for _, id := range dev.DeviceIDs {
+		if strings.Contains(strings.ToLower(id), text) {
+			return true
+		}
+	}
+	return strings.Contains(strings.ToLower(dev.DeviceDesc), text) ||
+		strings.Contains(strings.ToLower(dev.DevObjName), text) ||
+		strings.Contains(strings.ToLower(dev.LocationInformation), text) ||
+		strings.Contains(strings.ToLower(dev.FriendlyName), text) ||
+		strings.Contains(dev.PCI.String(), text) ||
+		strings.Contains(strings.ToLower(dev.Class), text) ||
+		strings.Contains(strings.ToLower(dev.Driver), text) ||
+		strings.Contains(strings.ToLower(dev.InstanceID), text) ||
+		strings.Contains(strings.ToLower(dev.Parent), text) ||
+		strings.Contains(strings.ToLower(dev.ContainerID), text)
+}
+
+// selectDevice finds the device named by selector: the device object name,
+// the instance ID, the PCI location or a part of the name that is unique.
+func selectDevice(devices []Device, selector string) (*Device, error) {
+	pci, pciErr := parsePCILocation(selector)
+	for i := range devices {
+		dev := &devices[i]
+		if (dev.DevObjName != "" && strings.EqualFold(dev.DevObjName, selector)) ||
+			(dev.InstanceID != "" && strings.EqualFold(dev.InstanceID, selector)) ||
+			(pciErr == nil && dev.PCI == pci) {
+			return dev, nil
+		}
+	}
+
+	var matches []*Device
+	text := strings.ToLower(selector)
+	for i := range devices {
+		if matchesSearch(&devices[i], text) {
+			matches = append(matches, &devices[i])
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w %q", errNoDevice, selector)
+	case 1:
+		return matches[0], nil
+	}
+
+	names := make([]string, len(matches))
+	for i, dev := range matches {
+		names[i] = "  " + deviceID(dev) + "  " + dev.DeviceDesc
+	}
+	return nil, fmt.Errorf("%q matches %d devices, use one of:\n%s", selector, len(matches), strings.Join(names, "\n"))
+}
+
+// selectDeviceCLI is selectDevice with the error printed and turned into an exit code.
+func selectDeviceCLI(devices []Device, selector string) (*Device, int, bool) {
+	dev, err := selectDevice(devices, selector)
+	switch {
+	case errors.Is(err, errNoDevice):
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitNotFound, false
+	case err != nil:
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitUsage, false
+	}
+	return dev, exitOK, true
+}
+
+// deviceID is the shortest unique name of a device for the command line.
+func deviceID(dev *Device) string {
+	if dev.DevObjName != "" {
+		return dev.DevObjName
+	}
+	return dev.InstanceID
+}
+
+// deviceClass returns the setup class with its GUID, e.g. "Net {4d36e972-e325-11ce-bfc1-08002be10318}".
+func deviceClass(dev *Device) string {
+	return strings.TrimSpace(dev.Class + " " + dev.ClassGUID)
+}
+
+// firstString returns the first of a list like the hardware IDs, the most specific one.
+func firstString(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
+
+func numaNodeName(node int) string {
+	if node < 0 {
+		return ""
+	}
+	return strconv.Itoa(node)
+}
+
+func msiName(msi uint32) string {
+	switch msi {
+	case 0:
+		return "off"
+	case 1:
+		return "on"
+	default:
+		return "-"
+	}
+}
+
+func listCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	search := fs.String("search", "", "Only devices whose name, location or device object name contains the text")
+	msiOnly := fs.Bool("msi", false, "Only devices with MSI enabled")
+	policy := fs.Int("policy", -1, "Only devices with this DevicePolicy (0-5)")
+	changed := fs.Bool("changed", false, "Only devices with settings that are not the default")
+	problems := fs.Bool("problems", false, "Only devices with lint findings")
+	format := addFormatFlag(fs)
+	if _, code, ok := parseArgs(fs, args, 0, 0); !ok {
+		return code
+	}
+	if *policy < -1 || *policy > IrqPolicySpreadMessagesAcrossAllProcessors {
+		fmt.Fprintf(os.Stderr, "invalid -policy %d\n", *policy)
+		return exitUsage
+	}
+	if !validFormat(*format) {
+		return exitUsage
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+
+	var listed []*Device
+	for i := range devices {
+		dev := &devices[i]
+		switch {
+		case *search != "" && !matchesSearch(dev, strings.ToLower(*search)):
+			continue
+		case *msiOnly && dev.MsiSupported != 1:
+			continue
+		case *policy != -1 && dev.DevicePolicy != uint32(*policy):
+			continue
+		case *changed && dev.DevicePolicy == 0 && dev.DevicePriority == 0 && dev.AssignmentSetOverride.IsZero() && dev.MsiSupported != 1 && dev.MessageNumberLimit == 0:
+			continue
+		case *problems && len(lintDevice(dev, &cs)) == 0:
+			continue
+		}
+		listed = append(listed, dev)
+	}
+
+	if *format != formatText {
+		if err := writeDevices(os.Stdout, *format, listed); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitError
+		}
+		return exitOK
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DEVICE\tNAME\tMSI\tLIMIT\tPOLICY\tPRIORITY\tCPUS")
+	for _, dev := range listed {
+		limit := ""
+		if dev.MessageNumberLimit != 0 {
+			limit = fmt.Sprint(dev.MessageNumberLimit)
+		}
+		name := dev.DeviceDesc
+		if dev.State != 0 {
+			name += " (" + dev.State.String() + ")"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", deviceID(dev), name, msiName(dev.MsiSupported), limit, policyName(dev.DevicePolicy), priorityName(dev.DevicePriority), dev.AssignmentSetOverride)
+	}
+	tw.Flush()
+	return exitOK
+}
+
+func getCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	format := addFormatFlag(fs)
+	positional, code, ok := parseArgs(fs, args, 1, 1)
+	if !ok {
+		return code
+	}
+	if !validFormat(*format) {
+		return exitUsage
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	dev, code, ok := selectDeviceCLI(devices, positional[0])
+	if !ok {
+		return code
+	}
+
+	var err error
+	switch *format {
+	case formatJSON:
+		findings := lintDevice(dev, &cs)
+		if findings == nil {
+			findings = []Finding{}
+		}
+		err = writeJSON(os.Stdout, struct {
+			DeviceRecord
+			Findings []Finding `json:"findings"`
+		}{newDeviceRecord(dev), findings})
+	case formatCSV:
+		err = writeDevices(os.Stdout, *format, []*Device{dev})
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	if *format != formatText {
+		return exitOK
+	}
+
+	lastChange, rebootRequired := "", ""
+	if !dev.LastChange.IsZero() {
+		lastChange = dev.LastChange.Format("2006-01-02 15:04:05")
+	}
+	if dev.RebootRequired {
+		rebootRequired = "yes"
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, field := range [][2]string{
+		{"Name", dev.DeviceDesc},
+		{"Friendly Name", dev.FriendlyName},
+		{"Device Object", dev.DevObjName},
+		{"Instance ID", dev.InstanceID},
+		{"Hardware IDs", strings.Join(dev.DeviceIDs, ", ")},
+		{"Compatible IDs", strings.Join(dev.CompatibleIDs, ", ")},
+		{"Class", deviceClass(dev)},
+		{"Service", dev.Driver},
+		{"Parent", dev.Parent},
+		{"Container ID", dev.ContainerID},
+		{"Location", dev.LocationInformation},
+		{"Location Paths", strings.Join(dev.LocationPaths, ", ")},
+		{"PCI", dev.PCI.String()},
+		{"NUMA Node", numaNodeName(dev.NumaNode)},
+		{"Registry", dev.RegPath},
+		{"Interrupt Type", interruptType(dev.InterruptTypeMap)},
+		{"MSI", msiName(dev.MsiSupported)},
+		{"MessageNumberLimit", fmt.Sprint(dev.MessageNumberLimit)},
+		{"MaxMSILimit", fmt.Sprint(dev.MaxMSILimit)},
+		{"DevicePolicy", fmt.Sprintf("%d (%s)", dev.DevicePolicy, policyName(dev.DevicePolicy))},
+		{"DevicePriority", fmt.Sprintf("%d (%s)", dev.DevicePriority, priorityName(dev.DevicePriority))},
+		{"AssignmentSetOverride", dev.AssignmentSetOverride.String()},
+		{"Last Change", lastChange},
+		{"Reboot Required", rebootRequired},
+		{"State", dev.State.String()},
+	} {
+		if field[1] != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", field[0], field[1])
+		}
+	}
+	tw.Flush()
+
+	for _, finding := range lintDevice(dev, &cs) {
+		fmt.Printf("%s: %s [%s]\n", finding.Severity, finding.Message, finding.Rule)
+	}
+	return exitOK
+}
+
+// deviceSettings are the changes requested by set and the legacy flags.
+// -1 and "" keep the current value.
+type deviceSettings struct {
+	MsiSupported       int
+	MessageNumberLimit int
+	DevicePolicy       int
+	DevicePriority     int
+	CPUs               string
+}
+
+func (s *deviceSettings) empty() bool {
+	return s.MsiSupported == -1 && s.MessageNumberLimit == -1 && s.DevicePolicy == -1 && s.DevicePriority == -1 && s.CPUs == ""
+}
+
+// desired returns dev with the settings applied, or an error if they are invalid.
+func (s *deviceSettings) desired(dev *Device) (Device, error) {
+	after := *dev
+	switch {
+	case s.MsiSupported < -1 || s.MsiSupported > 1:
+		return after, fmt.Errorf("invalid MSISupported %d, expected 0 or 1", s.MsiSupported)
+	case s.MessageNumberLimit < -1:
+		return after, fmt.Errorf("invalid MessageNumberLimit %d", s.MessageNumberLimit)
+	case s.DevicePolicy < -1 || s.DevicePolicy > IrqPolicySpreadMessagesAcrossAllProcessors:
+		return after, fmt.Errorf("invalid DevicePolicy %d, expected 0-5", s.DevicePolicy)
+	case s.DevicePriority < -1 || s.DevicePriority > 3:
+		return after, fmt.Errorf("invalid DevicePriority %d, expected 0-3", s.DevicePriority)
+	}
+
+	if s.MsiSupported != -1 {
+		after.MsiSupported = uint32(s.MsiSupported)
+	}
+	if s.MessageNumberLimit != -1 {
+		after.MessageNumberLimit = uint32(s.MessageNumberLimit)
+	}
+	if s.DevicePolicy != -1 {
+		after.DevicePolicy = uint32(s.DevicePolicy)
+	}
+	if s.DevicePriority != -1 {
+		after.DevicePriority = uint32(s.DevicePriority)
+	}
+	if s.CPUs != "" {
+		if after.DevicePolicy != IrqPolicySpecifiedProcessors {
+			return after, fmt.Errorf("processors can only be set with DevicePolicy 4 (Specified Processors)")
+		}
+		mask, err := parseCPUList(s.CPUs)
+		if err != nil {
+			return after, err
+		}
+		after.AssignmentSetOverride = mask
+	}
+	if after.DevicePolicy == IrqPolicySpecifiedProcessors && after.AssignmentSetOverride.IsZero() {
+		return after, fmt.Errorf("DevicePolicy 4 (Specified Processors) needs at least one processor")
+	}
+	return after, nil
+}
+
+// applySettings writes the settings to dev and prints the changes.
+func applySettings(dev *Device, settings *deviceSettings) (changed bool, code int) {
+	after, err := settings.desired(dev)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false, exitUsage
+	}
+
+	if !msiChanged(dev, &after) && !affinityChanged(dev, &after) {
+		fmt.Fprintln(out, "Nothing to change.")
+		return false, exitOK
+	}
+	fmt.Fprintln(out, deviceTitle(dev))
+	for _, line := range describeChanges(dev, &after) {
+		fmt.Fprintln(out, "  "+line)
+	}
+
+	var plan Plan
+	if err := plan.Add(dev, &after); err != nil {
+		log.Println(err)
+		return false, exitError
+	}
+	applied, err := runPlan(&plan)
+	if err != nil {
+		log.Println(err)
+		return false, exitError
+	}
+	if !applied {
+		fmt.Fprintln(out, "Dry run, nothing was changed.")
+		return false, exitOK
+	}
+
+	before := *dev
+	*dev = after
+	recordChange(&before, dev)
+	return true, exitOK
+}
+
+func setCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	settings := deviceSettings{}
+	fs.IntVar(&settings.MsiSupported, "msi", -1, "MSI mode: 0=Off, 1=On")
+	fs.IntVar(&settings.MessageNumberLimit, "limit", -1, "MessageNumberLimit, 0 removes the limit")
+	fs.IntVar(&settings.DevicePolicy, "policy", -1, "0=Default, 1=All Close Proc, 2=One Close Proc, 3=All Proc in Machine, 4=Specified Proc, 5=Spread Messages Across All Proc")
+	fs.IntVar(&settings.DevicePriority, "priority", -1, "0=Undefined, 1=Low, 2=Normal, 3=High")
+	fs.StringVar(&settings.CPUs, "cpus", "", "Processors for DevicePolicy 4, e.g. 0,2,4-7, 1:0 (group relative), 0xF0, all,^0, pcores, ecores, no-smt, ccd1, llc0, numa1, core3, core3.t1, l2g0, ecluster0")
+	restart := fs.Bool("restart", false, "Restart the device if the settings changed")
+	addDryRunFlag(fs)
+	format := addFormatFlag(fs)
+	positional, code, ok := parseArgs(fs, args, 1, 1)
+	if !ok {
+		return code
+	}
+	if settings.empty() {
+		fmt.Fprintln(os.Stderr, "nothing to set")
+		fs.Usage()
+		return exitUsage
+	}
+	if !checkFormat(*format, name) {
+		return exitUsage
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	dev, code, ok := selectDeviceCLI(devices, positional[0])
+	if !ok {
+		return code
+	}
+
+	changed, code := applySettings(dev, &settings)
+	if code == exitOK && changed {
+		code = restartChanged([]*Device{dev}, *restart)
+	}
+	return writeResult(*format, code)
+}
+
+func simulateCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	settings := deviceSettings{}
+	fs.IntVar(&settings.MsiSupported, "msi", -1, "Simulate with MSI mode: 0=Off, 1=On")
+	fs.IntVar(&settings.MessageNumberLimit, "limit", -1, "Simulate with MessageNumberLimit, 0 removes the limit")
+	fs.IntVar(&settings.DevicePolicy, "policy", -1, "Simulate with DevicePolicy: 0=Default, 1=All Close Proc, 2=One Close Proc, 3=All Proc in Machine, 4=Specified Proc, 5=Spread Messages Across All Proc")
+	fs.StringVar(&settings.CPUs, "cpus", "", "Simulate with the processors for DevicePolicy 4")
+	numaNode := fs.Int("numa", -1, "NUMA node of the device, default the one Windows reports")
+	positional, code, ok := parseArgs(fs, args, 1, 1)
+	if !ok {
+		return code
+	}
+	settings.DevicePriority = -1
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	dev, code, ok := selectDeviceCLI(devices, positional[0])
+	if !ok {
+		return code
+	}
+	after, err := settings.desired(dev)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+
+	fmt.Println(deviceTitle(dev))
+	if *numaNode == -1 {
+		*numaNode = dev.NumaNode
+	}
+	simulation := SimulateInterrupts(&after, &cs, *numaNode)
+	for _, line := range simulation.Lines() {
+		fmt.Println("  " + line)
+	}
+	return exitOK
+}
+
+func exportCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	output := fs.String("o", "", "Output file or directory. Default: the current directory")
+	positional, code, ok := parseArgs(fs, args, 0, 1)
+	if !ok {
+		return code
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+
+	if len(positional) == 0 {
+		path := *output
+		if path == "" {
+			path = "."
+		}
+		return backupCLI(path, devices)
+	}
+
+	dev, code, ok := selectDeviceCLI(devices, positional[0])
+	if !ok {
+		return code
+	}
+	if dev.RegPath == "" {
+		fmt.Fprintf(os.Stderr, "%s: the registry location is unknown\n", deviceTitle(dev))
+		return exitError
+	}
+
+	path := *output
+	fileName := strings.ReplaceAll(dev.DeviceDesc, " ", "_") + ".reg"
+	if path == "" {
+		path = fileName
+	} else if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, fileName)
+	}
+	if err := os.WriteFile(path, createRegFile(dev.RegPath, dev), 0o644); err != nil {
+		log.Println(err)
+		return exitError
+	}
+	fmt.Fprintln(out, "Settings written to", path)
+	return exitOK
+}
+
+func importCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	restart := fs.Bool("restart", false, "Restart the changed devices")
+	addDryRunFlag(fs)
+	format := addFormatFlag(fs)
+	positional, code, ok := parseArgs(fs, args, 1, 1)
+	if !ok {
+		return code
+	}
+	if !checkFormat(*format, name) {
+		return exitUsage
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	return writeResult(*format, importCLI(positional[0], devices, *restart))
+}
+
+func restartCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	format := addFormatFlag(fs)
+	positional, code, ok := parseArgs(fs, args, 1, -1)
+	if !ok {
+		return code
+	}
+	if !checkFormat(*format, name) {
+		return exitUsage
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	if !canRestart() {
+		fmt.Fprintln(os.Stderr, errCannotRestart)
+		return exitUsage
+	}
+	var selected []*Device
+	for _, selector := range positional {
+		dev, code, ok := selectDeviceCLI(devices, selector)
+		if !ok {
+			return code
+		}
+		selected = append(selected, dev)
+	}
+	return writeResult(*format, restartChanged(selected, true))
+}
+
+func topologyCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	output := fs.String("o", "", "Write the topology as a fixture file (.json), e.g. to attach it to a bug report")
+	if _, code, ok := parseArgs(fs, args, 0, 0); !ok {
+		return code
+	}
+	if *output != "" {
+		if err := newTopologyFixture(cpuName(), &cs).Write(*output); err != nil {
+			log.Println(err)
+			return exitError
+		}
+		fmt.Fprintln(os.Stderr, "Topology written to", *output)
+		return exitOK
+	}
+
+	var features []string
+	if cs.HyperThreading {
+		features = append(features, "SMT")
+	}
+	if cs.EfficiencyClass {
+		features = append(features, "efficiency classes")
+	}
+	if cs.LastLevelCache {
+		features = append(features, "several last level caches")
+	}
+	if cs.NumaNode {
+		features = append(features, "NUMA")
+	}
+	fmt.Printf("%d logical processors in %d processor group(s)", len(cs.CPU), max(cs.Groups, 1))
+	if len(features) != 0 {
+		fmt.Printf(", %s", strings.Join(features, ", "))
+	}
+	fmt.Println()
+
+	l2 := map[int]string{}
+	if groups, err := cs.l2Groups(); err == nil {
+		for i, group := range groups {
+			for _, p := range group.Processors() {
+				l2[p] = strconv.Itoa(i)
+			}
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CPU\tGROUP\tINDEX\tCORE\tL2\tLLC\tNUMA\tCLASS\tSTATE")
+	for _, cpu := range cs.CPU {
+		group, ok := l2[cpu.Processor()]
+		if !ok {
+			group = "-"
+		}
+		state := cpu.Flags.String()
+		if state == "" {
+			state = "-"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%s\t%d\t%d\t%d\t%s\n", cpu.Processor(), cpu.Group, cpu.LogicalProcessorIndex, cpu.CoreIndex, group, cpu.LastLevelCacheIndex, cpu.NumaNodeIndex, cpu.EfficiencyClass, state)
+	}
+	tw.Flush()
+
+	if cs.Relations != nil {
+		printCaches(cs.Relations)
+	}
+	return exitOK
+}
+
+// printCaches summarizes the caches by level, type and size, e.g.
+// "L2 Unified 2 MiB x 6, shared by 4 processors".
+func printCaches(r *ProcessorRelations) {
+	type kind struct {
+		level  byte
+		typ    CacheType
+		size   uint32
+		shared int
+	}
+	var kinds []kind
+	count := map[kind]int{}
+	for _, cache := range r.Caches {
+		k := kind{cache.Level, cache.Type, cache.Size, cache.Processors.Count()}
+		if count[k] == 0 {
+			kinds = append(kinds, k)
+		}
+		count[k]++
+	}
+	sort.SliceStable(kinds, func(i, j int) bool { return kinds[i].level < kinds[j].level })
+
+	fmt.Println()
+	for _, k := range kinds {
+		fmt.Printf("L%d %s %s x %d, shared by %d processor(s)\n", k.level, k.typ, formatBytes(uint64(k.size)), count[k], k.shared)
+	}
+	fmt.Printf("%d core(s), %d package(s), %d NUMA node(s)\n", len(r.Cores), len(r.Packages), len(r.NumaNodes))
+}
+
+func formatBytes(n uint64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%d MiB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%d KiB", n>>10)
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+func captureCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	positional, code, ok := parseArgs(fs, args, 1, 1)
+	if !ok {
+		return code
+	}
+
+	capture, err := captureTopology()
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	fixture, err := capture.Fixture()
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	if len(fixture.CPUs) == 0 {
+		fmt.Fprintln(os.Stderr, "GetSystemCpuSetInformation returned no processors")
+		return exitError
+	}
+	// what this build derives, so a later build can be checked against it
+	var topology CpuSets
+	topology.Load(fixture.CPUs, fixture.Relations)
+	capture.Expect = newTopologyExpect(&topology)
+
+	if err := capture.Write(positional[0]); err != nil {
+		log.Println(err)
+		return exitError
+	}
+	fmt.Fprintf(os.Stderr, "%s, %d logical processors, %d CPUID leaves written to %s\n", fixture.Name, len(fixture.CPUs), len(capture.CPUID), positional[0])
+	return exitOK
+}
+
+func recordCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	fixtureName := fs.String("name", "", "Name of the machine, default the processor name")
+	positional, code, ok := parseArgs(fs, args, 1, 1)
+	if !ok {
+		return code
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	if *fixtureName == "" {
+		*fixtureName = cpuName()
+	}
+	fixture := newMachineFixture(*fixtureName, devices, &cs)
+	if err := fixture.Write(positional[0]); err != nil {
+		log.Println(err)
+		return exitError
+	}
+	fmt.Fprintf(os.Stderr, "%d devices and %d logical processors written to %s\n", len(devices), len(cs.CPU), positional[0])
+	return exitOK
+}
+
+func fixturesCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	positional, code, ok := parseArgs(fs, args, 0, -1)
+	if !ok {
+		return code
+	}
+	if len(positional) == 0 {
+		positional = []string{filepath.Join("fixtures", "topology")}
+	}
+
+	var paths []string
+	for _, arg := range positional {
+		matches, err := filepath.Glob(filepath.Join(arg, "*.json"))
+		if err != nil {
+			log.Println(err)
+			return exitUsage
+		}
+		if len(matches) == 0 {
+			matches = []string{arg}
+		}
+		paths = append(paths, matches...)
+	}
+
+	code = exitOK
+	for _, path := range paths {
+		fixture, _, err := openTopologyFile(path)
+		if err != nil {
+			log.Println(err)
+			return exitError
+		}
+		diffs := fixture.Check()
+		switch {
+		case fixture.Expect == nil:
+			fmt.Printf("SKIP %s: no expect\n", path)
+		case len(diffs) == 0:
+			fmt.Printf("ok   %s\n", path)
+		default:
+			fmt.Printf("FAIL %s\n", path)
+			for _, diff := range diffs {
+				fmt.Printf("     %s\n", diff)
+			}
+			code = exitProblems
+		}
+	}
+	return code
+}
+
+func lintCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	if _, code, ok := parseArgs(fs, args, 0, 0); !ok {
+		return code
+	}
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	return lintCLI(devices)
+}
+
+func cpumapCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	format := addFormatFlag(fs)
+	if _, code, ok := parseArgs(fs, args, 0, 0); !ok {
+		return code
+	}
+	if !validFormat(*format) {
+		return exitUsage
+	}
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	if err := writeCPUMap(os.Stdout, *format, devicesPerCPU(devices, &cs)); err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
+
+func recommendCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	apply := fs.Bool("apply", false, "Write the recommended settings, otherwise they are only shown with their registry operations")
+	restart := fs.Bool("restart", false, "With -apply: restart the changed devices")
+	addDryRunFlag(fs)
+	format := addFormatFlag(fs)
+	if _, code, ok := parseArgs(fs, args, 0, 0); !ok {
+		return code
+	}
+	if !checkFormat(*format, name) {
+		return exitUsage
+	}
+	if !*apply {
+		flagDryRun = true
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	return writeResult(*format, recommendCLI(devices, *restart))
+}
+
+func profileCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	restart := fs.Bool("restart", false, "With apply: restart the changed devices")
+	addDryRunFlag(fs)
+	format := addFormatFlag(fs)
+	positional, code, ok := parseArgs(fs, args, 2, 2)
+	if !ok {
+		return code
+	}
+	action, path := positional[0], positional[1]
+	if action != "apply" && action != "verify" && action != "generate" {
+		fmt.Fprintf(os.Stderr, "unknown profile action %q, expected apply, verify or generate\n", action)
+		return exitUsage
+	}
+	if action == "apply" && !checkFormat(*format, name+" "+action) {
+		return exitUsage
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+	switch action {
+	case "apply":
+		return writeResult(*format, profileApplyCLI(path, devices, *restart))
+	case "verify":
+		return profileVerifyCLI(path, devices)
+	default:
+		return profileGenerateCLI(path, devices)
+	}
+}
+
+func reconcileCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	fix := fs.Bool("fix", false, "Re-apply the profile and restart the affected devices")
+	report := fs.String("report", "", "Write the JSON report to this file instead of stdout")
+	positional, code, ok := parseArgs(fs, args, 1, 1)
+	if !ok {
+		if code == exitOK { // -h
+			return code
+		}
+		return ReconcileError
+	}
+
+	devices, err := loadDevices()
+	if err != nil {
+		log.Println(err)
+		return ReconcileError
+	}
+	return reconcileCLI(positional[0], devices, *fix, *report)
+}
+
+func helpCommand(name string, args []string) int {
+	if len(args) == 0 {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	cmd := findCommand(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+		return exitUsage
+	}
+	return cmd.Run(cmd.Name, []string{"-h"})
+}
@@ -1,11 +1,26 @@
 package main
 
-import "github.com/intel-go/cpuid"
+import (
+	"strings"
+
+	"github.com/intel-go/cpuid"
+)
+
+// The vendor and brand of the processor, replaced by the ones of a replayed capture.
+var (
+	cpuVendor = cpuid.VendorIdentificatorString
+	cpuBrand  = strings.TrimSpace(strings.TrimRight(cpuid.ProcessorBrandString, "\x00"))
+)
 
 func isAMD() bool {
-	return cpuid.VendorIdentificatorString == "AuthenticAMD"
+	return cpuVendor == "AuthenticAMD"
 }
 
 func isIntel() bool {
-	return cpuid.VendorIdentificatorString == "GenuineIntel"
+	return cpuVendor == "GenuineIntel"
+}
+
+// cpuName is the brand string of the processor, e.g. "Intel(R) Core(TM) i9-13900K".
+func cpuName() string {
+	return cpuBrand
 }
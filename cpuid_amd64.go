@@ -0,0 +1,3 @@
+package main
+
+func cpuidLow(leaf, subleaf uint32) (eax, ebx, ecx, edx uint32) // implemented in cpuidlow_amd64.s
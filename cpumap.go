@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// CPUDevices are the devices whose interrupts can arrive at a processor.
+type CPUDevices struct {
+	Processor int
+	Core      int       // counted like the dialog shows the cores
+	Pinned    []*Device // DevicePolicy 4 with the processor in AssignmentSetOverride
+	ByPolicy  []*Device // any other policy that can deliver to the processor, see SimulateInterrupts
+}
+
+// Conflict reports whether several devices are pinned to the processor.
+func (c *CPUDevices) Conflict() bool {
+	return len(c.Pinned) > 1
+}
+
+// devicesPerCPU inverts the device table: for every processor of topology
+// the devices that target it, explicitly or implicitly by their policy.
+func devicesPerCPU(devices []Device, topology *CpuSets) []CPUDevices {
+	cpus := make([]CPUDevices, len(topology.CPU))
+	index := map[int]int{}
+	core := map[int]int{}
+	for i, processors := range topology.cores() {
+		for _, p := range processors {
+			core[p] = i
+		}
+	}
+	for i, cpu := range topology.CPU {
+		cpus[i] = CPUDevices{Processor: cpu.Processor(), Core: core[cpu.Processor()]}
+		index[cpu.Processor()] = i
+	}
+
+	for i := range devices {
+		dev := &devices[i]
+		simulation := SimulateInterrupts(dev, topology, dev.NumaNode)
+		for _, p := range simulation.Targets.Processors() {
+			j, ok := index[p]
+			if !ok {
+				continue
+			}
+			if dev.DevicePolicy == IrqPolicySpecifiedProcessors && dev.AssignmentSetOverride.Has(p) {
+				cpus[j].Pinned = append(cpus[j].Pinned, dev)
+			} else {
+				cpus[j].ByPolicy = append(cpus[j].ByPolicy, dev)
+			}
+		}
+	}
+	return cpus
+}
+
+// heatBar draws the devices of a processor, # for every pinned device and
+// . for every device targeting it by its policy, at most width characters.
+func heatBar(c *CPUDevices, width int) string {
+	bar := strings.Repeat("#", len(c.Pinned)) + strings.Repeat(".", len(c.ByPolicy))
+	if len(bar) > width {
+		bar = bar[:width-1] + "+"
+	}
+	return bar
+}
+
+func deviceNames(devices []*Device) []string {
+	names := make([]string, len(devices))
+	for i, dev := range devices {
+		names[i] = dev.DeviceDesc
+	}
+	return names
+}
+
+// CPUDevicesRecord is the machine readable form of CPUDevices.
+type CPUDevicesRecord struct {
+	Processor int      `json:"processor"`
+	Core      int      `json:"core"`
+	Pinned    []string `json:"pinned"`
+	ByPolicy  []string `json:"byPolicy"`
+	Conflict  bool     `json:"conflict"`
+}
+
+// writeCPUMap writes the devices per processor as a heat map with the
+// pinned devices and the conflicts, or as JSON or CSV.
+func writeCPUMap(w io.Writer, format string, cpus []CPUDevices) error {
+	switch format {
+	case formatJSON:
+		records := make([]CPUDevicesRecord, len(cpus))
+		for i := range cpus {
+			c := &cpus[i]
+			records[i] = CPUDevicesRecord{c.Processor, c.Core, deviceNames(c.Pinned), deviceNames(c.ByPolicy), c.Conflict()}
+		}
+		return writeJSON(w, records)
+	case formatCSV:
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"processor", "core", "pinned", "byPolicy", "conflict"})
+		for i := range cpus {
+			c := &cpus[i]
+			cw.Write([]string{strconv.Itoa(c.Processor), strconv.Itoa(c.Core), strings.Join(deviceNames(c.Pinned), "; "), strings.Join(deviceNames(c.ByPolicy), "; "), strconv.FormatBool(c.Conflict())})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+
+	fmt.Fprintln(w, "Devices per processor, # pinned with DevicePolicy 4, . targeting it by their policy:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CPU\tCORE\tPINNED\tBY POLICY\tLOAD")
+	for i := range cpus {
+		c := &cpus[i]
+		pinned := strconv.Itoa(len(c.Pinned))
+		if c.Conflict() {
+			pinned += "!"
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%s\n", c.Processor, c.Core, pinned, len(c.ByPolicy), heatBar(c, 40))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var conflicts []string
+	pinned := false
+	fmt.Fprintln(w)
+	for i := range cpus {
+		c := &cpus[i]
+		if len(c.Pinned) != 0 {
+			pinned = true
+			fmt.Fprintf(w, "CPU %d: %s\n", c.Processor, strings.Join(deviceNames(c.Pinned), ", "))
+		}
+		if c.Conflict() {
+			conflicts = append(conflicts, strconv.Itoa(c.Processor))
+		}
+	}
+	if !pinned {
+		fmt.Fprintln(w, "No device is pinned to a processor.")
+	}
+	if len(conflicts) != 0 {
+		fmt.Fprintf(w, "\nSeveral devices are pinned to processor(s) %s, their interrupts compete for the same processor.\n", strings.Join(conflicts, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// cpuMapTestDevices returns two devices pinned to processor 2, two that
+// target processors by their policy and one specifying a missing processor.
+func cpuMapTestDevices() []Device {
+	device := func(name string, policy uint32, processors ...int) Device {
+		return Device{DeviceDesc: name, DevicePolicy: policy, AssignmentSetOverride: NewCPUMask(processors...), InterruptTypeMap: 1, NumaNode: -1}
+	}
+	return []Device{
+		device("GPU", IrqPolicySpecifiedProcessors, 2),
+		device("NIC", IrqPolicySpecifiedProcessors, 2, 3),
+		device("USB", IrqPolicyAllCloseProcessors),
+		device("Audio", IrqPolicyOneCloseProcessor),
+		device("Capture", IrqPolicySpecifiedProcessors, 70),
+	}
+}
+
+func TestDevicesPerCPU(t *testing.T) {
+	useTopologyFixture(t, "8-threads.json")
+	cpus := devicesPerCPU(cpuMapTestDevices(), &cs)
+	if len(cpus) != 8 {
+		t.Fatalf("%d processors, want 8", len(cpus))
+	}
+
+	want := map[int][2][]string{ // processor: pinned, by policy
+		0: {{}, {"USB", "Audio", "Capture"}},
+		2: {{"GPU", "NIC"}, {"USB", "Capture"}},
+		3: {{"NIC"}, {"USB", "Capture"}},
+		7: {{}, {"USB", "Capture"}},
+	}
+	for i, c := range cpus {
+		if c.Processor != i || c.Core != i {
+			t.Errorf("processor %d on core %d, want %d", c.Processor, c.Core, i)
+		}
+		if c.Conflict() != (i == 2) {
+			t.Errorf("processor %d: conflict %v", i, c.Conflict())
+		}
+		w, ok := want[i]
+		if !ok {
+			continue
+		}
+		if got := deviceNames(c.Pinned); !reflect.DeepEqual(got, w[0]) {
+			t.Errorf("processor %d: pinned %q, want %q", i, got, w[0])
+		}
+		if got := deviceNames(c.ByPolicy); !reflect.DeepEqual(got, w[1]) {
+			t.Errorf("processor %d: by policy %q, want %q", i, got, w[1])
+		}
+	}
+
+	// cores are counted like the dialog shows them, SMT threads share one
+	useTopologyFixture(t, "intel-core-i9-13900.json")
+	cpus = devicesPerCPU(nil, &cs)
+	if cpus[0].Core != 0 || cpus[1].Core != 0 || cpus[2].Core != 1 || cpus[31].Core != 23 {
+		t.Errorf("cores %d %d %d %d, want 0 0 1 23", cpus[0].Core, cpus[1].Core, cpus[2].Core, cpus[31].Core)
+	}
+}
+
+func TestWriteCPUMap(t *testing.T) {
+	useTopologyFixture(t, "8-threads.json")
+	cpus := devicesPerCPU(cpuMapTestDevices(), &cs)
+	if *update {
+		if err := os.MkdirAll(filepath.Join("testdata", "cpumap"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for format, file := range map[string]string{formatText: "cpumap.txt", formatJSON: "cpumap.json", formatCSV: "cpumap.csv"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeCPUMap(&buf, format, cpus); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("testdata", "cpumap", file), buf.Bytes())
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := writeCPUMap(&buf, formatText, devicesPerCPU(nil, &cs)); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte("No device is pinned to a processor.")) || bytes.Contains(buf.Bytes(), []byte("Several devices")) {
+		t.Errorf("heat map without devices:\n%s", buf.Bytes())
+	}
+}
@@ -0,0 +1,115 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tailscale/walk"
+	//lint:ignore ST1001 standard behavior tailscale/walk
+	. "github.com/tailscale/walk/declarative"
+)
+
+// cpuMapRow is a line of the devices per CPU table.
+type cpuMapRow struct {
+	Processor int
+	Core      int
+	Pinned    string
+	ByPolicy  int
+	Load      string
+	conflict  bool
+	devices   string // all devices, for the tooltip
+}
+
+type cpuMapModel struct {
+	items []cpuMapRow
+}
+
+func (m *cpuMapModel) Items() interface{} {
+	return m.items
+}
+
+// RunCPUMapDialog shows the inverse of the device table: the devices that
+// target every processor, with the processors shared by pinned devices in red.
+func RunCPUMapDialog(owner walk.Form, devices []Device) (int, error) {
+	var dlg *walk.Dialog
+	var tv *walk.TableView
+
+	model := &cpuMapModel{}
+	conflicts := 0
+	for _, c := range devicesPerCPU(devices, &cs) {
+		model.items = append(model.items, cpuMapRow{
+			Processor: c.Processor,
+			Core:      c.Core,
+			Pinned:    strings.Join(deviceNames(c.Pinned), ", "),
+			ByPolicy:  len(c.ByPolicy),
+			Load:      heatBar(&c, 40),
+			conflict:  c.Conflict(),
+			devices:   strings.Join(append(deviceNames(c.Pinned), deviceNames(c.ByPolicy)...), "\n"),
+		})
+		if c.Conflict() {
+			conflicts++
+		}
+	}
+	summary := "No processor is shared by pinned devices."
+	if conflicts != 0 {
+		summary = fmt.Sprintf("%d processor(s) are shared by pinned devices, their interrupts compete for the same processor.", conflicts)
+	}
+
+	return Dialog{
+		AssignTo: &dlg,
+		Title:    "Devices per CPU",
+		MinSize:  Size{Width: 600, Height: 400},
+		Layout:   VBox{},
+		Children: []Widget{
+			Label{
+				Text: "# pinned with Specified Processors, . targeting the processor by their policy",
+			},
+			TableView{
+				AssignTo:            &tv,
+				AlternatingRowBG:    true,
+				ColumnsSizable:      true,
+				LastColumnStretched: true,
+				Model:               model,
+				StyleCell: func(style *walk.CellStyle) {
+					if style.Row() >= len(model.items) {
+						return
+					}
+					row := &model.items[style.Row()]
+					switch {
+					case row.conflict:
+						style.BackgroundColor = walk.RGB(255, 200, 200)
+					case row.Pinned != "":
+						style.BackgroundColor = walk.RGB(255, 235, 180)
+					}
+				},
+				OnCurrentIndexChanged: func() {
+					if i := tv.CurrentIndex(); i >= 0 && i < len(model.items) {
+						tv.SetToolTipText(model.items[i].devices)
+					}
+				},
+				Columns: []TableViewColumn{
+					{Name: "Processor", Title: "CPU", Width: 40},
+					{Name: "Core", Title: "Core", Width: 40},
+					{Name: "Load", Title: "Load", Width: 160},
+					{Name: "ByPolicy", Title: "By Policy", Width: 60},
+					{Name: "Pinned", Title: "Pinned Devices"},
+				},
+			},
+			Label{
+				Text: summary,
+			},
+			Composite{
+				Layout: HBox{},
+				Children: []Widget{
+					HSpacer{},
+					PushButton{
+						Text:      "Close",
+						OnClicked: func() { dlg.Cancel() },
+					},
+				},
+			},
+		},
+	}.Run(owner)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// CPUMask is a set of logical processors of any size. Processor n is bit
+// n%64 of word n/64, so every word is the KAFFINITY of one processor group.
+// Methods never modify the receiver, copies of a Device stay independent.
+type CPUMask []uint64
+
+// NewCPUMask returns a mask with the given processors set.
+func NewCPUMask(processors ...int) CPUMask {
+	var m CPUMask
+	for _, p := range processors {
+		m = m.Set(p)
+	}
+	return m
+}
+
+// CPUMaskFromBytes decodes the little endian REG_BINARY form of AssignmentSetOverride.
+func CPUMaskFromBytes(data []byte) CPUMask {
+	m := make(CPUMask, (len(data)+7)/8)
+	for i, b := range data {
+		m[i/8] |= uint64(b) << (8 * (i % 8))
+	}
+	return m.trim()
+}
+
+// Bytes encodes the mask as little endian bytes, at least 8 (one KAFFINITY).
+// Trailing zero bytes are kept, use clen to trim them.
+func (m CPUMask) Bytes() []byte {
+	words := len(m)
+	if words == 0 {
+		words = 1
+	}
+	data := make([]byte, 8*words)
+	for i, word := range m {
+		for j := 0; j < 8; j++ {
+			data[8*i+j] = byte(word >> (8 * j))
+		}
+	}
+	return data
+}
+
+func (m CPUMask) trim() CPUMask {
+	n := len(m)
+	for n > 0 && m[n-1] == 0 {
+		n--
+	}
+	return m[:n]
+}
+
+func (m CPUMask) clone(size int) CPUMask {
+	if size < len(m) {
+		size = len(m)
+	}
+	c := make(CPUMask, size)
+	copy(c, m)
+	return c
+}
+
+func (m CPUMask) Has(processor int) bool {
+	if processor < 0 || processor/64 >= len(m) {
+		return false
+	}
+	return m[processor/64]&(1<<(processor%64)) != 0
+}
+
+func (m CPUMask) Set(processor int) CPUMask {
+	if processor < 0 {
+		return m
+	}
+	c := m.clone(processor/64 + 1)
+	c[processor/64] |= 1 << (processor % 64)
+	return c
+}
+
+func (m CPUMask) Clear(processor int) CPUMask {
+	if !m.Has(processor) {
+		return m
+	}
+	c := m.clone(0)
+	c[processor/64] &^= 1 << (processor % 64)
+	return c.trim()
+}
+
+func (m CPUMask) Toggle(processor int) CPUMask {
+	if m.Has(processor) {
+		return m.Clear(processor)
+	}
+	return m.Set(processor)
+}
+
+func (m CPUMask) IsZero() bool {
+	return len(m.trim()) == 0
+}
+
+func (m CPUMask) Equal(o CPUMask) bool {
+	a, b := m.trim(), o.trim()
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Less orders masks by their numeric value.
+func (m CPUMask) Less(o CPUMask) bool {
+	a, b := m.trim(), o.trim()
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+func (m CPUMask) Union(o CPUMask) CPUMask {
+	c := m.clone(len(o))
+	for i, word := range o {
+		c[i] |= word
+	}
+	return c
+}
+
+func (m CPUMask) Intersect(o CPUMask) CPUMask {
+	c := make(CPUMask, min(len(m), len(o)))
+	for i := range c {
+		c[i] = m[i] & o[i]
+	}
+	return c.trim()
+}
+
+func (m CPUMask) Count() int {
+	var n int
+	for _, word := range m {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
+
+// Processors returns the set processors in ascending order.
+func (m CPUMask) Processors() []int {
+	var result []int
+	for i, word := range m {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			result = append(result, i*64+bit)
+			word &^= 1 << bit
+		}
+	}
+	return result
+}
+
+// String formats the mask as a comma separated list of processors.
+func (m CPUMask) String() string {
+	processors := m.Processors()
+	result := make([]string, len(processors))
+	for i, p := range processors {
+		result[i] = strconv.Itoa(p)
+	}
+	return strings.Join(result, ",")
+}
+
+// parseProcessor parses a processor number, either global ("70") or
+// group relative ("1:6", processor 6 of group 1).
+func parseProcessor(s string) (int, error) {
+	group, index, ok := strings.Cut(s, ":")
+	if !ok {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid processor %q", s)
+		}
+		return n, nil
+	}
+
+	g, err := strconv.Atoi(group)
+	if err != nil || g < 0 {
+		return 0, fmt.Errorf("invalid processor group in %q", s)
+	}
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 || n > 63 {
+		return 0, fmt.Errorf("invalid processor in %q", s)
+	}
+	return g*64 + n, nil
+}
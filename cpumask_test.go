@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCPUMaskBytes(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       []byte
+		processors []int
+		bytes      []byte // the encoding of the decoded mask
+	}{
+		{"empty", nil, nil, make([]byte, 8)},
+		{"one byte", []byte{0x05}, []int{0, 2}, []byte{0x05, 0, 0, 0, 0, 0, 0, 0}},
+		{"one KAFFINITY", []byte{0, 0, 0, 0, 0, 0, 0, 0x80}, []int{63}, []byte{0, 0, 0, 0, 0, 0, 0, 0x80}},
+		{"nine bytes", []byte{0, 0, 0, 0, 0, 0, 0, 0, 0x01}, []int{64}, append(make([]byte, 8), 1, 0, 0, 0, 0, 0, 0, 0)},
+		{"two groups", []byte{0x01, 0, 0, 0, 0, 0, 0, 0x80, 0x02, 0, 0, 0, 0, 0, 0, 0}, []int{0, 63, 65}, []byte{0x01, 0, 0, 0, 0, 0, 0, 0x80, 0x02, 0, 0, 0, 0, 0, 0, 0}},
+		{"zero second group", append([]byte{0x01}, make([]byte, 15)...), []int{0}, []byte{0x01, 0, 0, 0, 0, 0, 0, 0}},
+		{"all zero", make([]byte, 16), nil, make([]byte, 8)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := CPUMaskFromBytes(tt.data)
+			if got := m.Processors(); !reflect.DeepEqual(got, tt.processors) {
+				t.Errorf("processors %v, want %v", got, tt.processors)
+			}
+			if got := m.Bytes(); !bytes.Equal(got, tt.bytes) {
+				t.Errorf("bytes %x, want %x", got, tt.bytes)
+			}
+			if again := CPUMaskFromBytes(m.Bytes()); !again.Equal(m) {
+				t.Errorf("decoded again %v, want %v", again, m)
+			}
+		})
+	}
+}
+
+func TestCPUMaskTrim(t *testing.T) {
+	if got := (CPUMask{1, 0, 0}).trim(); !reflect.DeepEqual(got, CPUMask{1}) {
+		t.Errorf("trim %v", got)
+	}
+	if got := (CPUMask{0, 0}).trim(); len(got) != 0 {
+		t.Errorf("trim of zero words %v", got)
+	}
+	if got := (CPUMask{0, 2}).trim(); !reflect.DeepEqual(got, CPUMask{0, 2}) {
+		t.Errorf("trim keeps the leading zero word: %v", got)
+	}
+}
+
+func TestCPUMaskEqual(t *testing.T) {
+	tests := []struct {
+		a, b  CPUMask
+		equal bool
+	}{
+		{nil, nil, true},
+		{nil, CPUMask{0, 0}, true},
+		{CPUMask{1}, CPUMask{1, 0}, true},
+		{CPUMask{1, 0, 0}, CPUMask{1}, true},
+		{CPUMask{1}, CPUMask{1, 1}, false},
+		{CPUMask{0, 1}, CPUMask{1}, false},
+		{CPUMask{2}, CPUMask{1}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Equal(tt.b); got != tt.equal {
+			t.Errorf("%#v.Equal(%#v) = %v", tt.a, tt.b, got)
+		}
+		if got := tt.b.Equal(tt.a); got != tt.equal {
+			t.Errorf("%#v.Equal(%#v) = %v", tt.b, tt.a, got)
+		}
+	}
+}
+
+func TestCPUMaskSetClear(t *testing.T) {
+	m := NewCPUMask(1)
+	grown := m.Set(129)
+	if !reflect.DeepEqual(grown, CPUMask{2, 0, 2}) {
+		t.Errorf("Set past the last word: %#v", grown)
+	}
+	if !reflect.DeepEqual(m, CPUMask{2}) {
+		t.Errorf("Set changed the receiver: %#v", m)
+	}
+	if got := m.Set(-1); !got.Equal(m) {
+		t.Errorf("Set(-1) = %v", got)
+	}
+
+	if got := m.Clear(200); !reflect.DeepEqual(got, m) {
+		t.Errorf("Clear past the last word: %#v", got)
+	}
+	if got := grown.Clear(129); !reflect.DeepEqual(got, CPUMask{2}) {
+		t.Errorf("Clear of the last processor is not trimmed: %#v", got)
+	}
+	if !reflect.DeepEqual(grown, CPUMask{2, 0, 2}) {
+		t.Errorf("Clear changed the receiver: %#v", grown)
+	}
+	if got := grown.Clear(1).Clear(129); !got.IsZero() || len(got) != 0 {
+		t.Errorf("every processor cleared: %#v", got)
+	}
+	if got := m.Toggle(1).Toggle(64); !got.Equal(NewCPUMask(64)) {
+		t.Errorf("Toggle %v", got)
+	}
+}
+
+func TestCPUMaskProcessors(t *testing.T) {
+	m := NewCPUMask(70, 0, 63, 64, 5)
+	if got, want := m.Processors(), []int{0, 5, 63, 64, 70}; !reflect.DeepEqual(got, want) {
+		t.Errorf("processors %v, want %v", got, want)
+	}
+	if got := m.String(); got != "0,5,63,64,70" {
+		t.Errorf("String() = %q", got)
+	}
+	if got := m.Count(); got != 5 {
+		t.Errorf("Count() = %d", got)
+	}
+	if got := (CPUMask{}).Processors(); got != nil {
+		t.Errorf("processors of an empty mask %v", got)
+	}
+	if got := m.Intersect(NewCPUMask(5, 64, 128)); !got.Equal(NewCPUMask(5, 64)) {
+		t.Errorf("Intersect %v", got)
+	}
+	if got := NewCPUMask(1).Union(NewCPUMask(65)); !got.Equal(NewCPUMask(1, 65)) {
+		t.Errorf("Union %v", got)
+	}
+	if !NewCPUMask(63).Less(NewCPUMask(64)) || NewCPUMask(64).Less(NewCPUMask(63)) || !(CPUMask{1, 0}).Less(NewCPUMask(1, 2)) {
+		t.Error("Less does not order by the numeric value")
+	}
+}
+
+func TestParseProcessor(t *testing.T) {
+	tests := []struct {
+		s         string
+		processor int
+		err       bool
+	}{
+		{"0", 0, false},
+		{"70", 70, false},
+		{"0:6", 6, false},
+		{"1:6", 70, false},
+		{"1:63", 127, false},
+		{"1:64", 0, true},
+		{"-1", 0, true},
+		{"1:-1", 0, true},
+		{"-1:1", 0, true},
+		{"a", 0, true},
+		{"1:", 0, true},
+		{":1", 0, true},
+		{"", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseProcessor(tt.s)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: error %v, want error %v", tt.s, err, tt.err)
+		}
+		if err == nil && got != tt.processor {
+			t.Errorf("%q: processor %d, want %d", tt.s, got, tt.processor)
+		}
+	}
+}
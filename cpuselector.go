@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseCPUList parses a processor selection for AssignmentSetOverride
+// against the topology of this machine, see parseCPUSelector.
+func parseCPUList(s string) (CPUMask, error) {
+	return parseCPUSelector(s, &cs)
+}
+
+// parseCPUSelector parses a comma separated list of terms. The processors of
+// every term are added in order, a term starting with ^ removes them instead:
+//
+//	7, 1:6        a processor, global or relative to its processor group
+//	0-3, 1:0-1:7  a range of processors
+//	0xF0          a hex mask, bit n is processor n
+//	all           every processor
+//	pcores        the processors of the highest efficiency class
+//	ecores        the processors of the lowest efficiency class
+//	smt           the second and further threads of every core
+//	no-smt        the same as ^smt, every processor if there is no SMT
+//	ccd1, llc1    the processors sharing the second last level cache
+//	numa1         the processors of the second NUMA node
+//	core3         every thread of the fourth core
+//	core3.t1      the second thread of the fourth core
+//	l2g2          the processors sharing the third L2 cache
+//	ecluster1     the second cluster of E-cores sharing an L2 cache
+//
+// Caches, NUMA nodes and cores are counted from 0 in the order of the
+// processors, like the dialog shows them. L2 groups and E-core clusters
+// need the cache information of GetLogicalProcessorInformationEx. If the first term removes
+// processors, the selection starts with all of them, so "^0" and "no-smt"
+// work on their own. An empty string selects nothing.
+func parseCPUSelector(s string, topology *CpuSets) (CPUMask, error) {
+	var mask CPUMask
+	first := true
+	for _, term := range strings.Split(s, ",") {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" {
+			continue
+		}
+
+		remove := false
+		switch {
+		case strings.HasPrefix(term, "^"):
+			remove = true
+			term = strings.TrimSpace(term[1:])
+		case term == "no-smt" || term == "nosmt":
+			remove = true
+			term = "smt"
+		}
+		if first && remove {
+			mask = topology.Mask()
+		}
+		first = false
+
+		var selected CPUMask
+		if remove && term == "smt" {
+			// without SMT every thread is the first of its core, nothing is removed
+			selected = topology.smtThreads()
+		} else {
+			var err error
+			if selected, err = selectorTerm(term, topology); err != nil {
+				return nil, err
+			}
+		}
+		if remove {
+			for _, p := range selected.Processors() {
+				mask = mask.Clear(p)
+			}
+		} else {
+			mask = mask.Union(selected)
+		}
+	}
+
+	if !first && mask.IsZero() {
+		return nil, fmt.Errorf("%q selects no processor", s)
+	}
+	return mask.trim(), nil
+}
+
+func selectorTerm(term string, topology *CpuSets) (CPUMask, error) {
+	switch term {
+	case "":
+		return nil, fmt.Errorf("^ without a processor")
+	case "all":
+		return topology.Mask(), nil
+	case "pcores", "ecores":
+		if !topology.EfficiencyClass {
+			return nil, fmt.Errorf("%s: the processor has no efficiency classes", term)
+		}
+		lowest, highest := topology.CPU[0].EfficiencyClass, topology.CPU[0].EfficiencyClass
+		for _, cpu := range topology.CPU {
+			lowest = min(lowest, cpu.EfficiencyClass)
+			highest = max(highest, cpu.EfficiencyClass)
+		}
+		class := lowest
+		if term == "pcores" {
+			class = highest
+		}
+		return topology.selectCPUs(func(cpu CpuSet) bool { return cpu.EfficiencyClass == class }), nil
+	case "smt":
+		if !topology.HyperThreading {
+			return nil, fmt.Errorf("%s: the processor has no simultaneous multithreading", term)
+		}
+		return topology.smtThreads(), nil
+	case "l2g", "ecluster":
+		return nil, fmt.Errorf("%s needs a number, e.g. %s0", term, term)
+	}
+
+	if strings.HasPrefix(term, "0x") {
+		return parseHexMask(term, topology)
+	}
+	for _, domain := range []struct {
+		prefix string
+		name   string
+		key    func(CpuSet) byte
+	}{
+		{"ccd", "last level caches", func(cpu CpuSet) byte { return cpu.LastLevelCacheIndex }},
+		{"llc", "last level caches", func(cpu CpuSet) byte { return cpu.LastLevelCacheIndex }},
+		{"numa", "NUMA nodes", func(cpu CpuSet) byte { return cpu.NumaNodeIndex }},
+		{"core", "cores", nil},
+	} {
+		if rest, ok := strings.CutPrefix(term, domain.prefix); ok && rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+			return selectDomain(term, rest, domain.prefix, domain.name, domain.key, topology)
+		}
+	}
+	for _, cluster := range []struct {
+		prefix string
+		name   string
+		groups func() ([]CPUMask, error)
+	}{
+		{"l2g", "L2 groups", topology.l2Groups},
+		{"ecluster", "E-core clusters", topology.eCoreClusters},
+	} {
+		if rest, ok := strings.CutPrefix(term, cluster.prefix); ok && rest != "" && rest[0] >= '0' && rest[0] <= '9' {
+			n, err := strconv.Atoi(rest)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s number in %q", cluster.prefix, term)
+			}
+			groups, err := cluster.groups()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", term, err)
+			}
+			if n >= len(groups) {
+				return nil, fmt.Errorf("%s: there are %d %s, %s0 to %s%d", term, len(groups), cluster.name, cluster.prefix, cluster.prefix, len(groups)-1)
+			}
+			return groups[n], nil
+		}
+	}
+
+	if from, to, ok := strings.Cut(term, "-"); ok {
+		first, err := parseProcessor(from)
+		if err != nil {
+			return nil, err
+		}
+		last, err := parseProcessor(to)
+		if err != nil {
+			return nil, err
+		}
+		if last < first {
+			return nil, fmt.Errorf("invalid range %q, the end is before the start", term)
+		}
+		var mask CPUMask
+		for p := first; p <= last; p++ {
+			if !topology.Has(p) {
+				return nil, fmt.Errorf("%s: processor %d does not exist", term, p)
+			}
+			mask = mask.Set(p)
+		}
+		return mask, nil
+	}
+
+	p, err := parseProcessor(term)
+	if err != nil {
+		return nil, fmt.Errorf("unknown processor selection %q", term)
+	}
+	if !topology.Has(p) {
+		return nil, fmt.Errorf("processor %s does not exist", term)
+	}
+	return NewCPUMask(p), nil
+}
+
+// selectDomain selects the n-th cache, NUMA node or core in "3" or, for
+// cores, a single thread in "3.t1". A nil key selects cores.
+func selectDomain(term, index, prefix, name string, key func(CpuSet) byte, topology *CpuSets) (CPUMask, error) {
+	thread := -1
+	if prefix == "core" {
+		if core, t, ok := strings.Cut(index, ".t"); ok {
+			index = core
+			n, err := strconv.Atoi(t)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid thread in %q", term)
+			}
+			thread = n
+		}
+	}
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s number in %q", prefix, term)
+	}
+
+	var domains [][]int
+	if key == nil {
+		domains = topology.cores()
+	} else {
+		domains = topology.domains(key)
+	}
+	if n >= len(domains) {
+		return nil, fmt.Errorf("%s: there are %d %s, %s0 to %s%d", term, len(domains), name, prefix, prefix, len(domains)-1)
+	}
+	processors := domains[n]
+	if thread == -1 {
+		return NewCPUMask(processors...), nil
+	}
+	if thread >= len(processors) {
+		return nil, fmt.Errorf("%s: %s%d has %d threads, t0 to t%d", term, prefix, n, len(processors), len(processors)-1)
+	}
+	return NewCPUMask(processors[thread]), nil
+}
+
+// parseHexMask parses a mask of any length, bit n selects processor n.
+func parseHexMask(term string, topology *CpuSets) (CPUMask, error) {
+	digits := term[2:]
+	if digits == "" {
+		return nil, fmt.Errorf("invalid hex mask %q", term)
+	}
+	var mask CPUMask
+	for i := 0; i < len(digits); i++ {
+		nibble, err := strconv.ParseUint(digits[len(digits)-1-i:len(digits)-i], 16, 4)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex mask %q", term)
+		}
+		for bit := 0; bit < 4; bit++ {
+			if nibble&(1<<bit) == 0 {
+				continue
+			}
+			p := 4*i + bit
+			if !topology.Has(p) {
+				return nil, fmt.Errorf("%s: processor %d does not exist", term, p)
+			}
+			mask = mask.Set(p)
+		}
+	}
+	return mask, nil
+}
+
+// l2Groups returns the processors sharing an L2 cache, ordered by their lowest processor.
+func (cs *CpuSets) l2Groups() ([]CPUMask, error) {
+	if cs.Relations == nil {
+		return nil, errNoCacheInformation
+	}
+	groups := cs.Relations.L2Groups()
+	if len(groups) == 0 {
+		return nil, fmt.Errorf("the processor reports no L2 cache")
+	}
+	return groups, nil
+}
+
+// eCoreClusters returns the L2 groups of the lowest efficiency class that are
+// shared by several cores, like the E-core quads of Intel hybrids.
+func (cs *CpuSets) eCoreClusters() ([]CPUMask, error) {
+	if !cs.EfficiencyClass {
+		return nil, fmt.Errorf("the processor has no efficiency classes")
+	}
+	groups, err := cs.l2Groups()
+	if err != nil {
+		return nil, err
+	}
+	lowest := cs.CPU[0].EfficiencyClass
+	for _, cpu := range cs.CPU {
+		lowest = min(lowest, cpu.EfficiencyClass)
+	}
+	ecores := cs.selectCPUs(func(cpu CpuSet) bool { return cpu.EfficiencyClass == lowest })
+
+	var clusters []CPUMask
+	for _, group := range groups {
+		if group.Intersect(ecores).Equal(group) && cs.Relations.coresIn(group) > 1 {
+			clusters = append(clusters, group)
+		}
+	}
+	if len(clusters) == 0 {
+		return nil, fmt.Errorf("no E-cores share an L2 cache")
+	}
+	return clusters, nil
+}
+
+var errNoCacheInformation = errors.New("the cache topology is unknown")
+
+// selectCPUs returns the processors for which match is true.
+func (cs *CpuSets) selectCPUs(match func(CpuSet) bool) CPUMask {
+	var mask CPUMask
+	for _, cpu := range cs.CPU {
+		if match(cpu) {
+			mask = mask.Set(cpu.Processor())
+		}
+	}
+	return mask
+}
+
+// domains groups the processors by processor group and key, in the order
+// in which the groups first appear.
+func (cs *CpuSets) domains(key func(CpuSet) byte) [][]int {
+	index := map[[2]int]int{}
+	var domains [][]int
+	for _, cpu := range cs.CPU {
+		k := [2]int{int(cpu.Group), int(key(cpu))}
+		i, ok := index[k]
+		if !ok {
+			i = len(domains)
+			index[k] = i
+			domains = append(domains, nil)
+		}
+		domains[i] = append(domains[i], cpu.Processor())
+	}
+	return domains
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSMTSelectors(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		selector string
+		want     []int // nil for an error
+	}{
+		{"intel-core-i9-13900.json", "0-5,no-smt", []int{0, 2, 4}},
+		{"intel-core-i9-13900.json", "0-5,^smt", []int{0, 2, 4}},
+		{"intel-core-i9-13900.json", "0-5,smt", []int{0, 1, 2, 3, 4, 5, 7, 9, 11, 13, 15}},
+		{"intel-core-i9-13900-no-ht.json", "0-5,no-smt", []int{0, 1, 2, 3, 4, 5}},
+		{"intel-core-i9-13900-no-ht.json", "0-5,^smt", []int{0, 1, 2, 3, 4, 5}},
+		{"intel-core-i9-13900-no-ht.json", "smt", nil},
+		{"intel-core-i9-13900-no-ht.json", "0-5,smt", nil},
+		{"8-threads.json", "nosmt", []int{0, 1, 2, 3, 4, 5, 6, 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture+"/"+tt.selector, func(t *testing.T) {
+			useTopologyFixture(t, tt.fixture)
+			mask, err := parseCPUSelector(tt.selector, &cs)
+			switch {
+			case tt.want == nil && err == nil:
+				t.Errorf("selects %v, want an error", mask.Processors())
+			case tt.want != nil && err != nil:
+				t.Error(err)
+			case tt.want != nil && !reflect.DeepEqual(mask.Processors(), tt.want):
+				t.Errorf("selects %v, want %v", mask.Processors(), tt.want)
+			}
+		})
+	}
+}
@@ -2,21 +2,19 @@ package main
 
 import (
 	"log"
-
-	"golang.org/x/sys/windows"
 )
 
-var SystemCpuSets = []SYSTEM_CPU_SET_INFORMATION{}
-
 const (
 	ToolTipTextNumaNode        = "A group-relative value indicating which NUMA node a CPU Set is on. All CPU Sets in a given group that are on the same NUMA node will have the same value for this field."
 	ToolTipTextLastLevelCache  = "A group-relative value indicating which CPU Sets share at least one level of cache with each other. This value is the same for all CPU Sets in a group that are on processors that share cache with each other."
 	ToolTipTextEfficiencyClass = "A value indicating the intrinsic energy efficiency of a processor for systems that support heterogeneous processors (such as ARM big.LITTLE systems). CPU Sets with higher numerical values of this field have home processors that are faster but less power-efficient than ones with lower values."
 )
 
+var cs CpuSets
+
 type CoreLayout struct {
-	Rows int
-	Cols int
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
 }
 
 type CpuSets struct {
@@ -26,72 +24,127 @@ type CpuSets struct {
 	NumaNode          bool // A group-relative value indicating which NUMA node a CPU Set is on. All CPU Sets in a given group that are on the same NUMA node will have the same value for this field.
 	LastLevelCache    bool // A group-relative value indicating which CPU Sets share at least one level of cache with each other. This value is the same for all CPU Sets in a group that are on processors that share cache with each other.
 	EfficiencyClass   bool // A value indicating the intrinsic energy efficiency of a processor for systems that support heterogeneous processors (such as ARM big.LITTLE systems). CPU Sets with higher numerical values of this field have home processors that are faster but less power-efficient than ones with lower values.
+	Groups            int  // number of processor groups, systems with more than 64 logical processors have several
 	CPU               []CpuSet
 	Layout            []CoreLayout
+	Relations         *ProcessorRelations // from GetLogicalProcessorInformationEx, nil if unknown
 }
 
 type CpuSet struct {
-	Id                    uint32
-	CoreIndex             byte
-	LogicalProcessorIndex byte
-	LastLevelCacheIndex   byte // A group-relative value indicating which CPU Sets share at least one level of cache with each other. This value is the same for all CPU Sets in a group that are on processors that share cache with each other.
-	EfficiencyClass       byte // A value indicating the intrinsic energy efficiency of a processor for systems that support heterogeneous processors (such as ARM big.LITTLE systems). CPU Sets with higher numerical values of this field have home processors that are faster but less power-efficient than ones with lower values.
-	NumaNodeIndex         byte // A group-relative value indicating which NUMA node a CPU Set is on. All CPU Sets in a given group that are on the same NUMA node will have the same value for this field.
+	Id                    uint32      `json:"id"`
+	Group                 uint16      `json:"group"`
+	CoreIndex             byte        `json:"core"`
+	LogicalProcessorIndex byte        `json:"logical"`
+	LastLevelCacheIndex   byte        `json:"llc"`             // A group-relative value indicating which CPU Sets share at least one level of cache with each other. This value is the same for all CPU Sets in a group that are on processors that share cache with each other.
+	EfficiencyClass       byte        `json:"class"`           // A value indicating the intrinsic energy efficiency of a processor for systems that support heterogeneous processors (such as ARM big.LITTLE systems). CPU Sets with higher numerical values of this field have home processors that are faster but less power-efficient than ones with lower values.
+	NumaNodeIndex         byte        `json:"numa"`            // A group-relative value indicating which NUMA node a CPU Set is on. All CPU Sets in a given group that are on the same NUMA node will have the same value for this field.
+	Flags                 CpuSetFlags `json:"flags,omitempty"` // parked, allocated or real-time when the topology was read
+}
+
+// Processor is the system wide processor number, the bit of the CPU in a CPUMask.
+func (cpu CpuSet) Processor() int {
+	return int(cpu.Group)*64 + int(cpu.LogicalProcessorIndex)
+}
+
+// Has reports whether the processor exists.
+func (cs *CpuSets) Has(processor int) bool {
+	for _, cpu := range cs.CPU {
+		if cpu.Processor() == processor {
+			return true
+		}
+	}
+	return false
 }
 
+// Mask returns all processors of the system.
+func (cs *CpuSets) Mask() CPUMask {
+	var mask CPUMask
+	for _, cpu := range cs.CPU {
+		mask = mask.Set(cpu.Processor())
+	}
+	return mask
+}
+
+// Init reads the processors of the system, the ones of the -machine-fixture
+// or the ones of the -topology-file fixture or capture in debug builds.
 func (cs *CpuSets) Init() {
-	size := 0x20 * 64
-	SystemCpuSets = make([]SYSTEM_CPU_SET_INFORMATION, size)
-
-	var length uint32
-	var hProcess windows.Handle
-	success := GetSystemCpuSetInformation(&SystemCpuSets[0], uint32(size), &length, uintptr(hProcess), 0)
-	if success != 1 {
-		log.Println("err")
-	} else {
-		SystemCpuSets = SystemCpuSets[:length]
-	}
-
-	/// debug
-	// Fake13900()
-	// Fake13900WithoutHT()
-	// Fake5900x()
-	// Fake8Threads()
-	// FakeNumaCCD12Core()
-	// Fake2CCD12CoreHT()
-	// Fake13600KF()
-
-	cs.CoreCount = int(uint32(len(SystemCpuSets)) / SystemCpuSets[0].Size)
+	if flagMachineFixture != "" {
+		fixture, err := LoadMachineFixture(flagMachineFixture)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if fixture.Topology != nil {
+			cs.Load(fixture.Topology.CPUs, fixture.Topology.Relations)
+			return
+		}
+	}
+	if flagTopologyFile != "" {
+		fixture, capture, err := openTopologyFile(flagTopologyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if capture != nil {
+			capture.Replay()
+		}
+		log.Println("topology file:", fixture.Name)
+		cs.Load(fixture.CPUs, fixture.Relations)
+		return
+	}
+
+	data, err := cpuSetInformation()
+	if err != nil {
+		log.Println(err)
+	}
+	cpus, err := decodeCpuSetInformation(data)
+	if err != nil {
+		log.Println(err)
+	}
+	cs.Load(cpus, processorRelations())
+}
+
+// processorRelations reads and decodes GetLogicalProcessorInformationEx, nil if that fails.
+func processorRelations() *ProcessorRelations {
+	data, err := logicalProcessorInformation()
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	relations, err := parseProcessorRelations(data)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+	return relations
+}
+
+// Load sets the processors and derives the topology flags and the layout of
+// the dialog from them. relations may be nil.
+func (cs *CpuSets) Load(cpus []CpuSet, relations *ProcessorRelations) {
+	*cs = CpuSets{Relations: relations}
+	cpus = orderByCore(cpus, cs.coreOf)
+	cs.CoreCount = len(cpus)
+
+	// threads are counted per core and not by index, the numbering of the
+	// threads of a core does not need to be contiguous
+	threads := map[[2]int]int{}
+	for _, cpu := range cpus {
+		threads[cs.coreOf(cpu)]++
+	}
+
 	var lastEfficiencyClass, lastLevelCache, lastNumaNodeIndex byte
-	var LogicalCores int
 	var ClassGroup = []int{}
-	for i := 0; i < cs.CoreCount; i++ {
-		cpu := SystemCpuSets[i].CpuSet()
+	for i, cpu := range cpus {
 		if i == 0 { // The EfficiencyClass starts with 1 on the Intel Gen12+
 			lastEfficiencyClass = cpu.EfficiencyClass
 		}
 
-		cs.CPU = append(cs.CPU, CpuSet{
-			Id:                    cpu.Id,
-			CoreIndex:             cpu.CoreIndex,
-			LogicalProcessorIndex: cpu.LogicalProcessorIndex,
-			EfficiencyClass:       cpu.EfficiencyClass,
-			LastLevelCacheIndex:   cpu.LastLevelCacheIndex,
-			NumaNodeIndex:         cpu.NumaNodeIndex,
-		})
-
-		// fmt.Printf("(%02d) [%d/%x] %02d/%02d Eff%d CCD%d NUMA%d\n", i, cpu.Id, cpu.Id, cpu.CoreIndex, cpu.LogicalProcessorIndex, cpu.EfficiencyClass, cpu.LastLevelCacheIndex, cpu.NumaNodeIndex)
-
-		if cpu.CoreIndex != cpu.LogicalProcessorIndex {
-			if !cs.HyperThreading {
-				cs.HyperThreading = true
-			}
-			if cs.MaxThreadsPerCore < int(cpu.LogicalProcessorIndex-cpu.CoreIndex) {
-				cs.MaxThreadsPerCore = int(cpu.LogicalProcessorIndex - cpu.CoreIndex)
-			}
-		} else {
-			LogicalCores++
+		cs.CPU = append(cs.CPU, cpu)
 
+		if n := threads[cs.coreOf(cpu)]; n > 1 {
+			cs.HyperThreading = true
+			cs.MaxThreadsPerCore = max(cs.MaxThreadsPerCore, n-1)
+		}
+		if cs.firstThread(len(cs.CPU) - 1) {
 			for len(ClassGroup) <= int(cpu.EfficiencyClass) {
 				ClassGroup = append(ClassGroup, 0)
 			}
@@ -110,6 +163,10 @@ func (cs *CpuSets) Init() {
 			cs.NumaNode = true
 		}
 
+		if cs.Groups <= int(cpu.Group) {
+			cs.Groups = int(cpu.Group) + 1
+		}
+
 		lastEfficiencyClass = cpu.EfficiencyClass
 		lastLevelCache = cpu.LastLevelCacheIndex
 		lastNumaNodeIndex = cpu.NumaNodeIndex
@@ -123,3 +180,62 @@ func (cs *CpuSets) Init() {
 		})
 	}
 }
+
+// coreOf identifies the core of cpu. The cores of GetLogicalProcessorInformationEx
+// are used when known, otherwise the group and CoreIndex of the CPU set.
+func (cs *CpuSets) coreOf(cpu CpuSet) [2]int {
+	if cs.Relations != nil {
+		if core := cs.Relations.core(cpu.Processor()); core != nil {
+			return [2]int{-1, core.Processors.Processors()[0]}
+		}
+	}
+	return [2]int{int(cpu.Group), int(cpu.CoreIndex)}
+}
+
+// firstThread reports whether cs.CPU[i] is the first thread of its core,
+// Load orders the threads of a core next to each other.
+func (cs *CpuSets) firstThread(i int) bool {
+	return i == 0 || cs.coreOf(cs.CPU[i]) != cs.coreOf(cs.CPU[i-1])
+}
+
+// cores returns the processors of every core, in the order of cs.CPU.
+func (cs *CpuSets) cores() [][]int {
+	var cores [][]int
+	for i, cpu := range cs.CPU {
+		if cs.firstThread(i) {
+			cores = append(cores, nil)
+		}
+		cores[len(cores)-1] = append(cores[len(cores)-1], cpu.Processor())
+	}
+	return cores
+}
+
+// smtThreads returns the second and further threads of every core.
+func (cs *CpuSets) smtThreads() CPUMask {
+	var mask CPUMask
+	for i, cpu := range cs.CPU {
+		if !cs.firstThread(i) {
+			mask = mask.Set(cpu.Processor())
+		}
+	}
+	return mask
+}
+
+// orderByCore moves the threads of every core next to its first one, cores
+// stay in the order in which they appear.
+func orderByCore(cpus []CpuSet, core func(CpuSet) [2]int) []CpuSet {
+	var keys [][2]int
+	byCore := map[[2]int][]CpuSet{}
+	for _, cpu := range cpus {
+		key := core(cpu)
+		if _, ok := byCore[key]; !ok {
+			keys = append(keys, key)
+		}
+		byCore[key] = append(byCore[key], cpu)
+	}
+	ordered := make([]CpuSet, 0, len(cpus))
+	for _, key := range keys {
+		ordered = append(ordered, byCore[key]...)
+	}
+	return ordered
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// cpuSetInformation returns the raw buffer of GetSystemCpuSetInformation.
+func cpuSetInformation() ([]byte, error) {
+	var length uint32
+	// the first call fails and reports the required buffer length in bytes,
+	// it can grow in between when processors are added
+	for attempt := 0; attempt < 4; attempt++ {
+		var data []byte
+		var information *SYSTEM_CPU_SET_INFORMATION
+		if length != 0 {
+			data = make([]byte, length)
+			information = (*SYSTEM_CPU_SET_INFORMATION)(unsafe.Pointer(&data[0]))
+		}
+		err := GetSystemCpuSetInformation(information, uint32(len(data)), &length, 0, 0)
+		switch {
+		case err == nil:
+			return data[:min(int(length), len(data))], nil
+		case err != windows.ERROR_INSUFFICIENT_BUFFER:
+			return nil, fmt.Errorf("GetSystemCpuSetInformation: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("GetSystemCpuSetInformation: the required buffer length keeps changing (%d bytes)", length)
+}
+
+// logicalProcessorInformation returns the raw buffer of GetLogicalProcessorInformationEx for RelationAll.
+func logicalProcessorInformation() ([]byte, error) {
+	var length uint32
+	for attempt := 0; attempt < 4; attempt++ {
+		var data []byte
+		var buffer *byte
+		if length != 0 {
+			data = make([]byte, length)
+			buffer = &data[0]
+		}
+		err := GetLogicalProcessorInformationEx(RelationAll, buffer, &length)
+		switch {
+		case err == nil:
+			return data[:min(int(length), len(data))], nil
+		case err != windows.ERROR_INSUFFICIENT_BUFFER:
+			return nil, fmt.Errorf("GetLogicalProcessorInformationEx: %w", err)
+		}
+	}
+	return nil, fmt.Errorf("GetLogicalProcessorInformationEx: the required buffer length keeps changing (%d bytes)", length)
+}
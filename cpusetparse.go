@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// CpuSetRecord is a decoded SYSTEM_CPU_SET_INFORMATION record of the type CpuSetInformation.
+// https://learn.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-system_cpu_set_information
+type CpuSetRecord struct {
+	Id                    uint32
+	Group                 uint16
+	LogicalProcessorIndex byte
+	CoreIndex             byte
+	LastLevelCacheIndex   byte
+	NumaNodeIndex         byte
+	EfficiencyClass       byte
+	AllFlags              CpuSetFlags
+	SchedulingClass       byte
+	AllocationTag         uint64
+}
+
+// CpuSetFlags is the AllFlags byte of a CPU set.
+type CpuSetFlags byte
+
+// Parked is set for processors parked by core parking when the information was read.
+func (f CpuSetFlags) Parked() bool {
+	return uint32(f)&SYSTEM_CPU_SET_INFORMATION_PARKED != 0
+}
+
+// Allocated is set for processors reserved for the exclusive use of a process.
+func (f CpuSetFlags) Allocated() bool {
+	return uint32(f)&SYSTEM_CPU_SET_INFORMATION_ALLOCATED != 0
+}
+
+func (f CpuSetFlags) AllocatedToTargetProcess() bool {
+	return uint32(f)&SYSTEM_CPU_SET_INFORMATION_ALLOCATED_TO_TARGET_PROCESS != 0
+}
+
+// RealTime is set for processors reserved for real-time use.
+func (f CpuSetFlags) RealTime() bool {
+	return uint32(f)&SYSTEM_CPU_SET_INFORMATION_REALTIME != 0
+}
+
+// Reserved reports whether the processor is allocated or reserved for real-time use.
+func (f CpuSetFlags) Reserved() bool {
+	return f.Allocated() || f.RealTime()
+}
+
+// String lists the states, e.g. "parked, real-time", "" if there are none.
+func (f CpuSetFlags) String() string {
+	var states []string
+	if f.Parked() {
+		states = append(states, "parked")
+	}
+	if f.Allocated() {
+		states = append(states, "allocated")
+	}
+	if f.AllocatedToTargetProcess() {
+		states = append(states, "allocated to this process")
+	}
+	if f.RealTime() {
+		states = append(states, "real-time")
+	}
+	return strings.Join(states, ", ")
+}
+
+const (
+	cpuSetRecordHeaderSize = 8  // Size and Type
+	cpuSetRecordSize       = 32 // up to and including AllocationTag
+)
+
+// parseCpuSetInformation walks the records of a GetSystemCpuSetInformation
+// buffer by their Size field, so records that grow in later versions of
+// Windows are skipped over correctly. Records of other types are ignored.
+func parseCpuSetInformation(data []byte) ([]CpuSetRecord, error) {
+	var records []CpuSetRecord
+	for offset := 0; offset < len(data); {
+		rest := data[offset:]
+		if len(rest) < cpuSetRecordHeaderSize {
+			return records, fmt.Errorf("cpu set information: %d trailing bytes at offset %d", len(rest), offset)
+		}
+		size := binary.LittleEndian.Uint32(rest[0:])
+		recordType := CPU_SET_INFORMATION_TYPE(binary.LittleEndian.Uint32(rest[4:]))
+		if size < cpuSetRecordHeaderSize || uint64(size) > uint64(len(rest)) {
+			return records, fmt.Errorf("cpu set information: invalid record size %d at offset %d, %d bytes left", size, offset, len(rest))
+		}
+		record := rest[:size]
+		offset += int(size)
+
+		if recordType != CpuSetInformation {
+			continue
+		}
+		if size < cpuSetRecordSize {
+			return records, fmt.Errorf("cpu set information: record at offset %d has %d bytes, at least %d expected", offset-int(size), size, cpuSetRecordSize)
+		}
+		records = append(records, CpuSetRecord{
+			Id:                    binary.LittleEndian.Uint32(record[8:]),
+			Group:                 binary.LittleEndian.Uint16(record[12:]),
+			LogicalProcessorIndex: record[14],
+			CoreIndex:             record[15],
+			LastLevelCacheIndex:   record[16],
+			NumaNodeIndex:         record[17],
+			EfficiencyClass:       record[18],
+			AllFlags:              CpuSetFlags(record[19]),
+			SchedulingClass:       record[20],
+			AllocationTag:         binary.LittleEndian.Uint64(record[24:]),
+		})
+	}
+	return records, nil
+}
+
+// decodeCpuSetInformation returns the processors of a GetSystemCpuSetInformation buffer.
+func decodeCpuSetInformation(data []byte) ([]CpuSet, error) {
+	records, err := parseCpuSetInformation(data)
+	if err != nil {
+		return nil, err
+	}
+	cpus := make([]CpuSet, len(records))
+	for i, r := range records {
+		cpus[i] = CpuSet{
+			Id:                    r.Id,
+			Group:                 r.Group,
+			CoreIndex:             r.CoreIndex,
+			LogicalProcessorIndex: r.LogicalProcessorIndex,
+			EfficiencyClass:       r.EfficiencyClass,
+			LastLevelCacheIndex:   r.LastLevelCacheIndex,
+			NumaNodeIndex:         r.NumaNodeIndex,
+			Flags:                 r.AllFlags,
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+var testCpuSets = []CpuSet{
+	{Id: 256, CoreIndex: 0, LogicalProcessorIndex: 0, EfficiencyClass: 1},
+	{Id: 257, CoreIndex: 0, LogicalProcessorIndex: 1, EfficiencyClass: 1, Flags: CpuSetFlags(SYSTEM_CPU_SET_INFORMATION_PARKED)},
+	{Id: 320, Group: 1, CoreIndex: 64, LogicalProcessorIndex: 0, LastLevelCacheIndex: 1, NumaNodeIndex: 1},
+}
+
+// cpuSetRecordHeader returns the header of a record without its body.
+func cpuSetRecordHeader(size, recordType uint32) []byte {
+	header := binary.LittleEndian.AppendUint32(nil, size)
+	return binary.LittleEndian.AppendUint32(header, recordType)
+}
+
+func TestParseCpuSetInformation(t *testing.T) {
+	one := encodeCpuSetInformation(testCpuSets[:1], cpuSetRecordSize)
+	tests := []struct {
+		name    string
+		data    []byte
+		records int // records returned, also with an error
+		err     bool
+	}{
+		{"empty", nil, 0, false},
+		{"three records", encodeCpuSetInformation(testCpuSets, cpuSetRecordSize), 3, false},
+		{"larger records", encodeCpuSetInformation(testCpuSets, 64), 3, false},
+		{"other record type", append(cpuSetRecordHeader(16, 1), make([]byte, 8)...), 0, false},
+		{"other record type between", append(append(one, cpuSetRecordHeader(8, 7)...), one...), 2, false},
+		{"truncated header", append(one, 8, 0, 0, 0), 1, true},
+		{"truncated record", one[:cpuSetRecordSize-1], 0, true},
+		{"size zero", append(one, cpuSetRecordHeader(0, 0)...), 1, true},
+		{"size smaller than the header", cpuSetRecordHeader(4, 0), 0, true},
+		{"size larger than the buffer", append(cpuSetRecordHeader(cpuSetRecordSize+8, 0), one[8:]...), 0, true},
+		{"largest size", append(cpuSetRecordHeader(0xFFFFFFFF, 0), one[8:]...), 0, true},
+		{"record too short", append(cpuSetRecordHeader(24, 0), make([]byte, 16)...), 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := parseCpuSetInformation(tt.data)
+			if (err != nil) != tt.err {
+				t.Errorf("error %v, want error %v", err, tt.err)
+			}
+			if len(records) != tt.records {
+				t.Errorf("%d records, want %d", len(records), tt.records)
+			}
+		})
+	}
+
+	cpus, err := decodeCpuSetInformation(encodeCpuSetInformation(testCpuSets, 40))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cpus, testCpuSets) {
+		t.Errorf("decoded %+v, want %+v", cpus, testCpuSets)
+	}
+}
+
+func FuzzDecodeCpuSetInformation(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(encodeCpuSetInformation(testCpuSets, cpuSetRecordSize))
+	f.Add(encodeCpuSetInformation(testCpuSets, 48))
+	f.Add(append(cpuSetRecordHeader(8, 1), encodeCpuSetInformation(testCpuSets[:1], cpuSetRecordSize)...))
+	f.Add(cpuSetRecordHeader(0xFFFFFFFF, 0))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cpus, err := decodeCpuSetInformation(data)
+		if err != nil {
+			return
+		}
+		if len(cpus) > len(data)/cpuSetRecordSize {
+			t.Fatalf("%d processors from %d bytes", len(cpus), len(data))
+		}
+		// the processors survive writing them again
+		again, err := decodeCpuSetInformation(encodeCpuSetInformation(cpus, cpuSetRecordSize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(cpus) != 0 && !reflect.DeepEqual(again, cpus) {
+			t.Fatalf("decoded again %+v, want %+v", again, cpus)
+		}
+	})
+}
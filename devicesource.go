@@ -0,0 +1,53 @@
+package main
+
+import (
+	"errors"
+)
+
+// DeviceSource enumerates the devices, reads their properties and gives
+// every device the PolicyStore of its device key, and restarts them. The
+// running system is read with SetupAPI, -offline reads a SYSTEM hive and
+// -machine-fixture replays a recorded machine.
+type DeviceSource interface {
+	// Devices returns the devices with their properties and interrupt settings.
+	Devices() ([]Device, error)
+	// CanRestart is false if changes only take effect the next time Windows boots.
+	CanRestart() bool
+	// Restart makes dev pick up its new settings. needReboot is true if
+	// the device could not be restarted.
+	Restart(dev *Device) (needReboot bool, err error)
+	// Close releases the devices and saves the changes of a file.
+	Close() error
+}
+
+// source is the DeviceSource chosen by the global options, opened by loadDevices.
+var source DeviceSource
+
+var errCannotRestart = errors.New("devices of an offline hive cannot be restarted")
+
+// openDeviceSource opens the SYSTEM hive of -offline, the fixture of
+// -machine-fixture or the running system.
+func openDeviceSource() (DeviceSource, error) {
+	switch {
+	case flagOffline != "" && flagMachineFixture != "":
+		return nil, errors.New("-offline and -machine-fixture cannot be combined")
+	case flagOffline != "":
+		system, err := OpenOfflineSystem(flagOffline)
+		if err != nil {
+			return nil, err
+		}
+		return system, nil
+	case flagMachineFixture != "":
+		fixture, err := OpenMachineFixture(flagMachineFixture)
+		if err != nil {
+			return nil, err
+		}
+		return fixture, nil
+	}
+	return liveDeviceSource()
+}
+
+// canRestart reports whether the devices of the source can be restarted.
+func canRestart() bool {
+	return source == nil || source.CanRestart()
+}
@@ -1,3 +1,5 @@
+//go:build windows
+
 package main
 
 import (
@@ -6,7 +8,6 @@ import (
 	"math"
 	"os"
 	"os/exec"
-	"sort"
 	"strings"
 
 	"github.com/tailscale/walk"
@@ -27,8 +28,15 @@ type IrqPrioritys struct {
 }
 
 type CheckBoxList struct {
-	Widget []Widget
-	List   []*walk.CheckBox
+	Widget    []Widget
+	List      []*walk.CheckBox
+	OnChanged func() // called after the selection changed
+}
+
+func (checkboxlist *CheckBoxList) changed() {
+	if checkboxlist.OnChanged != nil {
+		checkboxlist.OnChanged()
+	}
 }
 
 func IrqPolicy() []*IrqPolicys {
@@ -61,6 +69,19 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 	var devicePolicyCB, devicePriorityCB *walk.ComboBox
 	var deviceMessageNumberLimitNE *walk.NumberEdit
 	var checkBoxList = new(CheckBoxList)
+	var simulationTE *walk.TextEdit
+	simulationText := func() string {
+		simulation := SimulateInterrupts(device, &cs, device.NumaNode)
+		return strings.Join(simulation.Lines(), "\r\n")
+	}
+	updateSimulation := func() {
+		if simulationTE != nil {
+			simulationTE.SetText(simulationText())
+		}
+	}
+	checkBoxList.OnChanged = updateSimulation
+	l2Groups, _ := cs.l2Groups() // nil without cache information, the buttons are hidden then
+	eCoreClusters, _ := cs.eCoreClusters()
 
 	return Dialog{
 		AssignTo:      &dlg,
@@ -107,6 +128,59 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 								Text:     Bind("device.DevObjName == '' ? 'N/A' : device.DevObjName"),
 								ReadOnly: true,
 							},
+
+							Label{
+								Text: "Class:",
+							},
+							Label{
+								Text: orNA(deviceClass(device)),
+							},
+
+							Label{
+								Text: "Service:",
+							},
+							Label{
+								Text: Bind("device.Driver == '' ? 'N/A' : device.Driver"),
+							},
+
+							Label{
+								Text: "PCI / NUMA Node:",
+							},
+							Label{
+								Text: orNA(device.PCI.String()) + " / " + orNA(numaNodeName(device.NumaNode)),
+							},
+
+							Label{
+								Text: "Instance ID:",
+							},
+							LineEdit{
+								Text:     Bind("device.InstanceID == '' ? 'N/A' : device.InstanceID"),
+								ReadOnly: true,
+							},
+
+							Label{
+								Text: "Hardware IDs:",
+							},
+							LineEdit{
+								Text:     orNA(strings.Join(device.DeviceIDs, ", ")),
+								ReadOnly: true,
+							},
+
+							Label{
+								Text: "Parent:",
+							},
+							LineEdit{
+								Text:     Bind("device.Parent == '' ? 'N/A' : device.Parent"),
+								ReadOnly: true,
+							},
+
+							Label{
+								Text: "Container ID:",
+							},
+							LineEdit{
+								Text:     Bind("device.ContainerID == '' ? 'N/A' : device.ContainerID"),
+								ReadOnly: true,
+							},
 						},
 					},
 
@@ -131,6 +205,7 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 										device.MsiSupported = 0
 										deviceMessageNumberLimitNE.SetEnabled(false)
 									}
+									updateSimulation()
 								},
 							},
 
@@ -156,6 +231,7 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 										Value:              Bind("device.MessageNumberLimit < 1.0 ? 1.0 : device.MessageNumberLimit"),
 										OnValueChanged: func() {
 											device.MessageNumberLimit = uint32(deviceMessageNumberLimitNE.Value())
+											updateSimulation()
 										},
 									},
 								},
@@ -219,6 +295,7 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 											}
 
 											device.DevicePolicy = currentIndex
+											updateSimulation()
 											if device.DevicePolicy == 4 {
 												cpuArrayComView.SetVisible(true)
 												return
@@ -290,6 +367,24 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 												},
 											},
 
+											PushButton{
+												Text:        "L2 Group",
+												ToolTipText: "Selects the processors sharing an L2 cache, click again for the next group.",
+												Visible:     len(l2Groups) > 1,
+												OnClicked: func() {
+													checkBoxList.nextGroup(&device.AssignmentSetOverride, l2Groups)
+												},
+											},
+
+											PushButton{
+												Text:        "E-Core Cluster",
+												ToolTipText: "Selects a cluster of E-cores sharing an L2 cache, click again for the next cluster.",
+												Visible:     len(eCoreClusters) > 0,
+												OnClicked: func() {
+													checkBoxList.nextGroup(&device.AssignmentSetOverride, eCoreClusters)
+												},
+											},
+
 											HSpacer{},
 										},
 									},
@@ -298,6 +393,22 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 						},
 					},
 
+					GroupBox{
+						Title:       "Simulated Interrupt Targets",
+						ToolTipText: "The processors Windows is expected to deliver the interrupts of the device to with the settings above.",
+						Layout:      VBox{},
+						Children: []Widget{
+							TextEdit{
+								AssignTo: &simulationTE,
+								Text:     simulationText(),
+								ReadOnly: true,
+								VScroll:  true,
+								MinSize:  Size{Height: 60},
+								MaxSize:  Size{Height: 120},
+							},
+						},
+					},
+
 					GroupBox{
 						Title:  "Registry",
 						Layout: HBox{},
@@ -343,7 +454,7 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 										}
 										defer file.Close()
 
-										file.WriteString(createRegFile(regPath, device))
+										file.Write(createRegFile(regPath, device))
 									}
 								},
 							},
@@ -361,17 +472,11 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 						return "", nil
 					},
 					"viewAsHex": func(args ...interface{}) (interface{}, error) {
-						if args[0].(Bits) == ZeroBit {
+						mask := args[0].(CPUMask)
+						if mask.IsZero() {
 							return "N/A", nil
 						}
-						bits := args[0].(Bits)
-						var result []string
-						for bit, cpu := range CPUMap {
-							if Has(bit, bits) {
-								result = append(result, cpu)
-							}
-						}
-						return strings.Join(result, ", "), nil
+						return strings.ReplaceAll(mask.String(), ",", ", "), nil
 					},
 					"eq": func(args ...interface{}) (interface{}, error) {
 						if len(args) != 2 {
@@ -403,7 +508,7 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 						AssignTo: &acceptPB,
 						Text:     "OK",
 						OnClicked: func() {
-							if device.DevicePolicy == 4 && device.AssignmentSetOverride == ZeroBit {
+							if device.DevicePolicy == 4 && device.AssignmentSetOverride.IsZero() {
 								walk.MsgBox(dlg, "Invalid Option", "The affinity mask must contain at least one processor.", walk.MsgBoxIconError)
 							} else {
 								if err := db.Submit(); err != nil {
@@ -424,21 +529,45 @@ func RunDialog(owner walk.Form, device *Device) (int, error) {
 	}.Run(owner)
 }
 
-func (checkboxlist *CheckBoxList) create(bits *Bits) []Widget {
+func (checkboxlist *CheckBoxList) create(mask *CPUMask) []Widget {
+	checkboxlist.List = make([]*walk.CheckBox, len(cs.CPU))
+	if cs.Groups <= 1 {
+		return checkboxlist.createGroup(mask, 0, len(cs.CPU))
+	}
+
+	// systems with more than 64 logical processors, one box per processor group
+	var groups []Widget
+	start := 0
+	for i := 1; i <= len(cs.CPU); i++ {
+		if i < len(cs.CPU) && cs.CPU[i].Group == cs.CPU[start].Group {
+			continue
+		}
+		groups = append(groups, GroupBox{
+			Title:    fmt.Sprintf("Processor Group %d", cs.CPU[start].Group),
+			Layout:   HBox{},
+			Children: checkboxlist.createGroup(mask, start, i),
+		})
+		start = i
+	}
+	return groups
+}
+
+// createGroup creates the checkboxes for cs.CPU[start:end].
+func (checkboxlist *CheckBoxList) createGroup(mask *CPUMask, start, end int) []Widget {
 	var children, partThread, partCore, partNUMA, partGroup, partCache []Widget
 	var lastEfficiencyClass, lastNumaNodeIndex, lastLastLevelCache byte
 	var cpuCount, numaCount, llcCount int
 
-	checkboxlist.List = make([]*walk.CheckBox, len(cs.CPU))
-	for i, cpuThread := range cs.CPU {
+	for i := start; i < end; i++ {
+		cpuThread := cs.CPU[i]
 		// fmt.Printf("\n\n%d - Core: %d, LogicalProc: %d, Effi: %d\n", i,cpu.CoreIndex, cs.CPU[i].LogicalProcessorIndex, cs.CPU[i].EfficiencyClass)
 
-		var isLastItem = i+1 == cs.CoreCount
-		if i == 0 { // The EfficiencyClass starts with 1 on the Intel Gen12+
+		var isLastItem = i+1 == end
+		if i == start { // The EfficiencyClass starts with 1 on the Intel Gen12+
 			lastEfficiencyClass = cpuThread.EfficiencyClass
 		}
 
-		if len(partThread) != 0 && cpuThread.CoreIndex == cpuThread.LogicalProcessorIndex {
+		if len(partThread) != 0 && cs.firstThread(i) {
 			partCore = append(partCore, GroupBox{
 				Title:     fmt.Sprintf("Core %d", cpuCount),
 				Alignment: AlignHCenterVNear,
@@ -451,19 +580,27 @@ func (checkboxlist *CheckBoxList) create(bits *Bits) []Widget {
 			partThread = nil
 		}
 
-		local_CPUBits := CPUBits[i]
+		processor := cpuThread.Processor()
 		checkboxlist.List[i] = new(walk.CheckBox)
 
+		text, toolTip := fmt.Sprintf("Thread %d", processor), ""
+		if state := cpuThread.Flags.String(); state != "" {
+			text += " (" + state + ")"
+			toolTip = fmt.Sprintf("The processor was %s when the program started.", state)
+		}
+
 		partThread = append(partThread, CheckBox{
 			ColumnSpan:         3,
 			RowSpan:            3,
 			AlwaysConsumeSpace: true,
 			StretchFactor:      2,
-			Text:               fmt.Sprintf("Thread %d", cpuThread.LogicalProcessorIndex),
+			Text:               text,
+			ToolTipText:        toolTip,
 			AssignTo:           &checkboxlist.List[i],
-			Checked:            Has(*bits, local_CPUBits),
+			Checked:            mask.Has(processor),
 			OnClicked: func() {
-				*bits = Toggle(local_CPUBits, *bits)
+				*mask = mask.Toggle(processor)
+				checkboxlist.changed()
 			},
 		})
 
@@ -478,7 +615,7 @@ func (checkboxlist *CheckBoxList) create(bits *Bits) []Widget {
 			})
 		}
 
-		if i == 0 {
+		if i == start {
 			continue
 		}
 
@@ -606,55 +743,75 @@ func (checkboxlist *CheckBoxList) create(bits *Bits) []Widget {
 	}
 }
 
-func (checkboxlist *CheckBoxList) allOn(bits *Bits) {
+func (checkboxlist *CheckBoxList) allOn(mask *CPUMask) {
 	for i := 0; i < len(checkboxlist.List); i++ {
-		*bits = Set(CPUBits[i], *bits)
+		*mask = mask.Set(cs.CPU[i].Processor())
 		checkboxlist.List[i].SetChecked(true)
 	}
+	checkboxlist.changed()
 }
-func (checkboxlist *CheckBoxList) allOff(bits *Bits) {
+func (checkboxlist *CheckBoxList) allOff(mask *CPUMask) {
 	for i := 0; i < len(checkboxlist.List); i++ {
 		checkboxlist.List[i].SetChecked(false)
 	}
-	*bits = Bits(0)
+	*mask = nil
+	checkboxlist.changed()
 }
 
-func (checkboxlist *CheckBoxList) htOff(bits *Bits) {
+func (checkboxlist *CheckBoxList) htOff(mask *CPUMask) {
+	smt := cs.smtThreads()
 	for i := 0; i < len(cs.CPU); i++ {
-		if cs.CPU[i].CoreIndex != cs.CPU[i].LogicalProcessorIndex {
+		if smt.Has(cs.CPU[i].Processor()) {
 			checkboxlist.List[i].SetChecked(false)
-			if Has(CPUBits[i], *bits) {
-				*bits = Toggle(CPUBits[i], *bits)
-			}
+			*mask = mask.Clear(cs.CPU[i].Processor())
+		}
+	}
+	checkboxlist.changed()
+}
+
+// nextGroup selects only the next of groups after the one currently
+// selected, so repeated clicks walk through the L2 groups or E-core clusters.
+func (checkboxlist *CheckBoxList) nextGroup(mask *CPUMask, groups []CPUMask) {
+	next := 0
+	for i, group := range groups {
+		if group.Equal(*mask) {
+			next = (i + 1) % len(groups)
 		}
 	}
+	checkboxlist.only(mask, groups[next])
 }
 
-func (checkboxlist *CheckBoxList) pCoreOnly(bits *Bits) {
+// only selects the processors of selection.
+func (checkboxlist *CheckBoxList) only(mask *CPUMask, selection CPUMask) {
+	*mask = nil
 	for i := 0; i < len(cs.CPU); i++ {
-		if cs.CPU[i].EfficiencyClass == 1 {
-			checkboxlist.List[i].SetChecked(true)
-			*bits = Set(CPUBits[i], *bits)
-		} else {
-			checkboxlist.List[i].SetChecked(false)
-			if Has(CPUBits[i], *bits) {
-				*bits = Toggle(CPUBits[i], *bits)
-			}
+		on := selection.Has(cs.CPU[i].Processor())
+		checkboxlist.List[i].SetChecked(on)
+		if on {
+			*mask = mask.Set(cs.CPU[i].Processor())
 		}
 	}
+	checkboxlist.changed()
+}
+
+func (checkboxlist *CheckBoxList) pCoreOnly(mask *CPUMask) {
+	checkboxlist.onlyEfficiencyClass(mask, 1)
 }
-func (checkboxlist *CheckBoxList) eCoreOnly(bits *Bits) {
+func (checkboxlist *CheckBoxList) eCoreOnly(mask *CPUMask) {
+	checkboxlist.onlyEfficiencyClass(mask, 0)
+}
+
+func (checkboxlist *CheckBoxList) onlyEfficiencyClass(mask *CPUMask, class byte) {
 	for i := 0; i < len(cs.CPU); i++ {
-		if cs.CPU[i].EfficiencyClass == 0 {
-			checkboxlist.List[i].SetChecked(true)
-			*bits = Set(CPUBits[i], *bits)
+		on := cs.CPU[i].EfficiencyClass == class
+		checkboxlist.List[i].SetChecked(on)
+		if on {
+			*mask = mask.Set(cs.CPU[i].Processor())
 		} else {
-			checkboxlist.List[i].SetChecked(false)
-			if Has(CPUBits[i], *bits) {
-				*bits = Toggle(CPUBits[i], *bits)
-			}
+			*mask = mask.Clear(cs.CPU[i].Processor())
 		}
 	}
+	checkboxlist.changed()
 }
 
 // https://docs.microsoft.com/de-de/windows-hardware/drivers/kernel/enabling-message-signaled-interrupts-in-the-registry
@@ -666,20 +823,6 @@ func hasMsiX(b Bits) float64 {
 	}
 }
 
-func interruptType(b Bits) string {
-	if b == ZeroBit {
-		return ""
-	}
-	var types []string
-	for bit, name := range InterruptTypeMap {
-		if Has(b, bit) {
-			types = append(types, name)
-		}
-	}
-	sort.Strings(types)
-	return strings.Join(types, ", ")
-}
-
 func CalculateMargins(value int) Margins {
 	if cs.MaxThreadsPerCore+1 == value {
 		return Margins{
@@ -698,3 +841,11 @@ func CalculateMargins(value int) Margins {
 		}
 	}
 }
+
+// orNA returns s, or "N/A" like the bound labels if it is empty.
+func orNA(s string) string {
+	if s == "" {
+		return "N/A"
+	}
+	return s
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// deviceRegKeys describes the interrupt settings of dev as .reg sections below regPath.
+// Parsing the result with applyRegKey reproduces every setting of dev.
+// Settings at their default are written as key deletions, so importing the
+// result also removes keys that were created later.
+func deviceRegKeys(regPath string, dev *Device) []RegKey {
+	var keys []RegKey
+
+	affinity := RegKey{Path: regPath + `\` + affinityPolicyKey}
+	if dev.DevicePolicy == 0 && dev.DevicePriority == 0 && dev.AssignmentSetOverride.IsZero() {
+		affinity.Delete = true
+	} else {
+		affinity.Values = append(affinity.Values, RegValue{Name: "DevicePolicy", Type: RegDWord, DWord: dev.DevicePolicy})
+		affinity.Values = append(affinity.Values, dwordOrDelete("DevicePriority", dev.DevicePriority))
+		if dev.AssignmentSetOverride.IsZero() {
+			affinity.Values = append(affinity.Values, RegValue{Name: "AssignmentSetOverride", Type: RegDelete})
+		} else {
+			data := dev.AssignmentSetOverride.Bytes()
+			affinity.Values = append(affinity.Values, RegValue{Name: "AssignmentSetOverride", Type: RegBinary, Kind: 3, Data: data[:clen(data)]})
+		}
+	}
+	keys = append(keys, affinity)
+
+	if dev.MsiSupported == 2 { // no interrupt support reported
+		return withInterruptManagementKey(regPath, keys)
+	}
+
+	msi := RegKey{Path: regPath + `\` + msiPropertiesKey}
+	if dev.MsiSupported == 0 && dev.MessageNumberLimit == 0 {
+		msi.Delete = true
+	} else {
+		msi.Values = append(msi.Values, RegValue{Name: "MSISupported", Type: RegDWord, DWord: dev.MsiSupported})
+		msi.Values = append(msi.Values, dwordOrDelete("MessageNumberLimit", dev.MessageNumberLimit))
+	}
+	return withInterruptManagementKey(regPath, append(keys, msi))
+}
+
+// withInterruptManagementKey prepends the parent key if any subkey is written.
+func withInterruptManagementKey(regPath string, keys []RegKey) []RegKey {
+	for _, key := range keys {
+		if !key.Delete {
+			return append([]RegKey{{Path: regPath + `\` + interruptManagementKey}}, keys...)
+		}
+	}
+	return keys
+}
+
+// createRegFile returns the settings of item as a .reg file in regedit's UTF-16LE format.
+func createRegFile(regpath string, item *Device) []byte {
+	rf := RegFile{Keys: deviceRegKeys(regpath, item)}
+	return rf.Marshal()
+}
+
+// machineBackup returns one .reg file with the settings of every device.
+func machineBackup(devices []Device, created time.Time, computerName string) *RegFile {
+	rf := &RegFile{
+		Comments: []string{
+			"GoInterruptPolicy backup",
+			"Created: " + created.Format(time.RFC3339),
+			"Computer: " + computerName,
+		},
+	}
+
+	var count int
+	for i := range devices {
+		dev := &devices[i]
+		if dev.RegPath == "" {
+			continue
+		}
+		keys := deviceRegKeys(dev.RegPath, dev)
+		keys[0].Comments = deviceComments(dev)
+		rf.Keys = append(rf.Keys, keys...)
+		count++
+	}
+	rf.Comments = append(rf.Comments, fmt.Sprintf("Devices: %d", count))
+	return rf
+}
+
+func deviceComments(dev *Device) []string {
+	comments := []string{"Device: " + deviceTitle(dev)}
+	if dev.LocationInformation != "" {
+		comments = append(comments, "Location: "+dev.LocationInformation)
+	}
+	if dev.PCI.Valid {
+		comments = append(comments, "PCI: "+dev.PCI.String())
+	}
+	if dev.InstanceID != "" {
+		comments = append(comments, "Instance ID: "+dev.InstanceID)
+	}
+	return comments
+}
+
+// backupFileName returns the default name of a machine backup.
+func backupFileName(created time.Time, computerName string) string {
+	return fmt.Sprintf("GoInterruptPolicy_%s_%s.reg", computerName, created.Format("20060102-150405"))
+}
+
+func dwordOrDelete(name string, value uint32) RegValue {
+	if value == 0 {
+		return RegValue{Name: name, Type: RegDelete}
+	}
+	return RegValue{Name: name, Type: RegDWord, DWord: value}
+}
+
+// writeMachineBackup writes the backup of all devices to path. If path is a
+// directory the file gets the default name. It returns the written file.
+func writeMachineBackup(path string, devices []Device) (string, error) {
+	computerName, err := os.Hostname()
+	if err != nil {
+		computerName = "unknown"
+	}
+	created := time.Now()
+
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, backupFileName(created, computerName))
+	}
+
+	rf := machineBackup(devices, created, computerName)
+	return path, os.WriteFile(path, rf.Marshal(), 0o644)
+}
@@ -16,29 +16,72 @@ var (
 	flagRestart            bool
 	flagRestartOnChange    bool
 	flagHelp               bool
+	flagImport             string
+	flagBackup             string
+	flagProfileApply       string
+	flagProfileVerify      string
+	flagProfileGenerate    string
+	flagReconcile          string
+	flagFix                bool
+	flagReport             string
+	flagLint               bool
+	flagOffline            string
+	flagMachineFixture     string
+	flagIncludeInactive    bool
+	flagInstance           string
+	flagDryRun             bool
+	flagTopologyFile       string // debug builds only
 
 	CLIMode bool
 )
 
 func init() {
 	flag.StringVar(&flagDevObjName, "devobj", "", "\\Device\\00000123")
-	flag.StringVar(&flagCPU, "cpu", "", "e.g. 0,1,2,4")
+	flag.StringVar(&flagInstance, "instance", "", "Select the device by instance ID instead of -devobj, e.g. PCI\\VEN_8086&DEV_15B8&...\\3&11583659&0&FE")
+	flag.StringVar(&flagCPU, "cpu", "", "Processors, e.g. 0,2,4-7, 1:0 (group relative), 0xF0, all,^0, pcores, ecores, no-smt, ccd1, llc0, numa1, core3, core3.t1, l2g0, ecluster0")
 	flag.IntVar(&flagDevicePriority, "priority", -1, "0=Undefined, 1=Low, 2=Normal, 3=High")
 	flag.IntVar(&flagDevicePolicy, "policy", -1, "0=Default, 1=All Close Proc, 2=One Close Proc, 3=All Proc in Machine, 4=Specified Proc, 5=Spread Messages Across All Proc")
 	flag.IntVar(&flagMsiSupported, "msisupported", -1, "0=Off, 1=On")
 	flag.IntVar(&flagMessageNumberLimit, "msilimit", -1, "Message Signaled Interrupt Limit")
 	flag.BoolVar(&flagRestart, "restart", false, "Restart target device")
 	flag.BoolVar(&flagRestartOnChange, "restart-on-change", false, "Restart target device on change")
+	flag.StringVar(&flagImport, "import", "", "Apply the settings of a .reg file, e.g. one created by \"Export current settings\"")
+	flag.StringVar(&flagBackup, "backup", "", "Write the settings of all devices into one .reg file (file or directory)")
+	flag.StringVar(&flagProfileApply, "profile-apply", "", "Apply a device profile (.json, .yaml)")
+	flag.StringVar(&flagProfileVerify, "profile-verify", "", "Compare the devices with a profile, exit code 2 if they differ")
+	flag.StringVar(&flagProfileGenerate, "profile-generate", "", "Write a profile of the current settings (.json, .yaml)")
+	flag.StringVar(&flagReconcile, "reconcile", "", "Report drift against a profile as JSON. Exit code 0=in sync, 1=error, 2=drift or a missing device, 3=drift fixed")
+	flag.BoolVar(&flagFix, "fix", false, "With -reconcile: re-apply the profile and restart the affected devices")
+	flag.StringVar(&flagReport, "report", "", "With -reconcile: write the JSON report to this file instead of stdout")
+	flag.BoolVar(&flagLint, "lint", false, "Check all devices for invalid or risky settings, exit code 2 on errors")
+	flag.StringVar(&flagOffline, "offline", "", "Work on an offline SYSTEM hive (Windows\\System32\\config\\SYSTEM) instead of the running system")
+	flag.StringVar(&flagMachineFixture, "machine-fixture", "", "Replay the devices and processors of a file written by the record command instead of the running system, changes are not saved")
+	flag.BoolVar(&flagIncludeInactive, "include-inactive", false, "Also list disabled and not present (phantom) devices, e.g. to set the MSI mode of an unplugged device in advance")
+	flag.BoolVar(&flagDryRun, "dry-run", false, "Print the registry operations of a change instead of writing them, also for the OK button of the dialog")
 	flag.BoolVar(&flagHelp, "help", false, "Print Defaults")
+	if debugBuild {
+		flag.StringVar(&flagTopologyFile, "topology-file", "", "Use the processor topology of a fixture (fixtures/topology/*.json) or of a file written by the capture command instead of the one of this system")
+	}
+
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		printUsage(out)
+		fmt.Fprintln(out, "\nOptions without a command (deprecated, use the commands above):")
+		flag.PrintDefaults()
+	}
+}
 
+// parseFlags parses the command line, it is not done in init so that tests
+// can run without the options of the program.
+func parseFlags() {
 	flag.Parse()
 	if flagHelp {
-		fmt.Printf("Usage: %s [OPTIONS] argument ...\n", os.Args[0])
-		flag.PrintDefaults()
+		flag.CommandLine.SetOutput(os.Stdout)
+		flag.Usage()
 		os.Exit(0)
 	}
 
-	if flagDevObjName != "" || flagDevicePriority != -1 || flagDevicePolicy != -1 || flagMsiSupported != -1 || flagMessageNumberLimit != -1 || flagRestart || flagRestartOnChange {
+	if flagDevObjName != "" || flagInstance != "" || flagOffline != "" || flagDevicePriority != -1 || flagDevicePolicy != -1 || flagMsiSupported != -1 || flagMessageNumberLimit != -1 || flagRestart || flagRestartOnChange || flagImport != "" || flagBackup != "" || flagProfileApply != "" || flagProfileVerify != "" || flagProfileGenerate != "" || flagReconcile != "" || flagLint {
 		CLIMode = true
 	}
 
@@ -46,39 +89,9 @@ func init() {
 		fmt.Println("DevObjName:", flagDevObjName)
 	}
 	if flagDevicePriority != -1 {
-		var prio string
-		switch flagDevicePriority {
-		case 0:
-			prio = "Undefined"
-		case 1:
-			prio = "Low"
-		case 2:
-			prio = "Normal"
-		case 3:
-			prio = "High"
-		default:
-			prio = fmt.Sprintf("%d", flagDevicePriority)
-		}
-		fmt.Println("DevicePriority:", prio)
+		fmt.Println("DevicePriority:", priorityName(uint32(flagDevicePriority)))
 	}
 	if flagDevicePolicy != -1 {
-		var policy string
-		switch flagDevicePolicy {
-		case IrqPolicyMachineDefault: // 0x00
-			policy = "Default"
-		case IrqPolicyAllCloseProcessors: // 0x01
-			policy = "All Close Proc"
-		case IrqPolicyOneCloseProcessor: // 0x02
-			policy = "One Close Proc"
-		case IrqPolicyAllProcessorsInMachine: // 0x03
-			policy = "All Proc in Machine"
-		case IrqPolicySpecifiedProcessors: // 0x04
-			policy = "Specified Proc"
-		case IrqPolicySpreadMessagesAcrossAllProcessors: // 0x05
-			policy = "Spread Messages Across All Proc"
-		default:
-			policy = fmt.Sprintf("%d", flagDevicePolicy)
-		}
-		fmt.Println("DevicePolicy:", policy)
+		fmt.Println("DevicePolicy:", policyName(uint32(flagDevicePolicy)))
 	}
 }
@@ -6,6 +6,7 @@ require (
 	github.com/intel-go/cpuid v0.0.0-20220614022739-219e067757cb
 	github.com/tailscale/walk v0.0.0-20240108184108-6a278000867c
 	golang.org/x/sys v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,1208 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// Registry value types used in hives.
+const (
+	REG_NONE      = 0
+	REG_SZ        = 1
+	REG_EXPAND_SZ = 2
+	REG_BINARY    = 3
+	REG_DWORD     = 4
+	REG_MULTI_SZ  = 7
+)
+
+// https://github.com/msuhanov/regf/blob/master/Windows%20registry%20file%20format%20specification.md
+const (
+	hiveBaseBlockSize = 4096
+	hiveBinHeaderSize = 32
+	hiveBinAlignment  = 4096
+	hiveNoCell        = 0xFFFFFFFF
+	hiveBigDataLimit  = 16344 // larger values are split into "db" segments
+
+	// base block
+	regfSequence1  = 4
+	regfSequence2  = 8
+	regfTimestamp  = 12
+	regfMajor      = 20
+	regfRootCell   = 36
+	regfBinsLength = 40
+	regfChecksum   = 508
+
+	// key node, offsets into the cell data
+	nkFlags          = 2
+	nkTimestamp      = 4
+	nkParent         = 16
+	nkSubkeyCount    = 20
+	nkVolatileCount  = 24
+	nkSubkeyList     = 28
+	nkVolatileList   = 32
+	nkValueCount     = 36
+	nkValueList      = 40
+	nkSecurity       = 44
+	nkClass          = 48
+	nkMaxSubkeyName  = 52
+	nkMaxValueName   = 60
+	nkMaxValueData   = 64
+	nkNameLength     = 72
+	nkClassLength    = 74
+	nkName           = 76
+	nkFlagCompName   = 0x20
+	skReferenceCount = 12
+
+	// value
+	vkNameLength   = 2
+	vkDataSize     = 4
+	vkDataOffset   = 8
+	vkType         = 12
+	vkFlags        = 16
+	vkName         = 20
+	vkFlagCompName = 0x1
+	vkDataResident = 0x80000000
+)
+
+var le = binary.LittleEndian
+
+// Hive is a registry hive file in the regf format, e.g. an offline
+// Windows\System32\config\SYSTEM. Changes are made in memory until Save.
+type Hive struct {
+	data  []byte // base block followed by the hive bins
+	dirty bool
+}
+
+// HiveKey is a key node of a Hive.
+type HiveKey struct {
+	hive *Hive
+	cell uint32
+}
+
+// HiveValue is a value of a HiveKey.
+type HiveValue struct {
+	Name string
+	Type uint32
+	Data []byte
+}
+
+// OpenHive reads a hive file.
+func OpenHive(path string) (*Hive, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseHive(data)
+}
+
+// ParseHive checks the base block and returns the hive. Hives with changes
+// that are only in the transaction logs (.LOG1, .LOG2) are refused.
+func ParseHive(data []byte) (*Hive, error) {
+	if len(data) < hiveBaseBlockSize+hiveBinHeaderSize || string(data[:4]) != "regf" {
+		return nil, fmt.Errorf("not a registry hive")
+	}
+	if le.Uint32(data[regfChecksum:]) != hiveChecksum(data) {
+		return nil, fmt.Errorf("hive base block checksum mismatch")
+	}
+	if le.Uint32(data[regfMajor:]) != 1 {
+		return nil, fmt.Errorf("unsupported hive version %d", le.Uint32(data[regfMajor:]))
+	}
+	if le.Uint32(data[regfSequence1:]) != le.Uint32(data[regfSequence2:]) {
+		return nil, fmt.Errorf("hive was not unloaded cleanly, its transaction logs have to be replayed first (load and unload it once with reg.exe)")
+	}
+	binsLength := int(le.Uint32(data[regfBinsLength:]))
+	if binsLength%hiveBinAlignment != 0 || hiveBaseBlockSize+binsLength > len(data) {
+		return nil, fmt.Errorf("invalid hive bins length %d", binsLength)
+	}
+
+	h := &Hive{data: data[:hiveBaseBlockSize+binsLength]}
+	for offset := hiveBaseBlockSize; offset < len(h.data); {
+		end, err := h.binEnd(offset)
+		if err != nil {
+			return nil, err
+		}
+		// every cell has to fit into its bin, allocate walks them
+		for abs := offset + hiveBinHeaderSize; abs < end; {
+			size, _, err := h.cellSize(abs, end)
+			if err != nil {
+				return nil, err
+			}
+			abs += size
+		}
+		offset = end
+	}
+	if _, err := h.keyNode(h.rootCell()); err != nil {
+		return nil, fmt.Errorf("root key: %w", err)
+	}
+	return h, nil
+}
+
+func hiveChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < regfChecksum; i += 4 {
+		sum ^= le.Uint32(data[i:])
+	}
+	switch sum {
+	case 0xFFFFFFFF:
+		return 0xFFFFFFFE
+	case 0:
+		return 1
+	}
+	return sum
+}
+
+// Dirty reports whether the hive has changes that are not saved.
+func (h *Hive) Dirty() bool { return h.dirty }
+
+// Bytes returns the hive with an updated base block.
+func (h *Hive) Bytes() []byte {
+	if h.dirty {
+		sequence := le.Uint32(h.data[regfSequence1:]) + 1
+		le.PutUint32(h.data[regfSequence1:], sequence)
+		le.PutUint32(h.data[regfSequence2:], sequence)
+		le.PutUint64(h.data[regfTimestamp:], fileTime(time.Now()))
+		le.PutUint32(h.data[regfBinsLength:], uint32(len(h.data)-hiveBaseBlockSize))
+		le.PutUint32(h.data[regfChecksum:], hiveChecksum(h.data))
+		h.dirty = false
+	}
+	return h.data
+}
+
+// Save writes the hive to path. The hive is written to a temporary file in
+// the same directory and renamed over path, so a crash or a full disk never
+// leaves a partly written hive behind; the previous file is kept as
+// path.bak. The transaction logs next to the file are not touched, Windows
+// ignores them as they are older than the hive.
+func (h *Hive) Save(path string) error {
+	mode := os.FileMode(0o644)
+	previous, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if err := writeFileSync(path+".bak", previous, mode); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(h.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeFileSync is os.WriteFile that also flushes the file to the disk.
+func writeFileSync(path string, data []byte, mode os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// fileTime converts t into a Windows FILETIME.
+func fileTime(t time.Time) uint64 {
+	return uint64(t.UnixNano()/100) + 116444736000000000
+}
+
+func fileTimeToTime(ft uint64) time.Time {
+	if ft < 116444736000000000 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(ft-116444736000000000)*100)
+}
+
+func (h *Hive) rootCell() uint32 {
+	return le.Uint32(h.data[regfRootCell:])
+}
+
+// Root returns the root key of the hive.
+func (h *Hive) Root() HiveKey {
+	return HiveKey{hive: h, cell: h.rootCell()}
+}
+
+// cell returns the data of an allocated cell. The slice is only valid
+// until the next allocation.
+func (h *Hive) cell(offset uint32) ([]byte, error) {
+	abs := hiveBaseBlockSize + int(offset)
+	if offset == hiveNoCell || offset%8 != 0 || abs+4 > len(h.data) {
+		return nil, fmt.Errorf("invalid cell offset 0x%x", offset)
+	}
+	size := -int32(le.Uint32(h.data[abs:]))
+	if size < 8 || abs+int(size) > len(h.data) {
+		return nil, fmt.Errorf("cell 0x%x is not allocated", offset)
+	}
+	return h.data[abs+4 : abs+int(size)], nil
+}
+
+func (h *Hive) signedCell(offset uint32, signature string, minSize int) ([]byte, error) {
+	data, err := h.cell(offset)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < minSize || string(data[:2]) != signature {
+		return nil, fmt.Errorf("cell 0x%x is not a %q cell", offset, signature)
+	}
+	return data, nil
+}
+
+func (h *Hive) keyNode(offset uint32) ([]byte, error) {
+	data, err := h.signedCell(offset, "nk", nkName)
+	if err != nil {
+		return nil, err
+	}
+	if nkName+int(le.Uint16(data[nkNameLength:])) > len(data) {
+		return nil, fmt.Errorf("key 0x%x: name exceeds cell", offset)
+	}
+	return data, nil
+}
+
+// binEnd checks the hive bin at abs and returns where it ends.
+func (h *Hive) binEnd(abs int) (int, error) {
+	if abs+hiveBinHeaderSize > len(h.data) || string(h.data[abs:abs+4]) != "hbin" {
+		return 0, fmt.Errorf("missing hive bin at 0x%x", abs)
+	}
+	size := int(le.Uint32(h.data[abs+8:]))
+	if size < hiveBinAlignment || size%hiveBinAlignment != 0 || abs+size > len(h.data) {
+		return 0, fmt.Errorf("invalid hive bin size at 0x%x", abs)
+	}
+	return abs + size, nil
+}
+
+// cellSize returns the size of the cell at abs in the bin that ends at end
+// and whether it is allocated. A size of zero, one that is not a multiple of
+// 8 or one that crosses the end of the bin means that the hive is corrupt.
+func (h *Hive) cellSize(abs, end int) (size int, allocated bool, err error) {
+	if abs+4 > end {
+		return 0, false, fmt.Errorf("cell at 0x%x crosses its hive bin", abs-hiveBaseBlockSize)
+	}
+	raw := int64(int32(le.Uint32(h.data[abs:]))) // -math.MinInt32 does not fit into an int32
+	if raw < 0 {
+		raw, allocated = -raw, true
+	}
+	if raw < 8 || raw%8 != 0 || int64(abs)+raw > int64(end) {
+		return 0, false, fmt.Errorf("invalid cell size %d at 0x%x", raw, abs-hiveBaseBlockSize)
+	}
+	return int(raw), allocated, nil
+}
+
+// allocate returns a zeroed cell for size bytes of data. Free cells are
+// reused, otherwise a new hive bin is appended.
+func (h *Hive) allocate(size int) (uint32, error) {
+	need := (size + 4 + 7) &^ 7
+	for bin := hiveBaseBlockSize; bin < len(h.data); {
+		end, err := h.binEnd(bin)
+		if err != nil {
+			return 0, err
+		}
+		for abs := bin + hiveBinHeaderSize; abs < end; {
+			cellSize, allocated, err := h.cellSize(abs, end)
+			if err != nil {
+				return 0, err
+			}
+			if !allocated && cellSize >= need {
+				h.split(abs, need, cellSize)
+				return uint32(abs - hiveBaseBlockSize), nil
+			}
+			abs += cellSize
+		}
+		bin = end
+	}
+
+	binSize := (need + hiveBinHeaderSize + hiveBinAlignment - 1) &^ (hiveBinAlignment - 1)
+	bin := len(h.data)
+	h.data = append(h.data, make([]byte, binSize)...)
+	copy(h.data[bin:], "hbin")
+	le.PutUint32(h.data[bin+4:], uint32(bin-hiveBaseBlockSize))
+	le.PutUint32(h.data[bin+8:], uint32(binSize))
+	le.PutUint64(h.data[bin+20:], fileTime(time.Now()))
+	abs := bin + hiveBinHeaderSize
+	h.split(abs, need, binSize-hiveBinHeaderSize)
+	h.dirty = true
+	return uint32(abs - hiveBaseBlockSize), nil
+}
+
+// split marks need bytes of the free cell at abs as allocated and keeps the rest free.
+func (h *Hive) split(abs, need, free int) {
+	if free-need < 8 {
+		need = free
+	} else {
+		le.PutUint32(h.data[abs+need:], uint32(free-need))
+	}
+	le.PutUint32(h.data[abs:], uint32(-int32(need)))
+	clear(h.data[abs+4 : abs+need])
+	h.dirty = true
+}
+
+func (h *Hive) free(offset uint32) {
+	if offset == hiveNoCell {
+		return
+	}
+	abs := hiveBaseBlockSize + int(offset)
+	if abs+4 > len(h.data) {
+		return
+	}
+	if size := int32(le.Uint32(h.data[abs:])); size < 0 {
+		le.PutUint32(h.data[abs:], uint32(-size))
+		h.dirty = true
+	}
+}
+
+// newCell allocates a cell and copies data into it.
+func (h *Hive) newCell(data []byte) (uint32, error) {
+	offset, err := h.allocate(len(data))
+	if err != nil {
+		return 0, err
+	}
+	cell, _ := h.cell(offset)
+	copy(cell, data)
+	return offset, nil
+}
+
+// decodeHiveName decodes a key or value name, compressed names are Latin-1.
+func decodeHiveName(data []byte, compressed bool) string {
+	if compressed {
+		runes := make([]rune, len(data))
+		for i, b := range data {
+			runes[i] = rune(b)
+		}
+		return string(runes)
+	}
+	u16s := make([]uint16, len(data)/2)
+	for i := range u16s {
+		u16s[i] = le.Uint16(data[2*i:])
+	}
+	return string(utf16.Decode(u16s))
+}
+
+// encodeHiveName returns the stored form of name and whether it is compressed.
+func encodeHiveName(name string) ([]byte, bool) {
+	latin1 := make([]byte, 0, len(name))
+	for _, r := range name {
+		if r > 0xFF {
+			u16s := utf16.Encode([]rune(name))
+			data := make([]byte, 2*len(u16s))
+			for i, u := range u16s {
+				le.PutUint16(data[2*i:], u)
+			}
+			return data, false
+		}
+		latin1 = append(latin1, byte(r))
+	}
+	return latin1, true
+}
+
+// nameLength is the length of name in bytes as UTF-16, used for the maximum name fields.
+func nameLength(name string) uint32 {
+	return uint32(2 * len(utf16.Encode([]rune(name))))
+}
+
+// upperName is the sort key of subkey lists.
+func upperName(name string) string {
+	return strings.ToUpper(name)
+}
+
+func (k HiveKey) node() ([]byte, error) {
+	return k.hive.keyNode(k.cell)
+}
+
+// Name returns the name of the key.
+func (k HiveKey) Name() string {
+	data, err := k.node()
+	if err != nil {
+		return ""
+	}
+	length := int(le.Uint16(data[nkNameLength:]))
+	return decodeHiveName(data[nkName:nkName+length], le.Uint16(data[nkFlags:])&nkFlagCompName != 0)
+}
+
+// ModTime returns the last write time of the key.
+func (k HiveKey) ModTime() time.Time {
+	data, err := k.node()
+	if err != nil {
+		return time.Time{}
+	}
+	return fileTimeToTime(le.Uint64(data[nkTimestamp:]))
+}
+
+func (k HiveKey) touch() {
+	if data, err := k.node(); err == nil {
+		le.PutUint64(data[nkTimestamp:], fileTime(time.Now()))
+	}
+}
+
+// subkeyCells returns the key nodes of a subkey list in stored order.
+func (h *Hive) subkeyCells(list uint32, depth int) ([]uint32, error) {
+	if list == hiveNoCell {
+		return nil, nil
+	}
+	data, err := h.cell(list)
+	if err != nil || len(data) < 4 {
+		return nil, fmt.Errorf("subkey list 0x%x: %v", list, err)
+	}
+	count := int(le.Uint16(data[2:]))
+	var stride int
+	switch string(data[:2]) {
+	case "lf", "lh":
+		stride = 8
+	case "li", "ri":
+		stride = 4
+	default:
+		return nil, fmt.Errorf("subkey list 0x%x: unknown signature %q", list, data[:2])
+	}
+	if 4+count*stride > len(data) {
+		return nil, fmt.Errorf("subkey list 0x%x exceeds its cell", list)
+	}
+
+	var cells []uint32
+	for i := 0; i < count; i++ {
+		offset := le.Uint32(data[4+i*stride:])
+		if string(data[:2]) != "ri" {
+			cells = append(cells, offset)
+			continue
+		}
+		if depth > 0 {
+			return nil, fmt.Errorf("subkey list 0x%x: nested index root", list)
+		}
+		sub, err := h.subkeyCells(offset, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		cells = append(cells, sub...)
+	}
+	return cells, nil
+}
+
+// Subkeys returns the subkeys of k.
+func (k HiveKey) Subkeys() ([]HiveKey, error) {
+	data, err := k.node()
+	if err != nil {
+		return nil, err
+	}
+	if le.Uint32(data[nkSubkeyCount:]) == 0 {
+		return nil, nil
+	}
+	cells, err := k.hive.subkeyCells(le.Uint32(data[nkSubkeyList:]), 0)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]HiveKey, len(cells))
+	for i, cell := range cells {
+		keys[i] = HiveKey{hive: k.hive, cell: cell}
+		if _, err := keys[i].node(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// Subkey returns the subkey called name (case insensitive) or ErrNotExist.
+func (k HiveKey) Subkey(name string) (HiveKey, error) {
+	keys, err := k.Subkeys()
+	if err != nil {
+		return HiveKey{}, err
+	}
+	for _, key := range keys {
+		if strings.EqualFold(key.Name(), name) {
+			return key, nil
+		}
+	}
+	return HiveKey{}, ErrNotExist
+}
+
+// Open returns the key at the backslash separated path below k.
+func (k HiveKey) Open(path string) (HiveKey, error) {
+	key := k
+	for _, name := range strings.Split(path, `\`) {
+		if name == "" {
+			continue
+		}
+		var err error
+		if key, err = key.Subkey(name); err != nil {
+			return HiveKey{}, err
+		}
+	}
+	return key, nil
+}
+
+// CreatePath opens the key at path below k and creates missing keys.
+func (k HiveKey) CreatePath(path string) (HiveKey, error) {
+	key := k
+	for _, name := range strings.Split(path, `\`) {
+		if name == "" {
+			continue
+		}
+		var err error
+		if key, err = key.CreateSubkey(name); err != nil {
+			return HiveKey{}, err
+		}
+	}
+	return key, nil
+}
+
+// CreateSubkey returns the subkey called name and creates it if it is missing.
+// New keys share the security descriptor of k.
+func (k HiveKey) CreateSubkey(name string) (HiveKey, error) {
+	if existing, err := k.Subkey(name); err == nil || !errors.Is(err, ErrNotExist) {
+		return existing, err
+	}
+	if name == "" || strings.Contains(name, `\`) || len(name) > 255 {
+		return HiveKey{}, fmt.Errorf("invalid key name %q", name)
+	}
+
+	parent, err := k.node()
+	if err != nil {
+		return HiveKey{}, err
+	}
+	security := le.Uint32(parent[nkSecurity:])
+
+	encoded, compressed := encodeHiveName(name)
+	node := make([]byte, nkName+len(encoded))
+	copy(node, "nk")
+	if compressed {
+		le.PutUint16(node[nkFlags:], nkFlagCompName)
+	}
+	le.PutUint64(node[nkTimestamp:], fileTime(time.Now()))
+	le.PutUint32(node[nkParent:], k.cell)
+	le.PutUint32(node[nkSubkeyList:], hiveNoCell)
+	le.PutUint32(node[nkVolatileList:], hiveNoCell)
+	le.PutUint32(node[nkValueList:], hiveNoCell)
+	le.PutUint32(node[nkSecurity:], security)
+	le.PutUint32(node[nkClass:], hiveNoCell)
+	le.PutUint16(node[nkNameLength:], uint16(len(encoded)))
+	copy(node[nkName:], encoded)
+
+	cell, err := k.hive.newCell(node)
+	if err != nil {
+		return HiveKey{}, err
+	}
+	if sk, err := k.hive.signedCell(security, "sk", skReferenceCount+4); err == nil {
+		le.PutUint32(sk[skReferenceCount:], le.Uint32(sk[skReferenceCount:])+1)
+	}
+
+	child := HiveKey{hive: k.hive, cell: cell}
+	if err := k.insertSubkey(child, name); err != nil {
+		k.hive.free(cell)
+		return HiveKey{}, err
+	}
+	return child, nil
+}
+
+// DeleteSubkey deletes the subkey called name with its values. Keys that
+// have subkeys themselves are refused.
+func (k HiveKey) DeleteSubkey(name string) error {
+	child, err := k.Subkey(name)
+	if err != nil {
+		return err
+	}
+	data, err := child.node()
+	if err != nil {
+		return err
+	}
+	if le.Uint32(data[nkSubkeyCount:]) != 0 || le.Uint32(data[nkVolatileCount:]) != 0 {
+		return fmt.Errorf("key %q has subkeys", name)
+	}
+
+	values, err := child.valueCells()
+	if err != nil {
+		return err
+	}
+	for _, value := range values {
+		child.hive.freeValue(value)
+	}
+
+	data, _ = child.node()
+	k.hive.free(le.Uint32(data[nkValueList:]))
+	k.hive.free(le.Uint32(data[nkClass:]))
+	if sk, err := k.hive.signedCell(le.Uint32(data[nkSecurity:]), "sk", skReferenceCount+4); err == nil {
+		if count := le.Uint32(sk[skReferenceCount:]); count > 1 {
+			le.PutUint32(sk[skReferenceCount:], count-1)
+		}
+	}
+
+	if err := k.removeSubkey(child.cell); err != nil {
+		return err
+	}
+	k.hive.free(child.cell)
+	return nil
+}
+
+// insertSubkey adds child to the subkey list of k, sorted by upper case name.
+func (k HiveKey) insertSubkey(child HiveKey, name string) error {
+	h := k.hive
+	parent, err := k.node()
+	if err != nil {
+		return err
+	}
+	list := le.Uint32(parent[nkSubkeyList:])
+	count := le.Uint32(parent[nkSubkeyCount:])
+
+	if count == 0 || list == hiveNoCell {
+		h.free(list)
+		newList, err := h.writeSubkeyList("lh", []uint32{child.cell})
+		if err != nil {
+			return err
+		}
+		parent, _ = k.node()
+		le.PutUint32(parent[nkSubkeyList:], newList)
+	} else {
+		data, err := h.cell(list)
+		if err != nil {
+			return err
+		}
+		if string(data[:2]) == "ri" {
+			err = h.insertIntoIndexRoot(list, child.cell, name)
+		} else {
+			var newList uint32
+			newList, err = h.insertIntoList(list, child.cell, name)
+			if err == nil {
+				parent, _ = k.node()
+				le.PutUint32(parent[nkSubkeyList:], newList)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	parent, _ = k.node()
+	le.PutUint32(parent[nkSubkeyCount:], count+1)
+	if length := nameLength(name); length > le.Uint32(parent[nkMaxSubkeyName:]) {
+		le.PutUint32(parent[nkMaxSubkeyName:], length)
+	}
+	k.touch()
+	h.dirty = true
+	return nil
+}
+
+// insertIntoList writes a copy of the lf, lh or li list with child added and frees the old list.
+func (h *Hive) insertIntoList(list, child uint32, name string) (uint32, error) {
+	data, err := h.cell(list)
+	if err != nil {
+		return 0, err
+	}
+	signature := string(data[:2])
+	cells, err := h.subkeyCells(list, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	position := len(cells)
+	for i, cell := range cells {
+		if upperName(HiveKey{hive: h, cell: cell}.Name()) > upperName(name) {
+			position = i
+			break
+		}
+	}
+	cells = append(cells[:position], append([]uint32{child}, cells[position:]...)...)
+
+	newList, err := h.writeSubkeyList(signature, cells)
+	if err != nil {
+		return 0, err
+	}
+	h.free(list)
+	return newList, nil
+}
+
+// insertIntoIndexRoot adds child to the leaf list of an ri list that covers name.
+func (h *Hive) insertIntoIndexRoot(root, child uint32, name string) error {
+	data, err := h.cell(root)
+	if err != nil {
+		return err
+	}
+	count := int(le.Uint16(data[2:]))
+	if count == 0 {
+		return fmt.Errorf("empty index root 0x%x", root)
+	}
+
+	target := count - 1
+	for i := 0; i < count; i++ {
+		cells, err := h.subkeyCells(le.Uint32(data[4+4*i:]), 1)
+		if err != nil {
+			return err
+		}
+		if len(cells) != 0 && upperName(HiveKey{hive: h, cell: cells[len(cells)-1]}.Name()) > upperName(name) {
+			target = i
+			break
+		}
+	}
+
+	newList, err := h.insertIntoList(le.Uint32(data[4+4*target:]), child, name)
+	if err != nil {
+		return err
+	}
+	data, _ = h.cell(root)
+	le.PutUint32(data[4+4*target:], newList)
+	return nil
+}
+
+// removeSubkey removes child from the subkey list of k.
+func (k HiveKey) removeSubkey(child uint32) error {
+	h := k.hive
+	parent, err := k.node()
+	if err != nil {
+		return err
+	}
+	list := le.Uint32(parent[nkSubkeyList:])
+	data, err := h.cell(list)
+	if err != nil {
+		return err
+	}
+
+	var newList uint32
+	if string(data[:2]) == "ri" {
+		newList, err = h.removeFromIndexRoot(list, child)
+	} else {
+		newList, err = h.removeFromList(list, child)
+	}
+	if err != nil {
+		return err
+	}
+
+	parent, _ = k.node()
+	le.PutUint32(parent[nkSubkeyList:], newList)
+	le.PutUint32(parent[nkSubkeyCount:], le.Uint32(parent[nkSubkeyCount:])-1)
+	k.touch()
+	h.dirty = true
+	return nil
+}
+
+// removeFromList writes a copy of the list without child, hiveNoCell if it becomes empty.
+func (h *Hive) removeFromList(list, child uint32) (uint32, error) {
+	data, err := h.cell(list)
+	if err != nil {
+		return 0, err
+	}
+	signature := string(data[:2])
+	cells, err := h.subkeyCells(list, 1)
+	if err != nil {
+		return 0, err
+	}
+
+	var remaining []uint32
+	for _, cell := range cells {
+		if cell != child {
+			remaining = append(remaining, cell)
+		}
+	}
+	if len(remaining) == len(cells) {
+		return 0, fmt.Errorf("key 0x%x is not in subkey list 0x%x", child, list)
+	}
+
+	h.free(list)
+	if len(remaining) == 0 {
+		return hiveNoCell, nil
+	}
+	return h.writeSubkeyList(signature, remaining)
+}
+
+func (h *Hive) removeFromIndexRoot(root, child uint32) (uint32, error) {
+	data, err := h.cell(root)
+	if err != nil {
+		return 0, err
+	}
+	lists := make([]uint32, le.Uint16(data[2:]))
+	for i := range lists {
+		lists[i] = le.Uint32(data[4+4*i:])
+	}
+
+	for i, list := range lists {
+		cells, err := h.subkeyCells(list, 1)
+		if err != nil {
+			return 0, err
+		}
+		found := false
+		for _, cell := range cells {
+			found = found || cell == child
+		}
+		if !found {
+			continue
+		}
+
+		newList, err := h.removeFromList(list, child)
+		if err != nil {
+			return 0, err
+		}
+		if newList != hiveNoCell {
+			lists[i] = newList
+		} else {
+			lists = append(lists[:i], lists[i+1:]...)
+		}
+		h.free(root)
+		if len(lists) == 0 {
+			return hiveNoCell, nil
+		}
+		return h.writeSubkeyList("ri", lists)
+	}
+	return 0, fmt.Errorf("key 0x%x is not in index root 0x%x", child, root)
+}
+
+// writeSubkeyList creates a list cell. lf and lh lists get the name hint or hash of each key.
+func (h *Hive) writeSubkeyList(signature string, cells []uint32) (uint32, error) {
+	stride := 4
+	if signature == "lf" || signature == "lh" {
+		stride = 8
+	}
+	data := make([]byte, 4+stride*len(cells))
+	copy(data, signature)
+	le.PutUint16(data[2:], uint16(len(cells)))
+	for i, cell := range cells {
+		entry := data[4+stride*i:]
+		le.PutUint32(entry, cell)
+		name := HiveKey{hive: h, cell: cell}.Name()
+		switch signature {
+		case "lf":
+			encoded, _ := encodeHiveName(name)
+			copy(entry[4:8], encoded)
+		case "lh":
+			var hash uint32
+			for _, r := range upperName(name) {
+				hash = hash*37 + uint32(r)
+			}
+			le.PutUint32(entry[4:], hash)
+		}
+	}
+	return h.newCell(data)
+}
+
+// valueCells returns the vk cells of k.
+func (k HiveKey) valueCells() ([]uint32, error) {
+	data, err := k.node()
+	if err != nil {
+		return nil, err
+	}
+	count := int(le.Uint32(data[nkValueCount:]))
+	if count == 0 {
+		return nil, nil
+	}
+	list, err := k.hive.cell(le.Uint32(data[nkValueList:]))
+	if err != nil {
+		return nil, fmt.Errorf("value list: %w", err)
+	}
+	if 4*count > len(list) {
+		return nil, fmt.Errorf("value list exceeds its cell")
+	}
+	cells := make([]uint32, count)
+	for i := range cells {
+		cells[i] = le.Uint32(list[4*i:])
+	}
+	return cells, nil
+}
+
+func (h *Hive) valueNode(offset uint32) ([]byte, error) {
+	data, err := h.signedCell(offset, "vk", vkName)
+	if err != nil {
+		return nil, err
+	}
+	if vkName+int(le.Uint16(data[vkNameLength:])) > len(data) {
+		return nil, fmt.Errorf("value 0x%x: name exceeds cell", offset)
+	}
+	return data, nil
+}
+
+func (h *Hive) valueName(offset uint32) (string, error) {
+	data, err := h.valueNode(offset)
+	if err != nil {
+		return "", err
+	}
+	length := int(le.Uint16(data[vkNameLength:]))
+	return decodeHiveName(data[vkName:vkName+length], le.Uint16(data[vkFlags:])&vkFlagCompName != 0), nil
+}
+
+func (h *Hive) valueData(offset uint32) (uint32, []byte, error) {
+	data, err := h.valueNode(offset)
+	if err != nil {
+		return 0, nil, err
+	}
+	typ := le.Uint32(data[vkType:])
+	size := le.Uint32(data[vkDataSize:])
+	if size&vkDataResident != 0 {
+		size &^= vkDataResident
+		if size > 4 {
+			return 0, nil, fmt.Errorf("value 0x%x: invalid resident size %d", offset, size)
+		}
+		return typ, append([]byte(nil), data[vkDataOffset:vkDataOffset+size]...), nil
+	}
+	if size == 0 {
+		return typ, []byte{}, nil
+	}
+
+	cell, err := h.cell(le.Uint32(data[vkDataOffset:]))
+	if err != nil {
+		return 0, nil, err
+	}
+	if size > hiveBigDataLimit && len(cell) >= 8 && string(cell[:2]) == "db" {
+		segments, err := h.cell(le.Uint32(cell[4:]))
+		if err != nil {
+			return 0, nil, err
+		}
+		count := int(le.Uint16(cell[2:]))
+		var result []byte
+		for i := 0; i < count && 4*i+4 <= len(segments) && uint32(len(result)) < size; i++ {
+			segment, err := h.cell(le.Uint32(segments[4*i:]))
+			if err != nil {
+				return 0, nil, err
+			}
+			result = append(result, segment[:min(len(segment), hiveBigDataLimit)]...)
+		}
+		if uint32(len(result)) < size {
+			return 0, nil, fmt.Errorf("value 0x%x: big data is truncated", offset)
+		}
+		return typ, result[:size], nil
+	}
+	if int(size) > len(cell) {
+		return 0, nil, fmt.Errorf("value 0x%x: data exceeds its cell", offset)
+	}
+	return typ, append([]byte(nil), cell[:size]...), nil
+}
+
+// freeValue frees a vk cell and its data.
+func (h *Hive) freeValue(offset uint32) {
+	data, err := h.valueNode(offset)
+	if err != nil {
+		return
+	}
+	if size := le.Uint32(data[vkDataSize:]); size&vkDataResident == 0 && size != 0 {
+		h.freeData(le.Uint32(data[vkDataOffset:]), size)
+	}
+	h.free(offset)
+}
+
+func (k HiveKey) findValue(name string) (uint32, int, error) {
+	cells, err := k.valueCells()
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, cell := range cells {
+		valueName, err := k.hive.valueName(cell)
+		if err != nil {
+			return 0, 0, err
+		}
+		if strings.EqualFold(valueName, name) {
+			return cell, i, nil
+		}
+	}
+	return 0, 0, ErrNotExist
+}
+
+// Values returns all values of k.
+func (k HiveKey) Values() ([]HiveValue, error) {
+	cells, err := k.valueCells()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]HiveValue, 0, len(cells))
+	for _, cell := range cells {
+		name, err := k.hive.valueName(cell)
+		if err != nil {
+			return nil, err
+		}
+		typ, data, err := k.hive.valueData(cell)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, HiveValue{Name: name, Type: typ, Data: data})
+	}
+	return values, nil
+}
+
+// Value returns the type and data of the value called name or ErrNotExist.
+func (k HiveKey) Value(name string) (uint32, []byte, error) {
+	cell, _, err := k.findValue(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	return k.hive.valueData(cell)
+}
+
+// SetValue creates or replaces the value called name.
+func (k HiveKey) SetValue(name string, typ uint32, value []byte) error {
+	h := k.hive
+	if len(value) > hiveBigDataLimit {
+		return fmt.Errorf("value %q: data larger than %d bytes is not supported", name, hiveBigDataLimit)
+	}
+
+	cell, _, err := k.findValue(name)
+	switch {
+	case errors.Is(err, ErrNotExist):
+		if cell, err = k.addValue(name); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	}
+
+	vk, err := h.valueNode(cell)
+	if err != nil {
+		return err
+	}
+	oldSize := le.Uint32(vk[vkDataSize:])
+	oldCell := le.Uint32(vk[vkDataOffset:])
+	if oldSize&vkDataResident != 0 || oldSize == 0 {
+		oldCell = hiveNoCell
+	}
+
+	if len(value) <= 4 {
+		h.freeData(oldCell, oldSize)
+		vk, _ = h.valueNode(cell)
+		le.PutUint32(vk[vkDataSize:], uint32(len(value))|vkDataResident)
+		clear(vk[vkDataOffset : vkDataOffset+4])
+		copy(vk[vkDataOffset:], value)
+	} else {
+		dataCell := oldCell
+		if old, err := h.cell(oldCell); err != nil || len(old) < len(value) || oldSize > hiveBigDataLimit {
+			h.freeData(oldCell, oldSize)
+			if dataCell, err = h.allocate(len(value)); err != nil {
+				return err
+			}
+		}
+		data, _ := h.cell(dataCell)
+		copy(data, value)
+		vk, _ = h.valueNode(cell)
+		le.PutUint32(vk[vkDataSize:], uint32(len(value)))
+		le.PutUint32(vk[vkDataOffset:], dataCell)
+	}
+	le.PutUint32(vk[vkType:], typ)
+
+	node, _ := k.node()
+	if uint32(len(value)) > le.Uint32(node[nkMaxValueData:]) {
+		le.PutUint32(node[nkMaxValueData:], uint32(len(value)))
+	}
+	k.touch()
+	h.dirty = true
+	return nil
+}
+
+// freeData frees the data cell of a value, including the segments of big data.
+func (h *Hive) freeData(cell, size uint32) {
+	if cell == hiveNoCell {
+		return
+	}
+	if data, err := h.cell(cell); err == nil && size > hiveBigDataLimit && len(data) >= 8 && string(data[:2]) == "db" {
+		segmentList := le.Uint32(data[4:])
+		if segments, err := h.cell(segmentList); err == nil {
+			for i := 0; i < int(le.Uint16(data[2:])) && 4*i+4 <= len(segments); i++ {
+				h.free(le.Uint32(segments[4*i:]))
+			}
+		}
+		h.free(segmentList)
+	}
+	h.free(cell)
+}
+
+// addValue creates an empty REG_NONE value and appends it to the value list of k.
+func (k HiveKey) addValue(name string) (uint32, error) {
+	h := k.hive
+	cells, err := k.valueCells()
+	if err != nil {
+		return 0, err
+	}
+
+	encoded, compressed := encodeHiveName(name)
+	vk := make([]byte, vkName+len(encoded))
+	copy(vk, "vk")
+	le.PutUint16(vk[vkNameLength:], uint16(len(encoded)))
+	le.PutUint32(vk[vkDataSize:], vkDataResident)
+	if compressed {
+		le.PutUint16(vk[vkFlags:], vkFlagCompName)
+	}
+	copy(vk[vkName:], encoded)
+	cell, err := h.newCell(vk)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := k.writeValueList(append(cells, cell)); err != nil {
+		h.free(cell)
+		return 0, err
+	}
+	node, _ := k.node()
+	if length := nameLength(name); length > le.Uint32(node[nkMaxValueName:]) {
+		le.PutUint32(node[nkMaxValueName:], length)
+	}
+	return cell, nil
+}
+
+// writeValueList replaces the value list of k.
+func (k HiveKey) writeValueList(cells []uint32) error {
+	h := k.hive
+	node, err := k.node()
+	if err != nil {
+		return err
+	}
+	old := le.Uint32(node[nkValueList:])
+	if le.Uint32(node[nkValueCount:]) == 0 {
+		old = hiveNoCell
+	}
+
+	list := uint32(hiveNoCell)
+	if len(cells) != 0 {
+		data := make([]byte, 4*len(cells))
+		for i, cell := range cells {
+			le.PutUint32(data[4*i:], cell)
+		}
+		if list, err = h.newCell(data); err != nil {
+			return err
+		}
+	}
+	h.free(old)
+
+	node, _ = k.node()
+	le.PutUint32(node[nkValueList:], list)
+	le.PutUint32(node[nkValueCount:], uint32(len(cells)))
+	h.dirty = true
+	return nil
+}
+
+// DeleteValue deletes the value called name or returns ErrNotExist.
+func (k HiveKey) DeleteValue(name string) error {
+	cell, index, err := k.findValue(name)
+	if err != nil {
+		return err
+	}
+	cells, err := k.valueCells()
+	if err != nil {
+		return err
+	}
+	if err := k.writeValueList(append(cells[:index:index], cells[index+1:]...)); err != nil {
+		return err
+	}
+	k.hive.freeValue(cell)
+	k.touch()
+	return nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf16"
+)
+
+var systemHiveFixture = filepath.Join("testdata", "hive", "SYSTEM")
+
+// newTestHive returns an empty hive with one hive bin: a security cell, the
+// root key and one free cell.
+func newTestHive(t *testing.T) *Hive {
+	t.Helper()
+	data := make([]byte, hiveBaseBlockSize+hiveBinAlignment)
+	copy(data, "regf")
+	le.PutUint32(data[regfSequence1:], 1)
+	le.PutUint32(data[regfSequence2:], 1)
+	le.PutUint32(data[regfMajor:], 1)
+	le.PutUint32(data[regfMajor+4:], 5) // minor version
+	le.PutUint32(data[32:], 1)          // direct memory load
+	le.PutUint32(data[regfBinsLength:], hiveBinAlignment)
+	le.PutUint32(data[44:], 1) // clustering factor
+
+	bin := data[hiveBaseBlockSize:]
+	copy(bin, "hbin")
+	le.PutUint32(bin[8:], hiveBinAlignment)
+
+	// sk with an empty self-relative security descriptor
+	const skCell, skSize = hiveBinHeaderSize, 48
+	sk := bin[skCell:]
+	le.PutUint32(sk, allocatedCellSize(skSize))
+	copy(sk[4:], "sk")
+	le.PutUint32(sk[4+4:], skCell)
+	le.PutUint32(sk[4+8:], skCell)
+	le.PutUint32(sk[4+skReferenceCount:], 1)
+	le.PutUint32(sk[4+16:], 20)
+	sk[4+20] = 1                    // revision
+	le.PutUint16(sk[4+22:], 0x8000) // SE_SELF_RELATIVE
+
+	const rootCell, rootSize = skCell + skSize, 88
+	root := bin[rootCell:]
+	le.PutUint32(root, allocatedCellSize(rootSize))
+	copy(root[4:], "nk")
+	le.PutUint16(root[4+nkFlags:], 0x2C) // hive entry, no delete, compressed name
+	for _, offset := range []int{nkSubkeyList, nkVolatileList, nkValueList, nkClass} {
+		le.PutUint32(root[4+offset:], hiveNoCell)
+	}
+	le.PutUint32(root[4+nkSecurity:], skCell)
+	le.PutUint16(root[4+nkNameLength:], 4)
+	copy(root[4+nkName:], "ROOT")
+	le.PutUint32(data[regfRootCell:], rootCell)
+
+	le.PutUint32(bin[rootCell+rootSize:], uint32(hiveBinAlignment-rootCell-rootSize))
+	le.PutUint32(data[regfChecksum:], hiveChecksum(data))
+
+	h, err := ParseHive(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// allocatedCellSize returns the size field of an allocated cell of size bytes.
+func allocatedCellSize(size int) uint32 {
+	return uint32(-int32(size))
+}
+
+func hiveSZ(strs ...string) []byte {
+	u16s := utf16.Encode([]rune(strings.Join(strs, "\x00") + "\x00"))
+	data := make([]byte, 2*len(u16s))
+	for i, u := range u16s {
+		le.PutUint16(data[2*i:], u)
+	}
+	return data
+}
+
+func hiveMultiSZ(strs ...string) []byte {
+	return append(hiveSZ(strs...), 0, 0)
+}
+
+func hiveDWordData(value uint32) []byte {
+	return le.AppendUint32(nil, value)
+}
+
+// setHiveValues creates path below key and sets the values, string for
+// REG_SZ, []string for REG_MULTI_SZ, uint32 for REG_DWORD and []byte for
+// REG_BINARY.
+func setHiveValues(t *testing.T, key HiveKey, path string, values map[string]any) {
+	t.Helper()
+	key, err := key.CreatePath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range values {
+		switch value := value.(type) {
+		case string:
+			err = key.SetValue(name, REG_SZ, hiveSZ(value))
+		case []string:
+			err = key.SetValue(name, REG_MULTI_SZ, hiveMultiSZ(value...))
+		case uint32:
+			err = key.SetValue(name, REG_DWORD, hiveDWordData(value))
+		case []byte:
+			err = key.SetValue(name, REG_BINARY, value)
+		default:
+			t.Fatalf("%s\\%s: unsupported value %T", path, name, value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeSystemHiveFixture writes testdata/hive/SYSTEM, a SYSTEM hive with a
+// GPU and a network adapter, a disabled USB device and a device without
+// Device Parameters.
+func writeSystemHiveFixture(t *testing.T) {
+	h := newTestHive(t)
+	root := h.Root()
+	setHiveValues(t, root, "Select", map[string]any{"Current": uint32(1), "Default": uint32(1), "LastKnownGood": uint32(1)})
+
+	const enum = `ControlSet001\Enum\`
+	gpu := enum + `PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1\4&2b8f4b3c&0&0008`
+	setHiveValues(t, root, gpu, map[string]any{
+		"DeviceDesc":  "@oem12.inf,%nvidia_dev.2684%;NVIDIA GeForce RTX 4090",
+		"HardwareID":  []string{`PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1`, `PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE`, `PCI\VEN_10DE&DEV_2684`},
+		"Class":       "Display",
+		"ClassGUID":   "{4d36e968-e325-11ce-bfc1-08002be10318}",
+		"Service":     "nvlddmkm",
+		"ConfigFlags": uint32(0),
+	})
+	setHiveValues(t, root, gpu+`\`+pciInterruptSupportKey, map[string]any{"": []byte{0x07, 0x00}})
+	setHiveValues(t, root, gpu+`\`+pciInterruptMessageMaximumKey, map[string]any{"": []byte{0x01, 0x00, 0x00, 0x00}})
+	setHiveValues(t, root, gpu+`\Device Parameters\`+msiPropertiesKey, map[string]any{"MSISupported": uint32(1)})
+	setHiveValues(t, root, gpu+`\Device Parameters\`+affinityPolicyKey, map[string]any{"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "AssignmentSetOverride": []byte{0x10}})
+
+	nic := enum + `PCI\VEN_8086&DEV_15F3&SUBSYS_86721043&REV_03\6&1f5a4d2c&0&0038020A`
+	setHiveValues(t, root, nic, map[string]any{
+		"DeviceDesc":          "@oem7.inf,%e15f3nc.devicedesc%;Intel(R) Ethernet Controller I225-V",
+		"LocationInformation": "PCI bus 6, device 0, function 0",
+		"HardwareID":          []string{`PCI\VEN_8086&DEV_15F3&SUBSYS_86721043&REV_03`, `PCI\VEN_8086&DEV_15F3`},
+		"Class":               "Net",
+		"Service":             "e2fexpress",
+	})
+	setHiveValues(t, root, nic+`\`+pciInterruptSupportKey, map[string]any{"": []byte{0x06, 0x00}})
+	setHiveValues(t, root, nic+`\`+pciInterruptMessageMaximumKey, map[string]any{"": []byte{0x05, 0x00, 0x00, 0x00}})
+	setHiveValues(t, root, nic+`\Device Parameters\`+msiPropertiesKey, map[string]any{"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)})
+
+	usb := enum + `USB\VID_046D&PID_C539\5&3a4d6f2&0&4`
+	setHiveValues(t, root, usb, map[string]any{"DeviceDesc": "USB Composite Device", "ConfigFlags": uint32(CONFIG_FLAG_DISABLED)})
+	setHiveValues(t, root, usb+`\Device Parameters`, nil)
+
+	setHiveValues(t, root, enum+`ACPI\PNP0C0C\2&daba3ff&1`, map[string]any{"DeviceDesc": "ACPI Power Button"})
+
+	if err := os.MkdirAll(filepath.Dir(systemHiveFixture), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(systemHiveFixture, h.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copySystemHiveFixture copies the fixture so the test can write to it.
+func copySystemHiveFixture(t *testing.T) string {
+	t.Helper()
+	if *update {
+		writeSystemHiveFixture(t)
+	}
+	path := filepath.Join(t.TempDir(), "SYSTEM")
+	if err := os.WriteFile(path, mustReadFile(t, systemHiveFixture), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOfflineSystemDevices(t *testing.T) {
+	system, err := OpenOfflineSystem(copySystemHiveFixture(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if system.ControlSet != "ControlSet001" {
+		t.Errorf("control set %s", system.ControlSet)
+	}
+	devices, err := system.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 2 {
+		t.Fatalf("%d devices, want the GPU and the network adapter", len(devices))
+	}
+
+	gpu, nic := devices[0], devices[1]
+	if gpu.DeviceDesc != "NVIDIA GeForce RTX 4090" || gpu.Class != "Display" || gpu.Driver != "nvlddmkm" || len(gpu.DeviceIDs) != 3 {
+		t.Errorf("GPU %+v", gpu)
+	}
+	if gpu.InstanceID != `PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1\4&2b8f4b3c&0&0008` || !strings.HasSuffix(gpu.RegPath, gpu.InstanceID+`\Device Parameters`) {
+		t.Errorf("GPU instance %s, registry path %s", gpu.InstanceID, gpu.RegPath)
+	}
+	if gpu.InterruptTypeMap != 7 || gpu.MaxMSILimit != 1 || gpu.MsiSupported != 1 || gpu.DevicePolicy != IrqPolicySpecifiedProcessors || !gpu.AssignmentSetOverride.Equal(NewCPUMask(4)) {
+		t.Errorf("GPU settings: interrupt types %d, max MSI %d, MSI %d, policy %d, CPUs %s", gpu.InterruptTypeMap, gpu.MaxMSILimit, gpu.MsiSupported, gpu.DevicePolicy, gpu.AssignmentSetOverride)
+	}
+	if nic.DeviceDesc != "Intel(R) Ethernet Controller I225-V" || nic.LocationInformation != "PCI bus 6, device 0, function 0" || nic.MsiSupported != 1 || nic.MessageNumberLimit != 4 || nic.MaxMSILimit != 5 {
+		t.Errorf("network adapter %+v", nic)
+	}
+
+	saved := flagIncludeInactive
+	t.Cleanup(func() { flagIncludeInactive = saved })
+	flagIncludeInactive = true
+	devices, err = system.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(devices) != 3 || devices[2].DeviceDesc != "USB Composite Device" || devices[2].State != DeviceDisabled {
+		t.Errorf("with -include-inactive: %d devices", len(devices))
+	}
+}
+
+// TestOfflineSystemWrite applies a plan to the hive, saves it and reads it again.
+func TestOfflineSystemWrite(t *testing.T) {
+	path := copySystemHiveFixture(t)
+	system, err := OpenOfflineSystem(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := system.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpu, nic := devices[0], devices[1]
+	gpu.DevicePolicy, gpu.AssignmentSetOverride, gpu.DevicePriority = IrqPolicyMachineDefault, CPUMask{}, 3
+	nic.DevicePolicy, nic.AssignmentSetOverride, nic.MessageNumberLimit = IrqPolicySpecifiedProcessors, NewCPUMask(2, 3), 0
+	var plan Plan
+	if err := plan.Add(&devices[0], &gpu); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Add(&devices[1], &nic); err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if err := system.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(mustReadFile(t, path), mustReadFile(t, systemHiveFixture)) {
+		t.Fatal("the hive was not written")
+	}
+	// the previous hive is kept and the temporary file is gone
+	if !bytes.Equal(mustReadFile(t, path+".bak"), mustReadFile(t, systemHiveFixture)) {
+		t.Error("the backup is not the previous hive")
+	}
+	if files, err := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp")); err != nil || len(files) != 0 {
+		t.Errorf("temporary files %q (%v)", files, err)
+	}
+
+	system, err = OpenOfflineSystem(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err = system.Devices()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []*Device{&gpu, &nic} {
+		got := &devices[i]
+		if affinityChanged(got, want) || msiChanged(got, want) {
+			t.Errorf("%s after saving: %v", got.DeviceDesc, describeChanges(want, got))
+		}
+	}
+}
+
+func TestHiveKeysAndValues(t *testing.T) {
+	h := newTestHive(t)
+	key, err := h.Root().CreatePath(`Software\Test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("0123456789abcdef"), hiveBigDataLimit/16) // larger than a hive bin
+	values := []HiveValue{
+		{Name: "", Type: REG_SZ, Data: hiveSZ("default")},
+		{Name: "Small", Type: REG_DWORD, Data: hiveDWordData(42)},
+		{Name: "Grüße", Type: REG_BINARY, Data: []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		{Name: "Large", Type: REG_BINARY, Data: large},
+	}
+	for _, value := range values {
+		if err := key.SetValue(value.Name, value.Type, value.Data); err != nil {
+			t.Fatalf("%s: %v", value.Name, err)
+		}
+	}
+	if err := key.SetValue("Big", REG_BINARY, make([]byte, hiveBigDataLimit+1)); err == nil {
+		t.Error("values that need db segments are written")
+	}
+	var names []string
+	for i := range 600 { // more subkeys than fit into one list
+		name := strings.Repeat("k", i%7+1) + string(rune('A'+i%26)) + strings.Repeat("0", i/26)
+		if _, err := key.CreateSubkey(name); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	if err := key.DeleteValue("Small"); err != nil {
+		t.Fatal(err)
+	}
+	if err := key.DeleteSubkey(names[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = ParseHive(bytes.Clone(h.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = h.Root().Open(`SOFTWARE\test`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := key.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []HiveValue{values[0], values[2], values[3]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("values %d, want %d", len(got), len(want))
+	}
+	subkeys, err := key.Subkeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subkeys) != len(names)-1 {
+		t.Errorf("%d subkeys, want %d", len(subkeys), len(names)-1)
+	}
+	if _, err := key.Subkey(names[0]); err == nil {
+		t.Errorf("deleted subkey %s still exists", names[0])
+	}
+	if _, err := key.Subkey(strings.ToUpper(names[599])); err != nil {
+		t.Error(err)
+	}
+}
+
+// firstCell returns the absolute offset of the first allocated or free cell of the first bin.
+func firstCell(t *testing.T, h *Hive, allocated bool) int {
+	t.Helper()
+	end := hiveBaseBlockSize + hiveBinAlignment
+	for abs := hiveBaseBlockSize + hiveBinHeaderSize; abs < end; {
+		size, isAllocated, err := h.cellSize(abs, end)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if isAllocated == allocated {
+			return abs
+		}
+		abs += size
+	}
+	t.Fatal("no such cell")
+	return 0
+}
+
+// TestHiveCorruptCells expects broken cell and bin sizes to be refused
+// instead of looping or indexing out of range.
+func TestHiveCorruptCells(t *testing.T) {
+	tests := map[string]struct {
+		allocated bool
+		size      uint32
+	}{
+		"zero free cell":         {false, 0},
+		"zero allocated cell":    {true, 0},
+		"smallest int32":         {true, 1 << 31},
+		"free cell crossing bin": {false, 2 * hiveBinAlignment},
+		"cell crossing bin":      {true, allocatedCellSize(2 * hiveBinAlignment)},
+		"unaligned free cell":    {false, 12},
+		"cell smaller than 8":    {true, allocatedCellSize(4)},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			// corrupted after reading, allocate has to stop at the cell
+			h := newTestHive(t)
+			le.PutUint32(h.data[firstCell(t, h, tt.allocated):], tt.size)
+			if _, err := h.allocate(hiveBinAlignment); err == nil {
+				t.Error("allocate: no error")
+			}
+
+			// corrupted on disk
+			h = newTestHive(t)
+			le.PutUint32(h.data[firstCell(t, h, tt.allocated):], tt.size)
+			if _, err := ParseHive(h.data); err == nil {
+				t.Error("ParseHive: no error")
+			}
+		})
+	}
+
+	h := newTestHive(t)
+	le.PutUint32(h.data[hiveBaseBlockSize+8:], 2*hiveBinAlignment)
+	if _, err := h.allocate(8); err == nil {
+		t.Error("bin larger than the hive: no error")
+	}
+	if _, err := ParseHive(h.data); err == nil {
+		t.Error("ParseHive with a bin larger than the hive: no error")
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ImportChange is the effect of a .reg file on one device.
+type ImportChange struct {
+	Device *Device
+	Before Device
+	After  Device
+}
+
+// normalizeRegPath makes registry paths from .reg files and NtQueryKey comparable.
+func normalizeRegPath(path string) string {
+	path = strings.ToLower(strings.Trim(strings.TrimSpace(path), `\`))
+	if strings.HasPrefix(path, `hklm\`) {
+		path = `hkey_local_machine\` + path[len(`hklm\`):]
+	}
+
+	parts := strings.Split(path, `\`)
+	for i := range parts {
+		if strings.HasPrefix(parts[i], "controlset00") {
+			parts[i] = "currentcontrolset"
+			break
+		}
+	}
+	return strings.Join(parts, `\`)
+}
+
+// splitInterruptManagementPath splits a key into the device key and the part
+// starting at "\Interrupt Management". ok is false for unrelated keys.
+func splitInterruptManagementPath(path string) (deviceKey, subKey string, ok bool) {
+	normalized := normalizeRegPath(path)
+	index := strings.Index(normalized+`\`, `\`+strings.ToLower(interruptManagementKey)+`\`)
+	if index == -1 {
+		return "", "", false
+	}
+	return normalized[:index], normalized[index+1:], true
+}
+
+// planImport maps every Interrupt Management key of rf to a device and
+// returns the resulting changes. Keys that cannot be mapped end up in warnings.
+func planImport(rf *RegFile, devices []Device) (changes []ImportChange, warnings []string) {
+	byPath := make(map[string]int, len(devices))
+	for i := range devices {
+		if devices[i].RegPath != "" {
+			byPath[normalizeRegPath(devices[i].RegPath)] = i
+		}
+	}
+
+	changeIndex := map[int]int{}
+	for _, key := range rf.Keys {
+		deviceKey, subKey, ok := splitInterruptManagementPath(key.Path)
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("skipped [%s]: not an Interrupt Management key", key.Path))
+			continue
+		}
+		i, ok := byPath[deviceKey]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("skipped [%s]: no matching device", key.Path))
+			continue
+		}
+
+		ci, ok := changeIndex[i]
+		if !ok {
+			ci = len(changes)
+			changeIndex[i] = ci
+			changes = append(changes, ImportChange{
+				Device: &devices[i],
+				Before: devices[i],
+				After:  devices[i],
+			})
+		}
+		warnings = append(warnings, applyRegKey(&changes[ci].After, subKey, key)...)
+	}
+
+	var result []ImportChange
+	for _, change := range changes {
+		if msiChanged(&change.Before, &change.After) || affinityChanged(&change.Before, &change.After) {
+			result = append(result, change)
+		}
+	}
+	return result, warnings
+}
+
+// applyRegKey applies one .reg section to the settings of dev.
+func applyRegKey(dev *Device, subKey string, key RegKey) (warnings []string) {
+	switch subKey {
+	case strings.ToLower(interruptManagementKey):
+		if key.Delete {
+			resetAffinityPolicy(dev)
+			resetMSIProperties(dev)
+		}
+	case strings.ToLower(affinityPolicyKey):
+		if key.Delete {
+			resetAffinityPolicy(dev)
+			return nil
+		}
+		for _, value := range key.Values {
+			switch strings.ToLower(value.Name) {
+			case "devicepolicy":
+				dev.DevicePolicy = regDWordOrZero(value)
+			case "devicepriority":
+				dev.DevicePriority = regDWordOrZero(value)
+			case "assignmentsetoverride":
+				dev.AssignmentSetOverride = nil
+				if value.Type == RegBinary {
+					dev.AssignmentSetOverride = CPUMaskFromBytes(value.Data)
+				}
+				for _, p := range dev.AssignmentSetOverride.Processors() {
+					if !cs.Has(p) {
+						warnings = append(warnings, fmt.Sprintf("[%s] AssignmentSetOverride: processor %d does not exist", key.Path, p))
+					}
+				}
+			default:
+				warnings = append(warnings, fmt.Sprintf("[%s] ignored unknown value %q", key.Path, value.Name))
+			}
+		}
+	case strings.ToLower(msiPropertiesKey):
+		if key.Delete {
+			resetMSIProperties(dev)
+			return nil
+		}
+		if dev.MsiSupported == 2 {
+			return []string{fmt.Sprintf("[%s] device does not support message signaled interrupts", key.Path)}
+		}
+		for _, value := range key.Values {
+			switch strings.ToLower(value.Name) {
+			case "msisupported":
+				dev.MsiSupported = regDWordOrZero(value)
+			case "messagenumberlimit":
+				dev.MessageNumberLimit = regDWordOrZero(value)
+			default:
+				warnings = append(warnings, fmt.Sprintf("[%s] ignored unknown value %q", key.Path, value.Name))
+			}
+		}
+	default:
+		warnings = append(warnings, fmt.Sprintf("skipped [%s]: unknown key", key.Path))
+	}
+	return warnings
+}
+
+func regDWordOrZero(value RegValue) uint32 {
+	if value.Type == RegDWord {
+		return value.DWord
+	}
+	return 0
+}
+
+func resetAffinityPolicy(dev *Device) {
+	dev.DevicePolicy = 0
+	dev.DevicePriority = 0
+	dev.AssignmentSetOverride = nil
+}
+
+func resetMSIProperties(dev *Device) {
+	if dev.MsiSupported == 2 {
+		return
+	}
+	dev.MsiSupported = 0
+	dev.MessageNumberLimit = 0
+}
+
+// String returns a human readable preview of the change.
+func (c ImportChange) String() string {
+	var b strings.Builder
+	b.WriteString(deviceTitle(c.Device))
+	for _, line := range describeChanges(&c.Before, &c.After) {
+		b.WriteString("\n  ")
+		b.WriteString(line)
+	}
+	return b.String()
+}
+
+func deviceTitle(dev *Device) string {
+	if dev.DevObjName == "" {
+		return dev.DeviceDesc
+	}
+	return fmt.Sprintf("%s (%s)", dev.DeviceDesc, dev.DevObjName)
+}
+
+func describeChanges(before, after *Device) []string {
+	var lines []string
+	if before.MsiSupported != after.MsiSupported {
+		lines = append(lines, fmt.Sprintf("MSISupported: %d -> %d", before.MsiSupported, after.MsiSupported))
+	}
+	if before.MessageNumberLimit != after.MessageNumberLimit {
+		lines = append(lines, fmt.Sprintf("MessageNumberLimit: %d -> %d", before.MessageNumberLimit, after.MessageNumberLimit))
+	}
+	if before.DevicePolicy != after.DevicePolicy {
+		lines = append(lines, fmt.Sprintf("DevicePolicy: %d -> %d", before.DevicePolicy, after.DevicePolicy))
+	}
+	if before.DevicePriority != after.DevicePriority {
+		lines = append(lines, fmt.Sprintf("DevicePriority: %d -> %d", before.DevicePriority, after.DevicePriority))
+	}
+	if !before.AssignmentSetOverride.Equal(after.AssignmentSetOverride) {
+		lines = append(lines, fmt.Sprintf("AssignmentSetOverride: [%s] -> [%s]", before.AssignmentSetOverride, after.AssignmentSetOverride))
+	}
+	return lines
+}
+
+// importPlan returns the registry operations of the planned changes.
+func importPlan(changes []ImportChange) (*Plan, error) {
+	plan := &Plan{}
+	for i := range changes {
+		if err := plan.Add(&changes[i].Before, &changes[i].After); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+// applyImport performs the plan of the changes and updates the devices.
+func applyImport(changes []ImportChange, plan *Plan) error {
+	if err := plan.Apply(); err != nil {
+		return err
+	}
+	for i := range changes {
+		*changes[i].Device = changes[i].After
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	importNICPath    = `HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Enum\PCI\VEN_8086&DEV_125C&SUBSYS_00008086&REV_04\6&2f6e5e2&0&000800e6\Device Parameters`
+	importLegacyPath = `HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Enum\PCI\VEN_8086&DEV_7A84&SUBSYS_7D251462&REV_11\3&11583659&0&F8\Device Parameters`
+)
+
+// importTestDevices returns a GPU pinned to processors 2 and 3 with MSI, a
+// network adapter without settings and a device without MSI support.
+func importTestDevices(t *testing.T) []Device {
+	t.Helper()
+	useTopologyFixture(t, "8-threads.json")
+	devices := []Device{
+		{DeviceDesc: "GPU", RegPath: testRegPath, InterruptTypeMap: 2, store: newTestStore(t, storeValues{
+			interruptManagementKey: {},
+			affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x0c}},
+			msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+		})},
+		{DeviceDesc: "NIC", RegPath: importNICPath, InterruptTypeMap: 6, store: newTestStore(t, storeValues{interruptManagementKey: {}})},
+		{DeviceDesc: "Legacy", RegPath: importLegacyPath, InterruptTypeMap: 0, store: newTestStore(t, storeValues{interruptManagementKey: {}})},
+	}
+	for i := range devices {
+		readSettings(&devices[i])
+	}
+	return devices
+}
+
+// importRegFile returns a .reg file with the sections.
+func importRegFile(sections ...string) string {
+	return regFileHeader + "\r\n\r\n" + strings.Join(sections, "\r\n\r\n") + "\r\n"
+}
+
+func TestPlanImport(t *testing.T) {
+	gpuKey := `[` + testRegPath + `\Interrupt Management`
+	nicKey := `[` + importNICPath + `\Interrupt Management`
+	unchangedGPU := storeValues{
+		interruptManagementKey: {},
+		affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x0c}},
+		msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+	}
+	tests := []struct {
+		name     string
+		reg      string
+		changed  []string
+		warnings []string // substrings, in order
+		want     map[string]storeValues
+	}{
+		{
+			name: "ControlSet001 and HKLM",
+			reg: importRegFile(`[HKLM\SYSTEM\ControlSet001\Enum\PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1\4&2B8F4B3C&0&0008\Device Parameters\Interrupt Management\Affinity Policy]
+"DevicePolicy"=dword:00000005
+"DevicePriority"=dword:00000002`),
+			changed: []string{"GPU"},
+			want: map[string]storeValues{"GPU": {
+				interruptManagementKey: {},
+				affinityPolicyKey:      {"DevicePolicy": uint32(5), "DevicePriority": uint32(2)},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+			}},
+		},
+		{
+			name: "keys of one device are one change",
+			reg: importRegFile(nicKey+`\Affinity Policy]
+"DevicePolicy"=dword:00000004
+"AssignmentSetOverride"=hex:30`, nicKey+`\MessageSignaledInterruptProperties]
+"MSISupported"=dword:00000001
+"MessageNumberLimit"=dword:00000002`),
+			changed: []string{"NIC"},
+			want: map[string]storeValues{"NIC": {
+				interruptManagementKey: {},
+				affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "AssignmentSetOverride": []byte{0x30}},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(2)},
+			}, "GPU": unchangedGPU},
+		},
+		{
+			name: "deleted AssignmentSetOverride",
+			reg: importRegFile(gpuKey + `\Affinity Policy]
+"DevicePolicy"=dword:00000001
+"AssignmentSetOverride"=-`),
+			changed: []string{"GPU"},
+			want: map[string]storeValues{"GPU": {
+				interruptManagementKey: {},
+				affinityPolicyKey:      {"DevicePolicy": uint32(1), "DevicePriority": uint32(3)},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+			}},
+		},
+		{
+			name: "deleted AssignmentSetOverride of DevicePolicy 4",
+			reg: importRegFile(gpuKey + `\Affinity Policy]
+"AssignmentSetOverride"=-`),
+			changed: []string{"GPU"},
+			want: map[string]storeValues{"GPU": {
+				interruptManagementKey: {},
+				affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(3)},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+			}},
+		},
+		{
+			name:    "deleted Affinity Policy key",
+			reg:     importRegFile(`[-` + testRegPath + `\Interrupt Management\Affinity Policy]`),
+			changed: []string{"GPU"},
+			want: map[string]storeValues{"GPU": {
+				interruptManagementKey: {},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+			}},
+		},
+		{
+			name:    "deleted MSI key",
+			reg:     importRegFile(`[-` + testRegPath + `\Interrupt Management\MessageSignaledInterruptProperties]`),
+			changed: []string{"GPU"},
+			want: map[string]storeValues{"GPU": {
+				interruptManagementKey: {},
+				affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x0c}},
+			}},
+		},
+		{
+			name:    "deleted Interrupt Management key",
+			reg:     importRegFile(`[-` + testRegPath + `\Interrupt Management]`),
+			changed: []string{"GPU"},
+			want:    map[string]storeValues{"GPU": {interruptManagementKey: {}}},
+		},
+		{
+			name: "unknown device and key",
+			reg: importRegFile(`[HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Enum\PCI\VEN_1234&DEV_5678\0\Device Parameters\Interrupt Management\Affinity Policy]
+"DevicePolicy"=dword:00000003`, `[HKEY_LOCAL_MACHINE\SOFTWARE\Vendor]
+"DevicePolicy"=dword:00000003`, gpuKey+`\Routing]`),
+			warnings: []string{"no matching device", "not an Interrupt Management key", `Interrupt Management\Routing]: unknown key`},
+			want:     map[string]storeValues{"GPU": unchangedGPU},
+		},
+		{
+			name: "unknown values",
+			reg: importRegFile(gpuKey+`\Affinity Policy]
+"DevicePriority"=dword:00000001
+"DeviceGroup"=dword:00000001`, gpuKey+`\MessageSignaledInterruptProperties]
+"MessageLimit"=dword:00000008`),
+			changed:  []string{"GPU"},
+			warnings: []string{`ignored unknown value "DeviceGroup"`, `ignored unknown value "MessageLimit"`},
+			want: map[string]storeValues{"GPU": {
+				interruptManagementKey: {},
+				affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(1), "AssignmentSetOverride": []byte{0x0c}},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+			}},
+		},
+		{
+			name: "missing processor",
+			reg: importRegFile(gpuKey + `\Affinity Policy]
+"AssignmentSetOverride"=hex:04,01`),
+			changed:  []string{"GPU"},
+			warnings: []string{"processor 8 does not exist"},
+			want: map[string]storeValues{"GPU": {
+				interruptManagementKey: {},
+				affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x04, 0x01}},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+			}},
+		},
+		{
+			name: "device without MSI",
+			reg: importRegFile(`[` + importLegacyPath + `\Interrupt Management\MessageSignaledInterruptProperties]
+"MSISupported"=dword:00000001`),
+			warnings: []string{"does not support message signaled interrupts"},
+			want:     map[string]storeValues{"Legacy": {interruptManagementKey: {}}},
+		},
+		{
+			name: "unchanged settings",
+			reg: importRegFile(gpuKey+`\Affinity Policy]
+"DevicePolicy"=dword:00000004
+"DevicePriority"=dword:00000003
+"AssignmentSetOverride"=hex:0c`, gpuKey+`\MessageSignaledInterruptProperties]
+"MSISupported"=dword:00000001
+"MessageNumberLimit"=dword:00000004`),
+			want: map[string]storeValues{"GPU": unchangedGPU},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			devices := importTestDevices(t)
+			rf, err := ParseRegFile([]byte(tt.reg))
+			if err != nil {
+				t.Fatal(err)
+			}
+			changes, warnings := planImport(rf, devices)
+
+			var changed []string
+			for _, change := range changes {
+				changed = append(changed, change.Device.DeviceDesc)
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("changed %q, want %q", changed, tt.changed)
+			}
+			if len(warnings) != len(tt.warnings) {
+				t.Errorf("warnings %q, want %q", warnings, tt.warnings)
+			} else {
+				for i, want := range tt.warnings {
+					if !strings.Contains(warnings[i], want) {
+						t.Errorf("warning %q, want %q", warnings[i], want)
+					}
+				}
+			}
+
+			plan, err := importPlan(changes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := applyImport(changes, plan); err != nil {
+				t.Fatal(err)
+			}
+			for _, change := range changes {
+				if affinityChanged(change.Device, &change.After) || msiChanged(change.Device, &change.After) {
+					t.Errorf("%s was not updated", change.Device.DeviceDesc)
+				}
+			}
+			for i := range devices {
+				if want, ok := tt.want[devices[i].DeviceDesc]; ok {
+					checkStore(t, devices[i].store.(*memoryStore), want)
+				}
+			}
+		})
+	}
+}
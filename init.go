@@ -1,29 +1,41 @@
 package main
 
 import (
+	"fmt"
 	"log"
-	"strconv"
+	"sort"
+	"strings"
 	"time"
-
-	"golang.org/x/sys/windows/registry"
 )
 
 type Device struct {
-	Idata               DevInfoData
-	reg                 registry.Key
+	deviceHandle        // the SetupAPI handles of a device of the running system
+	store               PolicyStore
 	IrqPolicy           int32
 	DeviceDesc          string
-	DeviceIDs           []string
+	DeviceIDs           []string // hardware IDs, most specific first
+	CompatibleIDs       []string
+	InstanceID          string
+	LocationPaths       []string
+	PCI                 PCILocation
 	DevObjName          string
-	Driver              string
+	Class               string // setup class, e.g. Net
+	ClassGUID           string
+	Driver              string // driver service name, e.g. nvlddmkm
+	Parent              string // instance ID of the parent device, e.g. the PCI bridge
+	ContainerID         string // the same for all devices of one physical product
+	NumaNode            int    // DEVPKEY_Device_Numa_Node, -1 if unknown
 	LocationInformation string
 	FriendlyName        string
+	RegPath             string
 	LastChange          time.Time
+	RebootRequired      bool // Windows needs a reboot before the device works with its current settings
+	State               DeviceState
 
 	// AffinityPolicy
 	DevicePolicy          uint32
 	DevicePriority        uint32
-	AssignmentSetOverride Bits
+	AssignmentSetOverride CPUMask
 
 	// MessageSignaledInterruptProperties
 	MsiSupported       uint32
@@ -32,6 +44,60 @@ type Device struct {
 	InterruptTypeMap   Bits
 }
 
+// PCILocation is the bus/device/function of a PCI device.
+type PCILocation struct {
+	Valid    bool
+	Bus      uint32
+	Device   uint32
+	Function uint32
+}
+
+func (p PCILocation) String() string {
+	if !p.Valid {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d.%d", p.Bus, p.Device, p.Function)
+}
+
+// DeviceState marks the inactive devices listed with -include-inactive,
+// it is 0 for an enabled device that is present.
+type DeviceState byte
+
+const CONFIG_FLAG_DISABLED uint32 = 1
+
+const (
+	DeviceDisabled   DeviceState = 1 << iota // CONFIG_FLAG_DISABLED
+	DeviceNotPresent                         // a phantom device, installed but not connected
+)
+
+func (s DeviceState) Disabled() bool   { return s&DeviceDisabled != 0 }
+func (s DeviceState) NotPresent() bool { return s&DeviceNotPresent != 0 }
+
+// String lists the states, e.g. "disabled, not present".
+func (s DeviceState) String() string {
+	var states []string
+	if s.Disabled() {
+		states = append(states, "disabled")
+	}
+	if s.NotPresent() {
+		states = append(states, "not present")
+	}
+	return strings.Join(states, ", ")
+}
+
+// restartSkipped explains why dev is not restarted, "" if it can be.
+// Windows cannot restart a device that is disabled or not connected, its
+// settings take effect when it starts the next time.
+func restartSkipped(dev *Device) string {
+	switch {
+	case dev.State.NotPresent():
+		return "the device is not present, the settings take effect when it is connected"
+	case dev.State.Disabled():
+		return "the device is disabled, the settings take effect when it is enabled"
+	}
+	return ""
+}
+
 const (
 	// https://docs.microsoft.com/en-us/windows-hardware/drivers/kernel/interrupt-affinity-and-priority
 	IrqPolicyMachineDefault                    = iota // 0
@@ -42,11 +108,44 @@ const (
 	IrqPolicySpreadMessagesAcrossAllProcessors        // 5
 )
 
-type Bits uint64
+// policyName returns the short name of a DevicePolicy as shown in the table.
+func policyName(policy uint32) string {
+	// https://docs.microsoft.com/en-us/windows-hardware/drivers/kernel/interrupt-affinity-and-priority
+	switch policy {
+	case IrqPolicyMachineDefault: // 0x00
+		return "Default"
+	case IrqPolicyAllCloseProcessors: // 0x01
+		return "All Close Proc"
+	case IrqPolicyOneCloseProcessor: // 0x02
+		return "One Close Proc"
+	case IrqPolicyAllProcessorsInMachine: // 0x03
+		return "All Proc in Machine"
+	case IrqPolicySpecifiedProcessors: // 0x04
+		return "Specified Proc"
+	case IrqPolicySpreadMessagesAcrossAllProcessors: // 0x05
+		return "Spread Messages Across All Proc"
+	default:
+		return fmt.Sprintf("%d", policy)
+	}
+}
 
-var CPUMap map[Bits]string
+func priorityName(priority uint32) string {
+	switch priority {
+	case 0:
+		return "Undefined"
+	case 1:
+		return "Low"
+	case 2:
+		return "Normal"
+	case 3:
+		return "High"
+	default:
+		return fmt.Sprintf("%d", priority)
+	}
+}
+
+type Bits uint64
 
-var CPUBits []Bits
 var InterruptTypeMap = map[Bits]string{
 	0: "unknown",
 	1: "LineBased",
@@ -55,8 +154,26 @@ var InterruptTypeMap = map[Bits]string{
 	4: "MsiX",
 }
 
+func interruptType(b Bits) string {
+	return strings.Join(interruptTypes(b), ", ")
+}
+
+// interruptTypes returns the sorted names of the interrupt types in b.
+func interruptTypes(b Bits) []string {
+	if b == ZeroBit {
+		return nil
+	}
+	var types []string
+	for bit, name := range InterruptTypeMap {
+		if Has(b, bit) {
+			types = append(types, name)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
 var sysInfo SystemInfo
-var handle DevInfo
 
 const ZeroBit = Bits(0)
 
@@ -64,14 +181,6 @@ func init() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
 	sysInfo = GetSystemInfo()
-	CPUMap = make(map[Bits]string, sysInfo.NumberOfProcessors)
-	var index Bits = 1
-	for i := 0; i < int(sysInfo.NumberOfProcessors); i++ {
-		indexString := strconv.Itoa(i)
-		CPUMap[index] = indexString
-		CPUBits = append(CPUBits, index)
-		index *= 2
-	}
 }
 
 func Set(b, flag Bits) Bits    { return b | flag }
@@ -79,23 +188,6 @@ func Clear(b, flag Bits) Bits  { return b &^ flag }
 func Toggle(b, flag Bits) Bits { return b ^ flag }
 func Has(b, flag Bits) bool    { return b&flag != 0 }
 
-// https://gist.github.com/chiro-hiro/2674626cebbcb5a676355b7aaac4972d
-func i64tob(val uint64) []byte {
-	r := make([]byte, 8)
-	for i := uint64(0); i < 8; i++ {
-		r[i] = byte((val >> (i * 8)) & 0xff)
-	}
-	return r
-}
-
-func btoi64(val []byte) uint64 {
-	r := uint64(0)
-	for i := uint64(0); i < 8; i++ {
-		r |= uint64(val[i]) << (8 * i)
-	}
-	return r
-}
-
 func btoi32(val []byte) uint32 {
 	r := uint32(0)
 	for i := uint32(0); i < 4; i++ {
@@ -111,3 +203,12 @@ func btoi16(val []byte) uint16 {
 	}
 	return r
 }
+
+func clen(n []byte) int {
+	for i := len(n) - 1; i >= 0; i-- {
+		if n[i] != 0 {
+			return i + 1
+		}
+	}
+	return len(n)
+}
@@ -3,10 +3,7 @@ package main
 // https://github.com/prometheus-community/windows_exporter/blob/74eac8f29b8083b9e6a4832d739748739e4e3fe0/headers/sysinfoapi/sysinfoapi.go#L44
 
 import (
-	"syscall"
 	"unsafe"
-
-	"golang.org/x/sys/windows"
 )
 
 // wProcessorArchitecture is a wrapper for the union found in LP_SYSTEM_INFO
@@ -31,16 +28,16 @@ const (
 
 // SystemInfo is an idiomatic wrapper for LpSystemInfo
 type SystemInfo struct {
-	Arch                      ProcessorArchitecture
-	PageSize                  uint32
-	MinimumApplicationAddress uintptr
-	MaximumApplicationAddress uintptr
-	ActiveProcessorMask       uint
-	NumberOfProcessors        uint32
-	ProcessorType             uint32
-	AllocationGranularity     uint32
-	ProcessorLevel            uint16
-	ProcessorRevision         uint16
+	Arch                      ProcessorArchitecture `json:"arch"`
+	PageSize                  uint32                `json:"pageSize"`
+	MinimumApplicationAddress uintptr               `json:"minimumApplicationAddress"`
+	MaximumApplicationAddress uintptr               `json:"maximumApplicationAddress"`
+	ActiveProcessorMask       uint                  `json:"activeProcessorMask"`
+	NumberOfProcessors        uint32                `json:"numberOfProcessors"`
+	ProcessorType             uint32                `json:"processorType"`
+	AllocationGranularity     uint32                `json:"allocationGranularity"`
+	ProcessorLevel            uint16                `json:"processorLevel"`
+	ProcessorRevision         uint16                `json:"processorRevision"`
 }
 
 // LpSystemInfo is a wrapper for LPSYSTEM_INFO
@@ -58,34 +55,6 @@ type lpSystemInfo struct {
 	WProcessorRevision          uint16
 }
 
-var (
-	// Library
-	libKernel32 = windows.NewLazySystemDLL("kernel32.dll")
-
-	// Functions
-	getSystemCpuSetInformation = libKernel32.NewProc("GetSystemCpuSetInformation")
-	getSystemInfo              = libKernel32.NewProc("GetSystemInfo")
-)
-
-// GetSystemInfo is an idiomatic wrapper for the GetSystemInfo function from sysinfoapi
-// https://docs.microsoft.com/en-us/windows/win32/api/sysinfoapi/nf-sysinfoapi-getsysteminfo
-func GetSystemInfo() SystemInfo {
-	var info lpSystemInfo
-	getSystemInfo.Call(uintptr(unsafe.Pointer(&info)))
-	return SystemInfo{
-		Arch:                      ProcessorArchitecture(info.Arch.WProcessorArchitecture),
-		PageSize:                  info.DwPageSize,
-		MinimumApplicationAddress: info.LpMinimumApplicationAddress,
-		MaximumApplicationAddress: info.LpMinimumApplicationAddress,
-		ActiveProcessorMask:       info.DwActiveProcessorMask,
-		NumberOfProcessors:        info.DwNumberOfProcessors,
-		ProcessorType:             info.DwProcessorType,
-		AllocationGranularity:     info.DwAllocationGranularity,
-		ProcessorLevel:            info.WProcessorLevel,
-		ProcessorRevision:         info.WProcessorRevision,
-	}
-}
-
 // The SystemInformationClass constants have been derived from the SYSTEM_INFORMATION_CLASS enum definition.
 const (
 	SystemAllowedCpuSetsInformation = 0xA8
@@ -178,25 +147,10 @@ func (t *SYSTEM_CPU_SET_INFORMATION_Anonymous) CpuSetVal() SYSTEM_CPU_SET_INFORM
 
 type CPU_SET_INFORMATION_TYPE int32
 
+const CpuSetInformation CPU_SET_INFORMATION_TYPE = 0
+
 type SYSTEM_CPU_SET_INFORMATION struct {
 	Size uint32
 	Type CPU_SET_INFORMATION_TYPE
 	SYSTEM_CPU_SET_INFORMATION_Anonymous
 }
-
-func GetSystemCpuSetInformation(
-	information *SYSTEM_CPU_SET_INFORMATION,
-	bufferLength uint32,
-	returnedLength *uint32,
-	process uintptr,
-	flags uint32,
-) uint32 {
-	r1, _, _ := syscall.SyscallN(getSystemCpuSetInformation.Addr(),
-		uintptr(unsafe.Pointer(information)),
-		uintptr(bufferLength),
-		uintptr(unsafe.Pointer(returnedLength)),
-		process,
-		uintptr(flags),
-	)
-	return uint32(r1)
-}
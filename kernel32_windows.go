@@ -0,0 +1,78 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	// Library
+	libKernel32 = windows.NewLazySystemDLL("kernel32.dll")
+
+	// Functions
+	getSystemCpuSetInformation       = libKernel32.NewProc("GetSystemCpuSetInformation")
+	getSystemInfo                    = libKernel32.NewProc("GetSystemInfo")
+	getLogicalProcessorInformationEx = libKernel32.NewProc("GetLogicalProcessorInformationEx")
+)
+
+// GetSystemInfo is an idiomatic wrapper for the GetSystemInfo function from sysinfoapi
+// https://docs.microsoft.com/en-us/windows/win32/api/sysinfoapi/nf-sysinfoapi-getsysteminfo
+func GetSystemInfo() SystemInfo {
+	var info lpSystemInfo
+	getSystemInfo.Call(uintptr(unsafe.Pointer(&info)))
+	return SystemInfo{
+		Arch:                      ProcessorArchitecture(info.Arch.WProcessorArchitecture),
+		PageSize:                  info.DwPageSize,
+		MinimumApplicationAddress: info.LpMinimumApplicationAddress,
+		MaximumApplicationAddress: info.LpMaximumApplicationAddress,
+		ActiveProcessorMask:       info.DwActiveProcessorMask,
+		NumberOfProcessors:        info.DwNumberOfProcessors,
+		ProcessorType:             info.DwProcessorType,
+		AllocationGranularity:     info.DwAllocationGranularity,
+		ProcessorLevel:            info.WProcessorLevel,
+		ProcessorRevision:         info.WProcessorRevision,
+	}
+}
+
+func GetSystemCpuSetInformation(
+	information *SYSTEM_CPU_SET_INFORMATION,
+	bufferLength uint32,
+	returnedLength *uint32,
+	process windows.Handle,
+	flags uint32,
+) (err error) {
+	r1, _, e1 := syscall.SyscallN(getSystemCpuSetInformation.Addr(),
+		uintptr(unsafe.Pointer(information)),
+		uintptr(bufferLength),
+		uintptr(unsafe.Pointer(returnedLength)),
+		uintptr(process),
+		uintptr(flags),
+	)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
+
+// https://learn.microsoft.com/en-us/windows/win32/api/sysinfoapi/nf-sysinfoapi-getlogicalprocessorinformationex
+func GetLogicalProcessorInformationEx(relationshipType uint32, buffer *byte, returnedLength *uint32) (err error) {
+	r1, _, e1 := syscall.SyscallN(getLogicalProcessorInformationEx.Addr(),
+		uintptr(relationshipType),
+		uintptr(unsafe.Pointer(buffer)),
+		uintptr(unsafe.Pointer(returnedLength)),
+	)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+type Severity int
+
+const (
+	SeverityInfo Severity = iota
+	SeverityWarning
+	SeverityError
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityInfo:
+		return "info"
+	case SeverityWarning:
+		return "warning"
+	case SeverityError:
+		return "error"
+	default:
+		return fmt.Sprintf("severity %d", int(s))
+	}
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Finding is one problem found by a lint rule.
+type Finding struct {
+	Device   *Device  `json:"-"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// LintRule checks a single device against the processor topology and
+// returns a message for every problem it finds.
+type LintRule struct {
+	Name     string
+	Severity Severity
+	Check    func(dev *Device, topology *CpuSets) []string
+}
+
+var lintRules = []LintRule{
+	{"policy-range", SeverityError, lintPolicyRange},
+	{"priority-range", SeverityError, lintPriorityRange},
+	{"empty-mask", SeverityError, lintEmptyMask},
+	{"unknown-cpu", SeverityError, lintUnknownCPU},
+	{"unavailable-cpu", SeverityWarning, lintUnavailableCPU},
+	{"unused-mask", SeverityInfo, lintUnusedMask},
+	{"msi-unsupported", SeverityError, lintMSIUnsupported},
+	{"message-limit-max", SeverityWarning, lintMessageLimitMax},
+	{"message-limit-power-of-two", SeverityWarning, lintMessageLimitPowerOfTwo},
+}
+
+func lintPolicyRange(dev *Device, topology *CpuSets) []string {
+	if dev.DevicePolicy > IrqPolicySpreadMessagesAcrossAllProcessors {
+		return []string{fmt.Sprintf("DevicePolicy %d is not a valid policy", dev.DevicePolicy)}
+	}
+	return nil
+}
+
+func lintPriorityRange(dev *Device, topology *CpuSets) []string {
+	if dev.DevicePriority > 3 {
+		return []string{fmt.Sprintf("DevicePriority %d is outside of 0-3", dev.DevicePriority)}
+	}
+	return nil
+}
+
+func lintEmptyMask(dev *Device, topology *CpuSets) []string {
+	if dev.DevicePolicy == IrqPolicySpecifiedProcessors && dev.AssignmentSetOverride.Intersect(topology.Mask()).IsZero() {
+		return []string{"DevicePolicy 4 (Specified Processors) without any existing processor"}
+	}
+	return nil
+}
+
+func lintUnknownCPU(dev *Device, topology *CpuSets) []string {
+	if dev.DevicePolicy != IrqPolicySpecifiedProcessors {
+		return nil
+	}
+	var messages []string
+	for _, p := range dev.AssignmentSetOverride.Processors() {
+		if !topology.Has(p) {
+			messages = append(messages, fmt.Sprintf("AssignmentSetOverride names processor %d, which does not exist", p))
+		}
+	}
+	return messages
+}
+
+// lintUnavailableCPU warns when every processor of the mask is parked or
+// reserved, the interrupts then wake a parked core or compete with the
+// process or real-time work the processors are reserved for.
+func lintUnavailableCPU(dev *Device, topology *CpuSets) []string {
+	if dev.DevicePolicy != IrqPolicySpecifiedProcessors {
+		return nil
+	}
+	var parked, reserved, available int
+	for _, cpu := range topology.CPU {
+		if !dev.AssignmentSetOverride.Has(cpu.Processor()) {
+			continue
+		}
+		switch {
+		case cpu.Flags.Reserved():
+			reserved++
+		case cpu.Flags.Parked():
+			parked++
+		default:
+			available++
+		}
+	}
+	if available != 0 || parked+reserved == 0 {
+		return nil
+	}
+	return []string{fmt.Sprintf("AssignmentSetOverride [%s] only targets parked or reserved processors (%d parked, %d reserved)", dev.AssignmentSetOverride, parked, reserved)}
+}
+
+func lintUnusedMask(dev *Device, topology *CpuSets) []string {
+	if dev.DevicePolicy != IrqPolicySpecifiedProcessors && !dev.AssignmentSetOverride.IsZero() {
+		return []string{fmt.Sprintf("AssignmentSetOverride [%s] is ignored unless DevicePolicy is 4", dev.AssignmentSetOverride)}
+	}
+	return nil
+}
+
+func lintMSIUnsupported(dev *Device, topology *CpuSets) []string {
+	if dev.MsiSupported == 1 && dev.InterruptTypeMap == 1 { // LineBased only
+		return []string{"MSI is enabled but the device only reports line based interrupts"}
+	}
+	return nil
+}
+
+func lintMessageLimitMax(dev *Device, topology *CpuSets) []string {
+	if dev.MaxMSILimit != 0 && dev.MessageNumberLimit > dev.MaxMSILimit {
+		return []string{fmt.Sprintf("MessageNumberLimit %d exceeds the %d messages the device supports", dev.MessageNumberLimit, dev.MaxMSILimit)}
+	}
+	return nil
+}
+
+func lintMessageLimitPowerOfTwo(dev *Device, topology *CpuSets) []string {
+	if dev.MessageNumberLimit == 0 || Has(dev.InterruptTypeMap, 4) || !Has(dev.InterruptTypeMap, 2) { // plain MSI only
+		return nil
+	}
+	if bits.OnesCount32(dev.MessageNumberLimit) != 1 {
+		return []string{fmt.Sprintf("MessageNumberLimit %d is not a power of two, MSI allocates 1, 2, 4, 8, 16 or 32 messages", dev.MessageNumberLimit)}
+	}
+	return nil
+}
+
+// lintDevice runs every rule on dev.
+func lintDevice(dev *Device, topology *CpuSets) []Finding {
+	var findings []Finding
+	for _, rule := range lintRules {
+		for _, message := range rule.Check(dev, topology) {
+			findings = append(findings, Finding{
+				Device:   dev,
+				Rule:     rule.Name,
+				Severity: rule.Severity,
+				Message:  message,
+			})
+		}
+	}
+	return findings
+}
+
+// Lint runs every rule on every device.
+func Lint(devices []Device, topology *CpuSets) []Finding {
+	var findings []Finding
+	for i := range devices {
+		findings = append(findings, lintDevice(&devices[i], topology)...)
+	}
+	return findings
+}
+
+// maxSeverity returns the highest severity of findings and false if there are none.
+func maxSeverity(findings []Finding) (Severity, bool) {
+	if len(findings) == 0 {
+		return SeverityInfo, false
+	}
+	highest := SeverityInfo
+	for _, finding := range findings {
+		if finding.Severity > highest {
+			highest = finding.Severity
+		}
+	}
+	return highest, true
+}
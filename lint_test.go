@@ -0,0 +1,103 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// useLintTopology loads eight processors, 6 is parked and 7 reserved for
+// real-time work.
+func useLintTopology(t *testing.T) {
+	t.Helper()
+	useTopologyFixture(t, "8-threads.json")
+	cs.CPU[6].Flags = CpuSetFlags(SYSTEM_CPU_SET_INFORMATION_PARKED)
+	cs.CPU[7].Flags = CpuSetFlags(SYSTEM_CPU_SET_INFORMATION_REALTIME)
+}
+
+func TestLintRules(t *testing.T) {
+	useLintTopology(t)
+	specified := func(processors ...int) Device {
+		return Device{DevicePolicy: IrqPolicySpecifiedProcessors, AssignmentSetOverride: NewCPUMask(processors...), InterruptTypeMap: 2}
+	}
+	msi := func(typeMap Bits, limit, maxLimit uint32) Device {
+		return Device{InterruptTypeMap: typeMap, MsiSupported: 1, MessageNumberLimit: limit, MaxMSILimit: maxLimit}
+	}
+	tests := []struct {
+		name  string
+		dev   Device
+		rules []string
+	}{
+		{"valid", Device{DevicePolicy: IrqPolicySpecifiedProcessors, DevicePriority: 3, AssignmentSetOverride: NewCPUMask(2, 3), InterruptTypeMap: 6, MsiSupported: 1, MessageNumberLimit: 4, MaxMSILimit: 8}, nil},
+		{"default", Device{}, nil},
+
+		{"policy 5", Device{DevicePolicy: IrqPolicySpreadMessagesAcrossAllProcessors}, nil},
+		{"policy 6", Device{DevicePolicy: 6}, []string{"policy-range"}},
+
+		{"priority 3", Device{DevicePriority: 3}, nil},
+		{"priority 4", Device{DevicePriority: 4}, []string{"priority-range"}},
+
+		{"no processor", specified(), []string{"empty-mask"}},
+		{"only a missing processor", specified(70), []string{"empty-mask", "unknown-cpu"}},
+
+		{"missing processors", specified(1, 9, 64), []string{"unknown-cpu", "unknown-cpu"}},
+		{"existing processors", specified(0, 7), nil},
+
+		{"parked and reserved processors", specified(6, 7), []string{"unavailable-cpu"}},
+		{"one available processor", specified(5, 6, 7), nil},
+
+		{"mask without policy 4", Device{DevicePolicy: IrqPolicyAllCloseProcessors, AssignmentSetOverride: NewCPUMask(1)}, []string{"unused-mask"}},
+		{"policy without mask", Device{DevicePolicy: IrqPolicyAllCloseProcessors}, nil},
+
+		{"MSI on a line based device", msi(1, 0, 0), []string{"msi-unsupported"}},
+		{"MSI on a device with MSI", msi(3, 0, 0), nil},
+
+		{"limit above the maximum", msi(4, 8, 4), []string{"message-limit-max"}},
+		{"limit at the maximum", msi(4, 4, 4), nil},
+		{"unknown maximum", msi(4, 8, 0), nil},
+
+		{"MSI limit 3", msi(2, 3, 0), []string{"message-limit-power-of-two"}},
+		{"MSI-X limit 3", msi(6, 3, 0), nil},
+		{"MSI limit 8", msi(3, 8, 0), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rules []string
+			for _, finding := range lintDevice(&tt.dev, &cs) {
+				rules = append(rules, finding.Rule)
+				if finding.Device != &tt.dev || finding.Message == "" {
+					t.Errorf("finding %+v", finding)
+				}
+			}
+			if !reflect.DeepEqual(rules, tt.rules) {
+				t.Errorf("rules %q, want %q", rules, tt.rules)
+			}
+		})
+	}
+}
+
+func TestLintSeverity(t *testing.T) {
+	useLintTopology(t)
+	devices := []Device{
+		{DevicePolicy: IrqPolicyAllCloseProcessors, AssignmentSetOverride: NewCPUMask(1)}, // info
+		{InterruptTypeMap: 2, MessageNumberLimit: 3},                                      // warning
+		{DevicePriority: 4}, // error
+	}
+	findings := Lint(devices, &cs)
+	if len(findings) != 3 {
+		t.Fatalf("%d findings, want one per device: %+v", len(findings), findings)
+	}
+	for i, finding := range findings {
+		if finding.Device != &devices[i] || finding.Severity != Severity(i) {
+			t.Errorf("finding %d: %+v", i, finding)
+		}
+	}
+
+	for i, want := range []Severity{SeverityInfo, SeverityWarning, SeverityError} {
+		if got, ok := maxSeverity(findings[:i+1]); got != want || !ok {
+			t.Errorf("maxSeverity of %d findings = %v, %v, want %v", i+1, got, ok, want)
+		}
+	}
+	if _, ok := maxSeverity(nil); ok {
+		t.Error("maxSeverity reports a finding without any")
+	}
+}
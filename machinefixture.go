@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// MachineFixture is a whole machine stored in a file: the devices with their
+// properties and the values below their device keys, and the processor
+// topology. The record command writes the one of the running system and
+// -machine-fixture replays it, so the commands, the terminal interface and
+// the main window work without the devices of a real machine.
+type MachineFixture struct {
+	Name     string           `json:"name"`
+	Topology *TopologyFixture `json:"topology,omitempty"`
+	Devices  []DeviceFixture  `json:"devices"`
+}
+
+// DeviceFixture is a device of a MachineFixture.
+type DeviceFixture struct {
+	DeviceDesc          string    `json:"deviceDesc"`
+	FriendlyName        string    `json:"friendlyName,omitempty"`
+	DevObjName          string    `json:"devObjName,omitempty"`
+	InstanceID          string    `json:"instanceId"`
+	LocationInformation string    `json:"locationInformation,omitempty"`
+	HardwareIDs         []string  `json:"hardwareIds,omitempty"`
+	CompatibleIDs       []string  `json:"compatibleIds,omitempty"`
+	LocationPaths       []string  `json:"locationPaths,omitempty"`
+	PCI                 string    `json:"pci,omitempty"` // bus:device.function
+	Class               string    `json:"class,omitempty"`
+	ClassGUID           string    `json:"classGuid,omitempty"`
+	Service             string    `json:"service,omitempty"`
+	Parent              string    `json:"parent,omitempty"`
+	ContainerID         string    `json:"containerId,omitempty"`
+	NumaNode            *int      `json:"numaNode,omitempty"`
+	InterruptTypeMap    Bits      `json:"interruptTypeMap"`
+	MaxMSILimit         uint32    `json:"maxMsiLimit,omitempty"`
+	RegPath             string    `json:"regPath,omitempty"`
+	LastChange          time.Time `json:"lastChange"`
+	RebootRequired      bool      `json:"rebootRequired,omitempty"`
+	Disabled            bool      `json:"disabled,omitempty"`           // only replayed with -include-inactive
+	NotPresent          bool      `json:"notPresent,omitempty"`         // only replayed with -include-inactive
+	RestartNeedsReboot  bool      `json:"restartNeedsReboot,omitempty"` // Restart reports that the device could not be restarted
+
+	// Registry holds the keys below the device key that exist, by their
+	// path relative to it, e.g. affinityPolicyKey.
+	Registry map[string]RegistryKeyFixture `json:"registry,omitempty"`
+}
+
+// RegistryKeyFixture are the values of a key, binary values in hex.
+type RegistryKeyFixture struct {
+	DWords map[string]uint32 `json:"dwords,omitempty"`
+	Binary map[string]string `json:"binary,omitempty"`
+}
+
+// fixtureKeys and fixtureValues are what a DeviceFixture records of the
+// device key, the keys and values the program reads and writes.
+var (
+	fixtureKeys   = []string{interruptManagementKey, affinityPolicyKey, msiPropertiesKey}
+	fixtureValues = []struct {
+		path, name string
+		dword      bool
+	}{
+		{affinityPolicyKey, "DevicePolicy", true},
+		{affinityPolicyKey, "DevicePriority", true},
+		{affinityPolicyKey, "AssignmentSetOverride", false},
+		{msiPropertiesKey, "MSISupported", true},
+		{msiPropertiesKey, "MessageNumberLimit", true},
+	}
+)
+
+// newMachineFixture records devices and the topology cs.
+func newMachineFixture(name string, devices []Device, cs *CpuSets) *MachineFixture {
+	f := &MachineFixture{
+		Name:     name,
+		Devices:  make([]DeviceFixture, len(devices)),
+		Topology: &TopologyFixture{Name: name, CPUs: cs.CPU, Relations: cs.Relations},
+	}
+	if len(cs.CPU) == 0 {
+		f.Topology = nil
+	}
+	for i := range devices {
+		dev := &devices[i]
+		d := DeviceFixture{
+			DeviceDesc:          dev.DeviceDesc,
+			FriendlyName:        dev.FriendlyName,
+			DevObjName:          dev.DevObjName,
+			InstanceID:          dev.InstanceID,
+			LocationInformation: dev.LocationInformation,
+			HardwareIDs:         dev.DeviceIDs,
+			CompatibleIDs:       dev.CompatibleIDs,
+			LocationPaths:       dev.LocationPaths,
+			PCI:                 dev.PCI.String(),
+			Class:               dev.Class,
+			ClassGUID:           dev.ClassGUID,
+			Service:             dev.Driver,
+			Parent:              dev.Parent,
+			ContainerID:         dev.ContainerID,
+			InterruptTypeMap:    dev.InterruptTypeMap,
+			MaxMSILimit:         dev.MaxMSILimit,
+			RegPath:             dev.RegPath,
+			LastChange:          dev.LastChange,
+			RebootRequired:      dev.RebootRequired,
+			Disabled:            dev.State.Disabled(),
+			NotPresent:          dev.State.NotPresent(),
+			Registry:            map[string]RegistryKeyFixture{},
+		}
+		for _, path := range fixtureKeys {
+			if dev.store == nil {
+				break // no device key
+			}
+			if exists, err := dev.store.KeyExists(path); err == nil && exists {
+				d.Registry[path] = RegistryKeyFixture{DWords: map[string]uint32{}, Binary: map[string]string{}}
+			}
+		}
+		for _, v := range fixtureValues {
+			key, ok := d.Registry[v.path]
+			if !ok {
+				continue
+			}
+			if v.dword {
+				if value, err := dev.store.GetDWordValue(v.path, v.name); err == nil {
+					key.DWords[v.name] = value
+				}
+			} else if value, err := dev.store.GetBinaryValue(v.path, v.name); err == nil {
+				key.Binary[v.name] = hex.EncodeToString(value)
+			}
+		}
+		if dev.NumaNode >= 0 {
+			node := dev.NumaNode
+			d.NumaNode = &node
+		}
+		f.Devices[i] = d
+	}
+	return f
+}
+
+func LoadMachineFixture(path string) (*MachineFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture MachineFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if fixture.Topology != nil && len(fixture.Topology.CPUs) == 0 {
+		return nil, fmt.Errorf("%s: topology without cpus", path)
+	}
+	return &fixture, nil
+}
+
+func (f *MachineFixture) Write(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+// device returns the properties of the device, without its settings.
+func (d *DeviceFixture) device() (Device, error) {
+	dev := Device{
+		DeviceDesc:          d.DeviceDesc,
+		FriendlyName:        d.FriendlyName,
+		DevObjName:          d.DevObjName,
+		InstanceID:          d.InstanceID,
+		LocationInformation: d.LocationInformation,
+		DeviceIDs:           d.HardwareIDs,
+		CompatibleIDs:       d.CompatibleIDs,
+		LocationPaths:       d.LocationPaths,
+		Class:               d.Class,
+		ClassGUID:           d.ClassGUID,
+		Driver:              d.Service,
+		Parent:              d.Parent,
+		ContainerID:         d.ContainerID,
+		NumaNode:            -1,
+		InterruptTypeMap:    d.InterruptTypeMap,
+		MaxMSILimit:         d.MaxMSILimit,
+		RegPath:             d.RegPath,
+		LastChange:          d.LastChange,
+		RebootRequired:      d.RebootRequired,
+	}
+	if d.NumaNode != nil {
+		dev.NumaNode = *d.NumaNode
+	}
+	if d.Disabled {
+		dev.State |= DeviceDisabled
+	}
+	if d.NotPresent {
+		dev.State |= DeviceNotPresent
+	}
+	if d.PCI != "" {
+		pci, err := parsePCILocation(d.PCI)
+		if err != nil {
+			return dev, err
+		}
+		dev.PCI = pci
+	}
+	return dev, nil
+}
+
+// store returns the recorded values as a memoryStore.
+func (d *DeviceFixture) store() (*memoryStore, error) {
+	store := newMemoryStore()
+	for path, key := range d.Registry {
+		if err := store.CreateKey(path); err != nil {
+			return nil, err
+		}
+		for name, value := range key.DWords {
+			if err := store.SetDWordValue(path, name, value); err != nil {
+				return nil, err
+			}
+		}
+		for name, value := range key.Binary {
+			data, err := hex.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf(`%s\%s: %w`, path, name, err)
+			}
+			if err := store.SetBinaryValue(path, name, data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return store, nil
+}
+
+// machineFixtureSource is the DeviceSource of -machine-fixture. Changes are
+// kept in memory, the fixture file is never written.
+type machineFixtureSource struct {
+	fixture *MachineFixture
+	stores  []*memoryStore
+}
+
+// OpenMachineFixture reads the fixture at path for replay.
+func OpenMachineFixture(path string) (*machineFixtureSource, error) {
+	fixture, err := LoadMachineFixture(path)
+	if err != nil {
+		return nil, err
+	}
+	s := &machineFixtureSource{fixture: fixture, stores: make([]*memoryStore, len(fixture.Devices))}
+	for i := range fixture.Devices {
+		d := &fixture.Devices[i]
+		if s.stores[i], err = d.store(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %w", path, d.InstanceID, err)
+		}
+	}
+	return s, nil
+}
+
+func (s *machineFixtureSource) Devices() ([]Device, error) {
+	var devices []Device
+	for i := range s.fixture.Devices {
+		dev, err := s.fixture.Devices[i].device()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.fixture.Devices[i].InstanceID, err)
+		}
+		if dev.State != 0 && !flagIncludeInactive {
+			continue
+		}
+		dev.store = s.stores[i]
+		readAffinityPolicy(dev.store, &dev)
+		readMSIProperties(dev.store, &dev)
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+func (s *machineFixtureSource) CanRestart() bool {
+	return true
+}
+
+// Restart succeeds unless the fixture says that the device could not be restarted.
+func (s *machineFixtureSource) Restart(dev *Device) (bool, error) {
+	for i := range s.fixture.Devices {
+		if s.fixture.Devices[i].InstanceID == dev.InstanceID {
+			return s.fixture.Devices[i].RestartNeedsReboot, nil
+		}
+	}
+	return false, fmt.Errorf("%w %q in the machine fixture", errNoDevice, dev.InstanceID)
+}
+
+func (s *machineFixtureSource) Close() error {
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var machineFixtureFile = filepath.Join("testdata", "machine", "workstation.json")
+
+// writeMachineFixtureFile records a workstation with an i9-13900: a GPU that
+// cannot be restarted without a reboot, a network adapter pinned to
+// processor 2, a USB controller and a disabled capture card.
+func writeMachineFixtureFile(t *testing.T) {
+	useTopologyFixture(t, "intel-core-i9-13900.json")
+	lastChange := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	devices := []Device{
+		{
+			DeviceDesc:       "NVIDIA GeForce RTX 4090",
+			DevObjName:       `\Device\NTPNP_PCI0015`,
+			InstanceID:       `PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1\4&2B8F4B3C&0&0008`,
+			DeviceIDs:        []string{`PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1`, `PCI\VEN_10DE&DEV_2684`},
+			CompatibleIDs:    []string{`PCI\VEN_10DE&CC_030000`, `PCI\CC_030000`},
+			PCI:              PCILocation{Valid: true, Bus: 1},
+			Class:            "Display",
+			Driver:           "nvlddmkm",
+			InterruptTypeMap: 3,
+			MaxMSILimit:      1,
+			RegPath:          testRegPath,
+			store:            newTestStore(t, storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1)}}),
+		},
+		{
+			DeviceDesc:          "Intel(R) Ethernet Controller I226-V",
+			DevObjName:          `\Device\NTPNP_PCI0021`,
+			InstanceID:          `PCI\VEN_8086&DEV_125C&SUBSYS_00008086&REV_04\6&2F6E5E2&0&000800E6`,
+			DeviceIDs:           []string{`PCI\VEN_8086&DEV_125C&SUBSYS_00008086&REV_04`, `PCI\VEN_8086&DEV_125C`},
+			CompatibleIDs:       []string{`PCI\VEN_8086&CC_020000`, `PCI\CC_020000`},
+			LocationInformation: "PCI bus 4, device 0, function 0",
+			PCI:                 PCILocation{Valid: true, Bus: 4},
+			Class:               "Net",
+			Driver:              "e2fexpress",
+			InterruptTypeMap:    7,
+			MaxMSILimit:         5,
+			RegPath:             `HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Enum\PCI\VEN_8086&DEV_125C&SUBSYS_00008086&REV_04\6&2F6E5E2&0&000800E6\Device Parameters`,
+			store: newTestStore(t, storeValues{
+				interruptManagementKey: {},
+				msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+				affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x04}},
+			}),
+		},
+		{
+			DeviceDesc:       "Intel(R) USB 3.20 eXtensible Host Controller - 1.20 (Microsoft)",
+			InstanceID:       `PCI\VEN_8086&DEV_7AE0&SUBSYS_7D251462&REV_11\3&11583659&0&A0`,
+			DeviceIDs:        []string{`PCI\VEN_8086&DEV_7AE0&SUBSYS_7D251462&REV_11`},
+			CompatibleIDs:    []string{`PCI\CC_0C0330`},
+			PCI:              PCILocation{Valid: true, Device: 20},
+			Class:            "USB",
+			Driver:           "USBXHCI",
+			InterruptTypeMap: 3,
+			MaxMSILimit:      8,
+			store:            newTestStore(t, storeValues{}),
+		},
+		{
+			DeviceDesc:       "Magewell Pro Capture HDMI 4K",
+			InstanceID:       `PCI\VEN_1CD7&DEV_0010&SUBSYS_00101CD7&REV_00\4&3C1D2E5F&0&00E4`,
+			PCI:              PCILocation{Valid: true, Bus: 5},
+			InterruptTypeMap: 3,
+			State:            DeviceDisabled,
+			store:            newTestStore(t, storeValues{}),
+		},
+	}
+	for i := range devices {
+		devices[i].NumaNode = -1
+		devices[i].LastChange = lastChange
+	}
+
+	fixture := newMachineFixture("Workstation with an i9-13900", devices, &cs)
+	fixture.Devices[0].RestartNeedsReboot = true
+	if err := os.MkdirAll(filepath.Dir(machineFixtureFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fixture.Write(machineFixtureFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// useMachineFixture replays the fixture like -machine-fixture, the global
+// state of the commands is restored after the test.
+func useMachineFixture(t *testing.T) {
+	t.Helper()
+	if *update {
+		writeMachineFixtureFile(t)
+	}
+	savedFixture, savedSource, savedCs := flagMachineFixture, source, cs
+	t.Cleanup(func() { flagMachineFixture, source, cs = savedFixture, savedSource, savedCs })
+	flagMachineFixture, source = machineFixtureFile, nil
+	cs.Init()
+}
+
+// runCLI runs a command and returns what it wrote to stdout and stderr.
+func runCLI(t *testing.T, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	dir := t.TempDir()
+	files := make([]*os.File, 2)
+	for i := range files {
+		f, err := os.Create(filepath.Join(dir, []string{"stdout", "stderr"}[i]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files[i] = f
+	}
+
+	savedStdout, savedStderr, savedOut, savedResult, savedDryRun := os.Stdout, os.Stderr, out, cliResult, flagDryRun
+	os.Stdout, os.Stderr, out = files[0], files[1], files[0]
+	code = runCommand(args)
+	os.Stdout, os.Stderr, out, cliResult, flagDryRun = savedStdout, savedStderr, savedOut, savedResult, savedDryRun
+
+	return string(mustReadFile(t, files[0].Name())), string(mustReadFile(t, files[1].Name())), code
+}
+
+// getRecord returns the settings of a device as get -format json shows them.
+func getRecord(t *testing.T, name string) DeviceRecord {
+	t.Helper()
+	stdout, stderr, code := runCLI(t, "get", "-format", "json", name)
+	if code != exitOK {
+		t.Fatalf("get %s: exit code %d: %s", name, code, stderr)
+	}
+	var record DeviceRecord
+	if err := json.Unmarshal([]byte(stdout), &record); err != nil {
+		t.Fatalf("get %s: %v\n%s", name, err, stdout)
+	}
+	return record
+}
+
+func TestMachineFixtureList(t *testing.T) {
+	useMachineFixture(t)
+	if len(cs.CPU) != 32 {
+		t.Fatalf("%d processors from the fixture, want the 32 of the i9-13900", len(cs.CPU))
+	}
+
+	stdout, stderr, code := runCLI(t, "list")
+	if code != exitOK {
+		t.Fatalf("exit code %d: %s", code, stderr)
+	}
+	for _, want := range []string{"NVIDIA GeForce RTX 4090", "Intel(R) Ethernet Controller I226-V", "eXtensible Host Controller"} {
+		if !strings.Contains(stdout, want) {
+			t.Errorf("list does not show %q:\n%s", want, stdout)
+		}
+	}
+	if strings.Contains(stdout, "Magewell") {
+		t.Error("list shows the disabled device")
+	}
+
+	tests := map[string][]string{
+		"-search ethernet":  {"Intel(R) Ethernet Controller I226-V"},
+		"-search nvlddmkm":  {"NVIDIA GeForce RTX 4090"}, // the service
+		"-search dev_7ae0":  {"Intel(R) USB 3.20 eXtensible Host Controller - 1.20 (Microsoft)"},
+		"-policy 4":         {"Intel(R) Ethernet Controller I226-V"},
+		"-msi -changed":     {"NVIDIA GeForce RTX 4090", "Intel(R) Ethernet Controller I226-V"},
+		"-search no-device": nil,
+	}
+	for options, want := range tests {
+		stdout, stderr, code := runCLI(t, append([]string{"list", "-format", "json"}, strings.Fields(options)...)...)
+		if code != exitOK {
+			t.Errorf("%s: exit code %d: %s", options, code, stderr)
+			continue
+		}
+		var devices []DeviceRecord
+		if err := json.Unmarshal([]byte(stdout), &devices); err != nil {
+			t.Fatalf("%s: %v\n%s", options, err, stdout)
+		}
+		var got []string
+		for _, dev := range devices {
+			got = append(got, dev.Name)
+		}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("%s lists %q, want %q", options, got, want)
+		}
+	}
+}
+
+func TestMachineFixtureGetSetRestart(t *testing.T) {
+	useMachineFixture(t)
+
+	stdout, stderr, code := runCLI(t, "get", "4:0.0")
+	if code != exitOK || !strings.Contains(stdout, "Intel(R) Ethernet Controller I226-V") || !strings.Contains(stdout, "DevicePolicy:           4 (Specified Proc)") {
+		t.Errorf("get by PCI location, exit code %d:\n%s%s", code, stdout, stderr)
+	}
+	if _, _, code := runCLI(t, "get", "no such device"); code != exitNotFound {
+		t.Errorf("get of a missing device: exit code %d, want %d", code, exitNotFound)
+	}
+	if _, _, code := runCLI(t, "get", "intel"); code != exitUsage {
+		t.Errorf("get of an ambiguous name: exit code %d, want %d", code, exitUsage)
+	}
+
+	// a dry run changes nothing
+	if stdout, stderr, code := runCLI(t, "set", "nvidia", "-policy", "4", "-cpus", "pcores,no-smt,^0", "-dry-run"); code != exitOK || !strings.Contains(stdout, "Dry run") {
+		t.Fatalf("set -dry-run, exit code %d:\n%s%s", code, stdout, stderr)
+	}
+	if record := getRecord(t, "nvidia"); record.DevicePolicy != 0 || len(record.CPUs) != 0 {
+		t.Errorf("the dry run changed the device: %+v", record)
+	}
+
+	stdout, stderr, code = runCLI(t, "set", "nvidia", "-policy", "4", "-cpus", "pcores,no-smt,^0", "-priority", "3", "-restart")
+	if code != exitOK || !strings.Contains(stdout, "could not be restarted") {
+		t.Fatalf("set -restart, exit code %d:\n%s%s", code, stdout, stderr)
+	}
+	record := getRecord(t, "nvidia")
+	if record.DevicePolicy != IrqPolicySpecifiedProcessors || record.DevicePriority != 3 || !reflect.DeepEqual(record.CPUs, []int{2, 4, 6, 8, 10, 12, 14}) {
+		t.Errorf("after set: %+v", record)
+	}
+
+	stdout, stderr, code = runCLI(t, "restart", "ethernet", "usb")
+	if code != exitOK || strings.Count(stdout, "Device successfully restarted.") != 2 {
+		t.Errorf("restart, exit code %d:\n%s%s", code, stdout, stderr)
+	}
+	if _, _, code := runCLI(t, "restart", "magewell"); code != exitNotFound {
+		t.Errorf("restart of a disabled device: exit code %d, want %d", code, exitNotFound)
+	}
+}
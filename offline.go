@@ -0,0 +1,296 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+)
+
+// PCI device properties, stored as default value of Properties\{fmtid}\pid
+// below the device instance key (DEVPKEY_PciDevice_InterruptSupport and
+// DEVPKEY_PciDevice_InterruptMessageMaximum).
+const (
+	pciInterruptSupportKey        = `Properties\{3ab22e31-8264-4b4e-9af5-a8d2d8e33e62}\000E`
+	pciInterruptMessageMaximumKey = `Properties\{3ab22e31-8264-4b4e-9af5-a8d2d8e33e62}\000F`
+	deviceParametersKey           = `Device Parameters`
+)
+
+// OfflineSystem is a SYSTEM hive of a Windows installation that is not
+// running, e.g. opened from WinPE or a mounted deployment image.
+type OfflineSystem struct {
+	Path       string
+	Hive       *Hive
+	ControlSet string // the control set Windows boots from, e.g. ControlSet001
+}
+
+// OpenOfflineSystem opens the SYSTEM hive at path.
+func OpenOfflineSystem(path string) (*OfflineSystem, error) {
+	hive, err := OpenHive(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	selectKey, err := hive.Root().Open("Select")
+	if err != nil {
+		return nil, fmt.Errorf("%s: no Select key, not a SYSTEM hive", path)
+	}
+	current, err := hiveDWord(selectKey, "Current")
+	if err != nil {
+		return nil, fmt.Errorf("%s: Select\\Current: %w", path, err)
+	}
+
+	system := &OfflineSystem{
+		Path:       path,
+		Hive:       hive,
+		ControlSet: fmt.Sprintf("ControlSet%03d", current),
+	}
+	if _, err := hive.Root().Open(system.ControlSet); err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", path, system.ControlSet, err)
+	}
+	return system, nil
+}
+
+// Save writes the changes back to the hive file.
+func (s *OfflineSystem) Save() error {
+	if !s.Hive.Dirty() {
+		return nil
+	}
+	return s.Hive.Save(s.Path)
+}
+
+// Close writes the changes back to the hive file, see Save.
+func (s *OfflineSystem) Close() error {
+	return s.Save()
+}
+
+// CanRestart is false, the changes take effect the next time Windows boots.
+func (s *OfflineSystem) CanRestart() bool {
+	return false
+}
+
+// Restart fails, see CanRestart.
+func (s *OfflineSystem) Restart(dev *Device) (bool, error) {
+	return true, errCannotRestart
+}
+
+// Devices returns every device instance below Enum that has a Device
+// Parameters key. Offline there is no way to tell which devices are present,
+// so devices that were connected once are listed as well. Disabled devices
+// are only listed with -include-inactive.
+func (s *OfflineSystem) Devices() ([]Device, error) {
+	enum, err := s.Hive.Root().Open(s.ControlSet + `\Enum`)
+	if err != nil {
+		return nil, err
+	}
+	enumerators, err := enum.Subkeys()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []Device
+	for _, enumerator := range enumerators {
+		deviceKeys, err := enumerator.Subkeys()
+		if err != nil {
+			return nil, err
+		}
+		for _, deviceKey := range deviceKeys {
+			instances, err := deviceKey.Subkeys()
+			if err != nil {
+				return nil, err
+			}
+			for _, instance := range instances {
+				dev, ok := offlineDevice(instance, enumerator.Name()+`\`+deviceKey.Name()+`\`+instance.Name())
+				if ok {
+					devices = append(devices, dev)
+				}
+			}
+		}
+	}
+	return devices, nil
+}
+
+// offlineDevice reads a device instance key like setupAPISource reads a live device.
+func offlineDevice(instance HiveKey, instanceID string) (Device, bool) {
+	params, err := instance.Subkey(deviceParametersKey)
+	if err != nil {
+		return Device{}, false
+	}
+	var state DeviceState
+	if flags, err := hiveDWord(instance, "ConfigFlags"); err == nil && flags&CONFIG_FLAG_DISABLED != 0 {
+		if !flagIncludeInactive {
+			// Sorts out deactivated devices
+			return Device{}, false
+		}
+		state = DeviceDisabled
+	}
+
+	dev := Device{
+		DeviceDesc:          hiveIndirectString(instance, "DeviceDesc"),
+		FriendlyName:        hiveIndirectString(instance, "FriendlyName"),
+		LocationInformation: hiveString(instance, "LocationInformation"),
+		DeviceIDs:           hiveMultiString(instance, "HardwareID"),
+		CompatibleIDs:       hiveMultiString(instance, "CompatibleIDs"),
+		InstanceID:          instanceID,
+		Class:               hiveString(instance, "Class"),
+		ClassGUID:           hiveString(instance, "ClassGUID"),
+		Driver:              hiveString(instance, "Service"),
+		ContainerID:         hiveString(instance, "ContainerID"),
+		NumaNode:            -1, // only known while Windows runs
+		RegPath:             `HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Enum\` + instanceID + `\` + deviceParametersKey,
+		LastChange:          params.ModTime(),
+		State:               state,
+	}
+	if dev.DeviceDesc == "" {
+		return Device{}, false
+	}
+
+	if key, err := instance.Open(pciInterruptSupportKey); err == nil {
+		if _, data, err := key.Value(""); err == nil && len(data) >= 2 {
+			dev.InterruptTypeMap = Bits(btoi16(data))
+		}
+	}
+	if key, err := instance.Open(pciInterruptMessageMaximumKey); err == nil {
+		if _, data, err := key.Value(""); err == nil && len(data) >= 4 {
+			dev.MaxMSILimit = btoi32(data)
+		}
+	}
+
+	dev.store = hiveStore{key: params}
+	readAffinityPolicy(dev.store, &dev)
+	readMSIProperties(dev.store, &dev)
+	return dev, true
+}
+
+func hiveDWord(key HiveKey, name string) (uint32, error) {
+	typ, data, err := key.Value(name)
+	if err != nil {
+		return 0, err
+	}
+	if typ != REG_DWORD || len(data) < 4 {
+		return 0, fmt.Errorf("%s: not a REG_DWORD", name)
+	}
+	return le.Uint32(data), nil
+}
+
+func decodeHiveString(data []byte) []string {
+	u16s := make([]uint16, len(data)/2)
+	for i := range u16s {
+		u16s[i] = le.Uint16(data[2*i:])
+	}
+	strs := strings.Split(string(utf16.Decode(u16s)), "\x00")
+	for len(strs) != 0 && strs[len(strs)-1] == "" {
+		strs = strs[:len(strs)-1]
+	}
+	return strs
+}
+
+func hiveString(key HiveKey, name string) string {
+	typ, data, err := key.Value(name)
+	if err != nil || (typ != REG_SZ && typ != REG_EXPAND_SZ) {
+		return ""
+	}
+	if strs := decodeHiveString(data); len(strs) != 0 {
+		return strs[0]
+	}
+	return ""
+}
+
+// hiveIndirectString resolves "@file.inf,%key%;Text" to Text, offline the
+// string tables of the inf files are not available.
+func hiveIndirectString(key HiveKey, name string) string {
+	s := hiveString(key, name)
+	if strings.HasPrefix(s, "@") {
+		if i := strings.LastIndex(s, ";"); i != -1 {
+			return s[i+1:]
+		}
+	}
+	return s
+}
+
+func hiveMultiString(key HiveKey, name string) []string {
+	typ, data, err := key.Value(name)
+	if err != nil || typ != REG_MULTI_SZ {
+		return nil
+	}
+	return decodeHiveString(data)
+}
+
+// hiveStore is a PolicyStore over the Device Parameters key of an offline hive.
+type hiveStore struct {
+	key HiveKey
+}
+
+func (s hiveStore) KeyExists(path string) (bool, error) {
+	_, err := s.key.Open(path)
+	if errors.Is(err, ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s hiveStore) CreateKey(path string) error {
+	_, err := s.key.CreatePath(path)
+	return err
+}
+
+func (s hiveStore) DeleteKey(path string) error {
+	parent, name := "", path
+	if i := strings.LastIndex(path, `\`); i != -1 {
+		parent, name = path[:i], path[i+1:]
+	}
+	key, err := s.key.Open(parent)
+	if err != nil {
+		return err
+	}
+	return key.DeleteSubkey(name)
+}
+
+func (s hiveStore) GetDWordValue(path, name string) (uint32, error) {
+	key, err := s.key.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	return hiveDWord(key, name)
+}
+
+func (s hiveStore) SetDWordValue(path, name string, value uint32) error {
+	key, err := s.key.Open(path)
+	if err != nil {
+		return err
+	}
+	data := make([]byte, 4)
+	le.PutUint32(data, value)
+	return key.SetValue(name, REG_DWORD, data)
+}
+
+func (s hiveStore) GetBinaryValue(path, name string) ([]byte, error) {
+	key, err := s.key.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	typ, data, err := key.Value(name)
+	if err != nil {
+		return nil, err
+	}
+	if typ != REG_BINARY {
+		return nil, fmt.Errorf("%s\\%s: not a REG_BINARY", path, name)
+	}
+	return data, nil
+}
+
+func (s hiveStore) SetBinaryValue(path, name string, value []byte) error {
+	key, err := s.key.Open(path)
+	if err != nil {
+		return err
+	}
+	return key.SetValue(name, REG_BINARY, value)
+}
+
+func (s hiveStore) DeleteValue(path, name string) error {
+	key, err := s.key.Open(path)
+	if err != nil {
+		return err
+	}
+	return key.DeleteValue(name)
+}
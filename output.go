@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// out receives the human readable output of the commands. With -format json
+// or csv it is stderr, so stdout only carries the machine readable output.
+var out io.Writer = os.Stdout
+
+// cliResult collects the changes of the running command for -format json and
+// csv, it is nil with text output.
+var cliResult *Result
+
+// DeviceRecord is the machine readable form of a Device.
+type DeviceRecord struct {
+	Device             string    `json:"device"` // the name to pass to get and set
+	Name               string    `json:"name"`
+	FriendlyName       string    `json:"friendlyName,omitempty"`
+	DevObjName         string    `json:"devObjName,omitempty"`
+	InstanceID         string    `json:"instanceId,omitempty"`
+	HardwareIDs        []string  `json:"hardwareIds,omitempty"`
+	CompatibleIDs      []string  `json:"compatibleIds,omitempty"`
+	Class              string    `json:"class,omitempty"`
+	ClassGUID          string    `json:"classGuid,omitempty"`
+	Service            string    `json:"service,omitempty"`
+	Parent             string    `json:"parent,omitempty"`
+	ContainerID        string    `json:"containerId,omitempty"`
+	Location           string    `json:"location,omitempty"`
+	LocationPaths      []string  `json:"locationPaths,omitempty"`
+	PCI                string    `json:"pci,omitempty"`
+	NumaNode           *int      `json:"numaNode,omitempty"` // missing if unknown
+	RegPath            string    `json:"regPath,omitempty"`
+	InterruptTypes     []string  `json:"interruptTypes"`
+	MSISupported       *bool     `json:"msiSupported"` // null if the device has no MSI settings
+	MessageNumberLimit uint32    `json:"messageNumberLimit"`
+	MaxMSILimit        uint32    `json:"maxMsiLimit"`
+	DevicePolicy       uint32    `json:"devicePolicy"`
+	DevicePolicyName   string    `json:"devicePolicyName"`
+	DevicePriority     uint32    `json:"devicePriority"`
+	DevicePriorityName string    `json:"devicePriorityName"`
+	CPUs               []int     `json:"cpus"`
+	LastChange         time.Time `json:"lastChange"`
+	RebootRequired     bool      `json:"rebootRequired"`
+	State              string    `json:"state,omitempty"` // "disabled" or "not present", see -include-inactive
+}
+
+func newDeviceRecord(dev *Device) DeviceRecord {
+	r := DeviceRecord{
+		Device:             deviceID(dev),
+		Name:               dev.DeviceDesc,
+		FriendlyName:       dev.FriendlyName,
+		DevObjName:         dev.DevObjName,
+		InstanceID:         dev.InstanceID,
+		HardwareIDs:        dev.DeviceIDs,
+		CompatibleIDs:      dev.CompatibleIDs,
+		Class:              dev.Class,
+		ClassGUID:          dev.ClassGUID,
+		Service:            dev.Driver,
+		Parent:             dev.Parent,
+		ContainerID:        dev.ContainerID,
+		Location:           dev.LocationInformation,
+		LocationPaths:      dev.LocationPaths,
+		PCI:                dev.PCI.String(),
+		RegPath:            dev.RegPath,
+		InterruptTypes:     interruptTypes(dev.InterruptTypeMap),
+		MessageNumberLimit: dev.MessageNumberLimit,
+		MaxMSILimit:        dev.MaxMSILimit,
+		DevicePolicy:       dev.DevicePolicy,
+		DevicePolicyName:   policyName(dev.DevicePolicy),
+		DevicePriority:     dev.DevicePriority,
+		DevicePriorityName: priorityName(dev.DevicePriority),
+		CPUs:               dev.AssignmentSetOverride.Processors(),
+		LastChange:         dev.LastChange,
+		RebootRequired:     dev.RebootRequired,
+		State:              dev.State.String(),
+	}
+	if r.InterruptTypes == nil {
+		r.InterruptTypes = []string{}
+	}
+	if r.CPUs == nil {
+		r.CPUs = []int{}
+	}
+	if dev.MsiSupported <= 1 {
+		msi := dev.MsiSupported == 1
+		r.MSISupported = &msi
+	}
+	if dev.NumaNode >= 0 {
+		node := dev.NumaNode
+		r.NumaNode = &node
+	}
+	return r
+}
+
+var deviceCSVHeader = []string{
+	"device", "name", "friendlyName", "devObjName", "instanceId", "hardwareIds", "location", "pci", "regPath",
+	"interruptTypes", "msiSupported", "messageNumberLimit", "maxMsiLimit", "devicePolicy", "devicePolicyName",
+	"devicePriority", "devicePriorityName", "cpus", "lastChange", "rebootRequired", "state",
+	"compatibleIds", "locationPaths", "class", "classGuid", "service", "parent", "containerId", "numaNode",
+}
+
+// csvRow returns the fields in the order of deviceCSVHeader. Lists are
+// separated by ";", except cpus which uses the same "0,2,4" form as -cpus.
+func (r *DeviceRecord) csvRow() []string {
+	msi := ""
+	if r.MSISupported != nil {
+		msi = strconv.FormatBool(*r.MSISupported)
+	}
+	cpus := make([]string, len(r.CPUs))
+	for i, cpu := range r.CPUs {
+		cpus[i] = strconv.Itoa(cpu)
+	}
+	lastChange := ""
+	if !r.LastChange.IsZero() {
+		lastChange = r.LastChange.Format(time.RFC3339)
+	}
+	numaNode := ""
+	if r.NumaNode != nil {
+		numaNode = strconv.Itoa(*r.NumaNode)
+	}
+	return []string{
+		r.Device, r.Name, r.FriendlyName, r.DevObjName, r.InstanceID, strings.Join(r.HardwareIDs, ";"), r.Location, r.PCI, r.RegPath,
+		strings.Join(r.InterruptTypes, ";"), msi, fmt.Sprint(r.MessageNumberLimit), fmt.Sprint(r.MaxMSILimit), fmt.Sprint(r.DevicePolicy), r.DevicePolicyName,
+		fmt.Sprint(r.DevicePriority), r.DevicePriorityName, strings.Join(cpus, ","), lastChange, strconv.FormatBool(r.RebootRequired), r.State,
+		strings.Join(r.CompatibleIDs, ";"), strings.Join(r.LocationPaths, ";"), r.Class, r.ClassGUID, r.Service, r.Parent, r.ContainerID, numaNode,
+	}
+}
+
+// Change is one setting changed by a command.
+type Change struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+func changedFields(before, after *Device) []Change {
+	var changes []Change
+	for _, d := range driftFields(after, before) {
+		changes = append(changes, Change{Field: d.Field, Before: d.Actual, After: d.Expected})
+	}
+	return changes
+}
+
+// ResultDevice is a device touched by a command, with its settings afterwards.
+type ResultDevice struct {
+	DeviceRecord
+	Changes         []Change `json:"changes,omitempty"`
+	Restarted       bool     `json:"restarted"`
+	RestartRequired bool     `json:"restartRequired"`          // changed, but the device was not restarted
+	RestartSkipped  string   `json:"restartSkipped,omitempty"` // why an inactive device is not restarted
+}
+
+// Result is the report of one invocation that changes or restarts devices.
+type Result struct {
+	Command    string         `json:"command"`
+	Time       time.Time      `json:"time"`
+	Offline    string         `json:"offline,omitempty"`        // the hive file, if -offline was used
+	Fixture    string         `json:"machineFixture,omitempty"` // the fixture, if -machine-fixture was used
+	DryRun     bool           `json:"dryRun,omitempty"`
+	Devices    []ResultDevice `json:"devices"`
+	Operations []RegOp        `json:"operations"` // registry operations performed, or planned with -dry-run
+	ExitCode   int            `json:"exitCode"`
+
+	index map[*Device]int
+}
+
+func (r *Result) device(dev *Device) *ResultDevice {
+	i, ok := r.index[dev]
+	if !ok {
+		i = len(r.Devices)
+		r.index[dev] = i
+		r.Devices = append(r.Devices, ResultDevice{})
+	}
+	rd := &r.Devices[i]
+	rd.DeviceRecord = newDeviceRecord(dev)
+	return rd
+}
+
+// recordChange adds the settings changed from before to dev to the result.
+func recordChange(before, dev *Device) {
+	if cliResult == nil {
+		return
+	}
+	rd := cliResult.device(dev)
+	rd.Changes = append(rd.Changes, changedFields(before, dev)...)
+	rd.RestartRequired = canRestart()
+	if rd.RestartRequired {
+		rd.RestartSkipped = restartSkipped(dev)
+	}
+}
+
+// recordRestart adds the outcome of a device restart to the result.
+func recordRestart(dev *Device, restarted bool) {
+	if cliResult == nil {
+		return
+	}
+	rd := cliResult.device(dev)
+	rd.Restarted = restarted
+	if restarted || dev.RebootRequired {
+		rd.RestartRequired = false
+	}
+}
+
+// recordPlan adds the registry operations of plan to the result.
+func recordPlan(plan *Plan) {
+	if cliResult == nil {
+		return
+	}
+	cliResult.Operations = append(cliResult.Operations, plan.Ops...)
+}
+
+// addFormatFlag adds -format to the options of a command.
+func addFormatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", formatText, "Output format: text, json or csv")
+}
+
+// checkFormat validates -format. With json or csv the human readable output
+// goes to stderr and the changes are collected for writeResult.
+func checkFormat(format, command string) bool {
+	if !validFormat(format) {
+		return false
+	}
+	if format != formatText {
+		out = os.Stderr
+		cliResult = &Result{
+			Command:    command,
+			Time:       time.Now(),
+			Offline:    flagOffline,
+			Fixture:    flagMachineFixture,
+			DryRun:     flagDryRun,
+			Devices:    []ResultDevice{},
+			Operations: []RegOp{},
+			index:      map[*Device]int{},
+		}
+	}
+	return true
+}
+
+// validFormat reports whether format is text, json or csv and prints an error otherwise.
+func validFormat(format string) bool {
+	switch format {
+	case formatText, formatJSON, formatCSV:
+		return true
+	}
+	fmt.Fprintf(os.Stderr, "invalid -format %q, expected text, json or csv\n", format)
+	return false
+}
+
+// writeResult writes the collected result to stdout and returns code.
+func writeResult(format string, code int) int {
+	if cliResult == nil {
+		return code
+	}
+	cliResult.ExitCode = code
+
+	var err error
+	if format == formatJSON {
+		err = writeJSON(os.Stdout, cliResult)
+	} else {
+		w := csv.NewWriter(os.Stdout)
+		w.Write(append(deviceCSVHeader, "changes", "restarted", "restartRequired"))
+		for i := range cliResult.Devices {
+			rd := &cliResult.Devices[i]
+			changes := make([]string, len(rd.Changes))
+			for j, c := range rd.Changes {
+				changes[j] = fmt.Sprintf("%s: %v -> %v", c.Field, c.Before, c.After)
+			}
+			w.Write(append(rd.csvRow(), strings.Join(changes, "; "), strconv.FormatBool(rd.Restarted), strconv.FormatBool(rd.RestartRequired)))
+		}
+		w.Flush()
+		err = w.Error()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitError
+	}
+	return code
+}
+
+// writeDevices writes a device listing as JSON or CSV.
+func writeDevices(w io.Writer, format string, devices []*Device) error {
+	records := make([]DeviceRecord, len(devices))
+	for i, dev := range devices {
+		records[i] = newDeviceRecord(dev)
+	}
+	if format == formatJSON {
+		return writeJSON(w, records)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(deviceCSVHeader)
+	for i := range records {
+		cw.Write(records[i].csvRow())
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
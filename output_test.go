@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var outputTestTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// outputTestDevices returns a GPU with every field set and a disabled device
+// without MSI settings or NUMA node.
+func outputTestDevices(t *testing.T) []*Device {
+	t.Helper()
+	gpu := &Device{
+		DeviceDesc:            "NVIDIA GeForce RTX 4090",
+		DeviceIDs:             []string{`PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1`, `PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE`},
+		CompatibleIDs:         []string{`PCI\VEN_10DE&DEV_2684&REV_A1`, `PCI\CC_030000`},
+		InstanceID:            `PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1\4&2B8F4B3C&0&0008`,
+		LocationPaths:         []string{`PCIROOT(0)#PCI(0100)#PCI(0000)`, `ACPI(_SB_)#ACPI(PCI0)#ACPI(PEG0)#ACPI(PEGP)`},
+		PCI:                   PCILocation{Valid: true, Bus: 1, Device: 0, Function: 0},
+		DevObjName:            `\Device\NTPNP_PCI0015`,
+		Class:                 "Display",
+		ClassGUID:             "{4d36e968-e325-11ce-bfc1-08002be10318}",
+		Driver:                "nvlddmkm",
+		Parent:                `PCI\VEN_8086&DEV_A70D&SUBSYS_7D251462&REV_01\3&11583659&0&08`,
+		ContainerID:           "{00000000-0000-0000-ffff-ffffffffffff}",
+		NumaNode:              0,
+		LocationInformation:   "PCI bus 1, device 0, function 0",
+		FriendlyName:          "RTX 4090",
+		RegPath:               testRegPath,
+		LastChange:            outputTestTime,
+		DevicePolicy:          IrqPolicySpecifiedProcessors,
+		DevicePriority:        3,
+		AssignmentSetOverride: NewCPUMask(2, 3),
+		MsiSupported:          1,
+		MessageNumberLimit:    4,
+		MaxMSILimit:           9,
+		InterruptTypeMap:      7,
+		store: newTestStore(t, storeValues{
+			interruptManagementKey: {},
+			affinityPolicyKey:      {"DevicePolicy": uint32(IrqPolicySpecifiedProcessors), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x0c}},
+			msiPropertiesKey:       {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)},
+		}),
+	}
+	legacy := &Device{
+		DeviceDesc:       "High Definition Audio Controller",
+		InstanceID:       `PCI\VEN_8086&DEV_7A84&SUBSYS_7D251462&REV_11\3&11583659&0&F8`,
+		NumaNode:         -1,
+		RegPath:          importLegacyPath,
+		RebootRequired:   true,
+		State:            DeviceDisabled,
+		MsiSupported:     2,
+		InterruptTypeMap: 1,
+		store:            newTestStore(t, storeValues{interruptManagementKey: {}}),
+	}
+	return []*Device{gpu, legacy}
+}
+
+// captureStdout returns what f writes to os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	file, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	saved := os.Stdout
+	os.Stdout = file
+	f()
+	os.Stdout = saved
+	return string(mustReadFile(t, file.Name()))
+}
+
+func TestWriteDevices(t *testing.T) {
+	devices := outputTestDevices(t)
+	if *update {
+		if err := os.MkdirAll(filepath.Join("testdata", "output"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for format, file := range map[string]string{formatJSON: "devices.json", formatCSV: "devices.csv"} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := writeDevices(&buf, format, devices); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, filepath.Join("testdata", "output", file), buf.Bytes())
+		})
+	}
+
+	// the header names every column of a row
+	var buf bytes.Buffer
+	if err := writeDevices(&buf, formatCSV, devices); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(records[0], deviceCSVHeader) {
+		t.Errorf("header %q, want %q", records[0], deviceCSVHeader)
+	}
+	for _, record := range records[1:] {
+		if len(record) != len(deviceCSVHeader) {
+			t.Errorf("%d columns, want %d: %q", len(record), len(deviceCSVHeader), record)
+		}
+	}
+
+	// an empty listing is an empty array, not null
+	buf.Reset()
+	if err := writeDevices(&buf, formatJSON, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "[]\n" {
+		t.Errorf("empty listing %q", got)
+	}
+}
+
+func TestWriteResult(t *testing.T) {
+	savedResult, savedSource := cliResult, source
+	t.Cleanup(func() { cliResult, source = savedResult, savedSource })
+	source = nil // devices can be restarted
+
+	for format, file := range map[string]string{formatJSON: "result.json", formatCSV: "result.csv"} {
+		t.Run(format, func(t *testing.T) {
+			devices := outputTestDevices(t)
+			gpu, legacy := devices[0], devices[1]
+			cliResult = &Result{
+				Command:    "set",
+				Time:       outputTestTime,
+				Devices:    []ResultDevice{},
+				Operations: []RegOp{},
+				index:      map[*Device]int{},
+			}
+
+			before := *gpu
+			gpu.DevicePolicy, gpu.DevicePriority, gpu.AssignmentSetOverride = IrqPolicySpreadMessagesAcrossAllProcessors, 2, nil
+			var plan Plan
+			if err := plan.Add(&before, gpu); err != nil {
+				t.Fatal(err)
+			}
+			recordPlan(&plan)
+			recordChange(&before, gpu)
+			recordRestart(gpu, true)
+
+			before = *legacy
+			legacy.DevicePriority = 1
+			recordChange(&before, legacy)
+
+			var code int
+			stdout := captureStdout(t, func() { code = writeResult(format, exitOK) })
+			if code != exitOK {
+				t.Errorf("exit code %d", code)
+			}
+			checkGolden(t, filepath.Join("testdata", "output", file), []byte(stdout))
+		})
+	}
+
+	cliResult = nil
+	if stdout := captureStdout(t, func() { writeResult(formatJSON, exitOK) }); stdout != "" {
+		t.Errorf("result with text output: %q", stdout)
+	}
+}
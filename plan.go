@@ -0,0 +1,237 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Operations of a RegOp.
+const (
+	RegOpCreateKey   = "createKey"
+	RegOpDeleteKey   = "deleteKey"
+	RegOpSetValue    = "setValue"
+	RegOpDeleteValue = "deleteValue"
+)
+
+// RegOp is one registry operation on the key of a device. Old and New are
+// the data before and after, formatted for display; Old of a deleted key
+// lists the values that are deleted with it.
+type RegOp struct {
+	Device *Device `json:"-"`
+	Op     string  `json:"op"`
+	Key    string  `json:"key"` // full path, for display
+	Name   string  `json:"name,omitempty"`
+	Type   string  `json:"type,omitempty"` // REG_DWORD or REG_BINARY
+	Old    string  `json:"old,omitempty"`
+	New    string  `json:"new,omitempty"`
+
+	path   string // relative to the device key, e.g. affinityPolicyKey
+	dword  uint32
+	binary []byte
+}
+
+func (op *RegOp) String() string {
+	switch op.Op {
+	case RegOpCreateKey:
+		return fmt.Sprintf("create key   %s", op.Key)
+	case RegOpDeleteKey:
+		if op.Old == "" {
+			return fmt.Sprintf("delete key   %s", op.Key)
+		}
+		return fmt.Sprintf("delete key   %s (%s)", op.Key, op.Old)
+	case RegOpSetValue:
+		old := op.Old
+		if old == "" {
+			old = "(not set)"
+		}
+		return fmt.Sprintf("set value    %s\\%s %s: %s -> %s", op.Key, op.Name, op.Type, old, op.New)
+	case RegOpDeleteValue:
+		return fmt.Sprintf("delete value %s\\%s (%s)", op.Key, op.Name, op.Old)
+	}
+	return op.Op
+}
+
+// apply performs the operation through the store of the device.
+func (op *RegOp) apply() error {
+	store := op.Device.store
+	switch op.Op {
+	case RegOpCreateKey:
+		return store.CreateKey(op.path)
+	case RegOpDeleteKey:
+		return ignoreNotExist(store.DeleteKey(op.path))
+	case RegOpSetValue:
+		if op.Type == "REG_DWORD" {
+			return store.SetDWordValue(op.path, op.Name, op.dword)
+		}
+		return store.SetBinaryValue(op.path, op.Name, op.binary)
+	case RegOpDeleteValue:
+		return ignoreNotExist(store.DeleteValue(op.path, op.Name))
+	}
+	return fmt.Errorf("unknown registry operation %q", op.Op)
+}
+
+// Plan is the list of registry operations of a change. The same plan is
+// printed by -dry-run and performed by Apply.
+type Plan struct {
+	Ops []RegOp
+}
+
+// Add appends the operations that write the settings of item that differ from org.
+func (p *Plan) Add(org, item *Device) error {
+	if item.store == nil {
+		return fmt.Errorf("%s: the device has no registry key", deviceTitle(item))
+	}
+	if msiChanged(org, item) {
+		p.planMSIMode(item)
+	}
+	if affinityChanged(org, item) {
+		p.planAffinityPolicy(item)
+	}
+	return nil
+}
+
+func (p *Plan) Empty() bool {
+	return len(p.Ops) == 0
+}
+
+// Apply performs the operations in order and stops at the first error.
+func (p *Plan) Apply() error {
+	for i := range p.Ops {
+		op := &p.Ops[i]
+		if err := op.apply(); err != nil {
+			return fmt.Errorf("%s: %s: %w", deviceTitle(op.Device), op, err)
+		}
+	}
+	return nil
+}
+
+// Lines returns the operations for printing, one per line.
+func (p *Plan) Lines() []string {
+	lines := make([]string, len(p.Ops))
+	for i := range p.Ops {
+		lines[i] = p.Ops[i].String()
+	}
+	return lines
+}
+
+func (p *Plan) planMSIMode(item *Device) {
+	if item.MsiSupported != 1 {
+		p.deleteKey(item, msiPropertiesKey, "MSISupported", "MessageNumberLimit")
+		return
+	}
+
+	p.createKey(item, msiPropertiesKey)
+	p.setDWord(item, msiPropertiesKey, "MSISupported", 1)
+	if item.MessageNumberLimit == 0 {
+		p.deleteValue(item, msiPropertiesKey, "MessageNumberLimit")
+		return
+	}
+	p.setDWord(item, msiPropertiesKey, "MessageNumberLimit", item.MessageNumberLimit)
+}
+
+func (p *Plan) planAffinityPolicy(item *Device) {
+	if item.DevicePolicy == 0 && item.DevicePriority == 0 {
+		p.deleteKey(item, affinityPolicyKey, "DevicePolicy", "DevicePriority", "AssignmentSetOverride")
+		return
+	}
+
+	p.createKey(item, affinityPolicyKey)
+	p.setDWord(item, affinityPolicyKey, "DevicePolicy", item.DevicePolicy)
+	if item.DevicePriority == 0 {
+		p.deleteValue(item, affinityPolicyKey, "DevicePriority")
+	} else {
+		p.setDWord(item, affinityPolicyKey, "DevicePriority", item.DevicePriority)
+	}
+
+	if item.DevicePolicy != IrqPolicySpecifiedProcessors || item.AssignmentSetOverride.IsZero() {
+		p.deleteValue(item, affinityPolicyKey, "AssignmentSetOverride")
+		return
+	}
+	AssignmentSetOverrideByte := item.AssignmentSetOverride.Bytes()
+	p.setBinary(item, affinityPolicyKey, "AssignmentSetOverride", AssignmentSetOverrideByte[:clen(AssignmentSetOverrideByte)])
+}
+
+func regOpKey(item *Device, path string) string {
+	if item.RegPath == "" {
+		return path
+	}
+	return item.RegPath + `\` + path
+}
+
+// The helpers below leave out operations that would not change anything:
+// creating an existing key, deleting a missing one or setting a value to
+// the data it already has.
+
+func (p *Plan) createKey(item *Device, path string) {
+	if exists, err := item.store.KeyExists(path); err == nil && exists {
+		return
+	}
+	p.Ops = append(p.Ops, RegOp{Device: item, Op: RegOpCreateKey, Key: regOpKey(item, path), path: path})
+}
+
+// deleteKey deletes path, names are the values shown as deleted with it.
+func (p *Plan) deleteKey(item *Device, path string, names ...string) {
+	if exists, err := item.store.KeyExists(path); err == nil && !exists {
+		return
+	}
+	var old []string
+	for _, name := range names {
+		if data, ok := storeValueString(item.store, path, name); ok {
+			old = append(old, name+"="+data)
+		}
+	}
+	p.Ops = append(p.Ops, RegOp{Device: item, Op: RegOpDeleteKey, Key: regOpKey(item, path), Old: strings.Join(old, ", "), path: path})
+}
+
+func (p *Plan) deleteValue(item *Device, path, name string) {
+	old, ok := storeValueString(item.store, path, name)
+	if !ok {
+		return
+	}
+	p.Ops = append(p.Ops, RegOp{Device: item, Op: RegOpDeleteValue, Key: regOpKey(item, path), Name: name, Old: old, path: path})
+}
+
+func (p *Plan) setDWord(item *Device, path, name string, value uint32) {
+	if current, err := item.store.GetDWordValue(path, name); err == nil && current == value {
+		return
+	}
+	old, _ := storeValueString(item.store, path, name)
+	p.Ops = append(p.Ops, RegOp{Device: item, Op: RegOpSetValue, Key: regOpKey(item, path), Name: name, Type: "REG_DWORD", Old: old, New: formatDWord(value), path: path, dword: value})
+}
+
+func (p *Plan) setBinary(item *Device, path, name string, value []byte) {
+	if current, err := item.store.GetBinaryValue(path, name); err == nil && string(current) == string(value) {
+		return
+	}
+	old, _ := storeValueString(item.store, path, name)
+	p.Ops = append(p.Ops, RegOp{Device: item, Op: RegOpSetValue, Key: regOpKey(item, path), Name: name, Type: "REG_BINARY", Old: old, New: formatBinary(value), path: path, binary: value})
+}
+
+// storeValueString reads a REG_BINARY or REG_DWORD value for display. Binary
+// comes first, the registry store reads any value of 4 bytes as a DWORD.
+func storeValueString(store PolicyStore, path, name string) (string, bool) {
+	if value, err := store.GetBinaryValue(path, name); err == nil {
+		return formatBinary(value), true
+	}
+	if value, err := store.GetDWordValue(path, name); err == nil {
+		return formatDWord(value), true
+	}
+	return "", false
+}
+
+func formatDWord(value uint32) string {
+	return fmt.Sprintf("0x%08x (%d)", value, value)
+}
+
+func formatBinary(value []byte) string {
+	var b strings.Builder
+	b.WriteString("hex:")
+	for i, c := range value {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(hex.EncodeToString([]byte{c}))
+	}
+	return b.String()
+}
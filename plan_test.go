@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// storeValues is the content of a memoryStore for the tests: keys that
+// exist, mapped to their values, uint32 for REG_DWORD and []byte for REG_BINARY.
+type storeValues map[string]map[string]any
+
+func newTestStore(t *testing.T, values storeValues) *memoryStore {
+	t.Helper()
+	store := newMemoryStore()
+	for path, names := range values {
+		if err := store.CreateKey(path); err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range names {
+			var err error
+			switch value := value.(type) {
+			case uint32:
+				err = store.SetDWordValue(path, name, value)
+			case []byte:
+				err = store.SetBinaryValue(path, name, value)
+			default:
+				t.Fatalf("%s\\%s: unsupported value %T", path, name, value)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return store
+}
+
+// checkStore compares the keys and values of store with want.
+func checkStore(t *testing.T, store *memoryStore, want storeValues) {
+	t.Helper()
+	for _, path := range []string{interruptManagementKey, affinityPolicyKey, msiPropertiesKey} {
+		exists, _ := store.KeyExists(path)
+		names, wantExists := want[path]
+		if exists != wantExists {
+			t.Errorf("key %s exists: %v, want %v", path, exists, wantExists)
+			continue
+		}
+		for _, name := range []string{"MSISupported", "MessageNumberLimit", "DevicePolicy", "DevicePriority", "AssignmentSetOverride"} {
+			wantValue := names[name]
+			dword, dwordErr := store.GetDWordValue(path, name)
+			binary, binaryErr := store.GetBinaryValue(path, name)
+			switch wantValue := wantValue.(type) {
+			case nil:
+				if dwordErr == nil || binaryErr == nil {
+					t.Errorf("%s\\%s exists, want it deleted", path, name)
+				}
+			case uint32:
+				if dwordErr != nil || dword != wantValue {
+					t.Errorf("%s\\%s = %d (%v), want %d", path, name, dword, dwordErr, wantValue)
+				}
+			case []byte:
+				if binaryErr != nil || !bytes.Equal(binary, wantValue) {
+					t.Errorf("%s\\%s = %x (%v), want %x", path, name, binary, binaryErr, wantValue)
+				}
+			}
+		}
+	}
+}
+
+// opNames returns the operations of p in a short form, e.g. "setValue MSISupported".
+func opNames(p *Plan) []string {
+	var ops []string
+	for _, op := range p.Ops {
+		if op.Name == "" {
+			ops = append(ops, op.Op+" "+op.path)
+		} else {
+			ops = append(ops, op.Op+" "+op.Name)
+		}
+	}
+	return ops
+}
+
+func TestPlanMSIMode(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   storeValues
+		msi     uint32
+		limit   uint32
+		wantOps []string
+		want    storeValues
+	}{
+		{
+			name:  "enable creates the key",
+			store: storeValues{interruptManagementKey: {}},
+			msi:   1,
+			wantOps: []string{
+				"createKey " + msiPropertiesKey,
+				"setValue MSISupported",
+			},
+			want: storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1)}},
+		},
+		{
+			name:  "enable with a limit",
+			store: storeValues{interruptManagementKey: {}, msiPropertiesKey: {}},
+			msi:   1,
+			limit: 8,
+			wantOps: []string{
+				"setValue MSISupported",
+				"setValue MessageNumberLimit",
+			},
+			want: storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1), "MessageNumberLimit": uint32(8)}},
+		},
+		{
+			name:    "a limit of 0 deletes the value",
+			store:   storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1), "MessageNumberLimit": uint32(8)}},
+			msi:     1,
+			wantOps: []string{"deleteValue MessageNumberLimit"},
+			want:    storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1)}},
+		},
+		{
+			name:    "disable deletes the key",
+			store:   storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1), "MessageNumberLimit": uint32(8)}},
+			msi:     0,
+			wantOps: []string{"deleteKey " + msiPropertiesKey},
+			want:    storeValues{interruptManagementKey: {}},
+		},
+		{
+			name:    "disable without a key is a no-op",
+			store:   storeValues{interruptManagementKey: {}},
+			msi:     0,
+			wantOps: nil,
+			want:    storeValues{interruptManagementKey: {}},
+		},
+		{
+			name:    "unchanged values are a no-op",
+			store:   storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)}},
+			msi:     1,
+			limit:   4,
+			wantOps: nil,
+			want:    storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1), "MessageNumberLimit": uint32(4)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, tt.store)
+			dev := &Device{store: store, MsiSupported: tt.msi, MessageNumberLimit: tt.limit}
+			var p Plan
+			p.planMSIMode(dev)
+			if got := opNames(&p); !reflect.DeepEqual(got, tt.wantOps) {
+				t.Errorf("operations %q, want %q", got, tt.wantOps)
+			}
+			if err := p.Apply(); err != nil {
+				t.Fatal(err)
+			}
+			checkStore(t, store, tt.want)
+
+			var again Plan
+			again.planMSIMode(dev)
+			if !again.Empty() {
+				t.Errorf("second plan %q, want no operations", opNames(&again))
+			}
+		})
+	}
+}
+
+func TestPlanAffinityPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    storeValues
+		policy   uint32
+		priority uint32
+		mask     CPUMask
+		wantOps  []string
+		want     storeValues
+	}{
+		{
+			name:     "priority creates the key",
+			store:    storeValues{interruptManagementKey: {}},
+			priority: 3,
+			wantOps: []string{
+				"createKey " + affinityPolicyKey,
+				"setValue DevicePolicy",
+				"setValue DevicePriority",
+			},
+			want: storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(0), "DevicePriority": uint32(3)}},
+		},
+		{
+			name:   "specified processors write the mask",
+			store:  storeValues{interruptManagementKey: {}},
+			policy: IrqPolicySpecifiedProcessors,
+			mask:   NewCPUMask(1, 3),
+			wantOps: []string{
+				"createKey " + affinityPolicyKey,
+				"setValue DevicePolicy",
+				"setValue AssignmentSetOverride",
+			},
+			want: storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(4), "AssignmentSetOverride": []byte{0x0a}}},
+		},
+		{
+			name:   "a mask beyond 64 processors is written in full",
+			store:  storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(4), "AssignmentSetOverride": []byte{0x01}}},
+			policy: IrqPolicySpecifiedProcessors,
+			mask:   NewCPUMask(0, 64),
+			wantOps: []string{
+				"setValue AssignmentSetOverride",
+			},
+			want: storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(4), "AssignmentSetOverride": []byte{1, 0, 0, 0, 0, 0, 0, 0, 1}}},
+		},
+		{
+			name:   "another policy deletes the mask and the priority",
+			store:  storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(4), "DevicePriority": uint32(2), "AssignmentSetOverride": []byte{0x0f}}},
+			policy: IrqPolicyAllCloseProcessors,
+			wantOps: []string{
+				"setValue DevicePolicy",
+				"deleteValue DevicePriority",
+				"deleteValue AssignmentSetOverride",
+			},
+			want: storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(1)}},
+		},
+		{
+			name:    "defaults delete the key",
+			store:   storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(4), "AssignmentSetOverride": []byte{0x0f}}},
+			wantOps: []string{"deleteKey " + affinityPolicyKey},
+			want:    storeValues{interruptManagementKey: {}},
+		},
+		{
+			name:    "defaults without a key are a no-op",
+			store:   storeValues{interruptManagementKey: {}},
+			wantOps: nil,
+			want:    storeValues{interruptManagementKey: {}},
+		},
+		{
+			name:     "unchanged values are a no-op",
+			store:    storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(4), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x30}}},
+			policy:   IrqPolicySpecifiedProcessors,
+			priority: 3,
+			mask:     NewCPUMask(4, 5),
+			wantOps:  nil,
+			want:     storeValues{interruptManagementKey: {}, affinityPolicyKey: {"DevicePolicy": uint32(4), "DevicePriority": uint32(3), "AssignmentSetOverride": []byte{0x30}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t, tt.store)
+			dev := &Device{store: store, DevicePolicy: tt.policy, DevicePriority: tt.priority, AssignmentSetOverride: tt.mask}
+			var p Plan
+			p.planAffinityPolicy(dev)
+			if got := opNames(&p); !reflect.DeepEqual(got, tt.wantOps) {
+				t.Errorf("operations %q, want %q", got, tt.wantOps)
+			}
+			if err := p.Apply(); err != nil {
+				t.Fatal(err)
+			}
+			checkStore(t, store, tt.want)
+
+			var again Plan
+			again.planAffinityPolicy(dev)
+			if !again.Empty() {
+				t.Errorf("second plan %q, want no operations", opNames(&again))
+			}
+		})
+	}
+}
+
+func TestPlanAddUnchanged(t *testing.T) {
+	store := newTestStore(t, storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1)}})
+	dev := &Device{store: store, MsiSupported: 1}
+	org := *dev
+	var p Plan
+	if err := p.Add(&org, dev); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Empty() {
+		t.Errorf("operations %q for an unchanged device, want none", opNames(&p))
+	}
+
+	if err := p.Add(&org, &Device{}); err == nil {
+		t.Error("Add of a device without a store succeeded")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := newMemoryStore()
+	if _, err := store.GetDWordValue(affinityPolicyKey, "DevicePolicy"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("value of a missing key: %v, want ErrNotExist", err)
+	}
+	if err := store.SetDWordValue(affinityPolicyKey, "DevicePolicy", 1); !errors.Is(err, ErrNotExist) {
+		t.Errorf("set in a missing key: %v, want ErrNotExist", err)
+	}
+	if err := store.CreateKey(affinityPolicyKey); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := store.KeyExists(interruptManagementKey); !exists {
+		t.Error("CreateKey did not create the parent key")
+	}
+	if exists, _ := store.KeyExists(`INTERRUPT MANAGEMENT\affinity policy\`); !exists {
+		t.Error("key paths are case-sensitive")
+	}
+	if err := store.DeleteKey(interruptManagementKey); err == nil {
+		t.Error("deleted a key with subkeys")
+	}
+
+	if err := store.SetBinaryValue(affinityPolicyKey, "AssignmentSetOverride", []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetDWordValue(affinityPolicyKey, "AssignmentSetOverride"); err == nil {
+		t.Error("a REG_BINARY value was read as a DWORD")
+	}
+	if err := store.DeleteValue(affinityPolicyKey, "assignmentsetoverride"); err != nil {
+		t.Errorf("value names are case-sensitive: %v", err)
+	}
+	if err := store.DeleteValue(affinityPolicyKey, "AssignmentSetOverride"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("delete of a missing value: %v, want ErrNotExist", err)
+	}
+	if err := store.DeleteKey(affinityPolicyKey); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := store.KeyExists(affinityPolicyKey); exists {
+		t.Error("the key still exists after DeleteKey")
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Subkeys below the device key (DIREG_DEV) that hold the interrupt settings.
+const (
+	interruptManagementKey = `Interrupt Management`
+	affinityPolicyKey      = `Interrupt Management\Affinity Policy`
+	msiPropertiesKey       = `Interrupt Management\MessageSignaledInterruptProperties`
+)
+
+// ErrNotExist is returned by a PolicyStore when a key or value is missing.
+var ErrNotExist = errors.New("key or value does not exist")
+
+// PolicyStore reads and writes the values below a device key.
+// All paths are relative to the device key, e.g. affinityPolicyKey.
+type PolicyStore interface {
+	KeyExists(path string) (bool, error)
+	CreateKey(path string) error
+	DeleteKey(path string) error
+	GetDWordValue(path, name string) (uint32, error)
+	SetDWordValue(path, name string, value uint32) error
+	GetBinaryValue(path, name string) ([]byte, error)
+	SetBinaryValue(path, name string, value []byte) error
+	DeleteValue(path, name string) error
+}
+
+// readAffinityPolicy fills DevicePolicy, DevicePriority and AssignmentSetOverride from the store.
+func readAffinityPolicy(store PolicyStore, dev *Device) {
+	dev.DevicePolicy, _ = store.GetDWordValue(affinityPolicyKey, "DevicePolicy")                     // REG_DWORD
+	dev.DevicePriority, _ = store.GetDWordValue(affinityPolicyKey, "DevicePriority")                 // REG_DWORD
+	AssignmentSetOverrideByte, _ := store.GetBinaryValue(affinityPolicyKey, "AssignmentSetOverride") // REG_BINARY
+
+	dev.AssignmentSetOverride = CPUMaskFromBytes(AssignmentSetOverrideByte)
+}
+
+// readMSIProperties fills MsiSupported and MessageNumberLimit from the store.
+func readMSIProperties(store PolicyStore, dev *Device) {
+	if dev.InterruptTypeMap == ZeroBit {
+		dev.MsiSupported = 2 // invalid
+		return
+	}
+	dev.MessageNumberLimit, _ = store.GetDWordValue(msiPropertiesKey, "MessageNumberLimit") // REG_DWORD https://docs.microsoft.com/de-de/windows-hardware/drivers/kernel/enabling-message-signaled-interrupts-in-the-registry
+	dev.MsiSupported, _ = store.GetDWordValue(msiPropertiesKey, "MSISupported")             // REG_DWORD
+}
+
+// readSettings reads the settings of dev from its store again, e.g. after
+// a plan could not be applied completely.
+func readSettings(dev *Device) {
+	if dev.store == nil {
+		return
+	}
+	readAffinityPolicy(dev.store, dev)
+	readMSIProperties(dev.store, dev)
+}
+
+func ignoreNotExist(err error) error {
+	if errors.Is(err, ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// memoryStore is a PolicyStore that keeps everything in memory.
+// Key paths are case-insensitive like in the registry.
+type memoryStore struct {
+	keys map[string]map[string]memoryValue
+}
+
+type memoryValue struct {
+	dword  uint32
+	binary []byte
+	isWord bool
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{keys: map[string]map[string]memoryValue{}}
+}
+
+func memoryKeyName(path string) string {
+	return strings.ToLower(strings.Trim(path, `\`))
+}
+
+func (s *memoryStore) KeyExists(path string) (bool, error) {
+	_, ok := s.keys[memoryKeyName(path)]
+	return ok, nil
+}
+
+func (s *memoryStore) CreateKey(path string) error {
+	parts := strings.Split(memoryKeyName(path), `\`)
+	for i := range parts {
+		name := strings.Join(parts[:i+1], `\`)
+		if _, ok := s.keys[name]; !ok {
+			s.keys[name] = map[string]memoryValue{}
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteKey(path string) error {
+	name := memoryKeyName(path)
+	if _, ok := s.keys[name]; !ok {
+		return ErrNotExist
+	}
+	for key := range s.keys {
+		if strings.HasPrefix(key, name+`\`) {
+			return fmt.Errorf("%s: key has subkeys", path)
+		}
+	}
+	delete(s.keys, name)
+	return nil
+}
+
+func (s *memoryStore) value(path, name string) (memoryValue, error) {
+	values, ok := s.keys[memoryKeyName(path)]
+	if !ok {
+		return memoryValue{}, ErrNotExist
+	}
+	value, ok := values[strings.ToLower(name)]
+	if !ok {
+		return memoryValue{}, ErrNotExist
+	}
+	return value, nil
+}
+
+func (s *memoryStore) setValue(path, name string, value memoryValue) error {
+	values, ok := s.keys[memoryKeyName(path)]
+	if !ok {
+		return ErrNotExist
+	}
+	values[strings.ToLower(name)] = value
+	return nil
+}
+
+func (s *memoryStore) GetDWordValue(path, name string) (uint32, error) {
+	value, err := s.value(path, name)
+	if err != nil {
+		return 0, err
+	}
+	if !value.isWord {
+		return 0, fmt.Errorf("%s\\%s: not a REG_DWORD", path, name)
+	}
+	return value.dword, nil
+}
+
+func (s *memoryStore) SetDWordValue(path, name string, value uint32) error {
+	return s.setValue(path, name, memoryValue{dword: value, isWord: true})
+}
+
+func (s *memoryStore) GetBinaryValue(path, name string) ([]byte, error) {
+	value, err := s.value(path, name)
+	if err != nil {
+		return nil, err
+	}
+	if value.isWord {
+		return nil, fmt.Errorf("%s\\%s: not a REG_BINARY", path, name)
+	}
+	return append([]byte(nil), value.binary...), nil
+}
+
+func (s *memoryStore) SetBinaryValue(path, name string, value []byte) error {
+	return s.setValue(path, name, memoryValue{binary: append([]byte(nil), value...)})
+}
+
+func (s *memoryStore) DeleteValue(path, name string) error {
+	values, ok := s.keys[memoryKeyName(path)]
+	if !ok {
+		return ErrNotExist
+	}
+	if _, ok := values[strings.ToLower(name)]; !ok {
+		return ErrNotExist
+	}
+	delete(values, strings.ToLower(name))
+	return nil
+}
+
+func msiChanged(a, b *Device) bool {
+	return a.MsiSupported != b.MsiSupported || a.MessageNumberLimit != b.MessageNumberLimit
+}
+
+func affinityChanged(a, b *Device) bool {
+	return a.DevicePolicy != b.DevicePolicy || a.DevicePriority != b.DevicePriority || !a.AssignmentSetOverride.Equal(b.AssignmentSetOverride)
+}
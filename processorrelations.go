@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// LOGICAL_PROCESSOR_RELATIONSHIP
+// https://learn.microsoft.com/en-us/windows/win32/api/winnt/ns-winnt-system_logical_processor_information_ex
+const (
+	RelationProcessorCore    = 0
+	RelationNumaNode         = 1
+	RelationCache            = 2
+	RelationProcessorPackage = 3
+	RelationGroup            = 4
+	RelationProcessorDie     = 5
+	RelationNumaNodeEx       = 6
+	RelationProcessorModule  = 7
+	RelationAll              = 0xffff
+
+	LTP_PC_SMT = 0x1 // PROCESSOR_RELATIONSHIP.Flags
+)
+
+// CacheType is PROCESSOR_CACHE_TYPE.
+type CacheType uint32
+
+const (
+	CacheUnified CacheType = iota
+	CacheInstruction
+	CacheData
+	CacheTrace
+)
+
+func (t CacheType) String() string {
+	switch t {
+	case CacheUnified:
+		return "Unified"
+	case CacheInstruction:
+		return "Instruction"
+	case CacheData:
+		return "Data"
+	case CacheTrace:
+		return "Trace"
+	}
+	return fmt.Sprintf("CacheType(%d)", uint32(t))
+}
+
+// ProcessorRelations is the topology reported by GetLogicalProcessorInformationEx.
+// Processors are numbered like in a CPUMask, group*64 + bit.
+type ProcessorRelations struct {
+	Cores     []CoreRelation     `json:"cores"`
+	Caches    []CacheRelation    `json:"caches"`
+	Packages  []CPUMask          `json:"packages"`
+	Dies      []CPUMask          `json:"dies,omitempty"`
+	Modules   []CPUMask          `json:"modules,omitempty"` // e.g. the E-core clusters of Intel hybrids, Windows 11 and later
+	NumaNodes []NumaNodeRelation `json:"numaNodes"`
+	Groups    []GroupRelation    `json:"groups"`
+}
+
+type CoreRelation struct {
+	SMT             bool    `json:"smt"`
+	EfficiencyClass byte    `json:"efficiencyClass"`
+	Processors      CPUMask `json:"processors"`
+}
+
+type CacheRelation struct {
+	Level         byte      `json:"level"`
+	Type          CacheType `json:"type"`
+	Size          uint32    `json:"size"` // bytes
+	LineSize      uint16    `json:"lineSize"`
+	Associativity byte      `json:"associativity"` // 0xff is fully associative
+	Processors    CPUMask   `json:"processors"`
+}
+
+type NumaNodeRelation struct {
+	Node       uint32  `json:"node"`
+	Processors CPUMask `json:"processors"`
+}
+
+type GroupRelation struct {
+	MaximumProcessors byte    `json:"maximumProcessors"`
+	ActiveProcessors  byte    `json:"activeProcessors"`
+	Processors        CPUMask `json:"processors"`
+}
+
+// parseProcessorRelations decodes a GetLogicalProcessorInformationEx buffer of
+// RelationAll. Records are walked by their Size field, unknown
+// relationships are skipped.
+func parseProcessorRelations(data []byte) (*ProcessorRelations, error) {
+	r := &ProcessorRelations{}
+	numa := map[uint32]int{} // RelationNumaNode and RelationNumaNodeEx may both report a node
+
+	for offset := 0; offset < len(data); {
+		rest := data[offset:]
+		if len(rest) < 8 {
+			return nil, fmt.Errorf("logical processor information: %d trailing bytes at offset %d", len(rest), offset)
+		}
+		relationship := binary.LittleEndian.Uint32(rest[0:])
+		size := binary.LittleEndian.Uint32(rest[4:])
+		if size < 8 || uint64(size) > uint64(len(rest)) {
+			return nil, fmt.Errorf("logical processor information: invalid record size %d at offset %d, %d bytes left", size, offset, len(rest))
+		}
+		record := rest[:size]
+		at := offset
+		offset += int(size)
+
+		// the fixed part of every relationship ends with GroupCount, then follow the GROUP_AFFINITY masks
+		masks := func(countOffset, masksOffset int, countZeroIsOne bool) (CPUMask, error) {
+			if len(record) < countOffset+2 {
+				return nil, fmt.Errorf("logical processor information: relationship %d at offset %d has only %d bytes", relationship, at, len(record))
+			}
+			count := int(binary.LittleEndian.Uint16(record[countOffset:]))
+			if count == 0 && countZeroIsOne { // before Windows 11 and Windows Server 2022
+				count = 1
+			}
+			return parseGroupAffinities(record, masksOffset, count, at)
+		}
+
+		switch relationship {
+		case RelationProcessorCore, RelationProcessorPackage, RelationProcessorDie, RelationProcessorModule:
+			processors, err := masks(30, 32, false)
+			if err != nil {
+				return nil, err
+			}
+			switch relationship {
+			case RelationProcessorCore:
+				r.Cores = append(r.Cores, CoreRelation{
+					SMT:             record[8]&LTP_PC_SMT != 0,
+					EfficiencyClass: record[9],
+					Processors:      processors,
+				})
+			case RelationProcessorPackage:
+				r.Packages = append(r.Packages, processors)
+			case RelationProcessorDie:
+				r.Dies = append(r.Dies, processors)
+			case RelationProcessorModule:
+				r.Modules = append(r.Modules, processors)
+			}
+
+		case RelationNumaNode, RelationNumaNodeEx:
+			processors, err := masks(30, 32, true)
+			if err != nil {
+				return nil, err
+			}
+			node := binary.LittleEndian.Uint32(record[8:])
+			if i, ok := numa[node]; ok {
+				r.NumaNodes[i].Processors = r.NumaNodes[i].Processors.Union(processors)
+				continue
+			}
+			numa[node] = len(r.NumaNodes)
+			r.NumaNodes = append(r.NumaNodes, NumaNodeRelation{Node: node, Processors: processors})
+
+		case RelationCache:
+			processors, err := masks(38, 40, true)
+			if err != nil {
+				return nil, err
+			}
+			r.Caches = append(r.Caches, CacheRelation{
+				Level:         record[8],
+				Associativity: record[9],
+				LineSize:      binary.LittleEndian.Uint16(record[10:]),
+				Size:          binary.LittleEndian.Uint32(record[12:]),
+				Type:          CacheType(binary.LittleEndian.Uint32(record[16:])),
+				Processors:    processors,
+			})
+
+		case RelationGroup:
+			if len(record) < 32 {
+				return nil, fmt.Errorf("logical processor information: group relationship at offset %d has only %d bytes", at, len(record))
+			}
+			active := int(binary.LittleEndian.Uint16(record[10:]))
+			if len(record) < 32+active*48 {
+				return nil, fmt.Errorf("logical processor information: group relationship at offset %d is too short for %d groups", at, active)
+			}
+			for i := 0; i < active; i++ {
+				info := record[32+i*48:]
+				var processors CPUMask
+				mask := binary.LittleEndian.Uint64(info[40:])
+				for bit := 0; bit < 64; bit++ {
+					if mask&(1<<bit) != 0 {
+						processors = processors.Set(i*64 + bit)
+					}
+				}
+				r.Groups = append(r.Groups, GroupRelation{
+					MaximumProcessors: info[0],
+					ActiveProcessors:  info[1],
+					Processors:        processors,
+				})
+			}
+		}
+	}
+	return r, nil
+}
+
+// parseGroupAffinities decodes count GROUP_AFFINITY structures at offset of record.
+func parseGroupAffinities(record []byte, offset, count, at int) (CPUMask, error) {
+	if len(record) < offset+count*16 {
+		return nil, fmt.Errorf("logical processor information: record at offset %d is too short for %d group masks", at, count)
+	}
+	var processors CPUMask
+	for i := 0; i < count; i++ {
+		affinity := record[offset+i*16:]
+		mask := binary.LittleEndian.Uint64(affinity[0:])
+		group := int(binary.LittleEndian.Uint16(affinity[8:]))
+		for bit := 0; bit < 64; bit++ {
+			if mask&(1<<bit) != 0 {
+				processors = processors.Set(group*64 + bit)
+			}
+		}
+	}
+	return processors, nil
+}
+
+// core returns the core of processor, nil if it is unknown.
+func (r *ProcessorRelations) core(processor int) *CoreRelation {
+	for i := range r.Cores {
+		if r.Cores[i].Processors.Has(processor) {
+			return &r.Cores[i]
+		}
+	}
+	return nil
+}
+
+// L2Groups returns the processors sharing a level 2 data or unified cache,
+// ordered by their lowest processor.
+func (r *ProcessorRelations) L2Groups() []CPUMask {
+	var groups []CPUMask
+	for _, cache := range r.Caches {
+		if cache.Level != 2 || cache.Type == CacheInstruction || cache.Processors.IsZero() {
+			continue
+		}
+		duplicate := false
+		for _, g := range groups {
+			if g.Equal(cache.Processors) {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			groups = append(groups, cache.Processors)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Processors()[0] < groups[j].Processors()[0]
+	})
+	return groups
+}
+
+// coresIn counts the cores with processors in mask.
+func (r *ProcessorRelations) coresIn(mask CPUMask) int {
+	count := 0
+	for _, core := range r.Cores {
+		if !core.Processors.Intersect(mask).IsZero() {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/binary"
+	"math/bits"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// relationRecord returns a SYSTEM_LOGICAL_PROCESSOR_INFORMATION_EX, body
+// follows the Relationship and Size fields.
+func relationRecord(relationship uint32, body []byte) []byte {
+	record := binary.LittleEndian.AppendUint32(nil, relationship)
+	record = binary.LittleEndian.AppendUint32(record, uint32(8+len(body)))
+	return append(record, body...)
+}
+
+// groupAffinity returns a GROUP_AFFINITY.
+func groupAffinity(group uint16, mask uint64) []byte {
+	affinity := binary.LittleEndian.AppendUint64(nil, mask)
+	affinity = binary.LittleEndian.AppendUint16(affinity, group)
+	return append(affinity, make([]byte, 6)...) // Reserved
+}
+
+// processorRecord returns a PROCESSOR_RELATIONSHIP: Flags, EfficiencyClass,
+// Reserved[20], GroupCount and the masks.
+func processorRecord(relationship uint32, flags, class byte, affinities ...[]byte) []byte {
+	body := make([]byte, 24)
+	body[0], body[1] = flags, class
+	binary.LittleEndian.PutUint16(body[22:], uint16(len(affinities)))
+	for _, affinity := range affinities {
+		body = append(body, affinity...)
+	}
+	return relationRecord(relationship, body)
+}
+
+// numaRecord returns a NUMA_NODE_RELATIONSHIP: NodeNumber, Reserved[18],
+// GroupCount and the masks. Before Windows 11 GroupCount is 0 and there is
+// one mask.
+func numaRecord(node uint32, groupCount uint16, affinities ...[]byte) []byte {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint32(body, node)
+	binary.LittleEndian.PutUint16(body[22:], groupCount)
+	for _, affinity := range affinities {
+		body = append(body, affinity...)
+	}
+	return relationRecord(RelationNumaNode, body)
+}
+
+// cacheRecord returns a CACHE_RELATIONSHIP: Level, Associativity, LineSize,
+// CacheSize, Type, Reserved[18], GroupCount and the masks.
+func cacheRecord(level byte, size uint32, cacheType CacheType, groupCount uint16, affinities ...[]byte) []byte {
+	body := make([]byte, 32)
+	body[0], body[1] = level, 8
+	binary.LittleEndian.PutUint16(body[2:], 64)
+	binary.LittleEndian.PutUint32(body[4:], size)
+	binary.LittleEndian.PutUint32(body[8:], uint32(cacheType))
+	binary.LittleEndian.PutUint16(body[30:], groupCount)
+	for _, affinity := range affinities {
+		body = append(body, affinity...)
+	}
+	return relationRecord(RelationCache, body)
+}
+
+// groupRecord returns a GROUP_RELATIONSHIP: MaximumGroupCount,
+// ActiveGroupCount, Reserved[20] and a PROCESSOR_GROUP_INFO of 48 bytes for
+// every mask.
+func groupRecord(masks ...uint64) []byte {
+	body := make([]byte, 24)
+	binary.LittleEndian.PutUint16(body[0:], uint16(len(masks)))
+	binary.LittleEndian.PutUint16(body[2:], uint16(len(masks)))
+	for _, mask := range masks {
+		info := make([]byte, 48)
+		info[0], info[1] = 64, byte(bits.OnesCount64(mask))
+		binary.LittleEndian.PutUint64(info[40:], mask)
+		body = append(body, info...)
+	}
+	return relationRecord(RelationGroup, body)
+}
+
+// twoGroupRelations returns the buffer of a machine with two processor
+// groups of two cores with SMT, a NUMA node and an L3 cache per group. The
+// records are in the order Windows reports them. Before Windows 11 the
+// GroupCount of the NUMA and cache records is 0.
+func twoGroupRelations(windows10 bool) []byte {
+	count := uint16(1)
+	if windows10 {
+		count = 0
+	}
+	var data []byte
+	for _, core := range []struct {
+		group uint16
+		mask  uint64
+	}{{0, 0x3}, {0, 0xc}, {1, 0x3}, {1, 0xc}} {
+		data = append(data, processorRecord(RelationProcessorCore, LTP_PC_SMT, 0, groupAffinity(core.group, core.mask))...)
+		data = append(data, cacheRecord(2, 1<<20, CacheUnified, count, groupAffinity(core.group, core.mask))...)
+	}
+	for group := uint16(0); group < 2; group++ {
+		data = append(data, cacheRecord(3, 16<<20, CacheUnified, count, groupAffinity(group, 0xf))...)
+	}
+	data = append(data, processorRecord(RelationProcessorPackage, 0, 0, groupAffinity(0, 0xf), groupAffinity(1, 0xf))...)
+	data = append(data, numaRecord(0, count, groupAffinity(0, 0xf))...)
+	data = append(data, numaRecord(1, count, groupAffinity(1, 0xf))...)
+	return append(data, groupRecord(0xf, 0xf)...)
+}
+
+func TestParseProcessorRelations(t *testing.T) {
+	l2 := func(processors ...int) CacheRelation {
+		return CacheRelation{Level: 2, Type: CacheUnified, Size: 1 << 20, LineSize: 64, Associativity: 8, Processors: NewCPUMask(processors...)}
+	}
+	l3 := func(processors ...int) CacheRelation {
+		return CacheRelation{Level: 3, Type: CacheUnified, Size: 16 << 20, LineSize: 64, Associativity: 8, Processors: NewCPUMask(processors...)}
+	}
+	want := &ProcessorRelations{
+		Cores: []CoreRelation{
+			{SMT: true, Processors: NewCPUMask(0, 1)},
+			{SMT: true, Processors: NewCPUMask(2, 3)},
+			{SMT: true, Processors: NewCPUMask(64, 65)},
+			{SMT: true, Processors: NewCPUMask(66, 67)},
+		},
+		Caches:   []CacheRelation{l2(0, 1), l2(2, 3), l2(64, 65), l2(66, 67), l3(0, 1, 2, 3), l3(64, 65, 66, 67)},
+		Packages: []CPUMask{NewCPUMask(0, 1, 2, 3, 64, 65, 66, 67)},
+		NumaNodes: []NumaNodeRelation{
+			{Node: 0, Processors: NewCPUMask(0, 1, 2, 3)},
+			{Node: 1, Processors: NewCPUMask(64, 65, 66, 67)},
+		},
+		Groups: []GroupRelation{
+			{MaximumProcessors: 64, ActiveProcessors: 4, Processors: NewCPUMask(0, 1, 2, 3)},
+			{MaximumProcessors: 64, ActiveProcessors: 4, Processors: NewCPUMask(64, 65, 66, 67)},
+		},
+	}
+
+	for _, name := range []string{"two-groups", "two-groups-windows10"} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join("testdata", "topology", name+".lpi")
+			checkGolden(t, file, twoGroupRelations(name == "two-groups-windows10"))
+			relations, err := parseProcessorRelations(mustReadFile(t, file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(relations, want) {
+				t.Errorf("relations\n%+v\nwant\n%+v", relations, want)
+			}
+		})
+	}
+
+	one := processorRecord(RelationProcessorCore, 0, 1, groupAffinity(0, 1))
+	tests := []struct {
+		name  string
+		data  []byte
+		err   bool
+		check func(*ProcessorRelations) bool
+	}{
+		{"empty", nil, false, func(r *ProcessorRelations) bool { return reflect.DeepEqual(r, &ProcessorRelations{}) }},
+		{"efficiency class", one, false, func(r *ProcessorRelations) bool {
+			return len(r.Cores) == 1 && !r.Cores[0].SMT && r.Cores[0].EfficiencyClass == 1 && r.Cores[0].Processors.Equal(NewCPUMask(0))
+		}},
+		{"core without GroupCount", processorRecord(RelationProcessorCore, 0, 0), false, func(r *ProcessorRelations) bool {
+			return len(r.Cores) == 1 && r.Cores[0].Processors.IsZero() // only NUMA and cache records count 0 as one group
+		}},
+		{"die and module", append(processorRecord(RelationProcessorDie, 0, 0, groupAffinity(0, 0xff)), processorRecord(RelationProcessorModule, 0, 0, groupAffinity(0, 0xf0))...), false, func(r *ProcessorRelations) bool {
+			return len(r.Dies) == 1 && r.Dies[0].Count() == 8 && len(r.Modules) == 1 && r.Modules[0].Equal(NewCPUMask(4, 5, 6, 7))
+		}},
+		{"node reported twice", append(numaRecord(0, 1, groupAffinity(0, 0x3)), relationRecord(RelationNumaNodeEx, numaRecord(0, 1, groupAffinity(1, 0x1))[8:])...), false, func(r *ProcessorRelations) bool {
+			return len(r.NumaNodes) == 1 && r.NumaNodes[0].Processors.Equal(NewCPUMask(0, 1, 64))
+		}},
+		{"unknown relationship", append(relationRecord(99, make([]byte, 4)), one...), false, func(r *ProcessorRelations) bool { return len(r.Cores) == 1 }},
+		{"trailing bytes", append(one, 0, 0, 0, 0), true, nil},
+		{"size smaller than the header", append(binary.LittleEndian.AppendUint32(nil, RelationProcessorCore), 4, 0, 0, 0), true, nil},
+		{"size zero", append(binary.LittleEndian.AppendUint32(nil, RelationCache), 0, 0, 0, 0), true, nil},
+		{"size larger than the buffer", one[:len(one)-1], true, nil},
+		{"no GroupCount", relationRecord(RelationProcessorCore, make([]byte, 20)), true, nil},
+		{"cache without GroupCount", relationRecord(RelationCache, make([]byte, 28)), true, nil},
+		{"missing group mask", relationRecord(RelationProcessorCore, processorRecord(RelationProcessorCore, 0, 0, groupAffinity(0, 1), groupAffinity(1, 1))[8:8+24+16]), true, nil},
+		{"zero GroupCount without a mask", numaRecord(0, 0), true, nil},
+		{"short group record", relationRecord(RelationGroup, make([]byte, 20)), true, nil},
+		{"group record without its groups", groupRecord(0xf, 0xf)[:8+24+48], true, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relations, err := parseProcessorRelations(tt.data)
+			if (err != nil) != tt.err {
+				t.Fatalf("error %v, want error %v", err, tt.err)
+			}
+			if tt.check != nil && !tt.check(relations) {
+				t.Errorf("relations %+v", relations)
+			}
+		})
+	}
+}
+
+func FuzzParseProcessorRelations(f *testing.F) {
+	f.Add([]byte{})
+	f.Add(twoGroupRelations(false))
+	f.Add(twoGroupRelations(true))
+	f.Add(relationRecord(RelationGroup, make([]byte, 24)))
+	f.Add(append(binary.LittleEndian.AppendUint32(nil, RelationCache), 0xff, 0xff, 0xff, 0xff))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		relations, err := parseProcessorRelations(data)
+		if err != nil {
+			return
+		}
+		// every record has at least a header and a group at least 48 bytes
+		records := len(relations.Cores) + len(relations.Caches) + len(relations.Packages) + len(relations.Dies) + len(relations.Modules) + len(relations.NumaNodes)
+		if records > len(data)/8 || len(relations.Groups) > len(data)/48 {
+			t.Fatalf("%d records and %d groups from %d bytes", records, len(relations.Groups), len(data))
+		}
+	})
+}
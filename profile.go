@@ -0,0 +1,371 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Profile is a set of desired interrupt settings keyed by stable device identifiers.
+type Profile struct {
+	Devices []ProfileEntry `json:"devices" yaml:"devices"`
+}
+
+// ProfileEntry holds the settings for every device matched by Match.
+// Settings that are nil are left untouched. CPUs is required by
+// DevicePolicy 4 (Specified Processors) and not allowed with another policy.
+type ProfileEntry struct {
+	Name               string       `json:"name,omitempty" yaml:"name,omitempty"`
+	Match              ProfileMatch `json:"match" yaml:"match"`
+	DevicePolicy       *uint32      `json:"devicePolicy,omitempty" yaml:"devicePolicy,omitempty"`
+	DevicePriority     *uint32      `json:"devicePriority,omitempty" yaml:"devicePriority,omitempty"`
+	CPUs               *string      `json:"cpus,omitempty" yaml:"cpus,omitempty"` // e.g. "0,2,4", "pcores,no-smt" or "ccd1", see parseCPUSelector
+	MsiSupported       *uint32      `json:"msiSupported,omitempty" yaml:"msiSupported,omitempty"`
+	MessageNumberLimit *uint32      `json:"messageNumberLimit,omitempty" yaml:"messageNumberLimit,omitempty"`
+}
+
+// ProfileMatch selects devices. Every criterion that is set has to match.
+type ProfileMatch struct {
+	HardwareID   string `json:"hardwareId,omitempty" yaml:"hardwareId,omitempty"`
+	CompatibleID string `json:"compatibleId,omitempty" yaml:"compatibleId,omitempty"`
+	InstanceID   string `json:"instanceId,omitempty" yaml:"instanceId,omitempty"`
+	LocationPath string `json:"locationPath,omitempty" yaml:"locationPath,omitempty"`
+	PCI          string `json:"pci,omitempty" yaml:"pci,omitempty"`                 // bus:device.function, e.g. "1:0.0"
+	Description  string `json:"description,omitempty" yaml:"description,omitempty"` // regular expression for the name
+
+	description *regexp.Regexp
+}
+
+// ProfileResult is the outcome of the profile on one device.
+type ProfileResult struct {
+	Entry      *ProfileEntry   // the last entry that matches the device
+	Overridden []*ProfileEntry // earlier entries that match too, Entry wins where both set a value
+	Device     *Device
+	Before     Device
+	After      Device
+}
+
+func isYAMLFile(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// LoadProfile reads a JSON or YAML (.yaml, .yml) profile.
+func LoadProfile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseProfile(data, isYAMLFile(path))
+}
+
+func ParseProfile(data []byte, isYAML bool) (*Profile, error) {
+	profile := new(Profile)
+	var err error
+	if isYAML {
+		err = yaml.Unmarshal(data, profile)
+	} else {
+		err = json.Unmarshal(data, profile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range profile.Devices {
+		if err := profile.Devices[i].validate(); err != nil {
+			return nil, fmt.Errorf("entry %d (%s): %w", i+1, profile.Devices[i].title(), err)
+		}
+	}
+	return profile, nil
+}
+
+// Save writes the profile as JSON or YAML depending on the file extension.
+func (p *Profile) Save(path string) error {
+	var data []byte
+	var err error
+	if isYAMLFile(path) {
+		data, err = yaml.Marshal(p)
+	} else {
+		data, err = json.MarshalIndent(p, "", "  ")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+func (e *ProfileEntry) title() string {
+	if e.Name != "" {
+		return e.Name
+	}
+	return e.Match.String()
+}
+
+func (e *ProfileEntry) validate() error {
+	m := &e.Match
+	if m.HardwareID == "" && m.CompatibleID == "" && m.InstanceID == "" && m.LocationPath == "" && m.PCI == "" && m.Description == "" {
+		return fmt.Errorf("match is empty")
+	}
+	if m.PCI != "" {
+		if _, err := parsePCILocation(m.PCI); err != nil {
+			return err
+		}
+	}
+	if m.Description != "" {
+		re, err := regexp.Compile("(?i)" + m.Description)
+		if err != nil {
+			return err
+		}
+		m.description = re
+	}
+	if e.DevicePolicy != nil && *e.DevicePolicy > IrqPolicySpreadMessagesAcrossAllProcessors {
+		return fmt.Errorf("invalid devicePolicy %d", *e.DevicePolicy)
+	}
+	specified := e.DevicePolicy != nil && *e.DevicePolicy == IrqPolicySpecifiedProcessors
+	switch {
+	case e.CPUs != nil && !specified:
+		return fmt.Errorf("cpus can only be set with devicePolicy 4 (Specified Processors)")
+	case e.CPUs == nil && specified:
+		return fmt.Errorf("devicePolicy 4 (Specified Processors) needs cpus")
+	case e.CPUs != nil:
+		mask, err := parseCPUList(*e.CPUs)
+		if err != nil {
+			return err
+		}
+		if mask.IsZero() {
+			return fmt.Errorf("devicePolicy 4 (Specified Processors) needs at least one processor")
+		}
+	}
+	if e.DevicePriority != nil && *e.DevicePriority > 3 {
+		return fmt.Errorf("invalid devicePriority %d", *e.DevicePriority)
+	}
+	if e.MsiSupported != nil && *e.MsiSupported > 1 {
+		return fmt.Errorf("invalid msiSupported %d", *e.MsiSupported)
+	}
+	return nil
+}
+
+func (m ProfileMatch) String() string {
+	var parts []string
+	for _, part := range [][2]string{
+		{"hardwareId", m.HardwareID},
+		{"compatibleId", m.CompatibleID},
+		{"instanceId", m.InstanceID},
+		{"locationPath", m.LocationPath},
+		{"pci", m.PCI},
+		{"description", m.Description},
+	} {
+		if part[1] != "" {
+			parts = append(parts, part[0]+"="+part[1])
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// parsePCILocation parses "bus:device.function".
+func parsePCILocation(s string) (PCILocation, error) {
+	var p PCILocation
+	bus, rest, ok := strings.Cut(s, ":")
+	device, function, ok2 := strings.Cut(rest, ".")
+	if !ok || !ok2 {
+		return p, fmt.Errorf("invalid pci address %q, expected bus:device.function", s)
+	}
+	for _, field := range []struct {
+		value string
+		dst   *uint32
+	}{{bus, &p.Bus}, {device, &p.Device}, {function, &p.Function}} {
+		n, err := strconv.ParseUint(strings.TrimSpace(field.value), 10, 32)
+		if err != nil {
+			return p, fmt.Errorf("invalid pci address %q, expected bus:device.function", s)
+		}
+		*field.dst = uint32(n)
+	}
+	p.Valid = true
+	return p, nil
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether dev satisfies every criterion that is set.
+func (m *ProfileMatch) Matches(dev *Device) bool {
+	if m.HardwareID != "" && !containsFold(dev.DeviceIDs, m.HardwareID) {
+		return false
+	}
+	if m.CompatibleID != "" && !containsFold(dev.CompatibleIDs, m.CompatibleID) {
+		return false
+	}
+	if m.InstanceID != "" && !strings.EqualFold(dev.InstanceID, m.InstanceID) {
+		return false
+	}
+	if m.LocationPath != "" && !containsFold(dev.LocationPaths, m.LocationPath) {
+		return false
+	}
+	if m.PCI != "" {
+		p, err := parsePCILocation(m.PCI)
+		if err != nil || p != dev.PCI {
+			return false
+		}
+	}
+	if m.description != nil && !m.description.MatchString(dev.DeviceDesc) && !m.description.MatchString(dev.FriendlyName) {
+		return false
+	}
+	return true
+}
+
+// desired returns dev with the settings of the entry applied.
+func (e *ProfileEntry) desired(dev *Device) Device {
+	after := *dev
+	if e.DevicePolicy != nil {
+		after.DevicePolicy = *e.DevicePolicy
+	}
+	if e.DevicePriority != nil {
+		after.DevicePriority = *e.DevicePriority
+	}
+	if e.CPUs != nil && after.DevicePolicy == IrqPolicySpecifiedProcessors {
+		after.AssignmentSetOverride, _ = parseCPUList(*e.CPUs)
+	}
+	if after.MsiSupported != 2 { // no interrupt support reported
+		if e.MsiSupported != nil {
+			after.MsiSupported = *e.MsiSupported
+		}
+		if e.MessageNumberLimit != nil {
+			after.MessageNumberLimit = *e.MessageNumberLimit
+		}
+	}
+	return after
+}
+
+// Resolve matches every entry against devices and returns one result per
+// device. If several entries match a device their settings are merged in
+// profile order, so the last entry wins. Entries without a matching device
+// are returned in unmatched.
+func (p *Profile) Resolve(devices []Device) (results []ProfileResult, unmatched []*ProfileEntry) {
+	byDevice := map[*Device]int{}
+	for i := range p.Devices {
+		entry := &p.Devices[i]
+		found := false
+		for j := range devices {
+			if !entry.Match.Matches(&devices[j]) {
+				continue
+			}
+			found = true
+			if k, ok := byDevice[&devices[j]]; ok {
+				result := &results[k]
+				result.Overridden = append(result.Overridden, result.Entry)
+				result.Entry = entry
+				result.After = entry.desired(&result.After)
+				continue
+			}
+			byDevice[&devices[j]] = len(results)
+			results = append(results, ProfileResult{
+				Entry:  entry,
+				Device: &devices[j],
+				Before: devices[j],
+				After:  entry.desired(&devices[j]),
+			})
+		}
+		if !found {
+			unmatched = append(unmatched, entry)
+		}
+	}
+	return results, unmatched
+}
+
+// Changed reports whether the device differs from the profile.
+func (r *ProfileResult) Changed() bool {
+	return msiChanged(&r.Before, &r.After) || affinityChanged(&r.Before, &r.After)
+}
+
+// Plan returns the registry operations that write the profile settings.
+func (r *ProfileResult) Plan() (*Plan, error) {
+	plan := &Plan{}
+	return plan, plan.Add(&r.Before, &r.After)
+}
+
+// Apply writes the profile settings to the device.
+func (r *ProfileResult) Apply() error {
+	plan, err := r.Plan()
+	if err != nil {
+		return err
+	}
+	if err := plan.Apply(); err != nil {
+		return err
+	}
+	*r.Device = r.After
+	return nil
+}
+
+// overlap describes the entries that Entry overrides, "" if there are none.
+func (r *ProfileResult) overlap() string {
+	if len(r.Overridden) == 0 {
+		return ""
+	}
+	titles := make([]string, len(r.Overridden))
+	for i, entry := range r.Overridden {
+		titles[i] = fmt.Sprintf("%q", entry.title())
+	}
+	return fmt.Sprintf("%s: %s match too, the settings of %q win", deviceTitle(r.Device), strings.Join(titles, ", "), r.Entry.title())
+}
+
+// GenerateProfile creates a profile of every device with non default settings.
+// Devices are keyed by their most specific hardware ID, plus the location path
+// if several devices share that ID.
+func GenerateProfile(devices []Device) *Profile {
+	hardwareIDs := map[string]int{}
+	for i := range devices {
+		if len(devices[i].DeviceIDs) != 0 {
+			hardwareIDs[strings.ToUpper(devices[i].DeviceIDs[0])]++
+		}
+	}
+
+	profile := new(Profile)
+	for i := range devices {
+		dev := &devices[i]
+		if dev.DevicePolicy == 0 && dev.DevicePriority == 0 && dev.AssignmentSetOverride.IsZero() && dev.MsiSupported != 1 && dev.MessageNumberLimit == 0 {
+			continue
+		}
+
+		entry := ProfileEntry{Name: dev.DeviceDesc}
+		switch {
+		case len(dev.DeviceIDs) != 0:
+			entry.Match.HardwareID = dev.DeviceIDs[0]
+			if hardwareIDs[strings.ToUpper(dev.DeviceIDs[0])] > 1 {
+				if len(dev.LocationPaths) != 0 {
+					entry.Match.LocationPath = dev.LocationPaths[0]
+				} else {
+					entry.Match.InstanceID = dev.InstanceID
+				}
+			}
+		case dev.InstanceID != "":
+			entry.Match.InstanceID = dev.InstanceID
+		default:
+			continue
+		}
+
+		policy, priority := dev.DevicePolicy, dev.DevicePriority
+		entry.DevicePolicy, entry.DevicePriority = &policy, &priority
+		if dev.DevicePolicy == IrqPolicySpecifiedProcessors {
+			cpus := dev.AssignmentSetOverride.String()
+			entry.CPUs = &cpus
+		}
+		if dev.MsiSupported != 2 {
+			msi, limit := dev.MsiSupported, dev.MessageNumberLimit
+			entry.MsiSupported, entry.MessageNumberLimit = &msi, &limit
+		}
+		profile.Devices = append(profile.Devices, entry)
+	}
+	return profile
+}
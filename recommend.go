@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DeviceKind is the role of a device, derived from its PCI class code.
+type DeviceKind int
+
+const (
+	KindOther DeviceKind = iota
+	KindGPU
+	KindNetwork
+	KindUSB
+	KindStorage
+	KindAudio
+)
+
+func (k DeviceKind) String() string {
+	switch k {
+	case KindGPU:
+		return "GPU"
+	case KindNetwork:
+		return "network"
+	case KindUSB:
+		return "USB"
+	case KindStorage:
+		return "storage"
+	case KindAudio:
+		return "audio"
+	}
+	return "other"
+}
+
+// busy reports whether devices of the kind interrupt often enough to get a
+// core of their own. The cores are handed out in the order of the kinds.
+func (k DeviceKind) busy() bool {
+	return k == KindGPU || k == KindNetwork || k == KindUSB
+}
+
+// pciClassCode returns the class and subclass of the PCI\CC_ccss compatible ID, "" if there is none.
+func pciClassCode(dev *Device) string {
+	for _, id := range append(dev.CompatibleIDs, dev.DeviceIDs...) {
+		if code, ok := strings.CutPrefix(strings.ToUpper(id), `PCI\CC_`); ok && len(code) >= 4 {
+			return code[:4]
+		}
+	}
+	return ""
+}
+
+// classifyDevice derives the kind of dev from its PCI class code.
+// https://pcisig.com/sites/default/files/files/PCI_Code-ID_r_1_11__v24_Jan_2019.pdf
+func classifyDevice(dev *Device) DeviceKind {
+	code := pciClassCode(dev)
+	switch {
+	case code == "":
+		return KindOther
+	case strings.HasPrefix(code, "03"):
+		return KindGPU
+	case strings.HasPrefix(code, "02"):
+		return KindNetwork
+	case code == "0C03":
+		return KindUSB
+	case strings.HasPrefix(code, "01"):
+		return KindStorage
+	case code == "0401" || code == "0403":
+		return KindAudio
+	}
+	return KindOther
+}
+
+// Recommendation is the proposed setting of a device and why.
+type Recommendation struct {
+	Device  *Device
+	Kind    DeviceKind
+	After   Device
+	Reasons []string
+}
+
+func (r *Recommendation) Changed() bool {
+	return msiChanged(r.Device, &r.After) || affinityChanged(r.Device, &r.After)
+}
+
+// recommendCore is a core that can take the interrupts of a busy device.
+type recommendCore struct {
+	processors []int
+	class      byte
+	llc, numa  [2]int // group and index
+	parked     bool
+}
+
+// Recommend proposes settings for the devices following these heuristics:
+//
+//   - GPUs, network and USB controllers are busy devices and each gets one
+//     physical core, specified by its first thread so the SMT sibling stays
+//     free, in that order.
+//   - The core of processor 0 is avoided, Windows and many drivers do their
+//     work there.
+//   - Reserved processors are never used, parked ones only when nothing
+//     else is left.
+//   - The cores are taken from the highest efficiency class, the P-cores,
+//     and from the NUMA node of the device if it is known. Within that,
+//     they come from one last level cache (CCD) and NUMA node, the one
+//     with the most of them.
+//   - MSI is enabled where the device supports it and MessageNumberLimit
+//     is capped at the number of specified processors.
+//
+// Other devices are left unchanged, and so are all devices if the topology
+// is unknown.
+func Recommend(devices []Device, topology *CpuSets) []Recommendation {
+	recommendations := make([]Recommendation, len(devices))
+	var busy []*Recommendation
+	for i := range devices {
+		r := &recommendations[i]
+		r.Device = &devices[i]
+		r.After = devices[i]
+		r.Kind = classifyDevice(r.Device)
+		if r.Kind.busy() {
+			busy = append(busy, r)
+		} else {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("%s device, kept as is, only GPUs, network and USB controllers get a core of their own", r.Kind))
+		}
+	}
+	sort.SliceStable(busy, func(i, j int) bool { return busy[i].Kind < busy[j].Kind })
+	if len(busy) == 0 {
+		return recommendations
+	}
+
+	// the devices on each core by its first processor, a core is only
+	// shared when every candidate of a device is taken
+	owners := map[int][]*Recommendation{}
+	for _, r := range busy {
+		cores, reasons := recommendCores(topology, r.Device.NumaNode)
+		if len(cores) == 0 {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("%s device, kept as is, the processor topology is unknown", r.Kind))
+			continue
+		}
+		r.Reasons = append(r.Reasons, fmt.Sprintf("%s device, gets a core of its own", r.Kind))
+
+		if r.Device.MsiSupported == 0 && (Has(r.Device.InterruptTypeMap, 2) || Has(r.Device.InterruptTypeMap, 4)) {
+			r.After.MsiSupported = 1
+			r.Reasons = append(r.Reasons, "MSI enabled, the device supports message signaled interrupts")
+		}
+
+		core := cores[0]
+		for _, c := range cores {
+			if len(owners[c.processors[0]]) < len(owners[core.processors[0]]) {
+				core = c
+			}
+		}
+		r.After.DevicePolicy = IrqPolicySpecifiedProcessors
+		r.After.AssignmentSetOverride = NewCPUMask(core.processors[0])
+		r.Reasons = append(r.Reasons, reasons...)
+		if len(core.processors) > 1 {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("processor %d, the first thread of its core, the SMT sibling %s stays free", core.processors[0], joinInts(core.processors[1:])))
+		} else {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("processor %d", core.processors[0]))
+		}
+		if owner := owners[core.processors[0]]; len(owner) != 0 {
+			r.Reasons = append(r.Reasons, fmt.Sprintf("shares its core with %s, there are more busy devices than cores", deviceTitle(owner[0].Device)))
+		}
+		owners[core.processors[0]] = append(owners[core.processors[0]], r)
+
+		switch {
+		case r.After.MsiSupported != 1 || r.After.MessageNumberLimit == 1 || r.Device.MaxMSILimit == 1:
+		case r.Kind == KindNetwork:
+			r.Reasons = append(r.Reasons, "MessageNumberLimit kept, receive side scaling spreads its queues over several messages")
+		default:
+			r.After.MessageNumberLimit = 1
+			r.Reasons = append(r.Reasons, "MessageNumberLimit 1, further messages would target the same processor")
+		}
+	}
+	return recommendations
+}
+
+// recommendCores returns the cores for a busy device on numaNode, -1 if
+// unknown, in the order they are handed out, and the reasons for the choice.
+// There are none if the topology is unknown.
+func recommendCores(topology *CpuSets, numaNode int) ([]recommendCore, []string) {
+	byProcessor := map[int]CpuSet{}
+	for _, cpu := range topology.CPU {
+		byProcessor[cpu.Processor()] = cpu
+	}
+
+	var all []recommendCore
+	for _, processors := range topology.cores() {
+		first := byProcessor[processors[0]]
+		core := recommendCore{
+			processors: processors,
+			class:      first.EfficiencyClass,
+			llc:        [2]int{int(first.Group), int(first.LastLevelCacheIndex)},
+			numa:       [2]int{int(first.Group), int(first.NumaNodeIndex)},
+		}
+		reserved := false
+		for _, p := range processors {
+			reserved = reserved || byProcessor[p].Flags.Reserved()
+			core.parked = core.parked || byProcessor[p].Flags.Parked()
+		}
+		if !reserved {
+			all = append(all, core)
+		}
+	}
+	if len(all) == 0 {
+		// every core is reserved, there is no good choice
+		for _, processors := range topology.cores() {
+			all = append(all, recommendCore{processors: processors})
+		}
+		return all, []string{"every core is reserved"}
+	}
+
+	var reasons []string
+	var candidates []recommendCore
+	for _, core := range all {
+		if !NewCPUMask(core.processors...).Has(0) {
+			candidates = append(candidates, core)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = all
+		reasons = append(reasons, "processor 0 is the only choice")
+	} else {
+		reasons = append(reasons, "off the core of processor 0, Windows and many drivers do their work there")
+	}
+
+	if topology.EfficiencyClass {
+		highest := candidates[0].class
+		for _, core := range candidates {
+			highest = max(highest, core.class)
+		}
+		candidates = filterCores(candidates, func(core recommendCore) bool { return core.class == highest })
+		reasons = append(reasons, "on a P-core, the highest efficiency class")
+	}
+
+	local := false
+	if numaNode >= 0 && topology.NumaNode {
+		node := topology.numaNodeMask(numaNode)
+		if onNode := filterCores(candidates, func(core recommendCore) bool { return node.Has(core.processors[0]) }); len(onNode) != 0 {
+			candidates = onNode
+			local = true
+			reasons = append(reasons, fmt.Sprintf("on NUMA node %d, the one the device is attached to", numaNode))
+		}
+	}
+
+	if topology.LastLevelCache || (topology.NumaNode && !local) {
+		// the domain with the most cores
+		type domain struct{ llc, numa [2]int }
+		count := map[domain]int{}
+		var best domain
+		for _, core := range candidates {
+			d := domain{core.llc, core.numa}
+			count[d]++
+			if count[d] > count[best] {
+				best = d
+			}
+		}
+		candidates = filterCores(candidates, func(core recommendCore) bool { return domain{core.llc, core.numa} == best })
+		var where []string
+		if topology.LastLevelCache {
+			where = append(where, "last level cache")
+		}
+		if topology.NumaNode && !local {
+			where = append(where, "NUMA node")
+		}
+		reasons = append(reasons, fmt.Sprintf("within one %s with the other busy devices", strings.Join(where, " and ")))
+	}
+
+	// parked cores last
+	sort.SliceStable(candidates, func(i, j int) bool { return !candidates[i].parked && candidates[j].parked })
+	return candidates, reasons
+}
+
+func filterCores(cores []recommendCore, keep func(recommendCore) bool) []recommendCore {
+	var kept []recommendCore
+	for _, core := range cores {
+		if keep(core) {
+			kept = append(kept, core)
+		}
+	}
+	return kept
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = fmt.Sprint(v)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func recommendTestDevice(name, classCode string, numaNode int) Device {
+	return Device{
+		DeviceDesc:       name,
+		CompatibleIDs:    []string{`PCI\CC_` + classCode},
+		InterruptTypeMap: 2,
+		MsiSupported:     1,
+		NumaNode:         numaNode,
+	}
+}
+
+// recommendedProcessors returns the processor of every device, -1 for a
+// device without one.
+func recommendedProcessors(recommendations []Recommendation) []int {
+	processors := make([]int, len(recommendations))
+	for i, r := range recommendations {
+		processors[i] = -1
+		if p := r.After.AssignmentSetOverride.Processors(); len(p) == 1 {
+			processors[i] = p[0]
+		}
+	}
+	return processors
+}
+
+func TestRecommendUnknownTopology(t *testing.T) {
+	devices := []Device{recommendTestDevice("GPU", "030000", -1), recommendTestDevice("NIC", "020000", -1)}
+	devices[1].MsiSupported = 0
+	for _, r := range Recommend(devices, &CpuSets{}) {
+		if r.Changed() {
+			t.Errorf("%s changed without a topology", r.Device.DeviceDesc)
+		}
+		if len(r.Reasons) != 1 || !strings.Contains(r.Reasons[0], "the processor topology is unknown") {
+			t.Errorf("%s: reasons %q", r.Device.DeviceDesc, r.Reasons)
+		}
+	}
+}
+
+func TestRecommendNumaNode(t *testing.T) {
+	useTopologyFixture(t, "numa-2ccd-12-core.json")
+	devices := []Device{
+		recommendTestDevice("GPU on node 0", "030000", 0),
+		recommendTestDevice("GPU on node 1", "030000", 1),
+		recommendTestDevice("NIC on node 0", "020000", 0),
+		recommendTestDevice("USB controller", "0C0330", -1),
+		recommendTestDevice("NIC on a missing node", "020000", 5),
+	}
+
+	recommendations := Recommend(devices, &cs)
+	// node 0 without processor 0, node 1 has the most cores; the network
+	// adapters get their cores before the USB controller
+	want := []int{1, 6, 2, 8, 7}
+	for i, got := range recommendedProcessors(recommendations) {
+		if got != want[i] {
+			t.Errorf("%s: processor %d, want %d", devices[i].DeviceDesc, got, want[i])
+		}
+	}
+	if reasons := strings.Join(recommendations[0].Reasons, "\n"); !strings.Contains(reasons, "on NUMA node 0, the one the device is attached to") {
+		t.Errorf("reasons %s", reasons)
+	}
+
+	// the node numbers of GetLogicalProcessorInformationEx win
+	cs.Relations = &ProcessorRelations{NumaNodes: []NumaNodeRelation{
+		{Node: 0, Processors: NewCPUMask(6, 7, 8, 9, 10, 11)},
+		{Node: 1, Processors: NewCPUMask(0, 1, 2, 3, 4, 5)},
+	}}
+	if got := recommendedProcessors(Recommend(devices[:2], &cs)); got[0] != 6 || got[1] != 1 {
+		t.Errorf("processors %v with the nodes of the relations, want [6 1]", got)
+	}
+}
+
+func TestRecommendSharesCores(t *testing.T) {
+	useTopologyFixture(t, "numa-2ccd-12-core.json")
+	var devices []Device
+	for range 7 { // one more than node 1 has cores
+		devices = append(devices, recommendTestDevice("NIC", "020000", -1))
+	}
+	recommendations := Recommend(devices, &cs)
+	if got := recommendedProcessors(recommendations); got[6] != 6 {
+		t.Errorf("processors %v, the seventh device shares the first core", got)
+	}
+	if reasons := strings.Join(recommendations[6].Reasons, "\n"); !strings.Contains(reasons, "shares its core with NIC") {
+		t.Errorf("reasons %s", reasons)
+	}
+}
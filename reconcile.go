@@ -0,0 +1,134 @@
+package main
+
+import (
+	"time"
+)
+
+// Exit codes of -reconcile, meant for scheduled tasks.
+const (
+	ReconcileInSync = 0 // every device matches the profile
+	ReconcileError  = 1 // the profile could not be read or a write failed
+	ReconcileDrift  = 2 // drift found and not fixed, or an entry matches no device
+	ReconcileFixed  = 3 // drift found and re-applied
+)
+
+// Drift is one field that differs from the desired state.
+type Drift struct {
+	Field    string `json:"field"`
+	Expected any    `json:"expected"`
+	Actual   any    `json:"actual"`
+}
+
+type ReconcileDevice struct {
+	Name           string   `json:"name"`
+	DevObjName     string   `json:"devObjName,omitempty"`
+	InstanceID     string   `json:"instanceId,omitempty"`
+	Entry          string   `json:"entry"`
+	Overridden     []string `json:"overridden,omitempty"` // earlier entries that match too, Entry wins
+	Drift          []Drift  `json:"drift,omitempty"`
+	Fixed          bool     `json:"fixed,omitempty"`
+	Restarted      bool     `json:"restarted,omitempty"`
+	NeedReboot     bool     `json:"needReboot,omitempty"`
+	RestartSkipped string   `json:"restartSkipped,omitempty"` // why an inactive device is not restarted
+	Error          string   `json:"error,omitempty"`
+}
+
+// ReconcileReport is written as JSON by -reconcile.
+type ReconcileReport struct {
+	Time      time.Time         `json:"time"`
+	Profile   string            `json:"profile"`
+	Devices   []ReconcileDevice `json:"devices"`
+	Unmatched []string          `json:"unmatched,omitempty"`
+	Drifted   int               `json:"drifted"`
+	ExitCode  int               `json:"exitCode"`
+}
+
+// driftFields lists every setting of actual that differs from expected.
+func driftFields(expected, actual *Device) []Drift {
+	var drift []Drift
+	if expected.MsiSupported != actual.MsiSupported {
+		drift = append(drift, Drift{"MSISupported", expected.MsiSupported, actual.MsiSupported})
+	}
+	if expected.MessageNumberLimit != actual.MessageNumberLimit {
+		drift = append(drift, Drift{"MessageNumberLimit", expected.MessageNumberLimit, actual.MessageNumberLimit})
+	}
+	if expected.DevicePolicy != actual.DevicePolicy {
+		drift = append(drift, Drift{"DevicePolicy", expected.DevicePolicy, actual.DevicePolicy})
+	}
+	if expected.DevicePriority != actual.DevicePriority {
+		drift = append(drift, Drift{"DevicePriority", expected.DevicePriority, actual.DevicePriority})
+	}
+	if !expected.AssignmentSetOverride.Equal(actual.AssignmentSetOverride) {
+		drift = append(drift, Drift{"AssignmentSetOverride", expected.AssignmentSetOverride.String(), actual.AssignmentSetOverride.String()})
+	}
+	return drift
+}
+
+// reconcile compares devices with the profile. With fix set the profile is
+// re-applied and restart is called once for every device that drifted, also
+// when several entries match it.
+func reconcile(profile *Profile, profilePath string, devices []Device, fix bool, restart func(*Device) (needReboot bool, err error)) *ReconcileReport {
+	report := &ReconcileReport{
+		Time:    time.Now(),
+		Profile: profilePath,
+		Devices: []ReconcileDevice{},
+	}
+
+	results, unmatched := profile.Resolve(devices)
+	for _, entry := range unmatched {
+		report.Unmatched = append(report.Unmatched, entry.title())
+	}
+
+	failed := false
+	for i := range results {
+		result := &results[i]
+		rd := ReconcileDevice{
+			Name:       result.Device.DeviceDesc,
+			DevObjName: result.Device.DevObjName,
+			InstanceID: result.Device.InstanceID,
+			Entry:      result.Entry.title(),
+			Drift:      driftFields(&result.After, &result.Before),
+		}
+		for _, entry := range result.Overridden {
+			rd.Overridden = append(rd.Overridden, entry.title())
+		}
+
+		if len(rd.Drift) != 0 {
+			report.Drifted++
+			if fix {
+				if err := result.Apply(); err != nil {
+					rd.Error = err.Error()
+					failed = true
+				} else {
+					rd.Fixed = true
+					if reason := restartSkipped(result.Device); reason != "" && restart != nil {
+						rd.RestartSkipped = reason
+					} else if restart != nil {
+						needReboot, err := restart(result.Device)
+						if err != nil {
+							rd.Error = err.Error()
+							failed = true
+						}
+						rd.Restarted = err == nil && !needReboot
+						rd.NeedReboot = needReboot
+					}
+				}
+			}
+		}
+		report.Devices = append(report.Devices, rd)
+	}
+
+	switch {
+	case failed:
+		report.ExitCode = ReconcileError
+	case len(report.Unmatched) != 0: // a missing device cannot be fixed
+		report.ExitCode = ReconcileDrift
+	case report.Drifted == 0:
+		report.ExitCode = ReconcileInSync
+	case fix:
+		report.ExitCode = ReconcileFixed
+	default:
+		report.ExitCode = ReconcileDrift
+	}
+	return report
+}
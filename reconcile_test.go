@@ -0,0 +1,160 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const overlappingProfile = `
+devices:
+  - name: all NVIDIA
+    match:
+      description: nvidia
+    devicePolicy: 4
+    cpus: "2,3"
+    msiSupported: 1
+  - name: RTX 4090
+    match:
+      hardwareId: PCI\VEN_10DE&DEV_2684
+    devicePolicy: 4
+    devicePriority: 3
+    cpus: "4"
+  - name: Ethernet
+    match:
+      description: ethernet
+    msiSupported: 1
+`
+
+// useTopologyFixture makes the fixture of fixtures/topology the processors
+// of cs for the duration of the test.
+func useTopologyFixture(t *testing.T, name string) {
+	t.Helper()
+	fixture, err := LoadTopologyFixture(filepath.Join("fixtures", "topology", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := cs
+	t.Cleanup(func() { cs = saved })
+	cs.Load(fixture.CPUs, fixture.Relations)
+}
+
+func reconcileTestDevices(t *testing.T) []Device {
+	t.Helper()
+	useTopologyFixture(t, "8-threads.json")
+	gpu := Device{DeviceDesc: "NVIDIA GeForce RTX 4090", DeviceIDs: []string{`PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1`, `PCI\VEN_10DE&DEV_2684`}, InterruptTypeMap: 2}
+	gpu.store = newTestStore(t, storeValues{interruptManagementKey: {}})
+	nic := Device{DeviceDesc: "Intel(R) Ethernet Controller I225-V", DeviceIDs: []string{`PCI\VEN_8086&DEV_15F3`}, InterruptTypeMap: 2}
+	nic.store = newTestStore(t, storeValues{interruptManagementKey: {}, msiPropertiesKey: {"MSISupported": uint32(1)}})
+	nic.MsiSupported = 1
+	return []Device{gpu, nic}
+}
+
+func TestResolveMergesEntries(t *testing.T) {
+	devices := reconcileTestDevices(t)
+	profile, err := ParseProfile([]byte(overlappingProfile), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, unmatched := profile.Resolve(devices)
+	if len(unmatched) != 0 {
+		t.Errorf("unmatched %v", unmatched)
+	}
+	if len(results) != 2 {
+		t.Fatalf("%d results, want one per device", len(results))
+	}
+
+	gpu := results[0]
+	if gpu.Device != &devices[0] || gpu.Entry.Name != "RTX 4090" || len(gpu.Overridden) != 1 || gpu.Overridden[0].Name != "all NVIDIA" {
+		t.Errorf("entries of the GPU: %q overriding %v", gpu.Entry.Name, gpu.Overridden)
+	}
+	// the later entry wins where both set a value, the rest is merged
+	if gpu.After.DevicePolicy != IrqPolicySpecifiedProcessors || gpu.After.DevicePriority != 3 || gpu.After.MsiSupported != 1 || !gpu.After.AssignmentSetOverride.Equal(NewCPUMask(4)) {
+		t.Errorf("merged settings %+v", gpu.After)
+	}
+	if gpu.overlap() == "" || results[1].overlap() != "" {
+		t.Error("overlap is not reported for the GPU only")
+	}
+}
+
+func TestProfileEntryCPUs(t *testing.T) {
+	useTopologyFixture(t, "8-threads.json")
+	tests := []struct {
+		settings string
+		err      bool
+	}{
+		{"devicePolicy: 4\n    cpus: \"2,3\"", false},
+		{"devicePolicy: 4\n    cpus: \"0,^0\"", true},
+		{"devicePolicy: 4", true},
+		{"devicePolicy: 4\n    cpus: \"\"", true},
+		{"devicePolicy: 5\n    cpus: \"2\"", true},
+		{"cpus: \"2\"", true},
+		{"devicePolicy: 5", false},
+	}
+	for _, tt := range tests {
+		data := "devices:\n  - match:\n      description: nvidia\n    " + tt.settings + "\n"
+		if _, err := ParseProfile([]byte(data), true); (err != nil) != tt.err {
+			t.Errorf("%q: error %v, want error %v", tt.settings, err, tt.err)
+		}
+	}
+}
+
+func TestReconcileRestartsOnce(t *testing.T) {
+	devices := reconcileTestDevices(t)
+	profile, err := ParseProfile([]byte(overlappingProfile), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := reconcile(profile, "profile.yaml", devices, false, nil)
+	if report.ExitCode != ReconcileDrift || report.Drifted != 1 || len(report.Devices) != 2 {
+		t.Fatalf("report without fix: exit code %d, %d drifted, %d devices", report.ExitCode, report.Drifted, len(report.Devices))
+	}
+	if want := []string{"all NVIDIA"}; !reflect.DeepEqual(report.Devices[0].Overridden, want) {
+		t.Errorf("overridden %q, want %q", report.Devices[0].Overridden, want)
+	}
+
+	restarts := map[string]int{}
+	restart := func(dev *Device) (bool, error) {
+		restarts[dev.DeviceDesc]++
+		return false, nil
+	}
+	report = reconcile(profile, "profile.yaml", devices, true, restart)
+	if report.ExitCode != ReconcileFixed {
+		t.Fatalf("exit code %d, want %d: %+v", report.ExitCode, ReconcileFixed, report.Devices)
+	}
+	if want := map[string]int{"NVIDIA GeForce RTX 4090": 1}; !reflect.DeepEqual(restarts, want) {
+		t.Errorf("restarts %v, want %v", restarts, want)
+	}
+	if got, err := devices[0].store.GetBinaryValue(affinityPolicyKey, "AssignmentSetOverride"); err != nil || !reflect.DeepEqual(got, []byte{0x10}) {
+		t.Errorf("AssignmentSetOverride %x (%v), want the mask of the last entry", got, err)
+	}
+
+	report = reconcile(profile, "profile.yaml", devices, false, nil)
+	if report.ExitCode != ReconcileInSync {
+		t.Errorf("exit code %d after the fix, want %d: %+v", report.ExitCode, ReconcileInSync, report.Devices)
+	}
+}
+
+func TestReconcileUnmatched(t *testing.T) {
+	devices := reconcileTestDevices(t)
+	profile, err := ParseProfile([]byte(overlappingProfile+`  - name: Sound card
+    match:
+      description: audio
+    msiSupported: 1
+`), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fix := range []bool{false, true} {
+		report := reconcile(profile, "profile.yaml", devices, fix, nil)
+		if report.ExitCode != ReconcileDrift || !reflect.DeepEqual(report.Unmatched, []string{"Sound card"}) {
+			t.Errorf("fix %v: exit code %d, unmatched %q, want %d for the missing device", fix, report.ExitCode, report.Unmatched, ReconcileDrift)
+		}
+	}
+	// the devices that are there are in sync after the fix
+	if report := reconcile(profile, "profile.yaml", devices, false, nil); report.Drifted != 0 || report.ExitCode != ReconcileDrift {
+		t.Errorf("after the fix: %d drifted, exit code %d", report.Drifted, report.ExitCode)
+	}
+}
@@ -1,21 +1,15 @@
+//go:build windows
+
 package main
 
 import (
+	"errors"
 	"log"
 	"strings"
 
 	"golang.org/x/sys/windows/registry"
 )
 
-func clen(n []byte) int {
-	for i := len(n) - 1; i >= 0; i-- {
-		if n[i] != 0 {
-			return i + 1
-		}
-	}
-	return len(n)
-}
-
 func GetStringValue(key registry.Key, name string) string {
 	value, _, err := key.GetStringValue(name)
 	if err != nil {
@@ -38,87 +32,93 @@ func GetDWORDuint32Value(key registry.Key, name string) uint32 {
 	return btoi32(buf)
 }
 
-func setMSIMode(item *Device) {
-	var k registry.Key
-	var err error
-	if item.MsiSupported == 1 {
-		k, _, err = registry.CreateKey(item.reg, `Interrupt Management\MessageSignaledInterruptProperties`, registry.ALL_ACCESS)
-		if err != nil {
-			log.Println(err)
-		}
-		if err := k.SetDWordValue("MSISupported", 1); err != nil {
-			log.Println(err)
-		}
-
-		if item.MessageNumberLimit == 0 {
-			if err := k.DeleteValue("MessageNumberLimit"); err != nil {
-				log.Println(err)
-			}
-		} else {
-			if err := k.SetDWordValue("MessageNumberLimit", uint32(item.MessageNumberLimit)); err != nil {
-				log.Println(err)
-			}
-		}
-	} else {
-		k, err = registry.OpenKey(item.reg, `Interrupt Management\MessageSignaledInterruptProperties`, registry.ALL_ACCESS)
-		if err != nil {
-			log.Println(err)
-		}
+// registryStore is the PolicyStore backed by the live registry.
+type registryStore struct {
+	key registry.Key
+}
 
-		if err := registry.DeleteKey(item.reg, `Interrupt Management\MessageSignaledInterruptProperties`); err != nil {
-			log.Println(err)
-		}
-	}
-	if err := k.Close(); err != nil {
-		log.Println(err)
+func registryErr(err error) error {
+	if errors.Is(err, registry.ErrNotExist) {
+		return ErrNotExist
 	}
+	return err
 }
 
-func setAffinityPolicy(item *Device) {
-	var k registry.Key
-	var err error
-
-	if item.DevicePolicy == 0 && item.DevicePriority == 0 {
-
-		k, err = registry.OpenKey(item.reg, `Interrupt Management\Affinity Policy`, registry.ALL_ACCESS)
-		if err != nil {
-			log.Println(err)
-		}
+func (s registryStore) KeyExists(path string) (bool, error) {
+	k, err := registry.OpenKey(s.key, path, registry.QUERY_VALUE)
+	if errors.Is(err, registry.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, k.Close()
+}
 
-		if err := registry.DeleteKey(item.reg, `Interrupt Management\Affinity Policy`); err != nil {
-			log.Println(err)
-		}
+func (s registryStore) CreateKey(path string) error {
+	k, _, err := registry.CreateKey(s.key, path, registry.ALL_ACCESS)
+	if err != nil {
+		return err
+	}
+	return k.Close()
+}
 
-	} else {
+func (s registryStore) DeleteKey(path string) error {
+	return registryErr(registry.DeleteKey(s.key, path))
+}
 
-		k, _, err = registry.CreateKey(item.reg, `Interrupt Management\Affinity Policy`, registry.ALL_ACCESS)
-		if err != nil {
-			log.Println(err)
-		}
+func (s registryStore) GetDWordValue(path, name string) (uint32, error) {
+	k, err := registry.OpenKey(s.key, path, registry.QUERY_VALUE)
+	if err != nil {
+		return 0, registryErr(err)
+	}
+	defer k.Close()
 
-		if err := k.SetDWordValue("DevicePolicy", item.DevicePolicy); err != nil {
-			log.Println(err)
-		}
+	buf := make([]byte, 4)
+	if _, _, err := k.GetValue(name, buf); err != nil {
+		return 0, registryErr(err)
+	}
+	return btoi32(buf), nil
+}
 
-		if item.DevicePolicy != 4 {
-			k.DeleteValue("AssignmentSetOverride")
-		}
+func (s registryStore) SetDWordValue(path, name string, value uint32) error {
+	k, err := registry.OpenKey(s.key, path, registry.SET_VALUE)
+	if err != nil {
+		return registryErr(err)
+	}
+	defer k.Close()
+	return k.SetDWordValue(name, value)
+}
 
-		if item.DevicePriority == 0 {
-			k.DeleteValue("DevicePriority")
-		} else if err := k.SetDWordValue("DevicePriority", item.DevicePriority); err != nil {
-			log.Println(err)
-		}
+func (s registryStore) GetBinaryValue(path, name string) ([]byte, error) {
+	k, err := registry.OpenKey(s.key, path, registry.QUERY_VALUE)
+	if err != nil {
+		return nil, registryErr(err)
+	}
+	defer k.Close()
 
-		AssignmentSetOverrideByte := i64tob(uint64(item.AssignmentSetOverride))
-		if err := k.SetBinaryValue("AssignmentSetOverride", AssignmentSetOverrideByte[:clen(AssignmentSetOverrideByte)]); err != nil {
-			log.Println(err)
-		}
+	value, _, err := k.GetBinaryValue(name)
+	if err != nil {
+		return nil, registryErr(err)
+	}
+	return value, nil
+}
 
+func (s registryStore) SetBinaryValue(path, name string, value []byte) error {
+	k, err := registry.OpenKey(s.key, path, registry.SET_VALUE)
+	if err != nil {
+		return registryErr(err)
 	}
-	if err := k.Close(); err != nil {
-		log.Println(err)
+	defer k.Close()
+	return k.SetBinaryValue(name, value)
+}
+
+func (s registryStore) DeleteValue(path, name string) error {
+	k, err := registry.OpenKey(s.key, path, registry.SET_VALUE)
+	if err != nil {
+		return registryErr(err)
 	}
+	defer k.Close()
+	return registryErr(k.DeleteValue(name))
 }
 
 // \REGISTRY\MACHINE\
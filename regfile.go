@@ -0,0 +1,360 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const (
+	regFileHeader   = "Windows Registry Editor Version 5.00"
+	regFileHeaderV4 = "REGEDIT4"
+)
+
+type RegValueType int
+
+const (
+	RegDelete RegValueType = iota // "name"=-
+	RegDWord                      // dword:
+	RegBinary                     // hex: and hex(n):
+	RegString                     // "string"
+)
+
+// RegFile is the content of a .reg file as written by regedit.
+type RegFile struct {
+	Comments []string // written as "; " lines below the header, not parsed
+	Keys     []RegKey
+}
+
+// RegKey is one [section] of a .reg file.
+type RegKey struct {
+	Comments []string // written as "; " lines above the section, not parsed
+	Path     string
+	Delete   bool // [-HKEY_...]
+	Values   []RegValue
+}
+
+type RegValue struct {
+	Name  string // "" is the default value (@)
+	Type  RegValueType
+	Kind  uint32 // registry type of hex(n): values, REG_BINARY for hex:
+	DWord uint32
+	Data  []byte
+	Text  string
+}
+
+// ParseRegFile parses a "Windows Registry Editor Version 5.00" file (UTF-16LE with BOM or UTF-8).
+func ParseRegFile(data []byte) (*RegFile, error) {
+	text, err := decodeRegFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	var lineNumbers []int
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var pending strings.Builder
+	lineNumber, start := 0, 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if pending.Len() != 0 {
+			line = strings.TrimLeft(line, " \t")
+		} else {
+			start = lineNumber
+		}
+		if strings.HasSuffix(line, `\`) && !strings.HasPrefix(strings.TrimSpace(line), "[") {
+			pending.WriteString(strings.TrimSuffix(line, `\`))
+			continue
+		}
+		pending.WriteString(line)
+		lines = append(lines, pending.String())
+		lineNumbers = append(lineNumbers, start)
+		pending.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pending.Len() != 0 {
+		lines = append(lines, pending.String())
+		lineNumbers = append(lineNumbers, start)
+	}
+
+	rf := new(RegFile)
+	headerFound := false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if !headerFound {
+			if line != regFileHeader && line != regFileHeaderV4 {
+				return nil, fmt.Errorf("line %d: missing %q header", lineNumbers[i], regFileHeader)
+			}
+			headerFound = true
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			end := strings.LastIndex(line, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated key %q", lineNumbers[i], line)
+			}
+			key := RegKey{Path: line[1:end]}
+			if strings.HasPrefix(key.Path, "-") {
+				key.Delete = true
+				key.Path = key.Path[1:]
+			}
+			rf.Keys = append(rf.Keys, key)
+			continue
+		}
+
+		if len(rf.Keys) == 0 {
+			return nil, fmt.Errorf("line %d: value outside of a key", lineNumbers[i])
+		}
+		value, err := parseRegValue(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumbers[i], err)
+		}
+		key := &rf.Keys[len(rf.Keys)-1]
+		key.Values = append(key.Values, value)
+	}
+	if !headerFound {
+		return nil, fmt.Errorf("missing %q header", regFileHeader)
+	}
+	return rf, nil
+}
+
+func decodeRegFile(data []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		data = data[2:]
+		if len(data)%2 != 0 {
+			return "", fmt.Errorf("odd length UTF-16 file")
+		}
+		u16s := make([]uint16, len(data)/2)
+		for i := range u16s {
+			u16s[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+		}
+		return string(utf16.Decode(u16s)), nil
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return string(data[3:]), nil
+	default:
+		return string(data), nil
+	}
+}
+
+func parseRegValue(line string) (RegValue, error) {
+	var value RegValue
+	var rest string
+	switch {
+	case strings.HasPrefix(line, "@"):
+		rest = strings.TrimSpace(line[1:])
+	case strings.HasPrefix(line, `"`):
+		name, n, err := parseRegString(line)
+		if err != nil {
+			return value, err
+		}
+		value.Name = name
+		rest = strings.TrimSpace(line[n:])
+	default:
+		return value, fmt.Errorf("invalid value %q", line)
+	}
+
+	if !strings.HasPrefix(rest, "=") {
+		return value, fmt.Errorf("missing '=' in %q", line)
+	}
+	rest = strings.TrimSpace(rest[1:])
+
+	switch {
+	case rest == "-":
+		value.Type = RegDelete
+	case strings.HasPrefix(rest, `"`):
+		s, _, err := parseRegString(rest)
+		if err != nil {
+			return value, err
+		}
+		value.Type = RegString
+		value.Text = s
+	case strings.HasPrefix(strings.ToLower(rest), "dword:"):
+		n, err := strconv.ParseUint(strings.TrimSpace(rest[len("dword:"):]), 16, 32)
+		if err != nil {
+			return value, fmt.Errorf("invalid dword in %q", line)
+		}
+		value.Type = RegDWord
+		value.DWord = uint32(n)
+	case strings.HasPrefix(strings.ToLower(rest), "hex"):
+		kind := uint32(3) // REG_BINARY
+		rest = rest[len("hex"):]
+		if strings.HasPrefix(rest, "(") {
+			end := strings.Index(rest, ")")
+			if end == -1 {
+				return value, fmt.Errorf("invalid hex type in %q", line)
+			}
+			n, err := strconv.ParseUint(rest[1:end], 16, 32)
+			if err != nil {
+				return value, fmt.Errorf("invalid hex type in %q", line)
+			}
+			kind = uint32(n)
+			rest = rest[end+1:]
+		}
+		if !strings.HasPrefix(rest, ":") {
+			return value, fmt.Errorf("invalid hex value in %q", line)
+		}
+		data, err := parseRegHex(rest[1:])
+		if err != nil {
+			return value, fmt.Errorf("%w in %q", err, line)
+		}
+		value.Type = RegBinary
+		value.Kind = kind
+		value.Data = data
+	default:
+		return value, fmt.Errorf("unsupported value type in %q", line)
+	}
+	return value, nil
+}
+
+// parseRegString reads a quoted string and returns it with the number of bytes consumed.
+func parseRegString(s string) (string, int, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+			b.WriteByte(s[i])
+		case '"':
+			return b.String(), i + 1, nil
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string %q", s)
+}
+
+func parseRegHex(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return []byte{}, nil
+	}
+	parts := strings.Split(s, ",")
+	data := make([]byte, 0, len(parts))
+	for _, part := range parts {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		b, err := hex.DecodeString(part)
+		if err != nil || len(b) != 1 {
+			return nil, fmt.Errorf("invalid hex byte %q", part)
+		}
+		data = append(data, b[0])
+	}
+	return data, nil
+}
+
+// regLineWidth is where regedit wraps hex: values.
+const regLineWidth = 80
+
+// String returns the file content with CRLF line endings but without BOM.
+func (rf *RegFile) String() string {
+	var b strings.Builder
+	b.WriteString(regFileHeader)
+	b.WriteString("\r\n")
+	if len(rf.Comments) != 0 {
+		b.WriteString("\r\n")
+		writeRegComments(&b, rf.Comments)
+	}
+	for _, key := range rf.Keys {
+		b.WriteString("\r\n")
+		writeRegComments(&b, key.Comments)
+		b.WriteString("[")
+		if key.Delete {
+			b.WriteString("-")
+		}
+		b.WriteString(key.Path)
+		b.WriteString("]\r\n")
+		for _, value := range key.Values {
+			b.WriteString(value.String())
+			b.WriteString("\r\n")
+		}
+	}
+	return b.String()
+}
+
+func writeRegComments(b *strings.Builder, comments []string) {
+	for _, comment := range comments {
+		b.WriteString("; ")
+		b.WriteString(comment)
+		b.WriteString("\r\n")
+	}
+}
+
+// Marshal encodes the file as UTF-16LE with BOM, the format regedit writes.
+func (rf *RegFile) Marshal() []byte {
+	u16s := utf16.Encode([]rune(rf.String()))
+	data := make([]byte, 2, 2+2*len(u16s))
+	data[0], data[1] = 0xFF, 0xFE
+	for _, u := range u16s {
+		data = append(data, byte(u), byte(u>>8))
+	}
+	return data
+}
+
+func (value RegValue) String() string {
+	var name string
+	if value.Name == "" {
+		name = "@"
+	} else {
+		name = quoteRegString(value.Name)
+	}
+
+	switch value.Type {
+	case RegDelete:
+		return name + "=-"
+	case RegDWord:
+		return fmt.Sprintf("%s=dword:%08x", name, value.DWord)
+	case RegString:
+		return name + "=" + quoteRegString(value.Text)
+	default:
+		prefix := name + "=hex:"
+		if value.Kind != 0 && value.Kind != 3 { // REG_BINARY
+			prefix = fmt.Sprintf("%s=hex(%x):", name, value.Kind)
+		}
+		return formatRegHex(prefix, value.Data)
+	}
+}
+
+func quoteRegString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + s + `"`
+}
+
+// formatRegHex writes comma separated bytes and continues long lines with "\" like regedit.
+func formatRegHex(prefix string, data []byte) string {
+	var b strings.Builder
+	b.WriteString(prefix)
+	lineLength := len(prefix)
+	for i, c := range data {
+		b.WriteString(fmt.Sprintf("%02x", c))
+		lineLength += 2
+		if i == len(data)-1 {
+			break
+		}
+		b.WriteString(",")
+		lineLength++
+		if lineLength+3 > regLineWidth-2 {
+			b.WriteString("\\\r\n  ")
+			lineLength = 2
+		}
+	}
+	return b.String()
+}
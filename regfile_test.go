@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+const testRegPath = `HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Enum\PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1\4&2b8f4b3c&0&0008\Device Parameters`
+
+// checkGolden compares got with the golden file, or rewrites it with -update.
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		gotText, _ := decodeRegFile(got)
+		wantText, _ := decodeRegFile(want)
+		t.Errorf("%s differs\ngot:\n%s\nwant:\n%s", path, gotText, wantText)
+	}
+}
+
+// TestRegFileRoundTrip parses every golden file and expects Marshal to
+// write the same bytes again.
+func TestRegFileRoundTrip(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "regfile", "*.reg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden files")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(data, []byte{0xFF, 0xFE}) {
+				t.Fatal("the golden file is not UTF-16LE with BOM")
+			}
+			rf, err := ParseRegFile(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.HasPrefix(filepath.Base(file), "backup") {
+				// comments are not parsed, compare the keys of the backup instead
+				rf.Comments = nil
+				text, _ := decodeRegFile(data)
+				again, err := ParseRegFile([]byte(rf.String()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(again.Keys, rf.Keys) || !strings.Contains(text, "; GoInterruptPolicy backup") {
+					t.Error("the keys of the backup do not survive a round trip")
+				}
+				return
+			}
+			checkGolden(t, file, rf.Marshal())
+		})
+	}
+}
+
+func TestParseRegFileUTF8(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "regfile", "values.reg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	utf16File, err := ParseRegFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := decodeRegFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range map[string][]byte{
+		"utf-8":           []byte(text),
+		"utf-8 with BOM":  append([]byte{0xEF, 0xBB, 0xBF}, text...),
+		"LF line endings": []byte(strings.ReplaceAll(text, "\r\n", "\n")),
+	} {
+		rf, err := ParseRegFile(data)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(rf, utf16File) {
+			t.Errorf("%s: parsed to %+v, want %+v", name, rf, utf16File)
+		}
+	}
+}
+
+func TestParseRegFileValues(t *testing.T) {
+	rf, err := ParseRegFile(mustReadFile(t, filepath.Join("testdata", "regfile", "values.reg")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RegKey{
+		{Path: `HKEY_LOCAL_MACHINE\SOFTWARE\GoInterruptPolicy\Test`, Values: []RegValue{
+			{Name: "", Type: RegString, Text: "default"},
+			{Name: `Quoted "name" with \ backslash`, Type: RegString, Text: `C:\Windows\"x"`},
+			{Name: "DWord", Type: RegDWord, DWord: 0xdeadbeef},
+			{Name: "Binary", Type: RegBinary, Kind: 3, Data: []byte{0x00, 0x01, 0xfe, 0xff}},
+			{Name: "Empty", Type: RegBinary, Kind: 3, Data: []byte{}},
+			{Name: "MultiString", Type: RegBinary, Kind: 7, Data: []byte{'a', 0, 0, 0, 'b', 0, 0, 0, 0, 0}},
+			{Name: "Long", Type: RegBinary, Kind: 3, Data: bytes.Repeat([]byte{0x11, 0x22, 0x33, 0x44}, 16)},
+			{Name: "Removed", Type: RegDelete},
+		}},
+		{Path: `HKEY_LOCAL_MACHINE\SOFTWARE\GoInterruptPolicy\Test\Removed`, Delete: true},
+	}
+	if !reflect.DeepEqual(rf.Keys, want) {
+		t.Errorf("keys\n%+v\nwant\n%+v", rf.Keys, want)
+	}
+}
+
+func TestParseRegFileErrors(t *testing.T) {
+	tests := map[string]string{
+		"no header":          "[HKEY_LOCAL_MACHINE\\A]\r\n",
+		"value outside key":  regFileHeader + "\r\n\"A\"=dword:00000001\r\n",
+		"unterminated key":   regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A\r\n",
+		"invalid dword":      regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A]\r\n\"A\"=dword:xyz\r\n",
+		"invalid hex":        regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A]\r\n\"A\"=hex:0g\r\n",
+		"missing =":          regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A]\r\n\"A\" dword:00000001\r\n",
+		"unterminated name":  regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A]\r\n\"A=dword:00000001\r\n",
+		"odd length UTF-16":  "\xff\xfeW",
+		"unsupported value":  regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A]\r\n\"A\"=qword:1\r\n",
+		"invalid hex type":   regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A]\r\n\"A\"=hex(z):00\r\n",
+		"invalid value name": regFileHeader + "\r\n[HKEY_LOCAL_MACHINE\\A]\r\nA=dword:00000001\r\n",
+	}
+	for name, text := range tests {
+		if _, err := ParseRegFile([]byte(text)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+// TestExportGolden writes the settings of devices like "Export current
+// settings" and imports them again.
+func TestExportGolden(t *testing.T) {
+	tests := []struct {
+		file string
+		dev  Device
+	}{
+		{"export-specified.reg", Device{DevicePolicy: IrqPolicySpecifiedProcessors, DevicePriority: 3, AssignmentSetOverride: NewCPUMask(2, 3), MsiSupported: 1, MessageNumberLimit: 8}},
+		{"export-defaults.reg", Device{}},
+		{"export-groups.reg", Device{DevicePolicy: IrqPolicySpecifiedProcessors, AssignmentSetOverride: NewCPUMask(0, 64, 127), MsiSupported: 1}},
+		{"export-no-msi.reg", Device{DevicePolicy: IrqPolicyAllCloseProcessors, DevicePriority: 2, MsiSupported: 2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.file, func(t *testing.T) {
+			path := filepath.Join("testdata", "regfile", tt.file)
+			checkGolden(t, path, createRegFile(testRegPath, &tt.dev))
+
+			rf, err := ParseRegFile(mustReadFile(t, path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// import into a device that has different settings
+			devices := []Device{{RegPath: testRegPath, DevicePolicy: IrqPolicyOneCloseProcessor, DevicePriority: 1, AssignmentSetOverride: NewCPUMask(5), MsiSupported: 1, MessageNumberLimit: 2}}
+			if tt.dev.MsiSupported == 2 {
+				devices[0].MsiSupported, devices[0].MessageNumberLimit = 2, 0
+			}
+			changes, _ := planImport(rf, devices)
+			if len(changes) != 1 {
+				t.Fatalf("%d changes, want 1", len(changes))
+			}
+			got := changes[0].After
+			if msiChanged(&got, &tt.dev) || affinityChanged(&got, &tt.dev) {
+				t.Errorf("imported %v, want %v", describeChanges(&tt.dev, &got), tt.dev)
+			}
+		})
+	}
+}
+
+func TestMachineBackupGolden(t *testing.T) {
+	devices := []Device{
+		{DeviceDesc: "NVIDIA GeForce RTX 4090", DevObjName: `\Device\NTPNP_PCI0015`, RegPath: testRegPath, InstanceID: `PCI\VEN_10DE&DEV_2684&SUBSYS_16F310DE&REV_A1\4&2B8F4B3C&0&0008`, PCI: PCILocation{Valid: true, Bus: 1}, DevicePolicy: IrqPolicySpecifiedProcessors, AssignmentSetOverride: NewCPUMask(4), MsiSupported: 1},
+		{DeviceDesc: "Without a registry path"},
+		{DeviceDesc: "Intel(R) Ethernet Controller I225-V", RegPath: `HKEY_LOCAL_MACHINE\SYSTEM\CurrentControlSet\Enum\PCI\VEN_8086&DEV_15F3&SUBSYS_86721043&REV_03\6&1f5a4d2c&0&0038020A\Device Parameters`, LocationInformation: "PCI bus 6, device 0, function 0", MsiSupported: 1, MessageNumberLimit: 4},
+	}
+	rf := machineBackup(devices, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC), "TESTPC")
+	checkGolden(t, filepath.Join("testdata", "regfile", "backup.reg"), rf.Marshal())
+}
+
+func mustReadFile(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// InterruptSimulation is the predicted delivery of the interrupts of a device.
+type InterruptSimulation struct {
+	Type     string    // "line based", "MSI" or "MSI-X"
+	Messages int       // the messages Windows allocates, 1 for line based interrupts
+	Targets  CPUMask   // the processors that can receive an interrupt of the device
+	Vectors  []CPUMask // the processors of every message, nil for a single message
+	Notes    []string
+}
+
+// SimulateInterrupts predicts which processors receive the interrupts of dev
+// with its current settings, following the interrupt affinity policies
+// documented by Microsoft:
+// https://learn.microsoft.com/en-us/windows-hardware/drivers/kernel/interrupt-affinity-and-priority
+//
+// numaNode is the NUMA node of the device, -1 if it is unknown. The close
+// processors of a device are the ones of its node, all processors on
+// machines with a single node.
+func SimulateInterrupts(dev *Device, topology *CpuSets, numaNode int) InterruptSimulation {
+	var s InterruptSimulation
+	all := topology.Mask()
+	s.Type, s.Messages = simulateMessages(dev, &s.Notes)
+	if all.IsZero() {
+		s.Notes = append(s.Notes, "the processor topology is unknown")
+		return s
+	}
+
+	nearby := all
+	if topology.NumaNode {
+		if numaNode < 0 {
+			s.Notes = append(s.Notes, "the NUMA node of the device is unknown, all processors count as close")
+		} else {
+			nearby = topology.numaNodeMask(numaNode)
+			if nearby.IsZero() {
+				s.Notes = append(s.Notes, fmt.Sprintf("NUMA node %d has no processors, all processors count as close", numaNode))
+				nearby = all
+			}
+		}
+	}
+
+	switch dev.DevicePolicy {
+	case IrqPolicyMachineDefault:
+		s.Targets = nearby
+		s.Notes = append(s.Notes, "the machine default lets Windows choose, usually like All Close Processors")
+	case IrqPolicyAllCloseProcessors:
+		s.Targets = nearby
+	case IrqPolicyOneCloseProcessor:
+		s.Targets = NewCPUMask(nearby.Processors()[0])
+		s.Notes = append(s.Notes, "Windows chooses the processor, the simulation assumes the first close one")
+	case IrqPolicyAllProcessorsInMachine:
+		s.Targets = all
+	case IrqPolicySpecifiedProcessors:
+		s.Targets = dev.AssignmentSetOverride.Intersect(all).trim()
+		if s.Targets.IsZero() {
+			s.Notes = append(s.Notes, "no existing processor is specified, Windows falls back to the machine default")
+			s.Targets = nearby
+		}
+	case IrqPolicySpreadMessagesAcrossAllProcessors:
+		s.Targets = all
+	default:
+		s.Notes = append(s.Notes, fmt.Sprintf("DevicePolicy %d is not a valid policy, Windows uses the machine default", dev.DevicePolicy))
+		s.Targets = nearby
+	}
+
+	if s.Messages <= 1 {
+		return s
+	}
+	targets := s.Targets.Processors()
+	s.Vectors = make([]CPUMask, s.Messages)
+	switch {
+	case s.Type == "MSI":
+		// MSI messages share one address, so all of them go to the same processors
+		for i := range s.Vectors {
+			s.Vectors[i] = s.Targets
+		}
+		if len(targets) > 1 && dev.DevicePolicy == IrqPolicySpreadMessagesAcrossAllProcessors {
+			s.Notes = append(s.Notes, "plain MSI cannot spread its messages, every message targets the same processors")
+		}
+	case dev.DevicePolicy == IrqPolicySpreadMessagesAcrossAllProcessors, dev.DevicePolicy == IrqPolicySpecifiedProcessors:
+		// MSI-X messages are distributed round robin over the processors
+		for i := range s.Vectors {
+			s.Vectors[i] = NewCPUMask(targets[i%len(targets)])
+		}
+		if s.Messages > len(targets) {
+			s.Notes = append(s.Notes, fmt.Sprintf("%d messages share %d processors", s.Messages, len(targets)))
+		}
+	default:
+		for i := range s.Vectors {
+			s.Vectors[i] = s.Targets
+		}
+	}
+	return s
+}
+
+// numaNodeMask returns the processors of the NUMA node number node. The
+// NumaNodeIndex of a CPU set is not the node number, without the nodes of
+// GetLogicalProcessorInformationEx the nodes are counted in the order of
+// the processors.
+func (cs *CpuSets) numaNodeMask(node int) CPUMask {
+	if cs.Relations != nil && len(cs.Relations.NumaNodes) != 0 {
+		for _, n := range cs.Relations.NumaNodes {
+			if int(n.Node) == node {
+				return n.Processors
+			}
+		}
+		return nil
+	}
+	nodes := cs.domains(func(cpu CpuSet) byte { return cpu.NumaNodeIndex })
+	if node >= len(nodes) {
+		return nil
+	}
+	return NewCPUMask(nodes[node]...)
+}
+
+// simulateMessages returns the interrupt type and the number of messages of dev.
+func simulateMessages(dev *Device, notes *[]string) (string, int) {
+	msi, msix := Has(dev.InterruptTypeMap, 2), Has(dev.InterruptTypeMap, 4)
+	if dev.MsiSupported != 1 || !msi && !msix {
+		return "line based", 1
+	}
+
+	messages := int(dev.MaxMSILimit)
+	if messages == 0 {
+		*notes = append(*notes, "the device does not report how many messages it supports, one is assumed")
+		messages = 1
+	}
+	if dev.MessageNumberLimit != 0 {
+		messages = min(messages, int(dev.MessageNumberLimit))
+	}
+	if msix {
+		return "MSI-X", min(messages, 2048)
+	}
+	// MSI allocates a power of two, up to 32
+	allocated := 1
+	for allocated*2 <= min(messages, 32) {
+		allocated *= 2
+	}
+	if allocated != messages {
+		*notes = append(*notes, fmt.Sprintf("MSI allocates a power of two, %d instead of %d messages", allocated, messages))
+	}
+	return "MSI", allocated
+}
+
+const maxSimulationVectors = 32 // MSI-X devices can have up to 2048 messages
+
+// Lines describes the simulation for the dialog and the simulate command.
+func (s *InterruptSimulation) Lines() []string {
+	lines := []string{
+		fmt.Sprintf("%s, %d message(s) to processor(s) %s", s.Type, s.Messages, formatProcessors(s.Targets)),
+	}
+	for i, vector := range s.Vectors {
+		if i == maxSimulationVectors {
+			lines = append(lines, fmt.Sprintf("  ... %d more", len(s.Vectors)-i))
+			break
+		}
+		lines = append(lines, fmt.Sprintf("  message %d: %s", i, formatProcessors(vector)))
+	}
+	for _, note := range s.Notes {
+		lines = append(lines, "note: "+note)
+	}
+	return lines
+}
+
+// formatProcessors lists the processors of mask with ranges, e.g. "0-3,8".
+func formatProcessors(mask CPUMask) string {
+	processors := mask.Processors()
+	var parts []string
+	for i := 0; i < len(processors); {
+		j := i
+		for j+1 < len(processors) && processors[j+1] == processors[j]+1 {
+			j++
+		}
+		if j == i {
+			parts = append(parts, strconv.Itoa(processors[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", processors[i], processors[j]))
+		}
+		i = j + 1
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// simulationVectors formats the processors of every message.
+func simulationVectors(s InterruptSimulation) []string {
+	var vectors []string
+	for _, vector := range s.Vectors {
+		vectors = append(vectors, formatProcessors(vector))
+	}
+	return vectors
+}
+
+func TestSimulatePolicies(t *testing.T) {
+	useTopologyFixture(t, "numa-2ccd-12-core.json") // node 0 has processors 0-5, node 1 6-11
+	msix := func(policy uint32, processors ...int) Device {
+		return Device{DevicePolicy: policy, AssignmentSetOverride: NewCPUMask(processors...), InterruptTypeMap: 6, MsiSupported: 1, MaxMSILimit: 4}
+	}
+	tests := []struct {
+		name    string
+		dev     Device
+		targets string
+		vectors []string
+		note    string
+	}{
+		{"machine default", msix(IrqPolicyMachineDefault), "6-11", []string{"6-11", "6-11", "6-11", "6-11"}, "the machine default"},
+		{"all close", msix(IrqPolicyAllCloseProcessors), "6-11", []string{"6-11", "6-11", "6-11", "6-11"}, ""},
+		{"one close", msix(IrqPolicyOneCloseProcessor), "6", []string{"6", "6", "6", "6"}, "the first close one"},
+		{"all in machine", msix(IrqPolicyAllProcessorsInMachine), "0-11", []string{"0-11", "0-11", "0-11", "0-11"}, ""},
+		{"specified", msix(IrqPolicySpecifiedProcessors, 2, 3), "2-3", []string{"2", "3", "2", "3"}, "4 messages share 2 processors"},
+		{"specified and missing", msix(IrqPolicySpecifiedProcessors, 3, 70), "3", []string{"3", "3", "3", "3"}, "4 messages share 1 processors"},
+		{"specified without an existing processor", msix(IrqPolicySpecifiedProcessors, 70), "6-11", []string{"6", "7", "8", "9"}, "falls back to the machine default"},
+		{"spread", msix(IrqPolicySpreadMessagesAcrossAllProcessors), "0-11", []string{"0", "1", "2", "3"}, ""},
+		{"invalid policy", msix(6), "6-11", []string{"6-11", "6-11", "6-11", "6-11"}, "not a valid policy"},
+		{"spread with plain MSI", Device{DevicePolicy: IrqPolicySpreadMessagesAcrossAllProcessors, InterruptTypeMap: 2, MsiSupported: 1, MaxMSILimit: 2}, "0-11", []string{"0-11", "0-11"}, "plain MSI cannot spread"},
+		{"line based", Device{DevicePolicy: IrqPolicySpecifiedProcessors, AssignmentSetOverride: NewCPUMask(4), InterruptTypeMap: 1}, "4", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := SimulateInterrupts(&tt.dev, &cs, 1)
+			if got := formatProcessors(s.Targets); got != tt.targets {
+				t.Errorf("targets %s, want %s", got, tt.targets)
+			}
+			if got := simulationVectors(s); !reflect.DeepEqual(got, tt.vectors) {
+				t.Errorf("vectors %q, want %q", got, tt.vectors)
+			}
+			notes := strings.Join(s.Notes, "\n")
+			if tt.note != "" && !strings.Contains(notes, tt.note) || tt.note == "" && notes != "" {
+				t.Errorf("notes %q, want %q", notes, tt.note)
+			}
+		})
+	}
+}
+
+func TestSimulateMessages(t *testing.T) {
+	tests := []struct {
+		name          string
+		typeMap       Bits
+		msiSupported  uint32
+		limit, max    uint32
+		interruptType string
+		messages      int
+		note          string
+	}{
+		{"line based only", 1, 1, 0, 0, "line based", 1, ""},
+		{"MSI off", 2, 0, 0, 8, "line based", 1, ""},
+		{"MSI", 2, 1, 0, 8, "MSI", 8, ""},
+		{"MSI limit", 2, 1, 2, 8, "MSI", 2, ""},
+		{"MSI limit above the maximum", 2, 1, 16, 8, "MSI", 8, ""},
+		{"MSI limit not a power of two", 2, 1, 6, 8, "MSI", 4, "4 instead of 6 messages"},
+		{"MSI above 32 messages", 2, 1, 0, 64, "MSI", 32, "32 instead of 64 messages"},
+		{"unknown maximum", 2, 1, 8, 0, "MSI", 1, "one is assumed"},
+		{"MSI-X", 6, 1, 0, 16, "MSI-X", 16, ""},
+		{"MSI-X limit", 6, 1, 3, 16, "MSI-X", 3, ""},
+		{"MSI-X above 2048 messages", 4, 1, 0, 4096, "MSI-X", 2048, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := Device{InterruptTypeMap: tt.typeMap, MsiSupported: tt.msiSupported, MessageNumberLimit: tt.limit, MaxMSILimit: tt.max}
+			var notes []string
+			interruptType, messages := simulateMessages(&dev, &notes)
+			if interruptType != tt.interruptType || messages != tt.messages {
+				t.Errorf("%s with %d messages, want %s with %d", interruptType, messages, tt.interruptType, tt.messages)
+			}
+			if got := strings.Join(notes, "\n"); tt.note != "" && !strings.Contains(got, tt.note) || tt.note == "" && got != "" {
+				t.Errorf("notes %q, want %q", got, tt.note)
+			}
+		})
+	}
+}
+
+func TestSimulateNumaNodes(t *testing.T) {
+	dev := Device{DevicePolicy: IrqPolicyAllCloseProcessors, InterruptTypeMap: 1}
+	useTopologyFixture(t, "numa-2ccd-12-core.json")
+	tests := []struct {
+		node    int
+		targets string
+		note    string
+	}{
+		{0, "0-5", ""},
+		{1, "6-11", ""},
+		{-1, "0-11", "the NUMA node of the device is unknown"},
+		{2, "0-11", "NUMA node 2 has no processors"},
+	}
+	for _, tt := range tests {
+		s := SimulateInterrupts(&dev, &cs, tt.node)
+		if got := formatProcessors(s.Targets); got != tt.targets {
+			t.Errorf("node %d: targets %s, want %s", tt.node, got, tt.targets)
+		}
+		if notes := strings.Join(s.Notes, "\n"); tt.note != "" && !strings.Contains(notes, tt.note) || tt.note == "" && notes != "" {
+			t.Errorf("node %d: notes %q, want %q", tt.node, notes, tt.note)
+		}
+	}
+
+	// node numbers of GetLogicalProcessorInformationEx, node 1 is empty
+	cs.Relations = &ProcessorRelations{NumaNodes: []NumaNodeRelation{
+		{Node: 0, Processors: NewCPUMask(6, 7, 8, 9, 10, 11)},
+		{Node: 1},
+		{Node: 3, Processors: NewCPUMask(0, 1, 2, 3, 4, 5)},
+	}}
+	for node, want := range map[int]string{0: "6-11", 1: "0-11", 2: "0-11", 3: "0-5"} {
+		if got := formatProcessors(SimulateInterrupts(&dev, &cs, node).Targets); got != want {
+			t.Errorf("node %d of the relations: targets %s, want %s", node, got, want)
+		}
+	}
+
+	// a single node makes every processor close
+	useTopologyFixture(t, "intel-core-i9-13900.json")
+	if got := formatProcessors(SimulateInterrupts(&dev, &cs, 1).Targets); got != "0-31" {
+		t.Errorf("targets %s with a single node", got)
+	}
+	if s := SimulateInterrupts(&dev, &CpuSets{}, 0); s.Targets != nil || len(s.Notes) != 1 {
+		t.Errorf("without a topology: targets %v, notes %q", s.Targets, s.Notes)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// TopologyCapture is the raw processor information of a machine, written by
+// the capture command for bug reports. Debug builds replay it with
+// -topology-file instead of calling kernel32, so the layout of the dialog
+// and the presets can be reproduced on any machine.
+type TopologyCapture struct {
+	Time                 time.Time       `json:"time"`
+	CpuSetInformation    []byte          `json:"cpuSetInformation"`                     // the buffer of GetSystemCpuSetInformation
+	ProcessorInformation []byte          `json:"logicalProcessorInformation,omitempty"` // the buffer of GetLogicalProcessorInformationEx(RelationAll)
+	SystemInfo           SystemInfo      `json:"systemInfo"`
+	CPUID                []CPUIDLeaf     `json:"cpuid"` // of the processor the capture ran on
+	Expect               *TopologyExpect `json:"expect,omitempty"`
+}
+
+type CPUIDLeaf struct {
+	Leaf    uint32 `json:"leaf"`
+	Subleaf uint32 `json:"subleaf"`
+	EAX     uint32 `json:"eax"`
+	EBX     uint32 `json:"ebx"`
+	ECX     uint32 `json:"ecx"`
+	EDX     uint32 `json:"edx"`
+}
+
+// captureTopology reads the processor information of the running system.
+func captureTopology() (*TopologyCapture, error) {
+	data, err := cpuSetInformation()
+	if err != nil {
+		return nil, err
+	}
+	relations, err := logicalProcessorInformation()
+	if err != nil {
+		return nil, err
+	}
+	return &TopologyCapture{
+		Time:                 time.Now(),
+		CpuSetInformation:    data,
+		ProcessorInformation: relations,
+		SystemInfo:           GetSystemInfo(),
+		CPUID:                captureCPUID(),
+	}, nil
+}
+
+// captureCPUID reads the standard and extended CPUID leaves, with the
+// subleaves of the cache and topology leaves.
+func captureCPUID() []CPUIDLeaf {
+	var leaves []CPUIDLeaf
+	read := func(leaf, subleaf uint32) CPUIDLeaf {
+		eax, ebx, ecx, edx := cpuidLow(leaf, subleaf)
+		l := CPUIDLeaf{leaf, subleaf, eax, ebx, ecx, edx}
+		leaves = append(leaves, l)
+		return l
+	}
+	// the subleaves of a leaf up to the one for which last is true
+	subleaves := func(leaf uint32, last func(CPUIDLeaf) bool) {
+		for subleaf := uint32(0); subleaf < 64; subleaf++ {
+			if last(read(leaf, subleaf)) {
+				return
+			}
+		}
+	}
+	cacheType := func(l CPUIDLeaf) bool { return l.EAX&0x1f == 0 }
+	levelType := func(l CPUIDLeaf) bool { return (l.ECX>>8)&0xff == 0 }
+
+	// the limits protect against garbage in the maximum leaf
+	maxLeaf := min(read(0, 0).EAX, 0x40)
+	for leaf := uint32(1); leaf <= maxLeaf; leaf++ {
+		switch leaf {
+		case 0x4: // deterministic cache parameters
+			subleaves(leaf, cacheType)
+		case 0x7:
+			last := min(read(leaf, 0).EAX, 8)
+			for subleaf := uint32(1); subleaf <= last; subleaf++ {
+				read(leaf, subleaf)
+			}
+		case 0xb, 0x1f: // extended topology
+			subleaves(leaf, levelType)
+		default:
+			read(leaf, 0)
+		}
+	}
+
+	maxExtended := min(read(0x80000000, 0).EAX, 0x80000040)
+	for leaf := uint32(0x80000001); leaf <= maxExtended; leaf++ {
+		switch leaf {
+		case 0x8000001d: // AMD cache topology
+			subleaves(leaf, cacheType)
+		case 0x80000026: // AMD extended topology
+			subleaves(leaf, levelType)
+		default:
+			read(leaf, 0)
+		}
+	}
+	return leaves
+}
+
+func LoadTopologyCapture(path string) (*TopologyCapture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var capture TopologyCapture
+	if err := json.Unmarshal(data, &capture); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &capture, nil
+}
+
+func (c *TopologyCapture) Write(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
+
+func (c *TopologyCapture) cpuid(leaf, subleaf uint32) (CPUIDLeaf, bool) {
+	for _, l := range c.CPUID {
+		if l.Leaf == leaf && l.Subleaf == subleaf {
+			return l, true
+		}
+	}
+	return CPUIDLeaf{}, false
+}
+
+// Vendor decodes the vendor string of leaf 0, e.g. "GenuineIntel".
+func (c *TopologyCapture) Vendor() string {
+	l, ok := c.cpuid(0, 0)
+	if !ok {
+		return ""
+	}
+	b := make([]byte, 12)
+	binary.LittleEndian.PutUint32(b[0:], l.EBX)
+	binary.LittleEndian.PutUint32(b[4:], l.EDX)
+	binary.LittleEndian.PutUint32(b[8:], l.ECX)
+	return string(b)
+}
+
+// Brand decodes the brand string of the leaves 0x80000002 to 0x80000004.
+func (c *TopologyCapture) Brand() string {
+	var b []byte
+	for leaf := uint32(0x80000002); leaf <= 0x80000004; leaf++ {
+		l, ok := c.cpuid(leaf, 0)
+		if !ok {
+			return ""
+		}
+		for _, r := range []uint32{l.EAX, l.EBX, l.ECX, l.EDX} {
+			b = binary.LittleEndian.AppendUint32(b, r)
+		}
+	}
+	return strings.TrimSpace(strings.TrimRight(string(b), "\x00"))
+}
+
+// Fixture decodes the processors of the capture.
+func (c *TopologyCapture) Fixture() (*TopologyFixture, error) {
+	cpus, err := decodeCpuSetInformation(c.CpuSetInformation)
+	if err != nil {
+		return nil, err
+	}
+	var relations *ProcessorRelations
+	if len(c.ProcessorInformation) != 0 { // older captures have none
+		if relations, err = parseProcessorRelations(c.ProcessorInformation); err != nil {
+			return nil, err
+		}
+	}
+	name := c.Brand()
+	if name == "" {
+		name = c.Vendor()
+	}
+	return &TopologyFixture{
+		Name:      name,
+		CPUs:      cpus,
+		Relations: relations,
+		Expect:    c.Expect,
+	}, nil
+}
+
+// Replay makes the capture the processor of this process: the vendor and
+// brand used for the group titles and the system information.
+func (c *TopologyCapture) Replay() {
+	cpuVendor = c.Vendor()
+	cpuBrand = c.Brand()
+	sysInfo = c.SystemInfo
+}
+
+// openTopologyFile reads a fixture or a capture, capture is nil for a fixture.
+func openTopologyFile(path string) (*TopologyFixture, *TopologyCapture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, ok := keys["cpuSetInformation"]; !ok {
+		fixture, err := LoadTopologyFixture(path)
+		return fixture, nil, err
+	}
+
+	capture, err := LoadTopologyCapture(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	fixture, err := capture.Fixture()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(fixture.CPUs) == 0 {
+		return nil, nil, fmt.Errorf("%s: no cpus in cpuSetInformation", path)
+	}
+	return fixture, capture, nil
+}
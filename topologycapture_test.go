@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var topologyCaptureFile = filepath.Join("testdata", "topology", "intel-core-i9-13900.capture.json")
+
+// encodeCpuSetInformation writes cpus as a GetSystemCpuSetInformation
+// buffer, every record padded to size bytes.
+func encodeCpuSetInformation(cpus []CpuSet, size int) []byte {
+	var data []byte
+	for _, cpu := range cpus {
+		record := make([]byte, size)
+		binary.LittleEndian.PutUint32(record[0:], uint32(size))
+		binary.LittleEndian.PutUint32(record[4:], uint32(CpuSetInformation))
+		binary.LittleEndian.PutUint32(record[8:], cpu.Id)
+		binary.LittleEndian.PutUint16(record[12:], cpu.Group)
+		record[14] = cpu.LogicalProcessorIndex
+		record[15] = cpu.CoreIndex
+		record[16] = cpu.LastLevelCacheIndex
+		record[17] = cpu.NumaNodeIndex
+		record[18] = cpu.EfficiencyClass
+		record[19] = byte(cpu.Flags)
+		data = append(data, record...)
+	}
+	return data
+}
+
+// cpuidString returns the CPUID leaves from leaf on that hold s in the
+// registers EAX, EBX, ECX and EDX, like the brand string does.
+func cpuidString(leaf uint32, s string) []CPUIDLeaf {
+	b := make([]byte, (len(s)+15)&^15)
+	copy(b, s)
+	var leaves []CPUIDLeaf
+	for ; len(b) != 0; b = b[16:] {
+		r := func(i int) uint32 { return binary.LittleEndian.Uint32(b[4*i:]) }
+		leaves = append(leaves, CPUIDLeaf{Leaf: leaf, EAX: r(0), EBX: r(1), ECX: r(2), EDX: r(3)})
+		leaf++
+	}
+	return leaves
+}
+
+// writeTopologyCapture writes the capture of the i9-13900 fixture. The
+// records of a later Windows are larger, the capture uses 40 bytes so the
+// test covers that they are skipped by their size.
+func writeTopologyCapture(t *testing.T, fixture *TopologyFixture) {
+	register := func(s string) uint32 { return binary.LittleEndian.Uint32([]byte(s)) }
+	vendor := CPUIDLeaf{Leaf: 0, EAX: 0x20, EBX: register("Genu"), EDX: register("ineI"), ECX: register("ntel")}
+	capture := &TopologyCapture{
+		Time:              time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		CpuSetInformation: encodeCpuSetInformation(fixture.CPUs, 40),
+		SystemInfo: SystemInfo{
+			Arch:                      9, // PROCESSOR_ARCHITECTURE_AMD64
+			PageSize:                  4096,
+			MinimumApplicationAddress: 0x10000,
+			MaximumApplicationAddress: 0x7FFFFFFEFFFF,
+			ActiveProcessorMask:       0xFFFFFFFF,
+			NumberOfProcessors:        32,
+			ProcessorType:             8664,
+			AllocationGranularity:     65536,
+			ProcessorLevel:            6,
+			ProcessorRevision:         0xB701,
+		},
+		CPUID:  append([]CPUIDLeaf{vendor, {Leaf: 0x80000000, EAX: 0x80000008}}, cpuidString(0x80000002, "13th Gen Intel(R) Core(TM) i9-13900")...),
+		Expect: fixture.Expect,
+	}
+	if err := os.MkdirAll(filepath.Dir(topologyCaptureFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := capture.Write(topologyCaptureFile); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTopologyCaptureReplay replays a capture like -topology-file and
+// compares the processors with the fixture of the same CPU.
+func TestTopologyCaptureReplay(t *testing.T) {
+	want, err := LoadTopologyFixture(filepath.Join("fixtures", "topology", "intel-core-i9-13900.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *update {
+		writeTopologyCapture(t, want)
+	}
+
+	fixture, capture, err := openTopologyFile(topologyCaptureFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if capture == nil {
+		t.Fatal("the capture was read as a fixture")
+	}
+	if fixture.Name != "13th Gen Intel(R) Core(TM) i9-13900" {
+		t.Errorf("name %q", fixture.Name)
+	}
+	if !reflect.DeepEqual(fixture.CPUs, want.CPUs) {
+		t.Errorf("processors\n%+v\nwant\n%+v", fixture.CPUs, want.CPUs)
+	}
+	for _, diff := range fixture.Check() {
+		t.Error(diff)
+	}
+
+	var got, wantSets CpuSets
+	got.Load(fixture.CPUs, fixture.Relations)
+	wantSets.Load(want.CPUs, want.Relations)
+	if !reflect.DeepEqual(got, wantSets) {
+		t.Errorf("CpuSets\n%+v\nwant\n%+v", got, wantSets)
+	}
+
+	vendor, brand, info := cpuVendor, cpuBrand, sysInfo
+	t.Cleanup(func() { cpuVendor, cpuBrand, sysInfo = vendor, brand, info })
+	capture.Replay()
+	if !isIntel() || cpuName() != fixture.Name || sysInfo.NumberOfProcessors != 32 {
+		t.Errorf("replayed vendor %q, brand %q, %d processors", cpuVendor, cpuBrand, sysInfo.NumberOfProcessors)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// TopologyFixture is a processor topology stored in a file. The fixtures in
+// fixtures/topology describe known CPUs, "topology -o" writes the one of
+// the running system for bug reports and debug builds load one with
+// -topology-file.
+type TopologyFixture struct {
+	Name      string              `json:"name"`
+	CPUs      []CpuSet            `json:"cpus"`
+	Relations *ProcessorRelations `json:"relations,omitempty"`
+	Expect    *TopologyExpect     `json:"expect,omitempty"`
+}
+
+// TopologyExpect is what CpuSets.Load has to derive from the processors of a fixture.
+type TopologyExpect struct {
+	HyperThreading    bool         `json:"hyperThreading"`
+	EfficiencyClass   bool         `json:"efficiencyClass"`
+	LastLevelCache    bool         `json:"lastLevelCache"`
+	NumaNode          bool         `json:"numaNode"`
+	MaxThreadsPerCore int          `json:"maxThreadsPerCore"`
+	Layout            []CoreLayout `json:"layout"`
+}
+
+func newTopologyExpect(cs *CpuSets) *TopologyExpect {
+	return &TopologyExpect{
+		HyperThreading:    cs.HyperThreading,
+		EfficiencyClass:   cs.EfficiencyClass,
+		LastLevelCache:    cs.LastLevelCache,
+		NumaNode:          cs.NumaNode,
+		MaxThreadsPerCore: cs.MaxThreadsPerCore,
+		Layout:            cs.Layout,
+	}
+}
+
+func LoadTopologyFixture(path string) (*TopologyFixture, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fixture TopologyFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(fixture.CPUs) == 0 {
+		return nil, fmt.Errorf("%s: no cpus", path)
+	}
+	seen := map[int]bool{}
+	for _, cpu := range fixture.CPUs {
+		if seen[cpu.Processor()] {
+			return nil, fmt.Errorf("%s: processor %d (group %d, logical %d) is listed twice", path, cpu.Processor(), cpu.Group, cpu.LogicalProcessorIndex)
+		}
+		seen[cpu.Processor()] = true
+	}
+	if fixture.Name == "" {
+		fixture.Name = filepath.Base(path)
+	}
+	return &fixture, nil
+}
+
+// newTopologyFixture describes the topology cs, with its derived values as the expectation.
+func newTopologyFixture(name string, cs *CpuSets) *TopologyFixture {
+	return &TopologyFixture{
+		Name:      name,
+		CPUs:      cs.CPU,
+		Relations: cs.Relations,
+		Expect:    newTopologyExpect(cs),
+	}
+}
+
+// Write saves the fixture with one processor, core or cache per line, so
+// fixtures stay readable and diffable.
+func (f *TopologyFixture) Write(path string) error {
+	var b bytes.Buffer
+	name, _ := json.Marshal(f.Name)
+	fmt.Fprintf(&b, "{\n  \"name\": %s,\n  \"cpus\": ", name)
+	cpus := make([]any, len(f.CPUs))
+	for i := range f.CPUs {
+		cpus[i] = f.CPUs[i]
+	}
+	if err := writeJSONLines(&b, "  ", cpus); err != nil {
+		return err
+	}
+
+	if f.Relations != nil {
+		r := f.Relations
+		b.WriteString(",\n  \"relations\": {")
+		for i, list := range []struct {
+			name  string
+			items any
+		}{
+			{"cores", r.Cores},
+			{"caches", r.Caches},
+			{"packages", r.Packages},
+			{"dies", r.Dies},
+			{"modules", r.Modules},
+			{"numaNodes", r.NumaNodes},
+			{"groups", r.Groups},
+		} {
+			v := reflect.ValueOf(list.items)
+			items := make([]any, v.Len())
+			for i := range items {
+				items[i] = v.Index(i).Interface()
+			}
+			if i != 0 {
+				b.WriteByte(',')
+			}
+			fmt.Fprintf(&b, "\n    %q: ", list.name)
+			if err := writeJSONLines(&b, "    ", items); err != nil {
+				return err
+			}
+		}
+		b.WriteString("\n  }")
+	}
+
+	if f.Expect != nil {
+		expect, err := json.Marshal(f.Expect)
+		if err != nil {
+			return err
+		}
+		b.WriteString(",\n  \"expect\": ")
+		b.Write(expect)
+	}
+	b.WriteString("\n}\n")
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+// writeJSONLines writes a JSON array with one item per line.
+func writeJSONLines(b *bytes.Buffer, indent string, items []any) error {
+	if len(items) == 0 {
+		b.WriteString("[]")
+		return nil
+	}
+	b.WriteString("[\n")
+	for i, item := range items {
+		line, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		b.WriteString(indent + "  ")
+		b.Write(line)
+		if i != len(items)-1 {
+			b.WriteByte(',')
+		}
+		b.WriteByte('\n')
+	}
+	b.WriteString(indent + "]")
+	return nil
+}
+
+// Check loads the processors of the fixture and returns the differences to
+// its expectation, nil if it has none.
+func (f *TopologyFixture) Check() []string {
+	if f.Expect == nil {
+		return nil
+	}
+	var topology CpuSets
+	topology.Load(f.CPUs, f.Relations)
+	got := newTopologyExpect(&topology)
+
+	var diffs []string
+	want := reflect.ValueOf(*f.Expect)
+	have := reflect.ValueOf(*got)
+	for i := 0; i < want.NumField(); i++ {
+		if !reflect.DeepEqual(want.Field(i).Interface(), have.Field(i).Interface()) {
+			diffs = append(diffs, fmt.Sprintf("%s: got %v, want %v", want.Type().Field(i).Name, have.Field(i).Interface(), want.Field(i).Interface()))
+		}
+	}
+	return diffs
+}
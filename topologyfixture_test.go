@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// topologyCounts are the numbers of processors, cores, SMT threads, last
+// level caches, NUMA nodes and efficiency classes of a topology.
+type topologyCounts struct {
+	processors, cores, smtThreads, caches, numaNodes, classes int
+}
+
+func countTopology(topology *CpuSets) topologyCounts {
+	caches, nodes, classes := map[[2]int]bool{}, map[[2]int]bool{}, map[byte]bool{}
+	for _, cpu := range topology.CPU {
+		caches[[2]int{int(cpu.Group), int(cpu.LastLevelCacheIndex)}] = true
+		nodes[[2]int{int(cpu.Group), int(cpu.NumaNodeIndex)}] = true
+		classes[cpu.EfficiencyClass] = true
+	}
+	return topologyCounts{
+		processors: len(topology.CPU),
+		cores:      len(topology.cores()),
+		smtThreads: len(topology.smtThreads().Processors()),
+		caches:     len(caches),
+		numaNodes:  len(nodes),
+		classes:    len(classes),
+	}
+}
+
+// TestTopologyFixtures loads every fixture, compares it with its own
+// expectation and with the hardware the fixture describes.
+func TestTopologyFixtures(t *testing.T) {
+	want := map[string]topologyCounts{
+		"8-threads.json":                 {processors: 8, cores: 8, caches: 1, numaNodes: 1, classes: 1},
+		"amd-ryzen-9-5900x.json":         {processors: 24, cores: 12, smtThreads: 12, caches: 2, numaNodes: 1, classes: 1},
+		"intel-core-i5-13600kf.json":     {processors: 20, cores: 14, smtThreads: 6, caches: 1, numaNodes: 1, classes: 2},
+		"intel-core-i9-13900-no-ht.json": {processors: 24, cores: 24, caches: 1, numaNodes: 1, classes: 2},
+		"intel-core-i9-13900.json":       {processors: 32, cores: 24, smtThreads: 8, caches: 1, numaNodes: 1, classes: 2},
+		"numa-2ccd-12-core.json":         {processors: 12, cores: 12, caches: 2, numaNodes: 2, classes: 1},
+	}
+
+	files, err := filepath.Glob(filepath.Join("fixtures", "topology", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(want) {
+		t.Errorf("%d fixtures, want %d", len(files), len(want))
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			fixture, err := LoadTopologyFixture(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fixture.Expect == nil {
+				t.Fatal("the fixture has no expectation")
+			}
+			for _, diff := range fixture.Check() {
+				t.Error(diff)
+			}
+
+			var topology CpuSets
+			topology.Load(fixture.CPUs, fixture.Relations)
+			counts, ok := want[filepath.Base(file)]
+			if !ok {
+				t.Fatal("no counts for the fixture")
+			}
+			if got := countTopology(&topology); got != counts {
+				t.Errorf("counts %+v, want %+v", got, counts)
+			}
+			// the flags have to agree with the counts
+			if topology.HyperThreading != (counts.smtThreads != 0) || topology.LastLevelCache != (counts.caches > 1) ||
+				topology.NumaNode != (counts.numaNodes > 1) || topology.EfficiencyClass != (counts.classes > 1) {
+				t.Errorf("flags %+v do not match %+v", newTopologyExpect(&topology), counts)
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/spddl/GoInterruptPolicy/tui"
+)
+
+// tuiBackend connects the terminal interface to the devices. Apply and
+// restart work like in the main window: a Plan is applied, or only shown
+// with -dry-run, and the device source restarts the device.
+type tuiBackend struct {
+	devices []Device
+}
+
+func (b *tuiBackend) find(id string) (*Device, error) {
+	for i := range b.devices {
+		if deviceID(&b.devices[i]) == id {
+			return &b.devices[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w %q", errNoDevice, id)
+}
+
+func (b *tuiBackend) Devices() ([]tui.Device, error) {
+	devices := make([]tui.Device, len(b.devices))
+	for i := range b.devices {
+		devices[i] = newTUIDevice(&b.devices[i])
+	}
+	return devices, nil
+}
+
+func newTUIDevice(dev *Device) tui.Device {
+	location := dev.LocationInformation
+	if dev.PCI.Valid {
+		location = fmt.Sprintf("%s (PCI %s)", location, dev.PCI)
+	}
+	d := tui.Device{
+		ID:             deviceID(dev),
+		Name:           dev.DeviceDesc,
+		FriendlyName:   dev.FriendlyName,
+		Location:       location,
+		InterruptTypes: interruptTypes(dev.InterruptTypeMap),
+		HasMSI:         dev.MsiSupported != 2,
+		MaxMSILimit:    dev.MaxMSILimit,
+		Settings: tui.Settings{
+			MSISupported:       dev.MsiSupported == 1,
+			MessageNumberLimit: dev.MessageNumberLimit,
+			DevicePolicy:       dev.DevicePolicy,
+			DevicePriority:     dev.DevicePriority,
+			CPUs:               dev.AssignmentSetOverride.Processors(),
+		},
+	}
+	for _, field := range [][2]string{
+		{"Class", deviceClass(dev)},
+		{"Service", dev.Driver},
+		{"Instance ID", dev.InstanceID},
+		{"Hardware ID", firstString(dev.DeviceIDs)},
+		{"Parent", dev.Parent},
+		{"Container ID", dev.ContainerID},
+		{"NUMA Node", numaNodeName(dev.NumaNode)},
+	} {
+		if field[1] != "" {
+			d.Identity = append(d.Identity, field[0]+": "+field[1])
+		}
+	}
+	if dev.State != 0 {
+		d.State = dev.State.String()
+		d.NoRestart = restartSkipped(dev)
+	}
+	if dev.RebootRequired {
+		d.Findings = append(d.Findings, "Windows needs a reboot before the device works with its current settings")
+	}
+	findings := lintDevice(dev, &cs)
+	for _, finding := range findings {
+		d.Findings = append(d.Findings, fmt.Sprintf("%s: %s [%s]", finding.Severity, finding.Message, finding.Rule))
+	}
+	severity, found := maxSeverity(findings)
+	d.HasErrors = found && severity == SeverityError
+	return d
+}
+
+// Topology lists the processors core by core, so the threads of a core are
+// next to each other in the grid.
+func (b *tuiBackend) Topology() tui.Topology {
+	index := func(key func(CpuSet) byte) map[int]int {
+		m := map[int]int{}
+		for i, domain := range cs.domains(key) {
+			for _, p := range domain {
+				m[p] = i
+			}
+		}
+		return m
+	}
+	llc := index(func(cpu CpuSet) byte { return cpu.LastLevelCacheIndex })
+	numa := index(func(cpu CpuSet) byte { return cpu.NumaNodeIndex })
+
+	byProcessor := map[int]CpuSet{}
+	for _, cpu := range cs.CPU {
+		byProcessor[cpu.Processor()] = cpu
+	}
+
+	t := tui.Topology{
+		HyperThreading:    cs.HyperThreading,
+		EfficiencyClasses: cs.EfficiencyClass,
+		LastLevelCaches:   cs.LastLevelCache,
+		NumaNodes:         cs.NumaNode,
+	}
+	for core, threads := range cs.cores() {
+		for thread, p := range threads {
+			t.CPUs = append(t.CPUs, tui.CPU{
+				Processor:       p,
+				Group:           int(byProcessor[p].Group),
+				Core:            core,
+				Thread:          thread,
+				LLC:             llc[p],
+				NUMA:            numa[p],
+				EfficiencyClass: int(byProcessor[p].EfficiencyClass),
+				State:           byProcessor[p].Flags.String(),
+				Unavailable:     byProcessor[p].Flags.Parked() || byProcessor[p].Flags.Reserved(),
+			})
+		}
+	}
+	return t
+}
+
+func (b *tuiBackend) Apply(id string, settings tui.Settings) (tui.ApplyResult, error) {
+	dev, err := b.find(id)
+	if err != nil {
+		return tui.ApplyResult{}, err
+	}
+
+	after := *dev
+	if dev.MsiSupported != 2 {
+		after.MsiSupported = 0
+		if settings.MSISupported {
+			after.MsiSupported = 1
+		}
+	}
+	after.MessageNumberLimit = settings.MessageNumberLimit
+	after.DevicePolicy = settings.DevicePolicy
+	after.DevicePriority = settings.DevicePriority
+	after.AssignmentSetOverride = nil
+	for _, p := range settings.CPUs {
+		after.AssignmentSetOverride = after.AssignmentSetOverride.Set(p)
+	}
+
+	var plan Plan
+	if err := plan.Add(dev, &after); err != nil {
+		return tui.ApplyResult{}, err
+	}
+	result := tui.ApplyResult{
+		Changed:    msiChanged(dev, &after) || affinityChanged(dev, &after),
+		DryRun:     flagDryRun,
+		Operations: plan.Lines(),
+	}
+	if !flagDryRun {
+		if err := plan.Apply(); err != nil {
+			return tui.ApplyResult{}, err
+		}
+		*dev = after
+		if !canRestart() && result.Changed {
+			dev.RebootRequired = true
+		}
+	}
+	result.Device = newTUIDevice(dev)
+	return result, nil
+}
+
+// CanRestart is false for an offline hive, its changes take effect at the next boot.
+func (b *tuiBackend) CanRestart() bool {
+	return canRestart()
+}
+
+func (b *tuiBackend) Restart(id string) (bool, error) {
+	dev, err := b.find(id)
+	if err != nil {
+		return false, err
+	}
+	if reason := restartSkipped(dev); reason != "" {
+		return false, errors.New(reason)
+	}
+	needReboot, err := source.Restart(dev)
+	if err != nil {
+		return false, err
+	}
+	dev.RebootRequired = needReboot
+	return needReboot, nil
+}
+
+func tuiCommand(name string, args []string) int {
+	fs := newFlagSet(name)
+	addDryRunFlag(fs)
+	if _, code, ok := parseArgs(fs, args, 0, 0); !ok {
+		return code
+	}
+
+	devices, code, ok := loadDevicesCLI()
+	if !ok {
+		return code
+	}
+
+	term, err := tui.OpenTerminal()
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	err = tui.Run(&tuiBackend{devices: devices}, term)
+	if err := term.Restore(); err != nil {
+		log.Println(err)
+	}
+	if err != nil {
+		log.Println(err)
+		return exitError
+	}
+	return exitOK
+}
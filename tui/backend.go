@@ -0,0 +1,108 @@
+// Package tui is a full screen terminal user interface for headless and
+// remote shell sessions. It mirrors the main window: a searchable device
+// table, an editor for the interrupt settings of a device and a processor
+// grid grouped like the dialog.
+//
+// The package does not depend on Windows. Devices, topology, apply and
+// restart come from a Backend, so the interface runs on Linux against
+// FakeBackend.
+package tui
+
+import "strconv"
+
+// Settings are the interrupt settings of a device that the editor changes.
+type Settings struct {
+	MSISupported       bool
+	MessageNumberLimit uint32
+	DevicePolicy       uint32
+	DevicePriority     uint32
+	CPUs               []int // processors of AssignmentSetOverride, used with DevicePolicy 4
+}
+
+// Device is a row of the device table.
+type Device struct {
+	ID             string // passed back to the Backend
+	Name           string
+	FriendlyName   string
+	Location       string
+	InterruptTypes []string
+	Identity       []string // e.g. "Class: Net", shown in the editor and searched
+	HasMSI         bool     // the device reports MSI support, MSISupported can be set
+	MaxMSILimit    uint32
+	Settings       Settings
+	Findings       []string // lint findings, shown in the editor
+	HasErrors      bool     // at least one finding is an error, the row is highlighted
+	State          string   // e.g. "disabled" or "not present", empty for an active device
+	NoRestart      string   // why the device is not restarted, shown instead of the restart question
+}
+
+// CPU is a logical processor. Core, LLC and NUMA are counted from 0 over all
+// processors, in the order the processors are listed.
+type CPU struct {
+	Processor       int // the bit in AssignmentSetOverride
+	Group           int
+	Core            int
+	Thread          int // index of the thread within its core
+	LLC             int
+	NUMA            int
+	EfficiencyClass int
+	State           string // e.g. "parked" or "real-time", empty if the processor is available
+	Unavailable     bool   // parked or reserved, interrupts should not only target such processors
+}
+
+// Topology lists the processors and which groupings the machine has.
+type Topology struct {
+	CPUs              []CPU
+	HyperThreading    bool
+	EfficiencyClasses bool
+	LastLevelCaches   bool
+	NumaNodes         bool
+}
+
+// ApplyResult is the outcome of Backend.Apply.
+type ApplyResult struct {
+	Changed    bool
+	DryRun     bool     // nothing was written, Operations were only planned
+	Operations []string // the registry operations, one per line
+	Device     Device   // the device with its new settings
+}
+
+// Backend provides the devices and performs the changes.
+type Backend interface {
+	Devices() ([]Device, error)
+	Topology() Topology
+	// Apply writes settings to the device with the ID.
+	Apply(id string, settings Settings) (ApplyResult, error)
+	// CanRestart is false if devices cannot be restarted, e.g. for an offline hive.
+	CanRestart() bool
+	Restart(id string) (needReboot bool, err error)
+}
+
+// PolicyNames are the names of DevicePolicy 0 to 5, as shown in the table.
+var PolicyNames = []string{
+	"Default",
+	"All Close Proc",
+	"One Close Proc",
+	"All Proc in Machine",
+	"Specified Proc",
+	"Spread Messages Across All Proc",
+}
+
+// PriorityNames are the names of DevicePriority 0 to 3.
+var PriorityNames = []string{"Undefined", "Low", "Normal", "High"}
+
+const policySpecifiedProcessors = 4
+
+func policyName(policy uint32) string {
+	if int(policy) < len(PolicyNames) {
+		return PolicyNames[policy]
+	}
+	return strconv.Itoa(int(policy))
+}
+
+func priorityName(priority uint32) string {
+	if int(priority) < len(PriorityNames) {
+		return PriorityNames[priority]
+	}
+	return strconv.Itoa(int(priority))
+}
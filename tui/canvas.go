@@ -0,0 +1,178 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Color uint8
+
+const (
+	ColorDefault Color = iota
+	ColorRed
+	ColorGreen
+	ColorYellow
+	ColorBlue
+	ColorCyan
+)
+
+// Style is the look of a cell. The zero value is the terminal default.
+type Style struct {
+	FG      Color
+	BG      Color
+	Bold    bool
+	Dim     bool
+	Reverse bool
+}
+
+// sgr returns the escape sequence that switches from the default to s.
+func (s Style) sgr() string {
+	codes := []string{"0"}
+	if s.Bold {
+		codes = append(codes, "1")
+	}
+	if s.Dim {
+		codes = append(codes, "2")
+	}
+	if s.Reverse {
+		codes = append(codes, "7")
+	}
+	if s.FG != ColorDefault {
+		codes = append(codes, fmt.Sprint(30+colorCode(s.FG)))
+	}
+	if s.BG != ColorDefault {
+		codes = append(codes, fmt.Sprint(40+colorCode(s.BG)))
+	}
+	return "\x1b[" + strings.Join(codes, ";") + "m"
+}
+
+func colorCode(c Color) int {
+	switch c {
+	case ColorRed:
+		return 1
+	case ColorGreen:
+		return 2
+	case ColorYellow:
+		return 3
+	case ColorBlue:
+		return 4
+	case ColorCyan:
+		return 6
+	}
+	return 9
+}
+
+type Cell struct {
+	Rune  rune
+	Style Style
+}
+
+// Canvas is a screen of cells the model draws on. Drawing outside of the
+// canvas is clipped.
+type Canvas struct {
+	Width  int
+	Height int
+	cells  []Cell
+}
+
+func NewCanvas(width, height int) *Canvas {
+	c := &Canvas{Width: max(width, 0), Height: max(height, 0)}
+	c.cells = make([]Cell, c.Width*c.Height)
+	for i := range c.cells {
+		c.cells[i].Rune = ' '
+	}
+	return c
+}
+
+// Text draws s at x, y and returns the column after it.
+func (c *Canvas) Text(x, y int, s string, style Style) int {
+	for _, r := range s {
+		if r < ' ' {
+			r = ' '
+		}
+		if x >= 0 && x < c.Width && y >= 0 && y < c.Height {
+			c.cells[y*c.Width+x] = Cell{r, style}
+		}
+		x++
+	}
+	return x
+}
+
+// Fill sets the style of a whole line and clears it.
+func (c *Canvas) Fill(y int, style Style) {
+	for x := 0; x < c.Width; x++ {
+		c.Text(x, y, " ", style)
+	}
+}
+
+// Line returns the text of line y without styles.
+func (c *Canvas) Line(y int) string {
+	if y < 0 || y >= c.Height {
+		return ""
+	}
+	var b strings.Builder
+	for _, cell := range c.cells[y*c.Width : (y+1)*c.Width] {
+		b.WriteRune(cell.Rune)
+	}
+	return strings.TrimRight(b.String(), " ")
+}
+
+// String returns all lines without styles, e.g. to compare screens.
+func (c *Canvas) String() string {
+	lines := make([]string, c.Height)
+	for y := range lines {
+		lines[y] = c.Line(y)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (c *Canvas) row(y int) []Cell {
+	return c.cells[y*c.Width : (y+1)*c.Width]
+}
+
+// renderer writes canvases to an ANSI terminal. Only the lines that differ
+// from the previous canvas are written.
+type renderer struct {
+	out  io.Writer
+	prev *Canvas
+}
+
+func (r *renderer) render(c *Canvas) error {
+	w := bufio.NewWriter(r.out)
+	full := r.prev == nil || r.prev.Width != c.Width || r.prev.Height != c.Height
+	if full {
+		w.WriteString("\x1b[0m\x1b[2J")
+	}
+
+	for y := 0; y < c.Height; y++ {
+		row := c.row(y)
+		if !full && equalCells(row, r.prev.row(y)) {
+			continue
+		}
+		fmt.Fprintf(w, "\x1b[%d;1H", y+1)
+		var current Style
+		w.WriteString(current.sgr())
+		for _, cell := range row {
+			if cell.Style != current {
+				current = cell.Style
+				w.WriteString(current.sgr())
+			}
+			w.WriteRune(cell.Rune)
+		}
+		w.WriteString("\x1b[0m")
+	}
+
+	r.prev = c
+	return w.Flush()
+}
+
+func equalCells(a, b []Cell) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
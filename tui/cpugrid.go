@@ -0,0 +1,170 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// gridCell is the position of a processor in the grid, relative to its top left corner.
+type gridCell struct {
+	cpu   int // index into Topology.CPUs
+	x, y  int
+	width int
+}
+
+type gridText struct {
+	x, y  int
+	text  string
+	style Style
+}
+
+// gridLayout places the processors like the dialog: a section per NUMA
+// node, last level cache and efficiency class, and in it a box per core
+// with its threads.
+type gridLayout struct {
+	cells  []gridCell
+	texts  []gridText
+	height int
+}
+
+func layoutGrid(t *Topology, width int) gridLayout {
+	var g gridLayout
+	if len(t.CPUs) == 0 {
+		return g
+	}
+
+	numberWidth := len(fmt.Sprint(t.CPUs[len(t.CPUs)-1].Processor))
+	lowest, highest := t.CPUs[0].EfficiencyClass, t.CPUs[0].EfficiencyClass
+	for _, cpu := range t.CPUs {
+		lowest = min(lowest, cpu.EfficiencyClass)
+		highest = max(highest, cpu.EfficiencyClass)
+	}
+
+	x, y := 0, -1
+	var section string
+	for i := 0; i < len(t.CPUs); {
+		cpu := t.CPUs[i]
+		if title := sectionTitle(t, cpu, lowest, highest); title != section || y == -1 {
+			section = title
+			if y != -1 {
+				y++
+			}
+			y++
+			if title != "" {
+				g.texts = append(g.texts, gridText{0, y, title, Style{Bold: true}})
+				y++
+			}
+			x = 0
+		}
+
+		// all threads of the core
+		end := i + 1
+		for end < len(t.CPUs) && t.CPUs[end].Core == cpu.Core {
+			end++
+		}
+		coreWidth := 2 + (end-i)*(numberWidth+2) - 1
+		if x != 0 && x+coreWidth > width {
+			x = 0
+			y++
+		}
+
+		g.texts = append(g.texts, gridText{x, y, "[", Style{Dim: true}})
+		cx := x + 1
+		for j := i; j < end; j++ {
+			g.cells = append(g.cells, gridCell{cpu: j, x: cx, y: y, width: numberWidth + 1})
+			cx += numberWidth + 2
+		}
+		g.texts = append(g.texts, gridText{cx - 1, y, "]", Style{Dim: true}})
+		x = cx + 1
+		i = end
+	}
+	g.height = y + 1
+	return g
+}
+
+func sectionTitle(t *Topology, cpu CPU, lowest, highest int) string {
+	var parts []string
+	if t.NumaNodes {
+		parts = append(parts, fmt.Sprintf("NUMA %d", cpu.NUMA))
+	}
+	if t.LastLevelCaches {
+		parts = append(parts, fmt.Sprintf("LLC %d", cpu.LLC))
+	}
+	if t.EfficiencyClasses {
+		switch {
+		case highest-lowest == 1 && cpu.EfficiencyClass == highest:
+			parts = append(parts, "P-Cores")
+		case highest-lowest == 1:
+			parts = append(parts, "E-Cores")
+		default:
+			parts = append(parts, fmt.Sprintf("Efficiency Class %d", cpu.EfficiencyClass))
+		}
+	}
+	return strings.Join(parts, " / ")
+}
+
+// draw draws the grid at left, top. selected are the chosen processors and
+// cursor the focused cell, -1 for none.
+func (g *gridLayout) draw(c *Canvas, t *Topology, left, top int, selected map[int]bool, cursor int) {
+	for _, text := range g.texts {
+		c.Text(left+text.x, top+text.y, text.text, text.style)
+	}
+	for i, cell := range g.cells {
+		processor := t.CPUs[cell.cpu].Processor
+		style := Style{}
+		if t.CPUs[cell.cpu].Unavailable {
+			style = Style{FG: ColorYellow, Dim: true}
+		}
+		mark := " "
+		if selected[processor] {
+			style = Style{FG: ColorGreen, Bold: true}
+			mark = "x"
+		}
+		if i == cursor {
+			style.Reverse = true
+		}
+		c.Text(left+cell.x, top+cell.y, fmt.Sprintf("%s%*d", mark, cell.width-1, processor), style)
+	}
+}
+
+// move returns the cell next to cursor in the direction dx, dy: left and
+// right follow the processor order, up and down pick the closest cell of
+// the line above or below. It returns -1 when there is no such cell.
+func (g *gridLayout) move(cursor, dx, dy int) int {
+	if len(g.cells) == 0 {
+		return -1
+	}
+	if dx != 0 {
+		next := cursor + dx
+		if next < 0 || next >= len(g.cells) {
+			return -1
+		}
+		return next
+	}
+
+	from := g.cells[cursor]
+	targetY := -1
+	for _, cell := range g.cells {
+		if (dy < 0 && cell.y < from.y && (targetY == -1 || cell.y > targetY)) ||
+			(dy > 0 && cell.y > from.y && (targetY == -1 || cell.y < targetY)) {
+			targetY = cell.y
+		}
+	}
+	if targetY == -1 {
+		return -1
+	}
+	best, bestDistance := -1, 0
+	for i, cell := range g.cells {
+		if cell.y != targetY {
+			continue
+		}
+		distance := cell.x - from.x
+		if distance < 0 {
+			distance = -distance
+		}
+		if best == -1 || distance < bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+	return best
+}
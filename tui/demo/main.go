@@ -0,0 +1,30 @@
+// Command demo runs the terminal interface against tui.FakeBackend, so it
+// can be tried and checked on any machine:
+//
+//	go run ./tui/demo [-dry-run]
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/spddl/GoInterruptPolicy/tui"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "only show the registry operations")
+	flag.Parse()
+
+	backend := tui.NewFakeBackend()
+	backend.DryRun = *dryRun
+
+	term, err := tui.OpenTerminal()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = tui.Run(backend, term)
+	term.Restore()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,362 @@
+package tui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	styleBar      = Style{Reverse: true}
+	styleHeader   = Style{Bold: true}
+	styleSelected = Style{FG: ColorCyan, Reverse: true}
+	styleError    = Style{FG: ColorRed}
+	styleHelp     = Style{Dim: true}
+	styleFocus    = Style{Reverse: true}
+)
+
+// Draw renders the model onto c. The size of c is used for paging and
+// layout until the next Draw.
+func (m *Model) Draw(c *Canvas) {
+	m.width, m.height = c.Width, c.Height
+	switch m.view {
+	case viewEditor:
+		m.drawEditor(c)
+	default:
+		m.drawList(c)
+	}
+	if m.view == viewModal {
+		m.drawModal(c)
+	}
+}
+
+// column widths of the device table, the name gets the rest
+const (
+	colMSI      = 4
+	colLimit    = 6
+	colPolicy   = 20
+	colPriority = 10
+	colCPUs     = 16
+)
+
+func (m *Model) drawList(c *Canvas) {
+	c.Fill(0, styleBar)
+	c.Text(1, 0, "GoInterruptPolicy", Style{Reverse: true, Bold: true})
+	count := fmt.Sprintf("%d devices", len(m.devices))
+	if len(m.filtered) != len(m.devices) {
+		count = fmt.Sprintf("%d of %d devices", len(m.filtered), len(m.devices))
+	}
+	c.Text(c.Width-len(count)-1, 0, count, styleBar)
+
+	x := c.Text(1, 1, "Search: ", styleHelp)
+	x = c.Text(x, 1, m.search, Style{})
+	if m.editing {
+		c.Text(x, 1, " ", styleFocus)
+	}
+
+	nameWidth := max(c.Width-2-colMSI-colLimit-colPolicy-colPriority-colCPUs-5, 10)
+	row := func(y int, style Style, name, msi, limit, policy, priority, cpus string) {
+		line := fmt.Sprintf(" %-*s %-*s %*s %-*s %-*s %-*s",
+			nameWidth, truncate(name, nameWidth),
+			colMSI, msi,
+			colLimit, limit,
+			colPolicy, truncate(policy, colPolicy),
+			colPriority, truncate(priority, colPriority),
+			colCPUs, truncate(cpus, colCPUs))
+		if style != (Style{}) {
+			c.Fill(y, style)
+		}
+		c.Text(0, y, line, style)
+	}
+	row(2, styleHeader, "Name", "MSI", "Limit", "Policy", "Priority", "CPUs")
+
+	rows := m.tableRows()
+	if m.selected < m.offset {
+		m.offset = m.selected
+	}
+	if m.selected >= m.offset+rows {
+		m.offset = m.selected - rows + 1
+	}
+	m.offset = max(min(m.offset, len(m.filtered)-rows), 0)
+
+	for i := 0; i < rows && m.offset+i < len(m.filtered); i++ {
+		dev := &m.devices[m.filtered[m.offset+i]]
+		style := Style{}
+		if dev.State != "" {
+			style = styleHelp
+		}
+		if dev.HasErrors {
+			style = styleError
+		}
+		if m.offset+i == m.selected {
+			style = styleSelected
+		}
+		msi, limit := "", ""
+		if dev.HasMSI {
+			msi = "No"
+			if dev.Settings.MSISupported {
+				msi = "Yes"
+				if dev.Settings.MessageNumberLimit != 0 {
+					limit = strconv.Itoa(int(dev.Settings.MessageNumberLimit))
+				}
+			}
+		}
+		cpus := ""
+		if dev.Settings.DevicePolicy == policySpecifiedProcessors {
+			cpus = formatCPUs(dev.Settings.CPUs)
+		}
+		name := dev.Name
+		if dev.State != "" {
+			name += " (" + dev.State + ")"
+		}
+		row(3+i, style, name, msi, limit,
+			policyName(dev.Settings.DevicePolicy),
+			priorityName(dev.Settings.DevicePriority), cpus)
+	}
+	if len(m.filtered) == 0 {
+		c.Text(1, 3, "No devices match the search.", styleHelp)
+	}
+
+	m.drawFooter(c, "Enter edit  / search  r restart  q quit")
+}
+
+func (m *Model) drawFooter(c *Canvas, help string) {
+	if m.status != "" {
+		style := Style{}
+		if m.statusError {
+			style = styleError
+		}
+		c.Text(1, c.Height-2, m.status, style)
+	}
+	c.Fill(c.Height-1, styleBar)
+	c.Text(1, c.Height-1, help, styleBar)
+}
+
+func (m *Model) drawEditor(c *Canvas) {
+	e := m.editor
+	dev := &e.device
+	const labelWidth = 18
+	const top = 2 // the content starts below the title
+
+	// lines of the content, the focused field decides the scroll position
+	y := 0
+	focusY, focusHeight := 0, 1
+	var draws []func(offset int)
+	text := func(x int, s string, style Style) {
+		line := y
+		draws = append(draws, func(offset int) { c.Text(x, top+line-offset, s, style) })
+	}
+	field := func(id int, label, value string) {
+		style := Style{}
+		if e.field == id {
+			style = styleFocus
+			focusY = y
+		}
+		text(2, label, Style{})
+		text(2+labelWidth, value, style)
+		y++
+	}
+
+	if dev.FriendlyName != "" && dev.FriendlyName != dev.Name {
+		text(2, dev.FriendlyName, styleHelp)
+		y++
+	}
+	if dev.Location != "" {
+		text(2, dev.Location, styleHelp)
+		y++
+	}
+	if len(dev.InterruptTypes) != 0 {
+		text(2, "Interrupt Types: "+strings.Join(dev.InterruptTypes, ", "), styleHelp)
+		y++
+	}
+	for _, line := range dev.Identity {
+		text(2, line, styleHelp)
+		y++
+	}
+	if dev.NoRestart != "" {
+		text(2, "Inactive: "+dev.NoRestart, styleHelp)
+		y++
+	}
+	y++
+
+	if dev.HasMSI {
+		check := "[ ]"
+		if e.settings.MSISupported {
+			check = "[x]"
+		}
+		field(fieldMSI, "MSI Mode", check)
+		if e.settings.MSISupported {
+			limit := e.limit
+			if e.field == fieldLimit {
+				limit += "_"
+			} else if limit == "" {
+				limit = "-"
+			}
+			field(fieldLimit, "Message Limit", limit)
+			if dev.MaxMSILimit != 0 {
+				text(2+labelWidth+len(limit)+1, fmt.Sprintf("(max %d)", dev.MaxMSILimit), styleHelp)
+			}
+		}
+	} else {
+		text(2, "MSI Mode", Style{})
+		text(2+labelWidth, "not supported", styleHelp)
+		y++
+	}
+	field(fieldPolicy, "Device Policy", "< "+policyName(e.settings.DevicePolicy)+" >")
+	field(fieldPriority, "Device Priority", "< "+priorityName(e.settings.DevicePriority)+" >")
+
+	if e.settings.DevicePolicy == policySpecifiedProcessors {
+		y++
+		count := 0
+		for _, on := range e.cpus {
+			if on {
+				count++
+			}
+		}
+		text(2, fmt.Sprintf("Processors (%d selected)", count), styleHeader)
+		y++
+		g := layoutGrid(&m.topology, c.Width-4)
+		cursor := -1
+		if e.field == fieldCPUs && len(g.cells) != 0 {
+			cursor = min(e.cursor, len(g.cells)-1)
+			focusY, focusHeight = y, g.height
+			// keep the line of the cursor visible when the grid is taller than the screen
+			if g.height > c.Height-top-3 {
+				focusY, focusHeight = y+g.cells[cursor].y, 1
+			}
+		}
+		line := y
+		draws = append(draws, func(offset int) {
+			g.draw(c, &m.topology, 2, top+line-offset, e.cpus, cursor)
+		})
+		y += g.height
+
+		var states []string
+		for _, cpu := range m.topology.CPUs {
+			if cpu.State != "" {
+				states = append(states, fmt.Sprintf("%d %s", cpu.Processor, cpu.State))
+			}
+		}
+		if len(states) != 0 {
+			text(2, truncate("Unavailable: "+strings.Join(states, ", "), c.Width-4), Style{FG: ColorYellow, Dim: true})
+			y++
+		}
+	}
+
+	if len(dev.Findings) != 0 {
+		y++
+		text(2, "Findings", styleHeader)
+		y++
+		for _, finding := range dev.Findings {
+			text(2, finding, Style{FG: ColorYellow})
+			y++
+		}
+	}
+
+	// scroll so the focused field is visible
+	visible := max(c.Height-top-3, 1)
+	if focusY < e.offset {
+		e.offset = focusY
+	}
+	if focusY+focusHeight > e.offset+visible {
+		e.offset = min(focusY, focusY+focusHeight-visible)
+	}
+	e.offset = max(min(e.offset, y-visible), 0)
+	for _, draw := range draws {
+		draw(e.offset)
+	}
+
+	// the title and the footer are drawn last, over scrolled content
+	c.Fill(0, styleBar)
+	c.Text(1, 0, truncate(dev.Name, c.Width-2), Style{Reverse: true, Bold: true})
+	c.Fill(1, Style{})
+	c.Fill(c.Height-2, Style{})
+	c.Fill(c.Height-3, Style{})
+	if e.err != "" {
+		c.Text(1, c.Height-2, e.err, styleError)
+	}
+
+	help := "Tab next  Enter apply  Esc cancel"
+	switch e.field {
+	case fieldMSI:
+		help = "Space toggle  " + help
+	case fieldLimit:
+		help = "0-9 limit  " + help
+	case fieldPolicy, fieldPriority:
+		help = "Left/Right change  " + help
+	case fieldCPUs:
+		help = "Space toggle  a all  n none"
+		if m.topology.HyperThreading {
+			help += "  h no SMT"
+		}
+		if m.topology.EfficiencyClasses {
+			help += "  p P-cores  e E-cores"
+		}
+		help += "  Enter apply  Esc cancel"
+	}
+	c.Fill(c.Height-1, styleBar)
+	c.Text(1, c.Height-1, help, styleBar)
+}
+
+func (m *Model) drawModal(c *Canvas) {
+	md := m.modal
+	width := len(md.title) + 4
+	for _, line := range md.lines {
+		width = max(width, len(line)+4)
+	}
+	width = min(width, c.Width-2)
+	lines := md.lines
+	height := min(len(lines)+4, c.Height-2)
+	if len(lines) > height-4 {
+		lines = append(lines[:max(height-5, 0):max(height-5, 0)], "...")
+	}
+
+	left, top := (c.Width-width)/2, (c.Height-height)/2
+	border := Style{FG: ColorBlue}
+	for y := top; y < top+height; y++ {
+		c.Text(left, y, strings.Repeat(" ", width), Style{})
+	}
+	c.Text(left, top, "┌"+strings.Repeat("─", width-2)+"┐", border)
+	c.Text(left+2, top, " "+truncate(md.title, width-6)+" ", styleHeader)
+	for y := top + 1; y < top+height-1; y++ {
+		c.Text(left, y, "│", border)
+		c.Text(left+width-1, y, "│", border)
+	}
+	c.Text(left, top+height-1, "└"+strings.Repeat("─", width-2)+"┘", border)
+	for i, line := range lines {
+		c.Text(left+2, top+2+i, truncate(line, width-4), Style{})
+	}
+}
+
+// truncate shortens s to width runes, marking the cut with an ellipsis.
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if len(r) <= width {
+		return s
+	}
+	if width <= 1 {
+		return string(r[:max(width, 0)])
+	}
+	return string(r[:width-1]) + "…"
+}
+
+// formatCPUs lists processors with ranges, e.g. 0-3,8.
+func formatCPUs(cpus []int) string {
+	var parts []string
+	for i := 0; i < len(cpus); {
+		j := i
+		for j+1 < len(cpus) && cpus[j+1] == cpus[j]+1 {
+			j++
+		}
+		switch {
+		case j == i:
+			parts = append(parts, strconv.Itoa(cpus[i]))
+		case j == i+1:
+			parts = append(parts, strconv.Itoa(cpus[i]), strconv.Itoa(cpus[j]))
+		default:
+			parts = append(parts, fmt.Sprintf("%d-%d", cpus[i], cpus[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,180 @@
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// FakeBackend is an in-memory machine with a hybrid processor, 8 P-cores
+// with two threads each and 16 E-cores, and a handful of devices. It lets
+// the interface run and be tested without Windows.
+type FakeBackend struct {
+	devices  []Device
+	topology Topology
+	DryRun   bool     // Apply only reports the operations
+	Restarts []string // IDs passed to Restart
+}
+
+func NewFakeBackend() *FakeBackend {
+	b := &FakeBackend{}
+
+	t := &b.topology
+	t.HyperThreading = true
+	t.EfficiencyClasses = true
+	processor := 0
+	for core := 0; core < 8; core++ {
+		for thread := 0; thread < 2; thread++ {
+			t.CPUs = append(t.CPUs, CPU{Processor: processor, Core: core, Thread: thread, EfficiencyClass: 1})
+			processor++
+		}
+	}
+	for core := 8; core < 24; core++ {
+		t.CPUs = append(t.CPUs, CPU{Processor: processor, Core: core})
+		processor++
+	}
+	// core parking has parked the last E-core cluster
+	for i := len(t.CPUs) - 4; i < len(t.CPUs); i++ {
+		t.CPUs[i].State = "parked"
+		t.CPUs[i].Unavailable = true
+	}
+
+	b.devices = []Device{
+		{
+			ID:             `PCI\VEN_10DE&DEV_2684&SUBSYS_167C10DE&REV_A1\4&1A2B3C4D&0&0008`,
+			Name:           "NVIDIA GeForce RTX 4090",
+			FriendlyName:   "NVIDIA GeForce RTX 4090",
+			Location:       "PCI bus 1, device 0, function 0",
+			InterruptTypes: []string{"LineBased", "Msi"},
+			Identity:       []string{"Class: Display", "Service: nvlddmkm", `Parent: PCI\VEN_8086&DEV_A70D&SUBSYS_7D251462&REV_01\3&11583659&0&08`},
+			HasMSI:         true,
+			MaxMSILimit:    1,
+			Settings:       Settings{MSISupported: true},
+		},
+		{
+			ID:             `PCI\VEN_8086&DEV_125C&SUBSYS_00008086&REV_04\6&2F6E5E2&0&000800E6`,
+			Name:           "Intel(R) Ethernet Controller I226-V",
+			Location:       "PCI bus 4, device 0, function 0",
+			InterruptTypes: []string{"LineBased", "Msi", "MsiX"},
+			HasMSI:         true,
+			MaxMSILimit:    5,
+			Settings:       Settings{MSISupported: true, DevicePolicy: policySpecifiedProcessors, DevicePriority: 3, CPUs: []int{2}},
+		},
+		{
+			ID:             `PCI\VEN_8086&DEV_7AE0&SUBSYS_7D251462&REV_11\3&11583659&0&A0`,
+			Name:           "Intel(R) USB 3.20 eXtensible Host Controller - 1.20 (Microsoft)",
+			Location:       "PCI bus 0, device 20, function 0",
+			InterruptTypes: []string{"LineBased", "Msi"},
+			HasMSI:         true,
+			MaxMSILimit:    8,
+			Settings:       Settings{MSISupported: true, MessageNumberLimit: 8},
+		},
+		{
+			ID:             `PCI\VEN_8086&DEV_7A50&SUBSYS_7D251462&REV_11\3&11583659&0&FB`,
+			Name:           "High Definition Audio Controller",
+			Location:       "PCI bus 0, device 31, function 3",
+			InterruptTypes: []string{"LineBased", "Msi"},
+			HasMSI:         true,
+			MaxMSILimit:    1,
+			Settings:       Settings{DevicePolicy: policySpecifiedProcessors},
+			Findings:       []string{"error: DevicePolicy is 4 (Specified Proc) but AssignmentSetOverride is empty"},
+			HasErrors:      true,
+		},
+		{
+			ID:             `PCI\VEN_144D&DEV_A80A&SUBSYS_A801144D&REV_00\4&2B6A9E1&0&0010`,
+			Name:           "Standard NVM Express Controller",
+			Location:       "PCI bus 2, device 0, function 0",
+			InterruptTypes: []string{"LineBased", "Msi", "MsiX"},
+			HasMSI:         true,
+			MaxMSILimit:    33,
+			Settings:       Settings{MSISupported: true},
+		},
+		{
+			ID:             `PCI\VEN_1CD7&DEV_0010&SUBSYS_00101CD7&REV_00\4&3C1D2E5F&0&00E4`,
+			Name:           "Magewell Pro Capture HDMI 4K",
+			Location:       "PCI bus 5, device 0, function 0",
+			InterruptTypes: []string{"LineBased", "Msi"},
+			HasMSI:         true,
+			MaxMSILimit:    1,
+			State:          "not present",
+			NoRestart:      "the device is not present, the settings take effect when it is connected",
+		},
+		{
+			ID:             `PCI\VEN_8086&DEV_7A84&SUBSYS_7D251462&REV_11\3&11583659&0&F8`,
+			Name:           "Intel(R) LPC Controller",
+			Location:       "PCI bus 0, device 31, function 0",
+			InterruptTypes: []string{"LineBased"},
+		},
+	}
+	return b
+}
+
+func (b *FakeBackend) Devices() ([]Device, error) {
+	return append([]Device(nil), b.devices...), nil
+}
+
+func (b *FakeBackend) Topology() Topology {
+	return b.topology
+}
+
+func (b *FakeBackend) find(id string) (*Device, error) {
+	for i := range b.devices {
+		if b.devices[i].ID == id {
+			return &b.devices[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no device %q", id)
+}
+
+// Apply records the settings and describes them like registry operations.
+func (b *FakeBackend) Apply(id string, settings Settings) (ApplyResult, error) {
+	dev, err := b.find(id)
+	if err != nil {
+		return ApplyResult{}, err
+	}
+
+	var ops []string
+	before := dev.Settings
+	if before.MSISupported != settings.MSISupported {
+		ops = append(ops, fmt.Sprintf("set-value MSISupported = %t", settings.MSISupported))
+	}
+	if before.MessageNumberLimit != settings.MessageNumberLimit {
+		ops = append(ops, fmt.Sprintf("set-value MessageNumberLimit = %d", settings.MessageNumberLimit))
+	}
+	if before.DevicePolicy != settings.DevicePolicy {
+		ops = append(ops, fmt.Sprintf("set-value DevicePolicy = %d", settings.DevicePolicy))
+	}
+	if before.DevicePriority != settings.DevicePriority {
+		ops = append(ops, fmt.Sprintf("set-value DevicePriority = %d", settings.DevicePriority))
+	}
+	if formatCPUs(before.CPUs) != formatCPUs(settings.CPUs) {
+		ops = append(ops, fmt.Sprintf("set-value AssignmentSetOverride = %s", formatCPUs(settings.CPUs)))
+	}
+
+	result := ApplyResult{Changed: len(ops) != 0, DryRun: b.DryRun, Operations: ops, Device: *dev}
+	if b.DryRun || !result.Changed {
+		return result, nil
+	}
+	dev.Settings = settings
+	if settings.DevicePolicy != policySpecifiedProcessors || len(settings.CPUs) != 0 {
+		dev.Findings, dev.HasErrors = nil, false
+	}
+	result.Device = *dev
+	return result, nil
+}
+
+func (b *FakeBackend) CanRestart() bool {
+	return true
+}
+
+func (b *FakeBackend) Restart(id string) (bool, error) {
+	dev, err := b.find(id)
+	if err != nil {
+		return false, err
+	}
+	if dev.NoRestart != "" {
+		return false, errors.New(dev.NoRestart)
+	}
+	b.Restarts = append(b.Restarts, id)
+	return strings.Contains(id, "VEN_10DE"), nil
+}
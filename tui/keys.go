@@ -0,0 +1,99 @@
+package tui
+
+import "unicode/utf8"
+
+type KeyCode int
+
+const (
+	KeyRune KeyCode = iota
+	KeyEnter
+	KeyEsc
+	KeyTab
+	KeyBacktab
+	KeyBackspace
+	KeyDelete
+	KeyUp
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyHome
+	KeyEnd
+	KeyPgUp
+	KeyPgDn
+	KeyCtrlC
+	KeyCtrlS
+)
+
+// Key is a key press. Rune is set for KeyRune.
+type Key struct {
+	Code KeyCode
+	Rune rune
+}
+
+var escapeSequences = map[string]KeyCode{
+	"[A": KeyUp, "[B": KeyDown, "[C": KeyRight, "[D": KeyLeft,
+	"OA": KeyUp, "OB": KeyDown, "OC": KeyRight, "OD": KeyLeft,
+	"[H": KeyHome, "[F": KeyEnd, "OH": KeyHome, "OF": KeyEnd,
+	"[1~": KeyHome, "[4~": KeyEnd, "[7~": KeyHome, "[8~": KeyEnd,
+	"[3~": KeyDelete, "[5~": KeyPgUp, "[6~": KeyPgDn, "[Z": KeyBacktab,
+}
+
+// ParseKeys decodes the bytes read from a terminal in VT mode. A lone ESC is
+// the escape key, unknown escape sequences are dropped.
+func ParseKeys(data []byte) []Key {
+	var keys []Key
+	for len(data) > 0 {
+		b := data[0]
+		switch {
+		case b == 0x1b:
+			n, code, ok := parseEscape(data)
+			if ok {
+				keys = append(keys, Key{Code: code})
+			} else if n == 1 {
+				keys = append(keys, Key{Code: KeyEsc})
+			}
+			data = data[n:]
+			continue
+		case b == '\r' || b == '\n':
+			keys = append(keys, Key{Code: KeyEnter})
+			if b == '\r' && len(data) > 1 && data[1] == '\n' {
+				data = data[1:]
+			}
+		case b == '\t':
+			keys = append(keys, Key{Code: KeyTab})
+		case b == 0x7f || b == 0x08:
+			keys = append(keys, Key{Code: KeyBackspace})
+		case b == 0x03:
+			keys = append(keys, Key{Code: KeyCtrlC})
+		case b == 0x13:
+			keys = append(keys, Key{Code: KeyCtrlS})
+		case b < ' ':
+			// other control characters are ignored
+		default:
+			r, size := utf8.DecodeRune(data)
+			keys = append(keys, Key{Code: KeyRune, Rune: r})
+			data = data[size:]
+			continue
+		}
+		data = data[1:]
+	}
+	return keys
+}
+
+// parseEscape decodes the escape sequence at the start of data and returns
+// the number of bytes it uses.
+func parseEscape(data []byte) (int, KeyCode, bool) {
+	if len(data) < 2 || (data[1] != '[' && data[1] != 'O') {
+		return 1, 0, false
+	}
+	// CSI parameters and intermediates up to the final byte
+	end := 2
+	for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+		end++
+	}
+	if end == len(data) {
+		return len(data), 0, false
+	}
+	code, ok := escapeSequences[string(data[1:end+1])]
+	return end + 1, code, ok
+}
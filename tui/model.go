@@ -0,0 +1,539 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type view int
+
+const (
+	viewList view = iota
+	viewEditor
+	viewModal
+)
+
+// Editor fields in focus order.
+const (
+	fieldMSI = iota
+	fieldLimit
+	fieldPolicy
+	fieldPriority
+	fieldCPUs
+)
+
+// Model is the state of the interface. Update changes it for a key press and
+// Draw renders it, neither does any I/O besides calling the Backend, so the
+// model can be driven by a test or a script as well as by Run.
+type Model struct {
+	backend  Backend
+	topology Topology
+	devices  []Device
+
+	view     view
+	filtered []int // indices into devices that match the search
+	selected int   // index into filtered
+	offset   int   // first visible row of the table
+	search   string
+	editing  bool // typing into the search field
+
+	editor *editor
+	modal  *modal
+
+	status      string
+	statusError bool
+	quit        bool
+
+	width, height int // size of the last canvas
+}
+
+type editor struct {
+	device   Device
+	settings Settings
+	limit    string // MessageNumberLimit while typing
+	cpus     map[int]bool
+	field    int
+	cursor   int // focused cell of the processor grid
+	offset   int // first visible line, the grid may not fit
+	err      string
+}
+
+// modal is a message box. With confirm set it asks a yes/no question.
+type modal struct {
+	title   string
+	lines   []string
+	confirm func(m *Model, yes bool)
+}
+
+func NewModel(backend Backend) (*Model, error) {
+	devices, err := backend.Devices()
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(devices, func(i, j int) bool {
+		return devices[i].Name < devices[j].Name
+	})
+
+	m := &Model{
+		backend:  backend,
+		topology: backend.Topology(),
+		devices:  devices,
+		width:    80,
+		height:   24,
+	}
+	m.filter()
+	return m, nil
+}
+
+// Quit reports whether the user closed the interface.
+func (m *Model) Quit() bool {
+	return m.quit
+}
+
+func (m *Model) setStatus(text string, isError bool) {
+	m.status, m.statusError = text, isError
+}
+
+// filter applies the search and keeps the selected device if it still matches.
+func (m *Model) filter() {
+	current := -1
+	if len(m.filtered) != 0 {
+		current = m.filtered[m.selected]
+	}
+	text := strings.ToLower(m.search)
+	m.filtered = m.filtered[:0]
+	for i := range m.devices {
+		dev := &m.devices[i]
+		if text == "" ||
+			strings.Contains(strings.ToLower(dev.Name), text) ||
+			strings.Contains(strings.ToLower(dev.FriendlyName), text) ||
+			strings.Contains(strings.ToLower(dev.Location), text) ||
+			strings.Contains(strings.ToLower(dev.ID), text) ||
+			strings.Contains(strings.ToLower(strings.Join(dev.Identity, "\n")), text) {
+			if i == current {
+				m.selected = len(m.filtered)
+			}
+			m.filtered = append(m.filtered, i)
+		}
+	}
+	if m.selected >= len(m.filtered) {
+		m.selected = max(len(m.filtered)-1, 0)
+	}
+}
+
+func (m *Model) current() *Device {
+	if len(m.filtered) == 0 {
+		return nil
+	}
+	return &m.devices[m.filtered[m.selected]]
+}
+
+// Update handles a key press.
+func (m *Model) Update(key Key) {
+	if key.Code == KeyCtrlC {
+		m.quit = true
+		return
+	}
+	switch m.view {
+	case viewList:
+		m.updateList(key)
+	case viewEditor:
+		m.updateEditor(key)
+	case viewModal:
+		m.updateModal(key)
+	}
+}
+
+func (m *Model) tableRows() int {
+	return max(m.height-5, 1)
+}
+
+func (m *Model) updateList(key Key) {
+	if m.editing {
+		switch key.Code {
+		case KeyRune:
+			m.search += string(key.Rune)
+		case KeyBackspace:
+			if m.search != "" {
+				_, size := lastRune(m.search)
+				m.search = m.search[:len(m.search)-size]
+			}
+		case KeyEsc:
+			m.search = ""
+			m.editing = false
+		case KeyEnter, KeyDown, KeyTab:
+			m.editing = false
+		}
+		m.filter()
+		return
+	}
+
+	switch key.Code {
+	case KeyUp:
+		m.selected--
+	case KeyDown:
+		m.selected++
+	case KeyPgUp:
+		m.selected -= m.tableRows()
+	case KeyPgDn:
+		m.selected += m.tableRows()
+	case KeyHome:
+		m.selected = 0
+	case KeyEnd:
+		m.selected = len(m.filtered) - 1
+	case KeyEnter:
+		if dev := m.current(); dev != nil {
+			m.openEditor(dev)
+		}
+	case KeyEsc:
+		if m.search != "" {
+			m.search = ""
+			m.filter()
+		} else {
+			m.quit = true
+		}
+	case KeyRune:
+		switch key.Rune {
+		case '/':
+			m.editing = true
+		case 'q':
+			m.quit = true
+		case 'r':
+			if dev := m.current(); dev != nil {
+				m.askRestart(dev, false)
+			}
+		}
+	}
+	m.selected = max(min(m.selected, len(m.filtered)-1), 0)
+}
+
+func lastRune(s string) (rune, int) {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i]&0xc0 != 0x80 {
+			return []rune(s[i:])[0], len(s) - i
+		}
+	}
+	return 0, 0
+}
+
+func (m *Model) openEditor(dev *Device) {
+	e := &editor{
+		device:   *dev,
+		settings: dev.Settings,
+		cpus:     map[int]bool{},
+		field:    fieldPolicy,
+	}
+	if dev.HasMSI {
+		e.field = fieldMSI
+	}
+	if dev.Settings.MessageNumberLimit != 0 {
+		e.limit = strconv.Itoa(int(dev.Settings.MessageNumberLimit))
+	}
+	for _, p := range dev.Settings.CPUs {
+		e.cpus[p] = true
+	}
+	m.editor = e
+	m.view = viewEditor
+	m.setStatus("", false)
+}
+
+// fields returns the fields of the editor that can have the focus.
+func (e *editor) fields() []int {
+	var fields []int
+	if e.device.HasMSI {
+		fields = append(fields, fieldMSI)
+		if e.settings.MSISupported {
+			fields = append(fields, fieldLimit)
+		}
+	}
+	fields = append(fields, fieldPolicy, fieldPriority)
+	if e.settings.DevicePolicy == policySpecifiedProcessors {
+		fields = append(fields, fieldCPUs)
+	}
+	return fields
+}
+
+func (e *editor) focus(step int) {
+	fields := e.fields()
+	for i, field := range fields {
+		if field == e.field {
+			e.field = fields[(i+step+len(fields))%len(fields)]
+			return
+		}
+	}
+	e.field = fields[0]
+}
+
+func (m *Model) updateEditor(key Key) {
+	e := m.editor
+	e.err = ""
+
+	switch key.Code {
+	case KeyEsc:
+		m.editor = nil
+		m.view = viewList
+		m.setStatus("Canceled", false)
+		return
+	case KeyEnter, KeyCtrlS:
+		m.applyEditor()
+		return
+	case KeyTab:
+		e.focus(1)
+		return
+	case KeyBacktab:
+		e.focus(-1)
+		return
+	}
+
+	if e.field == fieldCPUs {
+		m.updateGrid(key)
+		return
+	}
+
+	switch key.Code {
+	case KeyUp:
+		e.focus(-1)
+		return
+	case KeyDown:
+		e.focus(1)
+		return
+	}
+
+	switch e.field {
+	case fieldMSI:
+		if key.Code == KeyLeft || key.Code == KeyRight || (key.Code == KeyRune && key.Rune == ' ') {
+			e.settings.MSISupported = !e.settings.MSISupported
+		}
+	case fieldLimit:
+		switch {
+		case key.Code == KeyBackspace && e.limit != "":
+			e.limit = e.limit[:len(e.limit)-1]
+		case key.Code == KeyRune && key.Rune >= '0' && key.Rune <= '9' && len(e.limit) < 4:
+			e.limit += string(key.Rune)
+		}
+	case fieldPolicy:
+		e.settings.DevicePolicy = cycle(e.settings.DevicePolicy, len(PolicyNames), key)
+	case fieldPriority:
+		e.settings.DevicePriority = cycle(e.settings.DevicePriority, len(PriorityNames), key)
+	}
+}
+
+// cycle changes value with left and right or space, wrapping around at n.
+func cycle(value uint32, n int, key Key) uint32 {
+	switch {
+	case key.Code == KeyLeft:
+		return uint32((int(value) + n - 1) % n)
+	case key.Code == KeyRight || (key.Code == KeyRune && key.Rune == ' '):
+		return uint32((int(value) + 1) % n)
+	}
+	return value
+}
+
+func (m *Model) updateGrid(key Key) {
+	e := m.editor
+	g := layoutGrid(&m.topology, m.width-4)
+	if len(g.cells) == 0 {
+		return
+	}
+	e.cursor = min(e.cursor, len(g.cells)-1)
+
+	move := func(dx, dy int) {
+		next := g.move(e.cursor, dx, dy)
+		switch {
+		case next != -1:
+			e.cursor = next
+		case dy < 0:
+			e.focus(-1)
+		}
+	}
+
+	switch key.Code {
+	case KeyLeft:
+		move(-1, 0)
+	case KeyRight:
+		move(1, 0)
+	case KeyUp:
+		move(0, -1)
+	case KeyDown:
+		move(0, 1)
+	case KeyHome:
+		e.cursor = 0
+	case KeyEnd:
+		e.cursor = len(g.cells) - 1
+	case KeyRune:
+		switch key.Rune {
+		case ' ', 'x':
+			p := m.topology.CPUs[g.cells[e.cursor].cpu].Processor
+			e.cpus[p] = !e.cpus[p]
+		case 'a':
+			e.preset(&m.topology, func(CPU) bool { return true })
+		case 'n':
+			e.preset(&m.topology, func(CPU) bool { return false })
+		case 'h':
+			if m.topology.HyperThreading {
+				for _, cpu := range m.topology.CPUs {
+					if cpu.Thread != 0 {
+						e.cpus[cpu.Processor] = false
+					}
+				}
+			}
+		case 'p', 'e':
+			if m.topology.EfficiencyClasses {
+				class := m.topology.CPUs[0].EfficiencyClass
+				for _, cpu := range m.topology.CPUs {
+					if (key.Rune == 'p' && cpu.EfficiencyClass > class) || (key.Rune == 'e' && cpu.EfficiencyClass < class) {
+						class = cpu.EfficiencyClass
+					}
+				}
+				e.preset(&m.topology, func(cpu CPU) bool { return cpu.EfficiencyClass == class })
+			}
+		}
+	}
+}
+
+// preset selects the processors for which match is true and clears the others.
+func (e *editor) preset(t *Topology, match func(CPU) bool) {
+	for _, cpu := range t.CPUs {
+		e.cpus[cpu.Processor] = match(cpu)
+	}
+}
+
+func (e *editor) result() (Settings, error) {
+	s := e.settings
+	s.CPUs = nil
+	s.MessageNumberLimit = 0
+	if e.limit != "" {
+		limit, err := strconv.Atoi(e.limit)
+		if err != nil {
+			return s, fmt.Errorf("invalid message limit %q", e.limit)
+		}
+		s.MessageNumberLimit = uint32(limit)
+	}
+
+	if s.DevicePolicy == policySpecifiedProcessors {
+		for p, on := range e.cpus {
+			if on {
+				s.CPUs = append(s.CPUs, p)
+			}
+		}
+		sort.Ints(s.CPUs)
+		if len(s.CPUs) == 0 {
+			return s, fmt.Errorf("select at least one processor for %s", PolicyNames[policySpecifiedProcessors])
+		}
+	} else {
+		// the processors only matter for DevicePolicy 4, keep them like the dialog
+		s.CPUs = e.device.Settings.CPUs
+	}
+	return s, nil
+}
+
+func (m *Model) applyEditor() {
+	e := m.editor
+	settings, err := e.result()
+	if err != nil {
+		e.err = err.Error()
+		return
+	}
+
+	result, err := m.backend.Apply(e.device.ID, settings)
+	if err != nil {
+		e.err = err.Error()
+		return
+	}
+
+	m.editor = nil
+	m.view = viewList
+	switch {
+	case result.DryRun:
+		lines := []string{"Dry run, nothing was changed."}
+		if len(result.Operations) == 0 {
+			lines = append(lines, "", "Nothing to change.")
+		} else {
+			lines = append(lines, "These registry operations would be performed:", "")
+			lines = append(lines, result.Operations...)
+		}
+		m.modal = &modal{title: "Dry Run", lines: lines}
+		m.view = viewModal
+		m.setStatus("Dry run, nothing was changed.", false)
+	case !result.Changed:
+		m.setStatus("Nothing to change.", false)
+	default:
+		for i := range m.devices {
+			if m.devices[i].ID == result.Device.ID {
+				m.devices[i] = result.Device
+			}
+		}
+		m.filter()
+		m.setStatus("Settings saved", false)
+		if m.backend.CanRestart() {
+			m.askRestart(&result.Device, true)
+		} else {
+			m.setStatus("Settings saved, they take effect the next time Windows boots.", false)
+		}
+	}
+}
+
+// askRestart asks before restarting the device, afterChange is set when
+// the question follows a change like in the main window.
+func (m *Model) askRestart(dev *Device, afterChange bool) {
+	if !m.backend.CanRestart() {
+		m.setStatus("Devices cannot be restarted here.", true)
+		return
+	}
+	if dev.NoRestart != "" {
+		m.setStatus("Not restarted, "+dev.NoRestart+".", false)
+		return
+	}
+	lines := []string{dev.Name, "", "Restart the device now? [y/N]"}
+	if afterChange {
+		lines = []string{"Your changes will not take effect until the device is restarted.", "", "Would you like to attempt to restart the device now? [y/N]"}
+	}
+	id := dev.ID
+	m.modal = &modal{
+		title: "Restart Device?",
+		lines: lines,
+		confirm: func(m *Model, yes bool) {
+			if !yes {
+				if afterChange {
+					m.setStatus("Restart required", false)
+				}
+				return
+			}
+			needReboot, err := m.backend.Restart(id)
+			switch {
+			case err != nil:
+				m.setStatus(err.Error(), true)
+			case needReboot:
+				m.setStatus("Device could not be restarted. Changes will take effect the next time you reboot.", true)
+			default:
+				m.setStatus("Device successfully restarted.", false)
+			}
+		},
+	}
+	m.view = viewModal
+}
+
+func (m *Model) updateModal(key Key) {
+	md := m.modal
+	if md.confirm == nil {
+		if key.Code == KeyEnter || key.Code == KeyEsc || (key.Code == KeyRune && key.Rune == 'q') {
+			m.modal = nil
+			m.view = viewList
+		}
+		return
+	}
+
+	var yes bool
+	switch {
+	case key.Code == KeyRune && (key.Rune == 'y' || key.Rune == 'Y'):
+		yes = true
+	case key.Code == KeyRune && (key.Rune == 'n' || key.Rune == 'N'), key.Code == KeyEsc, key.Code == KeyEnter:
+	default:
+		return
+	}
+	m.modal = nil
+	m.view = viewList
+	md.confirm(m, yes)
+}
@@ -0,0 +1,207 @@
+package tui
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	keyRight = "\x1b[C"
+	keyEsc   = "\x1b"
+)
+
+func newTestModel(t *testing.T) (*Model, *FakeBackend) {
+	t.Helper()
+	backend := NewFakeBackend()
+	m, err := NewModel(backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, backend
+}
+
+// press sends the keys of input like a terminal would, escape sequences included.
+func press(m *Model, inputs ...string) {
+	for _, input := range inputs {
+		for _, key := range ParseKeys([]byte(input)) {
+			m.Update(key)
+		}
+	}
+}
+
+// selectDevice replaces the search with name and leaves the search field.
+func selectDevice(t *testing.T, m *Model, name string) {
+	t.Helper()
+	if m.search != "" {
+		press(m, keyEsc)
+	}
+	press(m, "/"+name+"\r")
+	if dev := m.current(); dev == nil || !strings.Contains(strings.ToLower(dev.Name), name) {
+		t.Fatalf("search %q selects %v", name, dev)
+	}
+}
+
+func deviceSettings(t *testing.T, b *FakeBackend, name string) Settings {
+	t.Helper()
+	for _, dev := range b.devices {
+		if strings.Contains(dev.Name, name) {
+			return dev.Settings
+		}
+	}
+	t.Fatalf("no device %q", name)
+	return Settings{}
+}
+
+func TestSearch(t *testing.T) {
+	m, _ := newTestModel(t)
+	if len(m.filtered) != 7 {
+		t.Fatalf("%d devices listed", len(m.filtered))
+	}
+
+	press(m, "/intel")
+	if !m.editing || len(m.filtered) != 3 {
+		t.Errorf("search %q lists %d devices", m.search, len(m.filtered))
+	}
+	press(m, "\x7f\x7f\x7f\x7f\x7fservice: nvlddmkm\r")
+	if m.editing || len(m.filtered) != 1 || m.current().Name != "NVIDIA GeForce RTX 4090" {
+		t.Errorf("searching the identity selects %v", m.current())
+	}
+
+	press(m, keyEsc)
+	if m.search != "" || len(m.filtered) != 7 || m.current().Name != "NVIDIA GeForce RTX 4090" {
+		t.Errorf("escape keeps search %q and %d devices", m.search, len(m.filtered))
+	}
+	press(m, "/no such device\r")
+	if m.current() != nil {
+		t.Errorf("selects %v without a match", m.current())
+	}
+	press(m, "\r") // nothing to edit
+	if m.view != viewList {
+		t.Error("enter without a device opens the editor")
+	}
+}
+
+func TestEditAndApply(t *testing.T) {
+	m, b := newTestModel(t)
+	selectDevice(t, m, "nvm")
+
+	press(m, "\r")
+	if m.view != viewEditor || m.editor.field != fieldMSI {
+		t.Fatalf("editor not open on the MSI field")
+	}
+	// MessageNumberLimit, then DevicePolicy 4 and DevicePriority Low
+	press(m, "\t16\t", keyRight, keyRight, keyRight, keyRight, "\t", keyRight, "\t")
+	if m.editor.field != fieldCPUs {
+		t.Fatalf("focus on field %d, want the processor grid", m.editor.field)
+	}
+	// the P-cores without their second threads
+	press(m, "ph\r")
+
+	want := Settings{MSISupported: true, MessageNumberLimit: 16, DevicePolicy: policySpecifiedProcessors, DevicePriority: 1, CPUs: []int{0, 2, 4, 6, 8, 10, 12, 14}}
+	if got := deviceSettings(t, b, "NVM Express"); !reflect.DeepEqual(got, want) {
+		t.Errorf("applied %+v, want %+v", got, want)
+	}
+	if m.view != viewModal || m.modal.confirm == nil || m.current().Settings.MessageNumberLimit != 16 {
+		t.Fatal("no restart question after the change")
+	}
+
+	press(m, "n")
+	if m.view != viewList || m.status != "Restart required" || len(b.Restarts) != 0 {
+		t.Errorf("after no: status %q, restarts %v", m.status, b.Restarts)
+	}
+}
+
+func TestEditorErrors(t *testing.T) {
+	m, b := newTestModel(t)
+	selectDevice(t, m, "audio")
+	before := deviceSettings(t, b, "Audio")
+
+	// DevicePolicy 4 without processors
+	press(m, "\r\r")
+	if m.view != viewEditor || !strings.Contains(m.editor.err, "select at least one processor") {
+		t.Fatalf("error %q", m.editor.err)
+	}
+	press(m, keyEsc)
+	if m.view != viewList || m.status != "Canceled" || !reflect.DeepEqual(deviceSettings(t, b, "Audio"), before) {
+		t.Errorf("escape: status %q", m.status)
+	}
+
+	// unchanged settings
+	selectDevice(t, m, "lpc")
+	press(m, "\r\r")
+	if m.view != viewList || m.status != "Nothing to change." {
+		t.Errorf("status %q without changes", m.status)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	m, b := newTestModel(t)
+	b.DryRun = true
+	selectDevice(t, m, "ethernet")
+
+	before := deviceSettings(t, b, "Ethernet")
+	press(m, "\r", keyRight, "\r")
+	if m.view != viewModal || m.modal.title != "Dry Run" || m.modal.confirm != nil {
+		t.Fatalf("view %d after a dry run", m.view)
+	}
+	if m.modal.lines[len(m.modal.lines)-1] != "set-value MSISupported = false" {
+		t.Errorf("dry run lists %q", m.modal.lines)
+	}
+	if got := deviceSettings(t, b, "Ethernet"); !reflect.DeepEqual(got, before) {
+		t.Errorf("dry run changed the settings to %+v", got)
+	}
+
+	press(m, "\r")
+	if m.view != viewList || m.modal != nil {
+		t.Error("enter does not close the dry run")
+	}
+}
+
+func TestRestartQuestion(t *testing.T) {
+	m, b := newTestModel(t)
+	selectDevice(t, m, "nvidia")
+
+	press(m, "r")
+	if m.view != viewModal || m.modal.lines[0] != "NVIDIA GeForce RTX 4090" {
+		t.Fatal("r does not ask to restart the device")
+	}
+	press(m, "x") // other keys are ignored
+	if m.view != viewModal {
+		t.Fatal("the question was closed by another key")
+	}
+	press(m, "y")
+	if len(b.Restarts) != 1 || !m.statusError || !strings.Contains(m.status, "could not be restarted") {
+		t.Errorf("after yes: status %q, restarts %v", m.status, b.Restarts)
+	}
+
+	selectDevice(t, m, "ethernet")
+	press(m, "r", keyEsc)
+	if len(b.Restarts) != 1 || m.view != viewList {
+		t.Errorf("escape restarted the device: %v", b.Restarts)
+	}
+	press(m, "rY")
+	if len(b.Restarts) != 2 || m.status != "Device successfully restarted." {
+		t.Errorf("after Y: status %q", m.status)
+	}
+
+	// not present, the question is not asked
+	selectDevice(t, m, "magewell")
+	press(m, "r")
+	if m.view != viewList || !strings.HasPrefix(m.status, "Not restarted, the device is not present") {
+		t.Errorf("not present device: view %d, status %q", m.view, m.status)
+	}
+}
+
+func TestDraw(t *testing.T) {
+	m, _ := newTestModel(t)
+	selectDevice(t, m, "ethernet")
+	for _, keys := range []string{"", "\r\t\t\t\t"} {
+		press(m, keys)
+		c := NewCanvas(100, 40)
+		m.Draw(c)
+		if !strings.Contains(c.String(), "Intel(R) Ethernet Controller I226-V") {
+			t.Errorf("view %d does not show the device:\n%s", m.view, c.String())
+		}
+	}
+}
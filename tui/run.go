@@ -0,0 +1,78 @@
+package tui
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// Terminal is a terminal in raw mode that understands VT sequences.
+type Terminal interface {
+	io.ReadWriter
+	Size() (width, height int, err error)
+	// Restore returns the terminal to the mode it had before.
+	Restore() error
+}
+
+// Run shows the interface on term until the user quits.
+func Run(backend Backend, term Terminal) error {
+	m, err := NewModel(backend)
+	if err != nil {
+		return err
+	}
+
+	// alternate screen, hidden cursor
+	io.WriteString(term, "\x1b[?1049h\x1b[?25l")
+	defer io.WriteString(term, "\x1b[0m\x1b[?25h\x1b[?1049l")
+
+	keys := make(chan []Key)
+	readErr := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := term.Read(buf)
+			if n > 0 {
+				keys <- ParseKeys(buf[:n])
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	// the size is polled, there is no resize signal on Windows
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	r := &renderer{out: term}
+	for {
+		width, height, err := term.Size()
+		if err != nil {
+			return err
+		}
+		if width > 0 && height > 0 {
+			c := NewCanvas(width, height)
+			m.Draw(c)
+			if err := r.render(c); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case batch := <-keys:
+			for _, key := range batch {
+				m.Update(key)
+			}
+			if m.Quit() {
+				return nil
+			}
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+type linuxTerminal struct {
+	*os.File
+	saved *unix.Termios
+}
+
+// OpenTerminal puts the controlling terminal into raw mode.
+func OpenTerminal() (Terminal, error) {
+	f, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	fd := int(f.Fd())
+	saved, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	raw := *saved
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, &raw); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &linuxTerminal{File: f, saved: saved}, nil
+}
+
+func (t *linuxTerminal) Size() (int, int, error) {
+	ws, err := unix.IoctlGetWinsize(int(t.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(ws.Col), int(ws.Row), nil
+}
+
+func (t *linuxTerminal) Restore() error {
+	err := unix.IoctlSetTermios(int(t.Fd()), unix.TCSETS, t.saved)
+	t.Close()
+	return err
+}
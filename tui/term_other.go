@@ -0,0 +1,12 @@
+//go:build !linux && !windows
+
+package tui
+
+import (
+	"errors"
+	"runtime"
+)
+
+func OpenTerminal() (Terminal, error) {
+	return nil, errors.New("the terminal interface is not supported on " + runtime.GOOS)
+}
@@ -0,0 +1,91 @@
+package tui
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	libKernel32   = windows.NewLazySystemDLL("kernel32.dll")
+	attachConsole = libKernel32.NewProc("AttachConsole")
+)
+
+const attachParentProcess = ^uintptr(0) // ATTACH_PARENT_PROCESS
+
+type windowsTerminal struct {
+	in, out         *os.File
+	inMode, outMode uint32
+}
+
+// OpenTerminal attaches to the console of the parent process, the program
+// is linked as a GUI application and has none of its own, and switches it
+// to raw VT input and output.
+func OpenTerminal() (Terminal, error) {
+	attachConsole.Call(attachParentProcess) // fails if already attached, CONIN$ tells
+
+	in, err := os.OpenFile("CONIN$", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	out, err := os.OpenFile("CONOUT$", os.O_RDWR, 0)
+	if err != nil {
+		in.Close()
+		return nil, err
+	}
+	t := &windowsTerminal{in: in, out: out}
+
+	if err := windows.GetConsoleMode(windows.Handle(in.Fd()), &t.inMode); err != nil {
+		t.close()
+		return nil, err
+	}
+	if err := windows.GetConsoleMode(windows.Handle(out.Fd()), &t.outMode); err != nil {
+		t.close()
+		return nil, err
+	}
+
+	inMode := t.inMode
+	inMode &^= windows.ENABLE_ECHO_INPUT | windows.ENABLE_LINE_INPUT | windows.ENABLE_PROCESSED_INPUT
+	inMode |= windows.ENABLE_VIRTUAL_TERMINAL_INPUT
+	if err := windows.SetConsoleMode(windows.Handle(in.Fd()), inMode); err != nil {
+		t.close()
+		return nil, err
+	}
+	outMode := t.outMode | windows.ENABLE_PROCESSED_OUTPUT | windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING
+	if err := windows.SetConsoleMode(windows.Handle(out.Fd()), outMode); err != nil {
+		windows.SetConsoleMode(windows.Handle(in.Fd()), t.inMode)
+		t.close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *windowsTerminal) Read(p []byte) (int, error) {
+	return t.in.Read(p)
+}
+
+func (t *windowsTerminal) Write(p []byte) (int, error) {
+	return t.out.Write(p)
+}
+
+func (t *windowsTerminal) Size() (int, int, error) {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(t.out.Fd()), &info); err != nil {
+		return 0, 0, err
+	}
+	return int(info.Window.Right-info.Window.Left) + 1, int(info.Window.Bottom-info.Window.Top) + 1, nil
+}
+
+func (t *windowsTerminal) Restore() error {
+	err := windows.SetConsoleMode(windows.Handle(t.in.Fd()), t.inMode)
+	if err2 := windows.SetConsoleMode(windows.Handle(t.out.Fd()), t.outMode); err == nil {
+		err = err2
+	}
+	t.close()
+	return err
+}
+
+func (t *windowsTerminal) close() {
+	t.in.Close()
+	t.out.Close()
+}
@@ -580,3 +580,27 @@ var DEVPKEY_PciDevice_InterruptMessageMaximum = DEVPROPKEY{
 	windows.GUID{Data1: 0x3ab22e31, Data2: 0x8264, Data3: 0x4b4e, Data4: [8]byte{0x9a, 0xf5, 0xa8, 0xd2, 0xd8, 0xe3, 0x3e, 0x62}},
 	15,
 }
+
+// DEVPKEY_Device_Parent is the instance ID of the parent device.
+var DEVPKEY_Device_Parent = DEVPROPKEY{
+	windows.GUID{Data1: 0x4340a6c5, Data2: 0x93fa, Data3: 0x4706, Data4: [8]byte{0x97, 0x2c, 0x7b, 0x64, 0x80, 0x08, 0xa5, 0xa7}},
+	8,
+}
+
+// DEVPKEY_Device_Numa_Node is a DEVPROP_TYPE_UINT32, the NUMA node of the device. Only set on machines with several nodes.
+var DEVPKEY_Device_Numa_Node = DEVPROPKEY{
+	windows.GUID{Data1: 0x540b947e, Data2: 0x8b40, Data3: 0x45bc, Data4: [8]byte{0xa8, 0xa2, 0x6a, 0x0b, 0x89, 0x4c, 0xbd, 0xa2}},
+	3,
+}
+
+// DEVPKEY_Device_IsPresent is a DEVPROP_TYPE_BOOLEAN, false for a phantom device that is installed but not connected.
+var DEVPKEY_Device_IsPresent = DEVPROPKEY{
+	windows.GUID{Data1: 0x540b947e, Data2: 0x8b40, Data3: 0x45bc, Data4: [8]byte{0xa8, 0xa2, 0x6a, 0x0b, 0x89, 0x4c, 0xbd, 0xa2}},
+	5,
+}
+
+// DEVPKEY_Device_InstanceId is the device instance ID, e.g. PCI\VEN_8086&DEV_15B8&SUBSYS_86721043&REV_31\3&11583659&0&FE
+var DEVPKEY_Device_InstanceId = DEVPROPKEY{
+	windows.GUID{Data1: 0x78c34fc8, Data2: 0x104a, Data3: 0x4aca, Data4: [8]byte{0x9e, 0xa4, 0x52, 0x4d, 0x52, 0x99, 0x6e, 0x57}},
+	256,
+}
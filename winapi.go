@@ -1,6 +1,10 @@
+//go:build windows
+
 package main
 
 import (
+	"fmt"
+	"strings"
 	"syscall"
 	"unsafe"
 
@@ -14,101 +18,246 @@ var (
 	procSetupDiGetClassDevsW = modSetupapi.NewProc("SetupDiGetClassDevsW")
 )
 
-const CONFIG_FLAG_DISABLED uint32 = 1
+// deviceHandle is the part of a Device that only exists for the devices of
+// the running system.
+type deviceHandle struct {
+	Idata DevInfoData
+	reg   registry.Key
+}
 
-func FindAllDevices() ([]Device, DevInfo) {
-	var allDevices []Device
-	handle, err := SetupDiGetClassDevs(nil, nil, 0, uint32(DIGCF_ALLCLASSES|DIGCF_PRESENT))
+// liveDeviceSource returns the devices of the running system.
+func liveDeviceSource() (DeviceSource, error) {
+	return &setupAPISource{}, nil
+}
+
+// setupAPISource enumerates the devices of the running system with SetupAPI,
+// with -include-inactive also the disabled and not present ones.
+type setupAPISource struct {
+	handle DevInfo
+}
+
+func (s *setupAPISource) Devices() ([]Device, error) {
+	if err := s.Close(); err != nil {
+		return nil, err
+	}
+	flags := DIGCF_ALLCLASSES | DIGCF_PRESENT
+	if flagIncludeInactive {
+		flags = DIGCF_ALLCLASSES
+	}
+	handle, err := SetupDiGetClassDevs(nil, nil, 0, uint32(flags))
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("SetupDiGetClassDevs: %w", err)
 	}
+	s.handle = handle
 
-	var index = 0
-	for {
+	var allDevices []Device
+	for index := 0; ; index++ {
 		idata, err := SetupDiEnumDeviceInfo(handle, index)
 		if err != nil { // ERROR_NO_MORE_ITEMS
 			break
 		}
-		index++
-
-		dev := Device{
-			Idata: *idata,
+		if dev, ok := readDevice(handle, idata); ok {
+			allDevices = append(allDevices, dev)
 		}
+	}
+	return allDevices, nil
+}
+
+func (s *setupAPISource) CanRestart() bool {
+	return true
+}
+
+func (s *setupAPISource) Restart(dev *Device) (bool, error) {
+	return restartDevice(s.handle, dev)
+}
 
-		val, err := SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_CONFIGFLAGS)
-		if err == nil {
-			if val.(uint32)&CONFIG_FLAG_DISABLED != 0 {
+// Close destroys the device information set, the devices cannot be restarted afterwards.
+func (s *setupAPISource) Close() error {
+	if s.handle == 0 {
+		return nil
+	}
+	err := SetupDiDestroyDeviceInfoList(s.handle)
+	s.handle = 0
+	return err
+}
+
+// readDevice reads the properties, the device key and the interrupt
+// settings of a device. ok is false for devices without a description and,
+// without -include-inactive, for disabled devices.
+func readDevice(handle DevInfo, idata *DevInfoData) (dev Device, ok bool) {
+	dev = Device{
+		deviceHandle: deviceHandle{Idata: *idata},
+		NumaNode:     -1,
+	}
+
+	val, err := SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_CONFIGFLAGS)
+	if err == nil {
+		if val.(uint32)&CONFIG_FLAG_DISABLED != 0 {
+			if !flagIncludeInactive {
 				// Sorts out deactivated devices
-				continue
+				return dev, false
 			}
+			dev.State |= DeviceDisabled
 		}
+	}
 
-		val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_DEVICEDESC)
-		if err == nil {
-			if val.(string) == "" {
-				continue
-			}
-			dev.DeviceDesc = val.(string)
-		} else {
-			continue
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_DEVICEDESC)
+	if err == nil {
+		if val.(string) == "" {
+			return dev, false
 		}
+		dev.DeviceDesc = val.(string)
+	} else {
+		return dev, false
+	}
 
-		valProp, err := GetDeviceProperty(handle, idata, DEVPKEY_PciDevice_InterruptSupport)
-		if err == nil {
-			dev.InterruptTypeMap = Bits(btoi16(valProp))
-		}
+	valProp, err := GetDeviceProperty(handle, idata, DEVPKEY_PciDevice_InterruptSupport)
+	if err == nil {
+		dev.InterruptTypeMap = Bits(btoi16(valProp))
+	}
 
-		valProp, err = GetDeviceProperty(handle, idata, DEVPKEY_PciDevice_InterruptMessageMaximum)
-		if err == nil {
-			dev.MaxMSILimit = btoi32(valProp)
-		}
+	valProp, err = GetDeviceProperty(handle, idata, DEVPKEY_PciDevice_InterruptMessageMaximum)
+	if err == nil {
+		dev.MaxMSILimit = btoi32(valProp)
+	}
 
-		val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_FRIENDLYNAME)
-		if err == nil {
-			dev.FriendlyName = val.(string)
-		}
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_FRIENDLYNAME)
+	if err == nil {
+		dev.FriendlyName = val.(string)
+	}
 
-		val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_PHYSICAL_DEVICE_OBJECT_NAME)
-		if err == nil {
-			dev.DevObjName = val.(string)
-		}
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_PHYSICAL_DEVICE_OBJECT_NAME)
+	if err == nil {
+		dev.DevObjName = val.(string)
+	}
 
-		val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_LOCATION_INFORMATION)
-		if err == nil {
-			dev.LocationInformation = val.(string)
-		}
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_LOCATION_INFORMATION)
+	if err == nil {
+		dev.LocationInformation = val.(string)
+	}
 
-		dev.reg, _ = SetupDiOpenDevRegKey(handle, idata, DICS_FLAG_GLOBAL, 0, DIREG_DEV, windows.KEY_SET_VALUE)
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_HARDWAREID)
+	if err == nil {
+		dev.DeviceIDs, _ = val.([]string)
+	}
 
-		keyinfo, err := dev.reg.Stat()
-		if err == nil {
-			dev.LastChange = keyinfo.ModTime()
-		}
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_COMPATIBLEIDS)
+	if err == nil {
+		dev.CompatibleIDs, _ = val.([]string)
+	}
 
-		affinityPolicyKey, _ := registry.OpenKey(dev.reg, `Interrupt Management\Affinity Policy`, registry.QUERY_VALUE)
-		dev.DevicePolicy = GetDWORDuint32Value(affinityPolicyKey, "DevicePolicy")               // REG_DWORD
-		dev.DevicePriority = GetDWORDuint32Value(affinityPolicyKey, "DevicePriority")           // REG_DWORD
-		AssignmentSetOverrideByte := GetBinaryValue(affinityPolicyKey, "AssignmentSetOverride") // REG_BINARY
-		affinityPolicyKey.Close()
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_LOCATION_PATHS)
+	if err == nil {
+		dev.LocationPaths, _ = val.([]string)
+	}
 
-		if len(AssignmentSetOverrideByte) != 0 {
-			AssignmentSetOverrideBytes := make([]byte, 8)
-			copy(AssignmentSetOverrideBytes, AssignmentSetOverrideByte)
-			dev.AssignmentSetOverride = Bits(btoi64(AssignmentSetOverrideBytes))
-		}
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_CLASS)
+	if err == nil {
+		dev.Class, _ = val.(string)
+	}
 
-		if dev.InterruptTypeMap != ZeroBit {
-			messageSignaledInterruptPropertiesKey, _ := registry.OpenKey(dev.reg, `Interrupt Management\MessageSignaledInterruptProperties`, registry.QUERY_VALUE)
-			dev.MessageNumberLimit = GetDWORDuint32Value(messageSignaledInterruptPropertiesKey, "MessageNumberLimit") // REG_DWORD https://docs.microsoft.com/de-de/windows-hardware/drivers/kernel/enabling-message-signaled-interrupts-in-the-registry
-			dev.MsiSupported = GetDWORDuint32Value(messageSignaledInterruptPropertiesKey, "MSISupported")             // REG_DWORD
-			messageSignaledInterruptPropertiesKey.Close()
-		} else {
-			dev.MsiSupported = 2 // invalid
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_CLASSGUID)
+	if err == nil {
+		dev.ClassGUID, _ = val.(string)
+	}
+
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_SERVICE)
+	if err == nil {
+		dev.Driver, _ = val.(string)
+	}
+
+	val, err = SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_BASE_CONTAINERID)
+	if err == nil {
+		dev.ContainerID, _ = val.(string)
+	}
+
+	valProp, err = GetDeviceProperty(handle, idata, DEVPKEY_Device_Parent)
+	if err == nil {
+		dev.Parent = windows.UTF16ToString(BufToUTF16(valProp))
+	}
+
+	valProp, err = GetDeviceProperty(handle, idata, DEVPKEY_Device_Numa_Node)
+	if err == nil {
+		dev.NumaNode = int(btoi32(valProp))
+	}
+
+	valProp, err = GetDeviceProperty(handle, idata, DEVPKEY_Device_IsPresent)
+	if err == nil && valProp[0] == 0 { // DEVPROP_FALSE
+		dev.State |= DeviceNotPresent
+	}
+
+	var status, problem uint32
+	if err := windows.CM_Get_DevNode_Status(&status, &problem, windows.DEVINST(idata.DevInst), 0); err == nil {
+		dev.RebootRequired = status&windows.DN_NEED_RESTART != 0
+	}
+
+	valProp, err = GetDeviceProperty(handle, idata, DEVPKEY_Device_InstanceId)
+	if err == nil {
+		dev.InstanceID = windows.UTF16ToString(BufToUTF16(valProp))
+	}
+
+	if strings.HasPrefix(strings.ToUpper(dev.InstanceID), `PCI\`) {
+		bus, errBus := SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_BUSNUMBER)
+		address, errAddress := SetupDiGetDeviceRegistryProperty(handle, idata, SPDRP_ADDRESS)
+		if errBus == nil && errAddress == nil {
+			dev.PCI = PCILocation{
+				Valid:    true,
+				Bus:      bus.(uint32),
+				Device:   address.(uint32) >> 16,
+				Function: address.(uint32) & 0xFFFF,
+			}
 		}
+	}
 
-		allDevices = append(allDevices, dev)
+	dev.reg, _ = SetupDiOpenDevRegKey(handle, idata, DICS_FLAG_GLOBAL, 0, DIREG_DEV, windows.KEY_SET_VALUE)
+
+	if regPath, err := GetRegistryLocation(uintptr(dev.reg)); err == nil {
+		dev.RegPath = regPath
+	}
+
+	keyinfo, err := dev.reg.Stat()
+	if err == nil {
+		dev.LastChange = keyinfo.ModTime()
+	}
+
+	dev.store = registryStore{key: dev.reg}
+	readAffinityPolicy(dev.store, &dev)
+	readMSIProperties(dev.store, &dev)
+
+	return dev, true
+}
+
+// restartDevice sends DIF_PROPERTYCHANGE so the device picks up its new settings.
+// needReboot is true if Windows could not restart the device.
+func restartDevice(handle DevInfo, dev *Device) (needReboot bool, err error) {
+	propChangeParams := PropChangeParams{
+		ClassInstallHeader: *MakeClassInstallHeader(DIF_PROPERTYCHANGE),
+		StateChange:        DICS_PROPCHANGE,
+		Scope:              DICS_FLAG_GLOBAL,
+	}
+
+	if err := SetupDiSetClassInstallParams(handle, &dev.Idata, &propChangeParams.ClassInstallHeader, uint32(unsafe.Sizeof(propChangeParams))); err != nil {
+		return false, err
 	}
-	return allDevices, handle
+
+	if err := SetupDiCallClassInstaller(DIF_PROPERTYCHANGE, handle, &dev.Idata); err != nil {
+		return false, err
+	}
+
+	if err := SetupDiSetClassInstallParams(handle, &dev.Idata, &propChangeParams.ClassInstallHeader, uint32(unsafe.Sizeof(propChangeParams))); err != nil {
+		return false, err
+	}
+
+	if err := SetupDiCallClassInstaller(DIF_PROPERTYCHANGE, handle, &dev.Idata); err != nil {
+		return false, err
+	}
+
+	DeviceInstallParams, err := SetupDiGetDeviceInstallParams(handle, &dev.Idata)
+	if err != nil {
+		return false, err
+	}
+
+	return DeviceInstallParams.Flags&DI_NEEDREBOOT != 0, nil
 }
 
 func SetupDiGetClassDevs(classGuid *windows.GUID, enumerator *uint16, hwndParent uintptr, flags uint32) (handle DevInfo, err error) {